@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestIDHeader carries the ID of a request, both on the requests the
+// webhook receives and on those it makes to MicroMDM on their behalf.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits the request IDs accepted from clients, so they can
+// be logged and passed on safely.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+type requestIDKey struct{}
+
+// requestIDFrom returns the ID of the request ctx belongs to, or "".
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// accessLog gives each request an ID, taken from its X-Request-ID header if
+// it has a valid one, and logs the request once it has been served. The ID
+// is returned in the response and attached to every message logged while
+// handling the request. Probe requests are logged at debug level.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		logger := logFor(r.Context()).WithField("request_id", id)
+		if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+			logger = logger.WithField("trace_id", sc.TraceID().String())
+		}
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = withLogger(ctx, logger)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		entry := logger.WithFields(logrus.Fields{
+			"method":      r.Method,
+			"path":        r.URL.Path,
+			"status":      rec.status,
+			"bytes":       rec.bytes,
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"remote_addr": r.RemoteAddr,
+		})
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/version":
+			entry.Debug("request")
+		default:
+			entry.Info("request")
+		}
+	})
+}
+
+// statusRecorder records the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Flush lets event streams flush through the recorder.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
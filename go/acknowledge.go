@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// acknowledgment is a decoded command response from a device.
+type acknowledgment = webhook.Acknowledgment
+
+// responseHandler applies a successful command response to the device
+// record. It reports whether the device was modified.
+type responseHandler func(s *Server, ctx context.Context, d *Device, ack acknowledgment) (bool, error)
+
+// resolvePending matches ack to the pending command it answers. Commands
+// that completed stop being tracked, failures, of the given class, are
+// passed to failPending, and commands answered NotNow stay pending, deferred
+// by deferPending, until the device answers again. It returns the pending
+// command, if there was one, and whether the failed command will be sent
+// again.
+func (s *Server) resolvePending(ctx context.Context, ack *acknowledgment, class string) (PendingCommand, bool) {
+	if ack.CommandUUID == "" {
+		return PendingCommand{}, false
+	}
+	pending, ok := s.Pending.Get(ack.CommandUUID)
+	if !ok {
+		return PendingCommand{}, false
+	}
+	ack.RequestType = pending.RequestType
+
+	switch ack.Status {
+	case "Acknowledged":
+		s.Pending.Remove(ack.CommandUUID)
+		logFor(ctx).WithFields(logrus.Fields{
+			"request_type": pending.RequestType,
+			"duration":     ack.Time.Sub(pending.SentAt).String(),
+		}).Info("device completed command")
+	case "Error", "CommandFormatError":
+		return pending, s.failPending(ctx, pending, *ack, class)
+	case "NotNow":
+		s.deferPending(ctx, pending, *ack)
+	}
+	return pending, false
+}
+
+// dispatchAcknowledgment passes an acknowledged response to the handlers
+// registered for its RequestType, stopping at the first error. It reports
+// whether any of them modified the device.
+func (s *Server) dispatchAcknowledgment(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	if ack.Status != "Acknowledged" {
+		logFor(ctx).WithField("status", ack.Status).Info("device answered command")
+		return false, nil
+	}
+	changed := false
+	for _, handle := range responseHandlers[ack.RequestType] {
+		c, err := handle(s, ctx, d, ack)
+		if err != nil {
+			return changed, err
+		}
+		changed = changed || c
+	}
+	return changed, nil
+}
+
+func (s *Server) applyInstalledApplicationList(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	apps, err := webhook.ParseInstalledApplicationList(ack.Raw)
+	if err != nil {
+		return false, err
+	}
+	d.InstalledApps = apps
+	logFor(ctx).WithField("apps", len(apps)).Info("device reported installed applications")
+	return true, nil
+}
+
+func (s *Server) applyDeviceInformation(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	info, err := webhook.ParseDeviceInformation(ack.Raw)
+	if err != nil {
+		return false, err
+	}
+	info.UpdatedAt = ack.Time
+	d.Info = &info
+	updatePlatform(d)
+	logFor(ctx).WithFields(logrus.Fields{"model_name": info.ModelName, "os_version": info.OSVersion}).Info("device reported device information")
+	return true, nil
+}
+
+func (s *Server) applySecurityInfo(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	posture, err := webhook.ParseSecurityInfo(ack.Raw)
+	if err != nil {
+		return false, err
+	}
+	posture.UpdatedAt = ack.Time
+	d.Security = &posture
+	logFor(ctx).WithFields(logrus.Fields{
+		"filevault": posture.FDEEnabled,
+		"firewall":  posture.FirewallEnabled,
+		"passcode":  posture.PasscodePresent,
+	}).Info("device reported security info")
+	return true, nil
+}
+
+func (s *Server) applyProfileList(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	profiles, err := webhook.ParseProfileList(ack.Raw)
+	if err != nil {
+		return false, err
+	}
+	d.Profiles = profiles
+	logFor(ctx).WithField("profiles", len(profiles)).Info("device reported installed profiles")
+	if len(s.ExpectedProfiles) > 0 {
+		missing, unexpected := webhook.DiffProfiles(profiles, s.ExpectedProfiles)
+		if len(missing) > 0 {
+			logFor(ctx).WithField("missing_profiles", missing).Warn("device is missing expected profiles")
+		}
+		if len(unexpected) > 0 {
+			logFor(ctx).WithField("unexpected_profiles", unexpected).Warn("device has unexpected profiles")
+		}
+	}
+	return true, nil
+}
+
+func (s *Server) applyCertificateList(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	certs, err := webhook.ParseCertificateList(ack.Raw)
+	if err != nil && certs == nil {
+		return false, err
+	} else if err != nil {
+		logFor(ctx).WithError(err).Warn("skipped unreadable certificates")
+	}
+	d.Certificates = certs
+	logFor(ctx).WithField("certificates", len(certs)).Info("device reported certificates")
+	for _, c := range webhook.ExpiringIdentities(certs, time.Now(), s.CertExpiryWarning) {
+		logFor(ctx).WithFields(logrus.Fields{"common_name": c.CommonName, "not_after": c.NotAfter}).Warn("device identity certificate expires soon")
+	}
+	return true, nil
+}
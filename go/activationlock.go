@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// escrowBypassCode seals the Activation Lock bypass code of a
+// DeviceInformation response into d. Responses without one leave the
+// stored code alone, since devices stop reporting it once it was read.
+func (s *Server) escrowBypassCode(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	code, err := webhook.ParseActivationLockBypassCode(ack.Raw)
+	if err != nil || code == "" {
+		return false, err
+	}
+	if s.Escrow == nil {
+		logFor(ctx).Warn("not storing ActivationLockBypassCode: storing it requires an escrow key; set -escrow-key")
+		return false, nil
+	}
+	sealed, err := s.Escrow.seal(d.UDID, code)
+	if err != nil {
+		return false, fmt.Errorf("seal ActivationLockBypassCode: %v", err)
+	}
+	d.ActivationLockBypassCode = &store.EscrowedPIN{Sealed: sealed, CreatedAt: ack.Time}
+	logFor(ctx).Info("escrowed ActivationLockBypassCode")
+	return true, nil
+}
+
+// BypassCodeRequest is the reason for disclosing a device's Activation Lock
+// bypass code, which is logged.
+type BypassCodeRequest struct {
+	Reason string `json:"reason"`
+}
+
+// BypassCode is an escrowed Activation Lock bypass code as disclosed by the
+// admin API.
+type BypassCode struct {
+	Code       string    `json:"code"`
+	EscrowedAt time.Time `json:"escrowed_at"`
+}
+
+// handleGetActivationLockBypassCode discloses the Activation Lock bypass code escrowed for a
+// device, to clear Activation Lock from a device nobody can sign in to. As a
+// break-glass measure it takes a POST with a reason, which is logged with
+// who made the request.
+func (s *Server) handleGetActivationLockBypassCode(w http.ResponseWriter, r *http.Request) {
+	if s.Escrow == nil {
+		http.Error(w, "bypass code escrow is not configured; set -escrow-key", http.StatusServiceUnavailable)
+		return
+	}
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	var req BypassCodeRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandBody)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		http.Error(w, "a reason is required", http.StatusBadRequest)
+		return
+	}
+	if d.ActivationLockBypassCode == nil {
+		http.Error(w, fmt.Sprintf("no ActivationLockBypassCode is stored for device %s", d.UDID), http.StatusNotFound)
+		return
+	}
+	code, err := s.Escrow.open(d.UDID, d.ActivationLockBypassCode.Sealed)
+	if err != nil {
+		logFor(r.Context()).WithField("udid", d.UDID).WithError(err).Error("open escrowed ActivationLockBypassCode")
+		http.Error(w, fmt.Sprintf("open escrowed ActivationLockBypassCode: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logFor(r.Context()).WithFields(auditFields(r)).WithFields(logrus.Fields{"udid": d.UDID, "reason": req.Reason}).Warn("disclosed escrowed ActivationLockBypassCode")
+	writeJSON(w, http.StatusOK, BypassCode{Code: code, EscrowedAt: d.ActivationLockBypassCode.CreatedAt})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseCIDRs parses a comma-separated list of networks. A bare address is
+// taken as a network of that single address.
+func parseCIDRs(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid network %q: %v", s, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", s, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientAddr returns the address of the client that made r. When the peer
+// is one of s.TrustedProxies, X-Forwarded-For is read from the right,
+// skipping further trusted proxies, so a client cannot pick its address by
+// sending the header itself.
+func (s *Server) clientAddr(r *http.Request) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("invalid remote address %q", r.RemoteAddr)
+	}
+	if !containsAddr(s.TrustedProxies, addr) {
+		return addr.Unmap(), nil
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("invalid X-Forwarded-For address %q", hops[i])
+		}
+		addr = hop
+		if !containsAddr(s.TrustedProxies, addr) {
+			break
+		}
+	}
+	return addr.Unmap(), nil
+}
+
+// requireAllowedAddr rejects requests from clients outside s.AllowedNets.
+func (s *Server) requireAllowedAddr(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, err := s.clientAddr(r)
+		if err != nil || !containsAddr(s.AllowedNets, addr) {
+			if err == nil {
+				err = fmt.Errorf("%s is not in -allowed-cidrs", addr)
+			}
+			logFor(r.Context()).WithField("remote_addr", r.RemoteAddr).Warnf("rejected webhook: %v", err)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
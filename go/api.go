@@ -0,0 +1,386 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	mdmcmd "github.com/micromdm/micromdm/mdm/mdm"
+	"github.com/sirupsen/logrus"
+)
+
+// openAPISpec describes the admin API. The routes of apiHandler and the
+// client package are generated from it by cmd/apigen.
+//
+//go:generate go run ./cmd/apigen
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// maxCommandBody bounds the size of command requests to the admin API.
+const maxCommandBody = 1 << 20
+
+// eventKeepalive is how often an idle event stream sends a comment, so
+// proxies do not close it.
+const eventKeepalive = 30 * time.Second
+
+// apiHandler returns the admin API, which requires s.AdminToken on every
+// request. Its routes are under prefix+"/api/".
+func (s *Server) apiHandler(prefix string) http.Handler {
+	mux := http.NewServeMux()
+	registerAPI(mux, prefix, s)
+	return s.requireAdmin(mux)
+}
+
+// requireAdmin rejects requests that do not carry the admin token, either as
+// a bearer token or as the basic auth password.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, password, ok := r.BasicAuth(); ok {
+			token = password
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+			logFor(r.Context()).WithField("remote_addr", r.RemoteAddr).Warn("rejected admin API request: invalid credentials")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="micromdm-webhook"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// auditFields are the log fields identifying who made an admin API request:
+// its remote address and, for basic auth, the user name, which the admin
+// token does not check but callers set to name themselves.
+func auditFields(r *http.Request) logrus.Fields {
+	fields := logrus.Fields{"remote_addr": r.RemoteAddr}
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		fields["user"] = user
+	}
+	return fields
+}
+
+// handleListDevices returns a page of tracked devices as JSON, filtered and
+// sorted as described by parseDeviceQuery. When there are more devices, the
+// Link header holds the URL of the next page.
+func (s *Server) handleListDevices(w http.ResponseWriter, r *http.Request) {
+	q, err := parseDeviceQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	q.RespondedSince = time.Now().Add(-s.ResponsiveWindow)
+	devices, err := s.Devices.List()
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("list devices")
+		http.Error(w, fmt.Sprintf("list devices: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	page, next := q.Apply(devices)
+	if next != "" {
+		v := r.URL.Query()
+		v.Set("cursor", next)
+		w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", r.URL.Path, v.Encode()))
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// handleGetDevice returns a single device as JSON.
+func (s *Server) handleGetDevice(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+// apiDevice returns the device named in the path of an admin API request,
+// or answers the request with an error.
+func (s *Server) apiDevice(w http.ResponseWriter, r *http.Request) (Device, bool) {
+	d, err := s.Devices.Get(r.PathValue("udid"))
+	if err == store.ErrDeviceNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return d, false
+	} else if err != nil {
+		logFor(r.Context()).WithError(err).Error("get device")
+		http.Error(w, fmt.Sprintf("get device: %v", err), http.StatusInternalServerError)
+		return d, false
+	}
+	return d, true
+}
+
+// handleGetCommandHistory returns the command history of a device as JSON.
+func (s *Server) handleGetCommandHistory(w http.ResponseWriter, r *http.Request) {
+	records, err := s.History.CommandHistory(r.PathValue("udid"))
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("load command history")
+		http.Error(w, fmt.Sprintf("load command history: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []CommandRecord{}
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+// handleSendCommand queues a command for a device in MicroMDM. The body is a
+// JSON object with a request_type and any parameters the command takes, in
+// the format accepted by MicroMDM's /v1/commands endpoint. Commands the
+// platform of a known device does not take are refused.
+func (s *Server) handleSendCommand(w http.ResponseWriter, r *http.Request) {
+	udid := r.PathValue("udid")
+	requestType, payload, ok := readCommandPayload(w, r)
+	if !ok {
+		return
+	}
+	payload["udid"] = udid
+	if d, exists, err := s.loadDevice(udid); err == nil && exists {
+		if err := checkPlatform(d, requestType); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	uuid, err := s.postCommand(r.Context(), udid, requestType, payload)
+	if err != nil {
+		logFor(r.Context()).WithFields(logrus.Fields{"udid": udid, "request_type": requestType}).WithError(err).Error("send command")
+		http.Error(w, fmt.Sprintf("send command: %v", err), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"command_uuid": uuid,
+		"request_type": requestType,
+		"udid":         udid,
+	})
+}
+
+// readCommandPayload reads the JSON command in the body of r, as
+// handleSendCommand takes it, or writes the error to w.
+func readCommandPayload(w http.ResponseWriter, r *http.Request) (string, map[string]interface{}, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCommandBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("read request: %v", err), http.StatusBadRequest)
+		return "", nil, false
+	}
+	requestType, payload, err := parseCommandPayload(body)
+	if err == nil {
+		err = requireConfirmation(requestType)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return "", nil, false
+	}
+	return requestType, payload, true
+}
+
+// handleInstallProfile queues an InstallProfile command for a device in
+// MicroMDM. The body is the configuration profile, rendered with the device
+// if it is an XML template.
+func (s *Server) handleInstallProfile(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	if c, ok := s.readProfileCommand(w, r, d, ""); ok {
+		s.postAPICommand(w, r, c)
+	}
+}
+
+// readProfileCommand returns the InstallProfile command installing the
+// profile in the body of r on d, on the channel of the user with userID if it
+// is set, or writes the error to w.
+func (s *Server) readProfileCommand(w http.ResponseWriter, r *http.Request, d Device, userID string) (Command, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCommandBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("read request: %v", err), http.StatusBadRequest)
+		return Command{}, false
+	}
+	if len(body) == 0 {
+		http.Error(w, "no profile", http.StatusBadRequest)
+		return Command{}, false
+	}
+	p, err := parseProfile("upload", body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return Command{}, false
+	}
+	payload, err := p.render(s.profileVars(d, userID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return Command{}, false
+	}
+	c, err := s.profileCommand(r.Context(), d, p, payload)
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("sign profile")
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return Command{}, false
+	}
+	c.UserID = userID
+	return c, true
+}
+
+// handleRemoveProfile queues a RemoveProfile command for a device in
+// MicroMDM, removing the profile with the identifier in the path.
+func (s *Server) handleRemoveProfile(w http.ResponseWriter, r *http.Request) {
+	d := Device{UDID: r.PathValue("udid")}
+	s.postAPICommand(w, r, removeProfileCommand(d, r.PathValue("identifier")))
+}
+
+// handleLockDevice sends a device a DeviceLock command, with a PIN that is
+// escrowed for handleGetLockPINs if the device is a Mac. The optional body is
+// a JSON LockOptions.
+func (s *Server) handleLockDevice(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCommandBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	var opts LockOptions
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &opts); err != nil {
+			http.Error(w, fmt.Sprintf("invalid lock options: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if needsLockPIN(d) && s.Escrow == nil {
+		http.Error(w, "PIN escrow is not configured; set -escrow-key", http.StatusServiceUnavailable)
+		return
+	}
+
+	uuid, err := s.lockDevice(r.Context(), &d, opts)
+	if err != nil {
+		logFor(r.Context()).WithFields(logrus.Fields{"udid": d.UDID, "request_type": "DeviceLock"}).WithError(err).Error("lock device")
+		http.Error(w, fmt.Sprintf("lock device: %v", err), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"command_uuid": uuid,
+		"request_type": "DeviceLock",
+		"udid":         d.UDID,
+	})
+}
+
+// handleGetLockPINs discloses the PINs escrowed for a device, newest first.
+// Every disclosure is logged.
+func (s *Server) handleGetLockPINs(w http.ResponseWriter, r *http.Request) {
+	if s.Escrow == nil {
+		http.Error(w, "PIN escrow is not configured; set -escrow-key", http.StatusServiceUnavailable)
+		return
+	}
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	pins, err := s.lockPINs(d)
+	if err != nil {
+		logFor(r.Context()).WithField("udid", d.UDID).WithError(err).Error("open escrowed PINs")
+		http.Error(w, fmt.Sprintf("open escrowed PINs: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logFor(r.Context()).WithFields(auditFields(r)).WithFields(logrus.Fields{"udid": d.UDID, "pins": len(pins)}).Warn("disclosed escrowed lock PINs")
+	writeJSON(w, http.StatusOK, pins)
+}
+
+// postAPICommand queues c in MicroMDM and answers the admin API request r
+// with its CommandUUID.
+func (s *Server) postAPICommand(w http.ResponseWriter, r *http.Request, c Command) {
+	uuid, err := s.postCommandTo(r.Context(), c.UDID, c.UserID, c.RequestType, c)
+	if err != nil {
+		logFor(r.Context()).WithFields(logrus.Fields{"udid": c.UDID, "user_id": c.UserID, "request_type": c.RequestType}).WithError(err).Error("send command")
+		http.Error(w, fmt.Sprintf("send command: %v", err), http.StatusBadGateway)
+		return
+	}
+	resp := map[string]string{
+		"command_uuid": uuid,
+		"request_type": c.RequestType,
+		"udid":         c.UDID,
+	}
+	if c.UserID != "" {
+		resp["user_id"] = c.UserID
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// parseCommandPayload validates a JSON command in the format of MicroMDM's
+// /v1/commands endpoint, using the MicroMDM command types, and returns its
+// request type and fields.
+func parseCommandPayload(body []byte) (string, map[string]interface{}, error) {
+	var cmd mdmcmd.Command
+	if err := json.Unmarshal(body, &cmd); err != nil {
+		return "", nil, fmt.Errorf("invalid command: %v", err)
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", nil, fmt.Errorf("invalid command: %v", err)
+	}
+	return cmd.RequestType, payload, nil
+}
+
+// handleStreamEvents streams webhook events as server-sent events until the client
+// disconnects. The udid query parameter limits the stream to one device.
+func (s *Server) handleStreamEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	udid := r.URL.Query().Get("udid")
+
+	events, unsubscribe := s.Events.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(eventKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			io.WriteString(w, ": keepalive\n\n")
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if udid != "" && e.UDID != udid {
+				continue
+			}
+			b, err := json.Marshal(e)
+			if err != nil {
+				logFor(r.Context()).WithError(err).Error("encode event")
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", b)
+		}
+		flusher.Flush()
+	}
+}
+
+// handleGetOpenAPISpec serves the OpenAPI document for the admin API.
+func (s *Server) handleGetOpenAPISpec(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPISpec)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logrus.WithError(err).Error("encode JSON response")
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// appInstallVars counts the App Store installs sent to devices, and those
+// confirmed, failed, or removed from management once confirmed.
+var appInstallVars = expvar.NewMap("app_installs")
+
+// Management flags of InstallApplication commands.
+const (
+	// removeAppWithMDM removes the app when the MDM profile is removed.
+	removeAppWithMDM = 1
+	// preventAppBackup keeps the app's data out of backups.
+	preventAppBackup = 4
+)
+
+// failedAppStates are the states of installs the device gave up on.
+var failedAppStates = []string{"Failed", "UserRejected", "UpdateRejected", "ManagementRejected"}
+
+// appInstallDone reports whether an install in state will not change
+// without another InstallApplication command.
+func appInstallDone(state string) bool {
+	return state == "Managed" || slices.Contains(failedAppStates, state)
+}
+
+// AppInstallRequest installs an App Store app, given by its iTunes Store ID
+// or, for apps licensed through Apps and Books (VPP) with PurchaseMethod 1,
+// its bundle identifier.
+type AppInstallRequest struct {
+	ITunesStoreID int64  `json:"itunes_store_id,omitempty"`
+	Identifier    string `json:"identifier,omitempty"`
+	// PurchaseMethod is 0 for apps bought with redemption codes or
+	// user-based VPP licenses, and 1 for VPP-licensed apps.
+	PurchaseMethod int64 `json:"purchase_method,omitempty"`
+	// ManagementFlags are bits of removeAppWithMDM and preventAppBackup.
+	ManagementFlags int `json:"management_flags,omitempty"`
+}
+
+func (r AppInstallRequest) validate() error {
+	if (r.ITunesStoreID == 0) == (r.Identifier == "") {
+		return fmt.Errorf("set exactly one of itunes_store_id or identifier")
+	}
+	if r.PurchaseMethod != 0 && r.PurchaseMethod != 1 {
+		return fmt.Errorf("invalid purchase_method %d, want 0 or 1", r.PurchaseMethod)
+	}
+	if r.Identifier != "" && r.PurchaseMethod != 1 {
+		return fmt.Errorf("apps given by identifier must be VPP-licensed, with purchase_method 1")
+	}
+	if r.ManagementFlags&^(removeAppWithMDM|preventAppBackup) != 0 {
+		return fmt.Errorf("invalid management_flags %d, want a combination of %d and %d", r.ManagementFlags, removeAppWithMDM, preventAppBackup)
+	}
+	return nil
+}
+
+// installAppCommand returns the InstallApplication command installing the
+// App Store app of req on the device udid, or on the channel of its macOS
+// user with userID if it is set.
+func installAppCommand(udid, userID string, req AppInstallRequest) Command {
+	c := Command{UDID: udid, UserID: userID, RequestType: "InstallApplication", ITunesStoreID: req.ITunesStoreID, Identifier: req.Identifier, ManagementFlags: req.ManagementFlags}
+	if req.PurchaseMethod != 0 {
+		c.Options = &InstallAppOptions{PurchaseMethod: req.PurchaseMethod}
+	}
+	return c
+}
+
+// handleInstallApplication sends a device an InstallApplication command for
+// an App Store app and tracks the install until the device's
+// ManagedApplicationList confirms it. The body is a JSON AppInstallRequest.
+func (s *Server) handleInstallApplication(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	s.installApp(w, r, d, "")
+}
+
+// handleInstallUserApplication is handleInstallApplication for the channel
+// of a macOS user of the device, installing the app with a license assigned
+// to the user.
+func (s *Server) handleInstallUserApplication(w http.ResponseWriter, r *http.Request) {
+	d, userID, ok := s.apiUser(w, r)
+	if !ok {
+		return
+	}
+	s.installApp(w, r, d, userID)
+}
+
+// installApp sends the InstallApplication command of the request r on the
+// channel of d's user with userID, or d's own if it is empty, and tracks the
+// install.
+func (s *Server) installApp(w http.ResponseWriter, r *http.Request, d Device, userID string) {
+	var req AppInstallRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandBody)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c := installAppCommand(d.UDID, userID, req)
+	uuid, err := s.postCommandTo(r.Context(), c.UDID, c.UserID, c.RequestType, c)
+	if err != nil {
+		logFor(r.Context()).WithFields(logrus.Fields{"udid": c.UDID, "user_id": c.UserID, "request_type": c.RequestType}).WithError(err).Error("send command")
+		http.Error(w, fmt.Sprintf("send command: %v", err), http.StatusBadGateway)
+		return
+	}
+	recordAppInstall(&d, c, uuid, time.Now().UTC())
+	if err := s.Devices.Save(d); err != nil {
+		logFor(r.Context()).WithError(err).Error("save app install")
+	}
+	resp := map[string]string{
+		"command_uuid": uuid,
+		"request_type": c.RequestType,
+		"udid":         c.UDID,
+	}
+	if userID != "" {
+		resp["user_id"] = userID
+	}
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// recordAppInstall starts tracking c, an InstallApplication command for an
+// App Store app sent to d with the given CommandUUID, in place of the
+// earlier installs of the app on the same channel.
+func recordAppInstall(d *Device, c Command, uuid string, at time.Time) {
+	d.AppInstalls = slices.DeleteFunc(d.AppInstalls, func(a store.AppInstall) bool {
+		return a.UserID == c.UserID && (c.ITunesStoreID != 0 && a.ITunesStoreID == c.ITunesStoreID || c.Identifier != "" && a.Identifier == c.Identifier)
+	})
+	a := store.AppInstall{
+		CommandUUID:     uuid,
+		ITunesStoreID:   c.ITunesStoreID,
+		Identifier:      c.Identifier,
+		UserID:          c.UserID,
+		ManagementFlags: c.ManagementFlags,
+		RequestedAt:     at,
+	}
+	if c.Options != nil {
+		a.PurchaseMethod = c.Options.PurchaseMethod
+	}
+	d.AppInstalls = append(d.AppInstalls, a)
+	appInstallVars.Add("requested", 1)
+}
+
+// setAppInstallState records that the install a is in state.
+func setAppInstallState(ctx context.Context, a *store.AppInstall, state string, at time.Time) {
+	a.State, a.UpdatedAt = state, &at
+	switch {
+	case state == "Managed":
+		a.ConfirmedAt = &at
+		appInstallVars.Add("confirmed", 1)
+		logFor(ctx).WithField("identifier", a.Identifier).Info("app install confirmed")
+	case slices.Contains(failedAppStates, state):
+		appInstallVars.Add("failed", 1)
+		logFor(ctx).WithFields(logrus.Fields{"identifier": a.Identifier, "state": state}).Warn("app install failed")
+	}
+}
+
+// applyInstallApplication records the app and state an InstallApplication
+// response reports. Installs the admin API did not send, such as those of
+// blueprints and rules, are tracked from their response on.
+func (s *Server) applyInstallApplication(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	res, err := webhook.ParseInstallApplication(ack.Raw)
+	if err != nil {
+		return false, err
+	}
+	if res.Identifier == "" {
+		return false, nil
+	}
+	// Only the latest install of an app on each channel is kept.
+	d.AppInstalls = slices.DeleteFunc(d.AppInstalls, func(a store.AppInstall) bool {
+		return a.Identifier == res.Identifier && a.UserID == ack.UserID && a.CommandUUID != ack.CommandUUID
+	})
+	i := slices.IndexFunc(d.AppInstalls, func(a store.AppInstall) bool { return a.CommandUUID == ack.CommandUUID })
+	if i < 0 {
+		d.AppInstalls = append(d.AppInstalls, store.AppInstall{CommandUUID: ack.CommandUUID, UserID: ack.UserID, RequestedAt: ack.Time})
+		i = len(d.AppInstalls) - 1
+	}
+	a := &d.AppInstalls[i]
+	a.Identifier = res.Identifier
+	logFor(ctx).WithFields(logrus.Fields{"identifier": res.Identifier, "state": res.State}).Info("device is installing application")
+	setAppInstallState(ctx, a, res.State, ack.Time)
+	return true, nil
+}
+
+// applyManagedApplicationList stores the managed apps d reported and
+// updates its installs on the channel of the response with their status.
+// Confirmed installs the list leaves out are marked removed. Lists from
+// the channels of users only update their installs.
+func (s *Server) applyManagedApplicationList(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	apps, err := webhook.ParseManagedApplicationList(ack.Raw)
+	if err != nil {
+		return false, err
+	}
+	if ack.UserID == "" {
+		d.ManagedApps = apps
+	}
+	for i := range d.AppInstalls {
+		a := &d.AppInstalls[i]
+		if a.Identifier == "" || a.UserID != ack.UserID {
+			continue
+		}
+		j := slices.IndexFunc(apps, func(app store.ManagedApp) bool { return app.Identifier == a.Identifier })
+		switch {
+		case a.ConfirmedAt != nil && j < 0:
+			if a.RemovedAt == nil {
+				a.RemovedAt = &ack.Time
+				appInstallVars.Add("removed", 1)
+				logFor(ctx).WithField("identifier", a.Identifier).Info("confirmed app is no longer managed")
+			}
+		case a.ConfirmedAt != nil:
+			// The app may since have been uninstalled, and reinstalled.
+			a.State, a.UpdatedAt, a.RemovedAt = apps[j].Status, &ack.Time, nil
+		case appInstallDone(a.State):
+		case j >= 0 && apps[j].Status != a.State:
+			setAppInstallState(ctx, a, apps[j].Status, ack.Time)
+		}
+	}
+	logFor(ctx).WithField("apps", len(apps)).Info("device reported managed applications")
+	return true, nil
+}
+
+// appInstallLoop periodically sends ManagedApplicationList to the devices
+// with installs not yet done, so their installs are confirmed. Installs the
+// device has not answered for yet are left to -command-timeout.
+func (s *Server) appInstallLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.checkAppInstalls(ctx)
+	}
+}
+
+func (s *Server) checkAppInstalls(ctx context.Context) {
+	devices, err := s.Devices.List()
+	if err != nil {
+		logrus.WithError(err).Error("list devices for app installs")
+		return
+	}
+	for _, d := range devices {
+		var channels []string
+		for _, a := range d.AppInstalls {
+			if a.Identifier != "" && !appInstallDone(a.State) && !slices.Contains(channels, a.UserID) {
+				channels = append(channels, a.UserID)
+			}
+		}
+		for _, userID := range channels {
+			if userID == "" {
+				s.sendCommandToDevice(ctx, d, "ManagedApplicationList")
+			} else {
+				s.sendCommand(ctx, Command{UDID: d.UDID, UserID: userID, RequestType: "ManagedApplicationList"})
+			}
+		}
+	}
+}
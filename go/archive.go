@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// archiver keeps the raw JSON of the webhook events received.
+type archiver interface {
+	write(raw []byte) error
+	// forTenant returns an archiver for a tenant's events, kept apart from
+	// the others.
+	forTenant(name string) (archiver, error)
+	io.Closer
+}
+
+// archiveTimeFormat names archive files after the time they were started.
+const archiveTimeFormat = "20060102T150405.000Z"
+
+// eventArchive appends the raw JSON of webhook events to NDJSON files in a
+// directory, one event per line, starting a new file when the current one
+// reaches maxSize bytes or maxAge. Finished files are gzipped if compress
+// is set.
+type eventArchive struct {
+	dir      string
+	maxSize  int64
+	maxAge   time.Duration
+	compress bool
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	started time.Time
+
+	// compressing tracks finished files being gzipped.
+	compressing sync.WaitGroup
+}
+
+// newEventArchive archives events in dir, creating it if needed. Files left
+// uncompressed by an earlier run are compressed in the background.
+func newEventArchive(dir string, maxSize int64, maxAge time.Duration, compress bool) (*eventArchive, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create archive directory: %v", err)
+	}
+	a := &eventArchive{dir: dir, maxSize: maxSize, maxAge: maxAge, compress: compress}
+	if compress {
+		leftover, err := filepath.Glob(filepath.Join(dir, "events-*.ndjson"))
+		if err != nil {
+			return nil, fmt.Errorf("list archive files: %v", err)
+		}
+		for _, name := range leftover {
+			a.compressFile(name)
+		}
+	}
+	return a, nil
+}
+
+// forTenant returns an archive in a subdirectory named after the tenant.
+func (a *eventArchive) forTenant(name string) (archiver, error) {
+	return newEventArchive(filepath.Join(a.dir, name), a.maxSize, a.maxAge, a.compress)
+}
+
+// write appends the raw JSON of an event to the archive.
+func (a *eventArchive) write(raw []byte) error {
+	var line bytes.Buffer
+	if err := json.Compact(&line, raw); err != nil {
+		return fmt.Errorf("compact event: %v", err)
+	}
+	line.WriteByte('\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.f != nil && (a.maxSize > 0 && a.size+int64(line.Len()) > a.maxSize || a.maxAge > 0 && time.Since(a.started) >= a.maxAge) {
+		if err := a.finish(); err != nil {
+			return err
+		}
+	}
+	if a.f == nil {
+		a.started = time.Now().UTC()
+		name := filepath.Join(a.dir, "events-"+a.started.Format(archiveTimeFormat)+".ndjson")
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("open archive file: %v", err)
+		}
+		a.f, a.size = f, 0
+	}
+	n, err := a.f.Write(line.Bytes())
+	a.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("write archive file: %v", err)
+	}
+	return nil
+}
+
+// finish closes the current file and starts compressing it. It must be
+// called with a.mu held.
+func (a *eventArchive) finish() error {
+	name := a.f.Name()
+	err := a.f.Close()
+	a.f = nil
+	if err != nil {
+		return fmt.Errorf("close archive file: %v", err)
+	}
+	if a.compress {
+		a.compressFile(name)
+	}
+	return nil
+}
+
+// compressFile gzips name to name.gz in the background, removing name once
+// it is done.
+func (a *eventArchive) compressFile(name string) {
+	a.compressing.Add(1)
+	go func() {
+		defer a.compressing.Done()
+		if err := gzipFile(name); err != nil {
+			logrus.WithError(err).WithField("file", name).Error("compress archive file")
+		}
+	}()
+}
+
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := name + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, strings.TrimSuffix(tmp, ".tmp"))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Close closes the current file and waits for compression to finish.
+func (a *eventArchive) Close() error {
+	a.mu.Lock()
+	var err error
+	if a.f != nil {
+		err = a.finish()
+	}
+	a.mu.Unlock()
+	a.compressing.Wait()
+	return err
+}
+
+// readArchive calls fn with the raw JSON of each event archived in paths,
+// in order. A path is an archive file, plain or gzipped, or a directory, in
+// which case the archive files directly in it are read in order of name,
+// and so of time.
+func readArchive(paths []string, fn func(raw []byte) error) error {
+	for _, p := range paths {
+		fi, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		files := []string{p}
+		if fi.IsDir() {
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				return err
+			}
+			files = files[:0]
+			for _, e := range entries {
+				if name := e.Name(); !e.IsDir() && strings.HasPrefix(name, "events-") && (strings.HasSuffix(name, ".ndjson") || strings.HasSuffix(name, ".ndjson.gz")) {
+					files = append(files, filepath.Join(p, name))
+				}
+			}
+		}
+		for _, name := range files {
+			if err := readArchiveFile(name, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readArchiveFile(name string, fn func(raw []byte) error) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	if err := scanEvents(r, fn); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// scanEvents calls fn with each line of r that is not blank.
+func scanEvents(r io.Reader, fn func(raw []byte) error) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxWebhookBody+1)
+	for sc.Scan() {
+		if line := bytes.TrimSpace(sc.Bytes()); len(line) > 0 {
+			if err := fn(line); err != nil {
+				return err
+			}
+		}
+	}
+	return sc.Err()
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// maxS3Batches is how many batches of events are kept in memory while S3
+// cannot be reached, before the oldest events are dropped.
+const maxS3Batches = 100
+
+// S3ArchiveOptions configures an s3Archive.
+type S3ArchiveOptions struct {
+	Bucket string
+	// Prefix is prepended to the keys of the objects written, e.g.
+	// "micromdm/events/".
+	Prefix string
+	// Endpoint overrides the S3 endpoint for S3-compatible storage such as
+	// MinIO. Buckets are then addressed by path.
+	Endpoint string
+	// BatchSize is the number of events written to each object.
+	BatchSize int
+	// FlushInterval is the longest events wait before being written.
+	FlushInterval time.Duration
+}
+
+// s3Archive writes the raw JSON of webhook events to an S3 bucket in
+// batches, each a gzipped NDJSON object under
+// <prefix>YYYY/MM/DD/events-<time>-<id>.ndjson.gz. Objects are uploaded in
+// the background; batches that fail are retried at the next flush.
+type s3Archive struct {
+	client *s3.Client
+	opts   S3ArchiveOptions
+
+	mu     sync.Mutex
+	events [][]byte
+
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// newS3Archive archives events in the bucket named by opts. Credentials and
+// region come from the standard AWS environment, shared config, or task
+// role.
+func newS3Archive(opts S3ArchiveOptions) (*s3Archive, error) {
+	client, err := newS3Client(opts.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return startS3Archive(client, opts), nil
+}
+
+func newS3Client(endpoint string) (*s3.Client, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("load AWS config: %v", err)
+	}
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	}), nil
+}
+
+func startS3Archive(client *s3.Client, opts S3ArchiveOptions) *s3Archive {
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	a := &s3Archive{
+		client: client,
+		opts:   opts,
+		flush:  make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go a.loop()
+	return a
+}
+
+// forTenant returns an archive writing to the same bucket under the
+// tenant's own prefix.
+func (a *s3Archive) forTenant(name string) (archiver, error) {
+	opts := a.opts
+	opts.Prefix = path.Join(opts.Prefix, name) + "/"
+	return startS3Archive(a.client, opts), nil
+}
+
+func (a *s3Archive) write(raw []byte) error {
+	var line bytes.Buffer
+	if err := json.Compact(&line, raw); err != nil {
+		return fmt.Errorf("compact event: %v", err)
+	}
+	a.mu.Lock()
+	a.events = append(a.events, line.Bytes())
+	if n := len(a.events) - maxS3Batches*a.opts.BatchSize; n > 0 {
+		a.events = a.events[n:]
+		logrus.WithField("dropped", n).Error("S3 archive is too far behind, dropping oldest events")
+	}
+	full := len(a.events) >= a.opts.BatchSize
+	a.mu.Unlock()
+	if full {
+		select {
+		case a.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (a *s3Archive) loop() {
+	defer close(a.done)
+	interval := a.opts.FlushInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.upload(true)
+		case <-a.flush:
+			a.upload(false)
+		case <-a.stop:
+			a.upload(true)
+			return
+		}
+	}
+}
+
+// upload writes the buffered events in batches of opts.BatchSize, and the
+// last, smaller batch too if partial is set. It stops at the first batch
+// that fails, keeping it to be retried.
+func (a *s3Archive) upload(partial bool) {
+	for {
+		a.mu.Lock()
+		n := min(len(a.events), a.opts.BatchSize)
+		if n == 0 || n < a.opts.BatchSize && !partial {
+			a.mu.Unlock()
+			return
+		}
+		batch := a.events[:n:n]
+		a.events = a.events[n:]
+		a.mu.Unlock()
+
+		if err := a.put(batch); err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{"bucket": a.opts.Bucket, "events": n}).Error("upload events to S3")
+			a.mu.Lock()
+			a.events = append(batch, a.events...)
+			a.mu.Unlock()
+			return
+		}
+	}
+}
+
+func (a *s3Archive) put(batch [][]byte) error {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	for _, line := range batch {
+		zw.Write(line)
+		zw.Write([]byte{'\n'})
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	key := a.opts.Prefix + now.Format("2006/01/02/") + "events-" + now.Format(archiveTimeFormat) + "-" + newRequestID() + ".ndjson.gz"
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err := a.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:          aws.String(a.opts.Bucket),
+		Key:             aws.String(key),
+		Body:            bytes.NewReader(body.Bytes()),
+		ContentType:     aws.String("application/x-ndjson"),
+		ContentEncoding: aws.String("gzip"),
+	})
+	return err
+}
+
+// Close writes the events still buffered and stops the archive.
+func (a *s3Archive) Close() error {
+	close(a.stop)
+	<-a.done
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.events) > 0 {
+		return fmt.Errorf("%d events could not be written to S3", len(a.events))
+	}
+	return nil
+}
+
+// s3ArchiveKey matches the keys of the objects written by s3Archive, after
+// its prefix. Tenants' objects, under a further prefix, do not match.
+var s3ArchiveKey = regexp.MustCompile(`^\d{4}/\d{2}/\d{2}/events-[^/]+\.ndjson\.gz$`)
+
+// readS3Archive calls fn with the raw JSON of each event archived by an
+// s3Archive with the same bucket and prefix, in order.
+func readS3Archive(ctx context.Context, opts S3ArchiveOptions, fn func(raw []byte) error) error {
+	client, err := newS3Client(opts.Endpoint)
+	if err != nil {
+		return err
+	}
+	pages := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(opts.Bucket),
+		Prefix: aws.String(opts.Prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("list S3 archive: %v", err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if !s3ArchiveKey.MatchString(strings.TrimPrefix(key, opts.Prefix)) {
+				continue
+			}
+			if err := readS3Object(ctx, client, opts.Bucket, key, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readS3Object(ctx context.Context, client *s3.Client, bucket, key string, fn func(raw []byte) error) error {
+	out, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return fmt.Errorf("get %s: %v", key, err)
+	}
+	defer out.Body.Close()
+	zr, err := gzip.NewReader(out.Body)
+	if err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	defer zr.Close()
+	if err := scanEvents(zr, fn); err != nil {
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	return nil
+}
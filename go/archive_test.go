@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/groob/plist"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+)
+
+// recordingArchive keeps the events written to it.
+type recordingArchive struct{ events [][]byte }
+
+func (a *recordingArchive) write(raw []byte) error {
+	a.events = append(a.events, append([]byte(nil), raw...))
+	return nil
+}
+func (a *recordingArchive) forTenant(string) (archiver, error) { return a, nil }
+func (a *recordingArchive) Close() error                       { return nil }
+
+func TestArchivedEventsLeaveOutSecrets(t *testing.T) {
+	payload, err := plist.Marshal(map[string]interface{}{
+		"MessageType": "TokenUpdate",
+		"UDID":        "U",
+		"UnlockToken": []byte("UNLOCK-SECRET"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"topic":         "mdm.TokenUpdate",
+		"event_id":      "1",
+		"created_at":    "2026-10-14T10:00:00Z",
+		"checkin_event": map[string]interface{}{"udid": "U", "raw_payload": payload},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &recordingArchive{}
+	s := NewServer("http://mdm.invalid", "", store.NewMemoryStore())
+	s.Archives = []archiver{a}
+	s.SkipCommands = true
+	w := httptest.NewRecorder()
+	s.webhookHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(body)))
+	if len(a.events) != 1 {
+		t.Fatalf("archived %d events, want 1", len(a.events))
+	}
+	event, err := webhook.DecodeEvent(a.events[0])
+	if err != nil {
+		t.Fatalf("decode archived event: %v", err)
+	}
+	var archived map[string]interface{}
+	if err := plist.Unmarshal(event.CheckinEvent.RawPayload, &archived); err != nil {
+		t.Fatalf("decode archived payload: %v", err)
+	}
+	if _, ok := archived["UnlockToken"]; ok {
+		t.Errorf("archived event carries the UnlockToken: %v", archived)
+	}
+	if archived["UDID"] != "U" {
+		t.Errorf("archived event lost the UDID: %v", archived)
+	}
+}
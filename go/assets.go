@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// assetVars counts the imported rows of expected devices (imported), those
+// rejected (rejected), and the enrollments of devices not on the imported
+// lists (unexpected).
+var assetVars = expvar.NewMap("assets")
+
+// assetPlaceholderPrefix starts the UDIDs of the device records created for
+// the imported expected devices that have not enrolled, followed by their
+// UDID if the list gave one and their serial number otherwise.
+const assetPlaceholderPrefix = "asset:"
+
+// maxImportBody bounds the size of imported lists of expected devices.
+const maxImportBody = 16 << 20
+
+// isPlaceholder reports whether d is the record of a device that has not
+// enrolled, created from DEP or an imported list.
+func isPlaceholder(d Device) bool {
+	return isDEPPlaceholder(d) || strings.HasPrefix(d.UDID, assetPlaceholderPrefix)
+}
+
+// adoptPlaceholders gives d, an enrolling device, the DEP record, asset, and
+// tags of the placeholders of its UDID and serial number, and returns their
+// UDIDs for deleting once d is saved.
+func (s *Server) adoptPlaceholders(ctx context.Context, d *Device) []string {
+	if isPlaceholder(*d) {
+		return nil
+	}
+	udids := []string{assetPlaceholderPrefix + d.UDID}
+	if serial := deviceSerial(*d); serial != "" {
+		udids = append(udids, depPlaceholderUDID(serial), assetPlaceholderPrefix+serial)
+	}
+	var adopted []string
+	for _, udid := range udids {
+		p, err := s.Devices.Get(udid)
+		if err == store.ErrDeviceNotFound {
+			continue
+		}
+		if err != nil {
+			logFor(ctx).WithError(err).WithField("placeholder", udid).Error("load placeholder")
+			continue
+		}
+		if p.DEP != nil && (d.DEP == nil || !d.DEP.Assigned) {
+			d.DEP = p.DEP
+		}
+		if p.Asset != nil && (d.Asset == nil || !d.Asset.Expected) {
+			d.Asset = p.Asset
+		}
+		for _, tag := range p.Tags {
+			d.AddTag(tag)
+		}
+		adopted = append(adopted, udid)
+	}
+	if len(adopted) > 0 {
+		logFor(ctx).WithField("placeholders", adopted).Info("enrolling device listed ahead of enrollment")
+	}
+	return adopted
+}
+
+// matchAssets reports false if lists of expected devices were imported and
+// d, an enrolling device that took over its placeholders, is on none of
+// them, marking it unexpected.
+func (s *Server) matchAssets(ctx context.Context, d *Device) bool {
+	if d.Asset != nil && d.Asset.Expected {
+		return true
+	}
+	devices, err := s.Devices.List()
+	if err != nil {
+		logFor(ctx).WithError(err).Error("list devices for expected devices")
+		return true
+	}
+	imported := false
+	for _, other := range devices {
+		if other.UDID != d.UDID && other.Asset != nil && other.Asset.Expected {
+			imported = true
+			break
+		}
+	}
+	if !imported {
+		return true
+	}
+	if d.Asset == nil {
+		d.Asset = &store.Asset{}
+	}
+	d.Asset.UpdatedAt = d.LastSeen
+	return false
+}
+
+// notifyUnexpectedDevice logs, counts, and notifies about the enrollment of
+// d, a device not on the imported lists, once it is saved.
+func (s *Server) notifyUnexpectedDevice(ctx context.Context, d Device, event webhook.Event) {
+	assetVars.Add("unexpected", 1)
+	logFor(ctx).WithField("serial_number", deviceSerial(d)).Warn("device enrolling is not on the list of expected devices")
+	s.notify(ctx, notifyUnexpected, d, event)
+}
+
+// importResult is the response of handleImportDevices.
+type importResult struct {
+	Created int           `json:"created"`
+	Updated int           `json:"updated"`
+	Errors  []importError `json:"errors,omitempty"`
+}
+
+// importError is a row of an imported list that was left out.
+type importError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// assetColumns are the columns of imported lists, by the names their header
+// row may give them.
+var assetColumns = map[string]string{
+	"serial_number": "serial_number",
+	"serial":        "serial_number",
+	"udid":          "udid",
+	"owner":         "owner",
+	"tags":          "tags",
+}
+
+// handleImportDevices imports a CSV list of expected devices. Its header row
+// names the columns: serial_number (or serial) and udid, at least one of
+// which each row must fill, and optionally owner and tags, separated by
+// spaces or semicolons. Other columns are ignored. Each device is marked
+// expected, with its owner and tags, on its record or, until it enrolls, on
+// a placeholder record.
+func (s *Server) handleImportDevices(w http.ResponseWriter, r *http.Request) {
+	rows := csv.NewReader(io.LimitReader(r.Body, maxImportBody))
+	rows.FieldsPerRecord = -1
+	rows.TrimLeadingSpace = true
+	header, err := rows.Read()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("read CSV header: %v", err), http.StatusBadRequest)
+		return
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		if col, ok := assetColumns[strings.ToLower(strings.TrimSpace(name))]; ok {
+			columns[col] = i
+		}
+	}
+	_, hasSerial := columns["serial_number"]
+	_, hasUDID := columns["udid"]
+	if !hasSerial && !hasUDID {
+		http.Error(w, "CSV header has neither a serial_number nor a udid column", http.StatusBadRequest)
+		return
+	}
+
+	devices, err := s.Devices.List()
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("list devices")
+		http.Error(w, fmt.Sprintf("list devices: %v", err), http.StatusInternalServerError)
+		return
+	}
+	byUDID := make(map[string]Device, len(devices))
+	for _, d := range devices {
+		byUDID[d.UDID] = d
+	}
+	bySerial := devicesBySerial(devices)
+
+	now := time.Now().UTC()
+	var result importResult
+	for {
+		record, err := rows.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				http.Error(w, fmt.Sprintf("read CSV: %v", err), http.StatusBadRequest)
+				return
+			}
+			result.Errors = append(result.Errors, importError{Line: parseErr.Line, Error: parseErr.Err.Error()})
+			continue
+		}
+		line, _ := rows.FieldPos(0)
+		field := func(col string) string {
+			if i, ok := columns[col]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		udid, serial := field("udid"), field("serial_number")
+		if udid == "" && serial == "" {
+			result.Errors = append(result.Errors, importError{Line: line, Error: "no serial number or UDID"})
+			continue
+		}
+		tags := strings.FieldsFunc(field("tags"), func(r rune) bool { return r == ';' || unicode.IsSpace(r) })
+		if err := validateTagList(tags); err != nil {
+			result.Errors = append(result.Errors, importError{Line: line, Error: err.Error()})
+			continue
+		}
+
+		d, ok := byUDID[udid]
+		if !ok && udid != "" {
+			d, ok = byUDID[assetPlaceholderPrefix+udid]
+		}
+		if !ok && serial != "" {
+			d, ok = bySerial[serial]
+		}
+		if !ok {
+			key := udid
+			if key == "" {
+				key = serial
+			}
+			d = Device{UDID: assetPlaceholderPrefix + key, LastSeen: now}
+			if serial != "" {
+				d.Info = &DeviceInfo{SerialNumber: serial}
+			}
+		}
+		d.Asset = &store.Asset{Expected: true, Owner: field("owner"), ImportedAt: &now, UpdatedAt: now}
+		for _, tag := range tags {
+			d.AddTag(tag)
+		}
+		if err := s.Devices.Save(d); err != nil {
+			logFor(r.Context()).WithError(err).WithField("udid", d.UDID).Error("save expected device")
+			result.Errors = append(result.Errors, importError{Line: line, Error: fmt.Sprintf("save device: %v", err)})
+			continue
+		}
+		if ok {
+			result.Updated++
+		} else {
+			result.Created++
+		}
+		byUDID[d.UDID] = d
+		if prev, found := bySerial[serial]; serial != "" && (!found || prev.UDID == d.UDID) {
+			bySerial[serial] = d
+		}
+	}
+	assetVars.Add("imported", int64(result.Created+result.Updated))
+	assetVars.Add("rejected", int64(len(result.Errors)))
+	logFor(r.Context()).WithFields(logrus.Fields{"created": result.Created, "updated": result.Updated, "rejected": len(result.Errors)}).Info("imported expected devices")
+	writeJSON(w, http.StatusOK, result)
+}
+
+// validateTagList returns the error of the first of tags validateTag rejects.
+func validateTagList(tags []string) error {
+	for _, tag := range tags {
+		if err := validateTag(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
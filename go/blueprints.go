@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// blueprintVars counts the devices each blueprint was applied to, keyed by
+// its name.
+var blueprintVars = expvar.NewMap("blueprints")
+
+// reconcileVars counts the profiles installed and removed to bring devices
+// in step with their blueprints.
+var reconcileVars = expvar.NewMap("profile_reconciliation")
+
+// Blueprint is what a device is set up with when it enrolls: configuration
+// profiles to install, apps, and commands to send. The first blueprint of
+// the config file matching a device is applied on its first TokenUpdate.
+type Blueprint struct {
+	// Name identifies the blueprint in logs, /debug/vars, and the device's
+	// record.
+	Name string `yaml:"name"`
+
+	// Match selects the devices the blueprint is for. A blueprint without
+	// conditions matches every device.
+	Match BlueprintMatch `yaml:"match"`
+
+	// Profiles are the paths of .mobileconfig files to install, relative to
+	// the config file. XML profiles are templates executed with the device.
+	Profiles []string `yaml:"profiles"`
+
+	// Apps are installed with InstallApplication.
+	Apps []BlueprintApp `yaml:"apps"`
+
+	// Declarations are the paths of Declarative Device Management
+	// declarations, JSON files relative to the config file with the Type,
+	// Identifier, Payload, and optionally ServerToken of a declaration.
+	// They are served to devices through /declarative-management/ after a
+	// DeclarativeManagement command following the profiles; without an
+	// activation among them, one activating all their configurations is
+	// added.
+	Declarations []string `yaml:"declarations"`
+
+	// FallbackProfiles are installed, like Profiles, instead of the
+	// declarations on devices too old for them (macOS before 13, and iOS,
+	// iPadOS, and tvOS before 16).
+	FallbackProfiles []string `yaml:"fallback-profiles"`
+
+	// UserProfiles are installed, like Profiles, on the channel of each
+	// macOS user of the device when the user first logs in with MDM, for
+	// settings that apply to the user rather than the Mac.
+	UserProfiles []string `yaml:"user-profiles"`
+
+	// Munki, if set, bootstraps Munki on Macs after the profiles, and can
+	// have their inventory sent to MunkiReport.
+	Munki *BlueprintMunki `yaml:"munki"`
+
+	// Osquery, if set, enrolls Macs in osquery after the profiles, and
+	// links them to their hosts in Fleet.
+	Osquery *BlueprintOsquery `yaml:"osquery"`
+
+	// Settings, if set, are sent with a Settings command after the apps,
+	// e.g. to name the device.
+	Settings *DeviceSettings `yaml:"settings"`
+
+	// Commands are the request types sent after the profiles, apps, and
+	// settings, in addition to those sent on every TokenUpdate, e.g.
+	// DeviceConfigured to release a device held in Setup Assistant.
+	Commands []string `yaml:"commands"`
+
+	// Reconcile keeps the profiles of devices set up with the blueprint in
+	// step with it: whenever a device answers ProfileList, the blueprint's
+	// profiles it lacks are installed, and the other profiles installed by
+	// MDM are removed, except those matching Keep (glob patterns of profile
+	// identifiers) and -expected-profiles.
+	Reconcile bool         `yaml:"reconcile"`
+	Keep      RulePatterns `yaml:"keep"`
+
+	// profiles, fallbackProfiles, userProfiles, and declarations hold the
+	// contents of Profiles, FallbackProfiles, UserProfiles, and
+	// Declarations.
+	profiles         []*profile
+	fallbackProfiles []*profile
+	userProfiles     []*profile
+	declarations     *declarationSet
+}
+
+// BlueprintMatch are the conditions a device must meet for a blueprint, all
+// of which must hold.
+type BlueprintMatch struct {
+	// Model are glob patterns (as in path.Match) one of which the device's
+	// model, model name, or product name must match, e.g. "MacBook*" or
+	// "iPad*".
+	Model RulePatterns `yaml:"model"`
+
+	// DEP, when set, requires the device to be enrolling through Automated
+	// Device Enrollment (true), or not (false). Devices are told apart by
+	// the AwaitingConfiguration flag of their first TokenUpdate.
+	DEP *bool `yaml:"dep"`
+
+	// Tag are tags one of which the device must have, for devices tagged
+	// ahead of enrollment.
+	Tag RulePatterns `yaml:"tag"`
+
+	// Tags is a tag expression the device must match, e.g.
+	// "kiosk AND NOT loaner".
+	Tags *TagExpr `yaml:"tags"`
+
+	// Platform are the platforms (macOS, iOS, iPadOS, or tvOS) one of which
+	// the device must be on, e.g. to give Macs and iPhones their own
+	// blueprints.
+	Platform RulePatterns `yaml:"platform"`
+}
+
+// BlueprintApp is an app of a blueprint, given by the URL of its manifest,
+// its App Store ID, or the name of a package of -app-dir, which is installed
+// with InstallEnterpriseApplication. App Store apps can set the purchase
+// method and management flags of the admin API's app installs.
+type BlueprintApp struct {
+	ManifestURL     string `yaml:"manifest-url"`
+	ITunesStoreID   int64  `yaml:"itunes-store-id"`
+	Package         string `yaml:"package"`
+	PurchaseMethod  int64  `yaml:"purchase-method"`
+	ManagementFlags int    `yaml:"management-flags"`
+}
+
+func (b Blueprint) validate() error {
+	for _, p := range b.Match.Model {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("match: model pattern %q: %v", p, err)
+		}
+	}
+	for _, p := range b.Match.Platform {
+		if !slices.ContainsFunc(platforms, func(platform string) bool { return strings.EqualFold(p, platform) }) {
+			return fmt.Errorf("match: unknown platform %q; want one of %s", p, strings.Join(platforms, ", "))
+		}
+	}
+	for _, p := range b.Keep {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("keep: pattern %q: %v", p, err)
+		}
+	}
+	for i, a := range b.Apps {
+		set := 0
+		for _, ok := range []bool{a.ManifestURL != "", a.ITunesStoreID != 0, a.Package != ""} {
+			if ok {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("app %d: set exactly one of manifest-url, itunes-store-id, or package", i+1)
+		}
+		if a.ITunesStoreID != 0 {
+			if err := (AppInstallRequest{ITunesStoreID: a.ITunesStoreID, PurchaseMethod: a.PurchaseMethod, ManagementFlags: a.ManagementFlags}).validate(); err != nil {
+				return fmt.Errorf("app %d: %v", i+1, err)
+			}
+		} else if a.PurchaseMethod != 0 {
+			return fmt.Errorf("app %d: purchase-method is only for apps with itunes-store-id", i+1)
+		}
+		if a.Package != "" && a.ManagementFlags != 0 {
+			return fmt.Errorf("app %d: InstallEnterpriseApplication takes no management-flags", i+1)
+		}
+	}
+	if b.Settings != nil {
+		if err := b.Settings.validate(); err != nil {
+			return err
+		}
+	}
+	if b.Munki != nil {
+		if err := b.Munki.validate(); err != nil {
+			return err
+		}
+	}
+	if b.Osquery != nil {
+		if err := b.Osquery.validate(); err != nil {
+			return err
+		}
+	}
+	if len(b.FallbackProfiles) > 0 && len(b.Declarations) == 0 {
+		return fmt.Errorf("fallback-profiles are only for blueprints with declarations")
+	}
+	for _, requestType := range b.Commands {
+		if _, _, err := parseCommandPayload([]byte(fmt.Sprintf(`{"request_type": %q}`, requestType))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadProfiles reads the blueprint's profiles, declarations, and Munki and
+// osquery profiles, resolving relative paths against dir.
+func (b *Blueprint) loadProfiles(dir string) error {
+	for _, path := range b.Profiles {
+		p, err := loadProfile(path, dir)
+		if err != nil {
+			return err
+		}
+		b.profiles = append(b.profiles, p)
+	}
+	for _, path := range b.FallbackProfiles {
+		p, err := loadProfile(path, dir)
+		if err != nil {
+			return err
+		}
+		b.fallbackProfiles = append(b.fallbackProfiles, p)
+	}
+	for _, path := range b.UserProfiles {
+		p, err := loadProfile(path, dir)
+		if err != nil {
+			return err
+		}
+		b.userProfiles = append(b.userProfiles, p)
+	}
+	if len(b.Declarations) > 0 {
+		ds, err := loadDeclarations(b.Name, b.Declarations, dir)
+		if err != nil {
+			return err
+		}
+		b.declarations = ds
+	}
+	if b.Munki != nil {
+		if err := b.Munki.load(dir); err != nil {
+			return err
+		}
+	}
+	if b.Osquery != nil {
+		if err := b.Osquery.load(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matches reports whether the blueprint is for d, enrolling through DEP or
+// not.
+func (b *Blueprint) matches(d Device, dep bool) bool {
+	m := b.Match
+	if m.DEP != nil && *m.DEP != dep {
+		return false
+	}
+	if len(m.Model) > 0 {
+		var models []string
+		if d.Info != nil {
+			models = []string{d.Info.Model, d.Info.ModelName, d.Info.ProductName}
+		}
+		if !slices.ContainsFunc(models, func(model string) bool { return model != "" && matchAny(m.Model, model) }) {
+			return false
+		}
+	}
+	if m.Tags != nil && !m.Tags.matches(d) {
+		return false
+	}
+	if len(m.Platform) > 0 && !slices.ContainsFunc(m.Platform, func(p string) bool { return strings.EqualFold(p, d.Platform) }) {
+		return false
+	}
+	if len(m.Tag) > 0 && !slices.ContainsFunc(d.Tags, func(tag string) bool { return matchAny(m.Tag, tag) }) {
+		return false
+	}
+	return true
+}
+
+// matchAny reports whether value matches one of patterns.
+func matchAny(patterns []string, value string) bool {
+	return slices.ContainsFunc(patterns, func(p string) bool {
+		ok, _ := path.Match(p, value)
+		return ok
+	})
+}
+
+// addBlueprints sets up blueprints to be applied to enrolling devices.
+func (s *Server) addBlueprints(blueprints []Blueprint) {
+	for i := range blueprints {
+		b := &blueprints[i]
+		blueprintVars.Set(b.Name, new(expvar.Int))
+		s.Blueprints = append(s.Blueprints, b)
+	}
+}
+
+// blueprintFor returns the first blueprint matching d, or nil.
+func (s *Server) blueprintFor(d Device, dep bool) *Blueprint {
+	for _, b := range s.Blueprints {
+		if b.matches(d, dep) {
+			return b
+		}
+	}
+	return nil
+}
+
+// blueprintNamed returns the blueprint called name, or nil.
+func (s *Server) blueprintNamed(name string) *Blueprint {
+	for _, b := range s.Blueprints {
+		if b.Name == name {
+			return b
+		}
+	}
+	return nil
+}
+
+// applyBlueprint sends d the profiles, apps, settings, and commands of b.
+// Devices that take declarations are sent a DeclarativeManagement command
+// after the profiles, and those too old for them, marked as such in
+// handleTokenUpdate, the fallback profiles. They are sent one after the other rather than through s.Queue, whose
+// workers would reorder them: a DeviceConfigured sent before the profiles
+// would release the device from Setup Assistant unconfigured. Its App Store
+// installs are tracked in d's AppInstalls.
+func (s *Server) applyBlueprint(ctx context.Context, d Device, b *Blueprint) {
+	logFor(ctx).WithFields(logrus.Fields{"blueprint": b.Name, "profiles": len(b.profiles), "apps": len(b.Apps)}).Info("applying blueprint")
+	blueprintVars.Add(b.Name, 1)
+	var commands []Command
+	for _, p := range b.profiles {
+		c, err := s.installProfileCommand(ctx, d, "", p)
+		if err != nil {
+			logFor(ctx).WithError(err).Error("apply blueprint")
+			continue
+		}
+		commands = append(commands, c)
+	}
+	if b.declarations != nil {
+		if dm := d.DeclarativeManagement; dm != nil && dm.Fallback {
+			for _, p := range b.fallbackProfiles {
+				c, err := s.installProfileCommand(ctx, d, "", p)
+				if err != nil {
+					logFor(ctx).WithError(err).Error("apply blueprint")
+					continue
+				}
+				commands = append(commands, c)
+			}
+			ddmVars.Add("fallbacks", 1)
+			logFor(ctx).WithField("os_version", d.Info.OSVersion).Info("device is too old for declarations; sending fallback profiles")
+		} else if c, err := declarativeManagementCommand(d, b.declarations); err != nil {
+			logFor(ctx).WithError(err).Error("apply blueprint")
+		} else {
+			commands = append(commands, c)
+		}
+	}
+	if b.Munki != nil && d.Platform == platformMacOS {
+		commands = append(commands, s.munkiCommands(ctx, d, b.Munki)...)
+	}
+	if b.Osquery != nil && d.Platform == platformMacOS {
+		commands = append(commands, s.osqueryCommands(ctx, d, b.Osquery)...)
+	}
+	for _, a := range b.Apps {
+		if a.ITunesStoreID != 0 {
+			commands = append(commands, installAppCommand(d.UDID, "", AppInstallRequest{ITunesStoreID: a.ITunesStoreID, PurchaseMethod: a.PurchaseMethod, ManagementFlags: a.ManagementFlags}))
+			continue
+		}
+		if a.Package != "" {
+			if s.EnterpriseApps == nil {
+				logFor(ctx).WithField("package", a.Package).Error("apply blueprint: enterprise apps are not hosted; set -app-dir")
+				continue
+			}
+			manifestURL, err := s.EnterpriseApps.manifestURL(a.Package)
+			if err != nil {
+				logFor(ctx).WithField("package", a.Package).WithError(err).Error("apply blueprint")
+				continue
+			}
+			commands = append(commands, enterpriseAppCommand(d.UDID, manifestURL))
+			continue
+		}
+		commands = append(commands, Command{UDID: d.UDID, RequestType: "InstallApplication", ManifestURL: a.ManifestURL, ManagementFlags: a.ManagementFlags})
+	}
+	if b.Settings != nil {
+		c, err := settingsCommand(d, *b.Settings)
+		if err != nil {
+			logFor(ctx).WithError(err).Error("apply blueprint")
+		} else {
+			commands = append(commands, c)
+		}
+	}
+	for _, requestType := range b.Commands {
+		if skipUnsupported(ctx, d, requestType) {
+			continue
+		}
+		c := Command{UDID: d.UDID, RequestType: requestType}
+		if requestType == "DeviceInformation" {
+			c.Queries = webhook.DeviceInformationQueries
+		}
+		commands = append(commands, c)
+	}
+	tracked := false
+	for _, c := range commands {
+		if c.RequestType != "InstallApplication" || c.ITunesStoreID == 0 {
+			s.sendCommandNow(ctx, c)
+			continue
+		}
+		// App Store installs are tracked, as those of the admin API are.
+		uuid, err := s.postCommand(ctx, c.UDID, c.RequestType, c)
+		if err != nil {
+			logFor(ctx).WithFields(logrus.Fields{"udid": c.UDID, "request_type": c.RequestType}).WithError(err).Error("send command")
+		} else if uuid != "" {
+			recordAppInstall(&d, c, uuid, time.Now().UTC())
+			tracked = true
+		}
+	}
+	if tracked {
+		if err := s.Devices.Save(d); err != nil {
+			logFor(ctx).WithError(err).Error("save app install")
+		}
+	}
+}
+
+// reconcileProfiles installs the profiles of d's blueprint that d lacks and
+// removes the other profiles MDM installed on it, if the blueprint says so.
+// It runs after applyProfileList has stored the profiles d reported.
+func (s *Server) reconcileProfiles(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	b := s.blueprintNamed(d.Blueprint)
+	if b == nil || !b.Reconcile {
+		return false, nil
+	}
+	installed := make(map[string]bool, len(d.Profiles))
+	for _, p := range d.Profiles {
+		installed[p.Identifier] = true
+	}
+	var install []Command
+	want := make(map[string]bool, len(b.profiles))
+	for _, p := range b.profiles {
+		payload, err := p.render(s.profileVars(*d, ""))
+		var id string
+		if err == nil {
+			id, err = profileIdentifier(payload)
+		}
+		if err != nil {
+			// Without every identifier, the blueprint's own profiles
+			// could be removed.
+			logFor(ctx).WithError(err).WithField("blueprint", b.Name).Error("reconcile profiles")
+			return false, nil
+		}
+		want[id] = true
+		if installed[id] {
+			continue
+		}
+		c, err := s.profileCommand(ctx, *d, p, payload)
+		if err != nil {
+			logFor(ctx).WithError(err).WithField("blueprint", b.Name).Error("reconcile profiles")
+			continue
+		}
+		install = append(install, c)
+	}
+	if m := b.Munki; m != nil && d.Platform == platformMacOS {
+		id, err := m.profileIdentifier(s.profileVars(*d, ""))
+		if err != nil {
+			logFor(ctx).WithError(err).WithField("blueprint", b.Name).Error("reconcile profiles")
+			return false, nil
+		}
+		want[id] = true
+		if !installed[id] {
+			if c, err := s.munkiProfileCommand(ctx, *d, m); err != nil {
+				logFor(ctx).WithError(err).WithField("blueprint", b.Name).Error("reconcile profiles")
+			} else {
+				install = append(install, c)
+			}
+		}
+	}
+	if o := b.Osquery; o != nil && o.hasProfile() && d.Platform == platformMacOS {
+		id, err := o.profileIdentifier(s.profileVars(*d, ""))
+		if err != nil {
+			logFor(ctx).WithError(err).WithField("blueprint", b.Name).Error("reconcile profiles")
+			return false, nil
+		}
+		want[id] = true
+		if !installed[id] {
+			if c, err := s.osqueryProfileCommand(ctx, *d, o); err != nil {
+				logFor(ctx).WithError(err).WithField("blueprint", b.Name).Error("reconcile profiles")
+			} else {
+				install = append(install, c)
+			}
+		}
+	}
+	var remove []string
+	for _, p := range d.Profiles {
+		if p.IsManaged && !want[p.Identifier] && !matchAny(b.Keep, p.Identifier) && !slices.Contains(s.ExpectedProfiles, p.Identifier) {
+			remove = append(remove, p.Identifier)
+		}
+	}
+	if len(install) == 0 && len(remove) == 0 {
+		return false, nil
+	}
+	logFor(ctx).WithFields(logrus.Fields{"blueprint": b.Name, "installing": len(install), "removing": remove}).Info("reconciling profiles")
+	for _, c := range install {
+		s.sendCommand(ctx, c)
+	}
+	for _, id := range remove {
+		s.sendCommand(ctx, removeProfileCommand(*d, id))
+	}
+	reconcileVars.Add("installed", int64(len(install)))
+	reconcileVars.Add("removed", int64(len(remove)))
+	return false, nil
+}
+
+// checkinInfo decodes the device details of an Authenticate message, which
+// carries the same keys as a DeviceInformation response.
+func checkinInfo(raw []byte) (DeviceInfo, error) {
+	var info DeviceInfo
+	if len(raw) == 0 {
+		return info, fmt.Errorf("empty check-in message")
+	}
+	if err := plist.Unmarshal(raw, &info); err != nil {
+		return info, fmt.Errorf("decode check-in message: %v", err)
+	}
+	return info, nil
+}
+
+// awaitingConfiguration reports whether a TokenUpdate message says the
+// device is held in Setup Assistant, as devices enrolling through DEP are.
+func awaitingConfiguration(raw []byte) bool {
+	var msg struct{ AwaitingConfiguration bool }
+	if len(raw) == 0 {
+		return false
+	}
+	if err := plist.Unmarshal(raw, &msg); err != nil {
+		return false
+	}
+	return msg.AwaitingConfiguration
+}
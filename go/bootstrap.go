@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// Macs escrow a bootstrap token with MDM with a SetBootstrapToken check-in
+// once a user with a secure token logs in, and fetch it back with
+// GetBootstrapToken, e.g. to grant a secure token to a new user. MicroMDM
+// 1.6 does not know these check-ins and rejects them, so the topics are for
+// MDM servers, such as NanoMDM, that post them to the webhook.
+const (
+	setBootstrapTokenTopic = "mdm.SetBootstrapToken"
+	getBootstrapTokenTopic = "mdm.GetBootstrapToken"
+)
+
+// bootstrapVars counts the bootstrap tokens Macs escrowed (set), removed, and
+// fetched (retrieved), and the Macs notifiers were told have none (missing).
+var bootstrapVars = expvar.NewMap("bootstrap_tokens")
+
+// defaultBootstrapTokenGrace is how long an enrolled Mac can go without a
+// bootstrap token, by default, before it is reported: long enough for its
+// first user to log in.
+const defaultBootstrapTokenGrace = 24 * time.Hour
+
+// bootstrapTokenCheckInterval is how often Macs are checked for a bootstrap
+// token.
+const bootstrapTokenCheckInterval = time.Hour
+
+// bootstrapTokenMessage is a SetBootstrapToken check-in, or the answer to a
+// GetBootstrapToken one.
+type bootstrapTokenMessage struct {
+	BootstrapToken []byte `plist:",omitempty"`
+}
+
+// handleSetBootstrapToken records the bootstrap token a Mac escrowed, which
+// is kept encrypted with s.Escrow if it is set. A SetBootstrapToken message
+// without a token removes it.
+func (s *Server) handleSetBootstrapToken(ctx context.Context, event webhook.Event, w http.ResponseWriter) {
+	if event.CheckinEvent == nil {
+		logFor(ctx).Error("The event has no CheckinEvent")
+		http.Error(w, "The event has no CheckinEvent", http.StatusBadRequest)
+		return
+	}
+	var msg bootstrapTokenMessage
+	if err := plist.Unmarshal(event.CheckinEvent.RawPayload, &msg); err != nil {
+		decodeFailures.Add(1)
+		logFor(ctx).WithError(err).Error("decode SetBootstrapToken message")
+		http.Error(w, fmt.Sprintf("decode SetBootstrapToken message: %v", err), http.StatusBadRequest)
+		return
+	}
+	d, _, err := s.loadDevice(event.CheckinEvent.UDID)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device")
+		http.Error(w, fmt.Sprintf("load device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	now := eventTime(event)
+	d.LastSeen = now
+	bt := &store.BootstrapToken{UpdatedAt: &now}
+	if d.BootstrapToken != nil {
+		bt.RetrievedAt = d.BootstrapToken.RetrievedAt
+	}
+	if len(msg.BootstrapToken) == 0 {
+		bootstrapVars.Add("removed", 1)
+		logFor(ctx).Warn("Mac removed its bootstrap token")
+	} else {
+		bt.Escrowed = true
+		if s.Escrow == nil {
+			logFor(ctx).Warn("not storing bootstrap token: storing bootstrap tokens requires an escrow key; set -escrow-key")
+		} else if sealed, err := s.Escrow.seal(d.UDID, string(msg.BootstrapToken)); err != nil {
+			logFor(ctx).WithError(err).Error("seal bootstrap token")
+		} else {
+			bt.Token = &store.EscrowedPIN{Sealed: sealed, CreatedAt: now}
+		}
+		bootstrapVars.Add("set", 1)
+		logFor(ctx).WithField("stored", bt.Token != nil).Info("Mac escrowed its bootstrap token")
+	}
+	d.BootstrapToken = bt
+	if err := s.Devices.Save(d); err != nil {
+		logFor(ctx).WithError(err).Error("save device")
+		http.Error(w, fmt.Sprintf("save device: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetBootstrapToken answers a Mac's GetBootstrapToken message with the
+// bootstrap token it escrowed, as a plist for the MDM server to relay, or an
+// empty one if no token is stored for it.
+func (s *Server) handleGetBootstrapToken(ctx context.Context, event webhook.Event, w http.ResponseWriter) {
+	if event.CheckinEvent == nil {
+		logFor(ctx).Error("The event has no CheckinEvent")
+		http.Error(w, "The event has no CheckinEvent", http.StatusBadRequest)
+		return
+	}
+	d, _, err := s.loadDevice(event.CheckinEvent.UDID)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device")
+		http.Error(w, fmt.Sprintf("load device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	now := eventTime(event)
+	d.LastSeen = now
+	var msg bootstrapTokenMessage
+	if bt := d.BootstrapToken; bt == nil || bt.Token == nil || s.Escrow == nil {
+		logFor(ctx).Warn("Mac asked for a bootstrap token, but none is stored")
+	} else if token, err := s.Escrow.open(d.UDID, bt.Token.Sealed); err != nil {
+		logFor(ctx).WithError(err).Error("open bootstrap token")
+	} else {
+		msg.BootstrapToken = []byte(token)
+		bt.RetrievedAt = &now
+		bootstrapVars.Add("retrieved", 1)
+		logFor(ctx).Info("Mac retrieved its bootstrap token")
+	}
+	if err := s.Devices.Save(d); err != nil {
+		logFor(ctx).WithError(err).Error("save device")
+		http.Error(w, fmt.Sprintf("save device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	body, err := plist.Marshal(msg)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("encode bootstrap token")
+		http.Error(w, fmt.Sprintf("encode bootstrap token: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(body)
+}
+
+// bootstrapTokenLoop periodically looks for Macs missing a bootstrap token.
+func (s *Server) bootstrapTokenLoop(ctx context.Context) {
+	ticker := time.NewTicker(bootstrapTokenCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.checkBootstrapTokens(ctx)
+	}
+}
+
+// checkBootstrapTokens records when enrolled Macs were first found without a
+// bootstrap token, and notifies once about each that has gone without one
+// for s.BootstrapTokenGrace.
+func (s *Server) checkBootstrapTokens(ctx context.Context) {
+	devices, err := s.Devices.List()
+	if err != nil {
+		logrus.WithError(err).Error("list devices for bootstrap tokens")
+		return
+	}
+	now := time.Now().UTC()
+	for _, d := range devices {
+		if mac, _ := isMac(d); !mac || !d.Enrolled || isRetired(d) {
+			continue
+		}
+		bt := d.BootstrapToken
+		if bt != nil && (bt.Escrowed || bt.AlertedAt != nil) {
+			continue
+		}
+		if bt == nil {
+			bt = &store.BootstrapToken{MissingSince: &now}
+		} else if bt.MissingSince == nil {
+			bt.MissingSince = &now
+		} else if now.Sub(*bt.MissingSince) >= s.BootstrapTokenGrace {
+			bt.AlertedAt = &now
+		} else {
+			continue
+		}
+		d.BootstrapToken = bt
+		logger := logFor(ctx).WithField("udid", d.UDID)
+		if err := s.Devices.Save(d); err != nil {
+			logger.WithError(err).Error("save device")
+			continue
+		}
+		if bt.AlertedAt != nil {
+			bootstrapVars.Add("missing", 1)
+			logger.WithField("missing_since", bt.MissingSince).Warn("Mac has not escrowed a bootstrap token")
+			s.notify(ctx, notifyBootstrapTokenMissing, d, webhook.Event{})
+		}
+	}
+}
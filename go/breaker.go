@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errCircuitOpen is returned for commands not sent because MicroMDM has
+// been failing.
+var errCircuitOpen = errors.New("MicroMDM circuit breaker is open")
+
+// breakerVars publishes the state of each MicroMDM circuit breaker, keyed by
+// tenant ("default" for the untenanted server), at /debug/vars.
+var breakerVars = expvar.NewMap("mdm_circuit_breaker")
+
+// BreakerPolicy controls when the circuit breaker around MicroMDM opens
+// and what happens to commands while it is open.
+type BreakerPolicy struct {
+	// Failures is the number of failures in a row that opens the breaker.
+	// Zero disables the breaker.
+	Failures int
+
+	// Cooldown is how long the breaker stays open before a single command
+	// is let through to test whether MicroMDM has recovered.
+	Cooldown time.Duration
+
+	// Wait holds commands until the breaker lets them through, or their
+	// context ends, instead of failing them straight away.
+	Wait bool
+}
+
+var defaultBreakerPolicy = BreakerPolicy{Failures: 5, Cooldown: 30 * time.Second}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// circuitBreaker stops commands from being sent to a MicroMDM server that
+// keeps failing. After policy.Failures failures in a row it opens and fails
+// commands without sending them; after policy.Cooldown it lets one through
+// and closes again if that succeeds.
+type circuitBreaker struct {
+	name   string
+	policy BreakerPolicy
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+
+	opened, rejected expvar.Int
+}
+
+func newCircuitBreaker(name string, policy BreakerPolicy) *circuitBreaker {
+	b := &circuitBreaker{name: name, policy: policy}
+	vars := new(expvar.Map)
+	vars.Set("state", expvar.Func(func() any {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		return b.state.String()
+	}))
+	vars.Set("opened", &b.opened)
+	vars.Set("rejected", &b.rejected)
+	breakerVars.Set(name, vars)
+	return b
+}
+
+// admit returns nil if a command may be sent now. Under policy.Wait it
+// waits for that; otherwise it fails straight away while the breaker is
+// open.
+func (b *circuitBreaker) admit(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	var err error
+	if b.policy.Wait {
+		err = b.wait(ctx)
+	} else {
+		err = b.allow()
+	}
+	if err != nil {
+		b.rejected.Add(1)
+	}
+	return err
+}
+
+// allow reports whether a command may be sent now. When it returns nil,
+// the outcome must be passed to record.
+func (b *circuitBreaker) allow() error {
+	if b == nil || b.policy.Failures <= 0 {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) >= b.policy.Cooldown {
+			b.state = breakerHalfOpen
+			return nil
+		}
+	case breakerClosed:
+		return nil
+	}
+	return errCircuitOpen
+}
+
+// wait blocks until allow lets a command through or ctx ends.
+func (b *circuitBreaker) wait(ctx context.Context) error {
+	for {
+		err := b.allow()
+		if err == nil {
+			return nil
+		}
+		b.mu.Lock()
+		retry := b.policy.Cooldown - time.Since(b.openedAt)
+		b.mu.Unlock()
+		if retry < 100*time.Millisecond {
+			// Another command is testing MicroMDM; check back shortly.
+			retry = 100 * time.Millisecond
+		}
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return fmt.Errorf("%v (%v)", err, ctx.Err())
+		}
+	}
+}
+
+// record updates the breaker with the outcome of a command allow let
+// through. failed is true for failures that suggest MicroMDM is unhealthy.
+func (b *circuitBreaker) record(ctx context.Context, failed bool) {
+	if b == nil || b.policy.Failures <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	logger := logFor(ctx).WithField("breaker", b.name)
+	if !failed {
+		if b.state != breakerClosed {
+			logger.Info("MicroMDM recovered, closing circuit breaker")
+		}
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.policy.Failures) {
+		b.state, b.openedAt = breakerOpen, time.Now()
+		b.opened.Add(1)
+		logger.WithFields(logrus.Fields{"failures": b.failures, "cooldown": b.policy.Cooldown.String()}).Warn("MicroMDM keeps failing, opening circuit breaker")
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxBulkJobs is the number of bulk jobs kept for polling. The oldest
+// finished jobs are dropped first.
+const maxBulkJobs = 100
+
+// DeviceFilter selects the devices a bulk command is sent to. Exactly one of
+// All, Tag, Tags, and UDIDs must be set; Platform narrows all but UDIDs to
+// the devices on a platform.
+type DeviceFilter struct {
+	All   bool     `json:"all,omitempty"`
+	Tag   string   `json:"tag,omitempty"`
+	Tags  *TagExpr `json:"tags,omitempty"`
+	UDIDs []string `json:"udids,omitempty"`
+
+	Platform string `json:"platform,omitempty"`
+}
+
+func (f DeviceFilter) validate() error {
+	set := 0
+	if f.All {
+		set++
+	}
+	if f.Tag != "" {
+		set++
+	}
+	if f.Tags != nil {
+		set++
+	}
+	if len(f.UDIDs) > 0 {
+		set++
+	}
+	if set != 1 {
+		return fmt.Errorf("filter must set exactly one of all, tag, tags, or udids")
+	}
+	if f.Platform != "" && len(f.UDIDs) > 0 {
+		return fmt.Errorf("filter platform narrows all, tag, or tags, not udids")
+	}
+	return nil
+}
+
+// selectDevices returns the UDIDs of the devices matching f. Devices found by
+// all, tag, or tags whose platform does not take requestType are left out.
+func (s *Server) selectDevices(f DeviceFilter, requestType string) ([]string, error) {
+	if len(f.UDIDs) > 0 {
+		return f.UDIDs, nil
+	}
+	devices, err := s.Devices.List()
+	if err != nil {
+		return nil, err
+	}
+	var udids []string
+	for _, d := range devices {
+		if isPlaceholder(d) {
+			continue
+		}
+		switch {
+		case f.All:
+		case f.Tags != nil:
+			if !f.Tags.matches(d) {
+				continue
+			}
+		case !d.HasTag(f.Tag):
+			continue
+		}
+		if f.Platform != "" && !strings.EqualFold(f.Platform, d.Platform) {
+			continue
+		}
+		if checkPlatform(d, requestType) != nil {
+			unsupportedVars.Add(requestType, 1)
+			continue
+		}
+		udids = append(udids, d.UDID)
+	}
+	return udids, nil
+}
+
+// BulkResult is the outcome of sending a bulk command to one device.
+type BulkResult struct {
+	UDID        string `json:"udid"`
+	CommandUUID string `json:"command_uuid,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// BulkJob tracks the fan-out of one command to many devices.
+type BulkJob struct {
+	ID          string       `json:"id"`
+	RequestType string       `json:"request_type"`
+	Total       int          `json:"total"`
+	Sent        int          `json:"sent"`
+	Failed      int          `json:"failed"`
+	Done        bool         `json:"done"`
+	CreatedAt   time.Time    `json:"created_at"`
+	FinishedAt  *time.Time   `json:"finished_at,omitempty"`
+	Results     []BulkResult `json:"results"`
+}
+
+// bulkJobs holds the bulk jobs that can be polled.
+type bulkJobs struct {
+	mu    sync.Mutex
+	jobs  map[string]*BulkJob
+	order []string
+
+	// running counts the jobs still sending commands.
+	running sync.WaitGroup
+}
+
+func newBulkJobs() *bulkJobs {
+	return &bulkJobs{jobs: make(map[string]*BulkJob)}
+}
+
+func (b *bulkJobs) add(job *BulkJob) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.jobs[job.ID] = job
+	b.order = append(b.order, job.ID)
+	for i := 0; len(b.jobs) > maxBulkJobs && i < len(b.order); {
+		id := b.order[i]
+		if j, ok := b.jobs[id]; !ok || j.Done {
+			delete(b.jobs, id)
+			b.order = append(b.order[:i], b.order[i+1:]...)
+			continue
+		}
+		i++
+	}
+}
+
+// get returns a copy of the job with the given ID.
+func (b *bulkJobs) get(id string) (BulkJob, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	job, ok := b.jobs[id]
+	if !ok {
+		return BulkJob{}, false
+	}
+	cp := *job
+	cp.Results = make([]BulkResult, len(job.Results))
+	copy(cp.Results, job.Results)
+	return cp, true
+}
+
+// update applies fn to the job with the given ID under the lock.
+func (b *bulkJobs) update(id string, fn func(*BulkJob)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if job, ok := b.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+type bulkCommandRequest struct {
+	Filter  DeviceFilter    `json:"filter"`
+	Command json.RawMessage `json:"command"`
+}
+
+// handleStartBulkCommand starts sending a command to every device matching a
+// filter and returns a job that can be polled for progress.
+func (s *Server) handleStartBulkCommand(w http.ResponseWriter, r *http.Request) {
+	var req bulkCommandRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandBody)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := req.Filter.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	requestType, payload, err := parseCommandPayload(req.Command)
+	if err == nil {
+		err = requireConfirmation(requestType)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	udids, err := s.selectDevices(req.Filter, requestType)
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("select devices")
+		http.Error(w, fmt.Sprintf("select devices: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	job := &BulkJob{
+		ID:          newJobID(),
+		RequestType: requestType,
+		Total:       len(udids),
+		CreatedAt:   time.Now().UTC(),
+	}
+	s.BulkJobs.add(job)
+	// The job outlives the request but stays part of its trace.
+	ctx := context.WithoutCancel(r.Context())
+	s.BulkJobs.running.Add(1)
+	go func() {
+		defer s.BulkJobs.running.Done()
+		s.runBulkJob(ctx, job.ID, requestType, payload, udids)
+	}()
+
+	snapshot, _ := s.BulkJobs.get(job.ID)
+	writeJSON(w, http.StatusAccepted, snapshot)
+}
+
+// runBulkJob sends the command to each device, no faster than s.BulkRate
+// commands per second.
+func (s *Server) runBulkJob(ctx context.Context, id, requestType string, payload map[string]interface{}, udids []string) {
+	ctx, span := tracer.Start(ctx, "bulk command job", trace.WithAttributes(
+		attribute.String("bulk.job_id", id),
+		attribute.String("mdm.request_type", requestType),
+		attribute.Int("bulk.devices", len(udids)),
+	))
+	defer span.End()
+	logger := logFor(ctx).WithFields(logrus.Fields{"job_id": id, "request_type": requestType})
+	ctx = withLogger(ctx, logger)
+
+	var throttle <-chan time.Time
+	if s.BulkRate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / s.BulkRate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	for i, udid := range udids {
+		if throttle != nil && i > 0 {
+			<-throttle
+		}
+		body := make(map[string]interface{}, len(payload)+1)
+		for k, v := range payload {
+			body[k] = v
+		}
+		body["udid"] = udid
+
+		result := BulkResult{UDID: udid}
+		uuid, err := s.postCommand(ctx, udid, requestType, body)
+		if err != nil {
+			logger.WithField("udid", udid).WithError(err).Error("bulk job: send command")
+			result.Error = err.Error()
+		} else {
+			result.CommandUUID = uuid
+		}
+		s.BulkJobs.update(id, func(job *BulkJob) {
+			job.Results = append(job.Results, result)
+			if err != nil {
+				job.Failed++
+			} else {
+				job.Sent++
+			}
+		})
+	}
+
+	s.BulkJobs.update(id, func(job *BulkJob) {
+		now := time.Now().UTC()
+		job.Done = true
+		job.FinishedAt = &now
+	})
+	logger.WithField("devices", len(udids)).Info("bulk job finished")
+}
+
+// wait blocks until every running job has sent its commands or ctx is done.
+func (b *bulkJobs) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		b.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handleGetBulkJob returns the progress of a bulk job.
+func (s *Server) handleGetBulkJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.BulkJobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "bulk job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func newJobID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
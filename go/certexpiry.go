@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// certExpiryVars counts the identity certificates found nearing expiry
+// (expiring), those of them already expired (expired), the renewals run for
+// them (renewals), and the devices that went on to replace them (renewed).
+var certExpiryVars = expvar.NewMap("cert_expiry")
+
+// certExpiryCheckInterval is how often the certificates devices reported are
+// checked for expiry.
+const certExpiryCheckInterval = time.Hour
+
+// CertRenewal is what happens when the identity certificate of a device
+// nears expiry, in addition to notifiers being told of it.
+type CertRenewal struct {
+	// CommonNames are glob patterns (as in path.Match) of the common names
+	// of the identity certificates watched, e.g. those of the MDM identity
+	// issued over SCEP. All identity certificates are watched if it is
+	// empty.
+	CommonNames RulePatterns `yaml:"common-names"`
+
+	// Tags and Platform limit the renewal to the devices matching the tag
+	// expression, and on one of the platforms, if they are set.
+	Tags     *TagExpr     `yaml:"tags"`
+	Platform RulePatterns `yaml:"platform"`
+
+	// Then are actions, like those of rules, run once for each certificate
+	// found nearing expiry, e.g. installing a profile with a new SCEP
+	// payload, or a hook starting a re-enrollment.
+	Then []RuleAction `yaml:"then"`
+
+	// rule runs Then.
+	rule *rule
+}
+
+func (cr CertRenewal) validate() error {
+	for _, p := range cr.CommonNames {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("common-names: pattern %q: %v", p, err)
+		}
+	}
+	for _, platform := range cr.Platform {
+		if !slices.ContainsFunc(platforms, func(known string) bool { return strings.EqualFold(platform, known) }) {
+			return fmt.Errorf("unknown platform %q; want one of %s", platform, strings.Join(platforms, ", "))
+		}
+	}
+	for i, a := range cr.Then {
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("action %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// addCertRenewal sets up cr to run on the certificates found nearing expiry,
+// adding the notifiers and hooks of its actions as addRules does.
+func (s *Server) addCertRenewal(cr *CertRenewal, smtp EmailOptions) error {
+	r, err := s.newRule("cert-renewal", Rule{Name: "cert-renewal", Then: cr.Then}, smtp)
+	if err != nil {
+		return err
+	}
+	cr.rule = r
+	s.CertRenewal = cr
+	return nil
+}
+
+// watches reports whether the identity certificate c is watched for expiry.
+func (cr *CertRenewal) watches(c store.DeviceCertificate) bool {
+	if cr == nil || len(cr.CommonNames) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(cr.CommonNames, func(p string) bool {
+		ok, _ := path.Match(p, c.CommonName)
+		return ok
+	})
+}
+
+// renews reports whether the renewal actions are for d.
+func (cr *CertRenewal) renews(d Device) bool {
+	if cr.Tags != nil && !cr.Tags.matches(d) {
+		return false
+	}
+	return len(cr.Platform) == 0 || slices.ContainsFunc(cr.Platform, func(platform string) bool { return strings.EqualFold(platform, d.Platform) })
+}
+
+// expiringCertificate returns the watched identity certificate of d that
+// expires first, if it expires before now+s.CertExpiryWarning.
+func (s *Server) expiringCertificate(d Device, now time.Time) (store.DeviceCertificate, bool) {
+	var first store.DeviceCertificate
+	found := false
+	for _, c := range webhook.ExpiringIdentities(d.Certificates, now, s.CertExpiryWarning) {
+		if s.CertRenewal.watches(c) && (!found || c.NotAfter.Before(first.NotAfter)) {
+			first, found = c, true
+		}
+	}
+	return first, found
+}
+
+// certExpiryLoop periodically checks the certificates of devices for expiry.
+func (s *Server) certExpiryLoop(ctx context.Context) {
+	ticker := time.NewTicker(certExpiryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.checkCertExpiry(ctx)
+	}
+}
+
+// checkCertExpiry looks through the identity certificates the enrolled
+// devices last reported in CertificateList responses. Once for each watched
+// certificate that expires within s.CertExpiryWarning, notifiers are told of
+// it and the actions of s.CertRenewal are run; devices whose certificate was
+// since replaced by one that does not are recorded as renewed.
+func (s *Server) checkCertExpiry(ctx context.Context) {
+	devices, err := s.Devices.List()
+	if err != nil {
+		logrus.WithError(err).Error("list devices for certificate expiry")
+		return
+	}
+	now := time.Now().UTC()
+	for _, d := range devices {
+		if !d.Enrolled || isRetired(d) || len(d.Certificates) == 0 {
+			continue
+		}
+		logger := logFor(ctx).WithField("udid", d.UDID)
+		c, expiring := s.expiringCertificate(d, now)
+		ce := d.CertExpiry
+		switch {
+		case !expiring && ce != nil && ce.RenewedAt == nil:
+			ce.RenewedAt = &now
+			if err := s.Devices.Save(d); err != nil {
+				logger.WithError(err).Error("save device")
+				continue
+			}
+			certExpiryVars.Add("renewed", 1)
+			logger.WithField("common_name", ce.CommonName).Info("device identity certificate was renewed")
+			continue
+		case !expiring || ce != nil && ce.CommonName == c.CommonName && ce.NotAfter.Equal(c.NotAfter):
+			continue
+		}
+		d.CertExpiry = &store.CertExpiry{CommonName: c.CommonName, NotAfter: c.NotAfter, AlertedAt: now}
+		certExpiryVars.Add("expiring", 1)
+		if !c.NotAfter.After(now) {
+			certExpiryVars.Add("expired", 1)
+		}
+		renew := s.CertRenewal != nil && s.CertRenewal.renews(d)
+		logger.WithFields(logrus.Fields{"common_name": c.CommonName, "not_after": c.NotAfter, "renewal": renew}).Warn("device identity certificate expires soon")
+		n := s.newNotification(notifyCertExpiring, d, webhook.Event{})
+		n.Certificate = &c
+		if s.Notifiers != nil {
+			s.Notifiers.notify(ctx, n)
+		}
+		if renew {
+			certExpiryVars.Add("renewals", 1)
+			s.runActions(ctx, s.CertRenewal.rule, &d, webhook.Event{}, n, nil)
+		}
+		if err := s.Devices.Save(d); err != nil {
+			logger.WithError(err).Error("save device")
+		}
+	}
+}
@@ -0,0 +1,1123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/client"
+)
+
+const usageText = `Usage: micromdm-webhook <command> [flags]
+
+Commands:
+  serve                               run the webhook server (the default)
+  devices list                        list devices
+  devices show <udid>                 show a device and its command history
+  devices lock-pins <udid>            show the escrowed PINs of a device's DeviceLock commands
+  devices bypass-code -reason <why> <udid>
+                                      show the escrowed Activation Lock bypass code of a device
+  devices filevault-key -reason <why> <udid>
+                                      show the escrowed FileVault recovery key of a Mac
+  devices compliance                  report how devices measure up to the compliance policies
+  devices licenses                    report the VPP licenses app installs took, and those to reclaim
+  devices tags                        list the tags of devices, with how many devices have each
+  devices tag <udid> <tag>            tag a device, even one yet to enroll
+  devices untag <udid> <tag>          remove a tag from a device
+  devices import <file.csv>           import a list of expected devices, flagging enrollments of others
+  command send <udid> <request_type> [key=value ...]
+                                      queue a command for a device, or with -user <user_id> for one of its macOS users
+  command install-profile <udid> <file.mobileconfig>
+                                      queue an InstallProfile command, for a macOS user with -user <user_id>
+  command remove-profile <udid> <identifier>
+                                      queue a RemoveProfile command
+  command lock <udid>                 lock a device, with an escrowed PIN for Macs
+  command clear-passcode <udid>       clear the passcode of an iOS device
+  command rotate-filevault-key <udid> rotate the escrowed FileVault recovery key of a Mac
+  command lost-mode <udid>            put a supervised iOS device in Lost Mode, or take it out with -disable
+  command locate <udid>               ask a device in Lost Mode for its location
+  command restart <udid>              queue a RestartDevice command
+  command shutdown <udid>             queue a ShutDownDevice command
+  command settings <udid>             rename a device or change its managed settings
+  command install-app <udid> <itunes_store_id|bundle_id>
+                                      install an App Store app, for a macOS user with -user <user_id>
+  command install-enterprise-app <udid> <package>
+                                      install a package hosted from the server's -app-dir
+  command os-update <udid> <product_key>
+                                      schedule an OS update the device reported as available
+  command erase <udid>                request to erase a device; confirm with -confirm <token>
+  static list                         list the files hosted from the server's -static-dir
+  static url <path>                   print a signed URL of a hosted file, expiring after -ttl
+  events tail                         print webhook events as they arrive
+  replay <file|directory|s3://bucket/prefix> ...
+                                      handle archived webhook events again
+
+The devices, command, static, and events commands talk to the admin API of a running
+server; replay works on the device store directly. Run "micromdm-webhook <command> -h" for the flags of a command.
+`
+
+func usage() {
+	fmt.Fprint(os.Stderr, usageText)
+}
+
+// adminFlags adds the flags for reaching a server's admin API to fs and
+// returns a function that builds the client once fs is parsed. The flags can
+// also be set with WEBHOOK_URL, WEBHOOK_ADMIN_TOKEN, and WEBHOOK_ADMIN_USER.
+func adminFlags(fs *flag.FlagSet) func() *client.Client {
+	url := fs.String("url", "http://localhost", "URL of the webhook server")
+	token := fs.String("admin-token", "", "admin token of the webhook server")
+	user := fs.String("admin-user", "", "name recorded in the server's audit logs of the requests")
+	return func() *client.Client {
+		c := client.New(*url, *token)
+		c.User = *user
+		return c
+	}
+}
+
+// parseFlags parses args into fs after applying the environment.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	if err := applyEnv(fs); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	fs.Parse(args)
+}
+
+// cliContext returns a context that is canceled on interrupt.
+func cliContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt)
+}
+
+func runDevices(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: micromdm-webhook devices list|show|lock-pins|bypass-code|filevault-key|compliance|licenses|tags|tag|untag|import")
+	}
+	switch args[0] {
+	case "list":
+		return devicesList(args[1:])
+	case "show":
+		return devicesShow(args[1:])
+	case "lock-pins":
+		return devicesLockPINs(args[1:])
+	case "bypass-code":
+		return devicesBypassCode(args[1:])
+	case "filevault-key":
+		return devicesFileVaultKey(args[1:])
+	case "compliance":
+		return devicesCompliance(args[1:])
+	case "licenses":
+		return devicesLicenses(args[1:])
+	case "tags":
+		return devicesTags(args[1:])
+	case "tag", "untag":
+		return devicesTag(args[0], args[1:])
+	case "import":
+		return devicesImport(args[1:])
+	}
+	return fmt.Errorf("unknown devices command %q", args[0])
+}
+
+func devicesList(args []string) error {
+	fs := flag.NewFlagSet("devices list", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	var (
+		flEnrolled  = fs.String("enrolled", "", "only list enrolled (true) or unenrolled (false) devices")
+		flRetired   = fs.String("retired", "", "only list devices retired (true) or not retired (false) when they checked out")
+		flDEP       = fs.String("dep", "", "only list devices DEP does (true) or does not (false) list as assigned to the server")
+		flExpected  = fs.String("expected", "", "only list devices that are (true) or are not (false) on an imported list of expected devices")
+		flResponds  = fs.String("responsive", "", "only list devices that did (true) or did not (false) drain their command queue within the server's -responsive-window")
+		flOSVersion = fs.String("os-version", "", `only list devices running this OS version, e.g. "17" or ">=17.4"`)
+		flModel     = fs.String("model", "", "only list devices of this model identifier or name")
+		flPlatform  = fs.String("platform", "", "only list devices on this platform: macOS, iOS, iPadOS, or tvOS")
+		flTag       = fs.String("tag", "", "only list devices with this tag")
+		flOwner     = fs.String("owner", "", "only list devices an imported list gave this owner")
+		flOsquery   = fs.String("osquery-host-id", "", "only list the Mac linked to this osquery host")
+		flSort      = fs.String("sort", "", "sort by udid, last_seen, os_version, or model; prefix with - for descending")
+		flJSON      = fs.Bool("json", false, "print JSON instead of a table")
+	)
+	parseFlags(fs, args)
+
+	opts := client.ListDevicesParams{
+		OSVersion:     *flOSVersion,
+		Model:         *flModel,
+		Platform:      *flPlatform,
+		Tag:           *flTag,
+		Owner:         *flOwner,
+		OsqueryHostID: *flOsquery,
+		Sort:          *flSort,
+		Limit:         maxPageSize,
+	}
+	if *flEnrolled != "" {
+		enrolled, err := strconv.ParseBool(*flEnrolled)
+		if err != nil {
+			return fmt.Errorf("invalid -enrolled value %q", *flEnrolled)
+		}
+		opts.Enrolled = &enrolled
+	}
+	if *flRetired != "" {
+		retired, err := strconv.ParseBool(*flRetired)
+		if err != nil {
+			return fmt.Errorf("invalid -retired value %q", *flRetired)
+		}
+		opts.Retired = &retired
+	}
+	if *flDEP != "" {
+		listed, err := strconv.ParseBool(*flDEP)
+		if err != nil {
+			return fmt.Errorf("invalid -dep value %q", *flDEP)
+		}
+		opts.DEP = &listed
+	}
+	if *flExpected != "" {
+		expected, err := strconv.ParseBool(*flExpected)
+		if err != nil {
+			return fmt.Errorf("invalid -expected value %q", *flExpected)
+		}
+		opts.Expected = &expected
+	}
+	if *flResponds != "" {
+		responsive, err := strconv.ParseBool(*flResponds)
+		if err != nil {
+			return fmt.Errorf("invalid -responsive value %q", *flResponds)
+		}
+		opts.Responsive = &responsive
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	devices, err := newClient().ListAllDevices(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if *flJSON {
+		return printJSON(devices)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "UDID\tENROLLED\tPLATFORM\tOS\tMODEL\tLAST SEEN")
+	for _, d := range devices {
+		var osVersion, model string
+		if d.Info != nil {
+			osVersion, model = d.Info.OSVersion, d.Info.Model
+		}
+		fmt.Fprintf(tw, "%s\t%t\t%s\t%s\t%s\t%s\n", d.UDID, d.Enrolled, d.Platform, osVersion, model, d.LastSeen.Local().Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func devicesShow(args []string) error {
+	fs := flag.NewFlagSet("devices show", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: micromdm-webhook devices show [flags] <udid>")
+	}
+	udid := fs.Arg(0)
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	c := newClient()
+	d, err := c.GetDevice(ctx, udid)
+	if err != nil {
+		return err
+	}
+	history, err := c.GetCommandHistory(ctx, udid)
+	if err != nil {
+		return err
+	}
+	return printJSON(struct {
+		client.Device
+		Commands []client.CommandRecord `json:"commands"`
+	}{d, history})
+}
+
+func devicesCompliance(args []string) error {
+	fs := flag.NewFlagSet("devices compliance", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	var (
+		flStatus = fs.String("status", "", "only list devices with this status: compliant, noncompliant, or unknown")
+		flPolicy = fs.String("policy", "", "only list devices by their status under this policy")
+		flJSON   = fs.Bool("json", false, "print JSON instead of tables")
+	)
+	parseFlags(fs, args)
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	report, err := newClient().GetComplianceReport(ctx, client.GetComplianceReportParams{Status: *flStatus, Policy: *flPolicy})
+	if err != nil {
+		return err
+	}
+	if *flJSON {
+		return printJSON(report)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "POLICY\tCOMPLIANT\tNONCOMPLIANT\tUNKNOWN")
+	for _, p := range report.Policies {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\n", p.Name, p.Compliant, p.Noncompliant, p.Unknown)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "UDID\tNAME\tSTATUS\tVIOLATIONS")
+	for _, d := range report.Devices {
+		var violations []string
+		for _, p := range d.Policies {
+			for _, v := range p.Violations {
+				violations = append(violations, p.Policy+": "+v)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", d.UDID, d.Name, d.Status, strings.Join(violations, "; "))
+	}
+	return tw.Flush()
+}
+
+func devicesLicenses(args []string) error {
+	fs := flag.NewFlagSet("devices licenses", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	var (
+		flApp         = fs.String("app", "", "only report the licenses of the app with this bundle ID or iTunes Store ID")
+		flReclaimable = fs.Bool("reclaimable", false, "only report the licenses no longer in use")
+		flJSON        = fs.Bool("json", false, "print JSON instead of tables")
+	)
+	parseFlags(fs, args)
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	params := client.GetLicenseReportParams{Identifier: *flApp}
+	if *flReclaimable {
+		params.Reclaimable = flReclaimable
+	}
+	report, err := newClient().GetLicenseReport(ctx, params)
+	if err != nil {
+		return err
+	}
+	if *flJSON {
+		return printJSON(report)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "APP\tITUNES_STORE_ID\tCONSUMED\tDEVICE\tUSER\tRECLAIMABLE")
+	for _, a := range report.Apps {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", a.Identifier, a.ITunesStoreID, a.Consumed, a.Device, a.User, a.Reclaimable)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "APP\tUDID\tNAME\tLICENSE\tUSER_ID\tSTATE\tRECLAIM")
+	for _, a := range report.Apps {
+		for _, l := range a.Assignments {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", a.Identifier, l.UDID, l.Name, l.License, l.UserID, l.State, l.Reclaim)
+		}
+	}
+	return tw.Flush()
+}
+
+func devicesTags(args []string) error {
+	fs := flag.NewFlagSet("devices tags", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	parseFlags(fs, args)
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	tags, err := newClient().ListTags(ctx)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TAG\tDEVICES")
+	for _, t := range tags {
+		fmt.Fprintf(tw, "%s\t%d\n", t.Tag, t.Devices)
+	}
+	return tw.Flush()
+}
+
+// devicesTag runs devices tag and devices untag, as given by name.
+func devicesTag(name string, args []string) error {
+	fs := flag.NewFlagSet("devices "+name, flag.ExitOnError)
+	newClient := adminFlags(fs)
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("usage: micromdm-webhook devices %s [flags] <udid> <tag>", name)
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	c := newClient()
+	tag := c.AddTag
+	if name == "untag" {
+		tag = c.RemoveTag
+	}
+	t, err := tag(ctx, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", t.UDID, strings.Join(t.Tags, ", "))
+	return nil
+}
+
+func devicesImport(args []string) error {
+	fs := flag.NewFlagSet("devices import", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: micromdm-webhook devices import [flags] <file.csv>
+
+The header row names the columns: serial_number (or serial) and udid, at
+least one of which each row fills, and optionally owner and tags, separated
+by spaces or semicolons. Once a list is imported, devices enrolling that are
+on none are flagged as unexpected.`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	csv, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	result, err := newClient().ImportDevices(ctx, csv)
+	if err != nil {
+		return err
+	}
+	for _, e := range result.Errors {
+		fmt.Fprintf(os.Stderr, "%s:%d: %s\n", fs.Arg(0), e.Line, e.Error)
+	}
+	fmt.Printf("imported %d devices: %d created, %d updated\n", result.Created+result.Updated, result.Created, result.Updated)
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("left out %d rows", len(result.Errors))
+	}
+	return nil
+}
+
+func devicesLockPINs(args []string) error {
+	fs := flag.NewFlagSet("devices lock-pins", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: micromdm-webhook devices lock-pins [flags] <udid>")
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	pins, err := newClient().GetLockPINs(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PIN\tCREATED")
+	for _, p := range pins {
+		fmt.Fprintf(tw, "%s\t%s\n", p.PIN, p.CreatedAt.Local().Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func devicesBypassCode(args []string) error {
+	fs := flag.NewFlagSet("devices bypass-code", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	reason := fs.String("reason", "", "why the code is needed, logged by the server (required)")
+	parseFlags(fs, args)
+	if fs.NArg() != 1 || *reason == "" {
+		return fmt.Errorf("usage: micromdm-webhook devices bypass-code -reason <why> [flags] <udid>")
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	code, err := newClient().GetActivationLockBypassCode(ctx, fs.Arg(0), client.DisclosureRequest{Reason: *reason})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s (escrowed %s)\n", code.Code, code.EscrowedAt.Local().Format(time.RFC3339))
+	return nil
+}
+
+func devicesFileVaultKey(args []string) error {
+	fs := flag.NewFlagSet("devices filevault-key", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	reason := fs.String("reason", "", "why the key is needed, logged by the server (required)")
+	parseFlags(fs, args)
+	if fs.NArg() != 1 || *reason == "" {
+		return fmt.Errorf("usage: micromdm-webhook devices filevault-key -reason <why> [flags] <udid>")
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	key, err := newClient().GetFileVaultKey(ctx, fs.Arg(0), client.DisclosureRequest{Reason: *reason})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s (escrowed %s; the server rotates it now that it was disclosed)\n", key.RecoveryKey, key.EscrowedAt.Local().Format(time.RFC3339))
+	return nil
+}
+
+func runCommand(args []string) error {
+	if len(args) > 0 && args[0] == "install-profile" {
+		return runInstallProfile(args[1:])
+	}
+	if len(args) > 0 && args[0] == "remove-profile" {
+		return runRemoveProfile(args[1:])
+	}
+	if len(args) > 0 && args[0] == "lock" {
+		return runLock(args[1:])
+	}
+	if len(args) > 0 && args[0] == "clear-passcode" {
+		return runClearPasscode(args[1:])
+	}
+	if len(args) > 0 && args[0] == "rotate-filevault-key" {
+		return runRotateFileVaultKey(args[1:])
+	}
+	if len(args) > 0 && args[0] == "lost-mode" {
+		return runLostMode(args[1:])
+	}
+	if len(args) > 0 && args[0] == "locate" {
+		return runLocate(args[1:])
+	}
+	if len(args) > 0 && args[0] == "push" {
+		return runPush(args[1:])
+	}
+	if len(args) > 0 && (args[0] == "restart" || args[0] == "shutdown") {
+		return runPower(args[0], args[1:])
+	}
+	if len(args) > 0 && args[0] == "settings" {
+		return runSettings(args[1:])
+	}
+	if len(args) > 0 && args[0] == "install-app" {
+		return runInstallApp(args[1:])
+	}
+	if len(args) > 0 && args[0] == "install-enterprise-app" {
+		return runInstallEnterpriseApp(args[1:])
+	}
+	if len(args) > 0 && args[0] == "os-update" {
+		return runOSUpdate(args[1:])
+	}
+	if len(args) > 0 && args[0] == "erase" {
+		return runErase(args[1:])
+	}
+	if len(args) == 0 || args[0] != "send" {
+		return fmt.Errorf("usage: micromdm-webhook command send|install-profile|remove-profile|settings|install-app|install-enterprise-app|lock|clear-passcode|rotate-filevault-key|lost-mode|locate|push|restart|shutdown|os-update|erase [flags] <udid> ...")
+	}
+	fs := flag.NewFlagSet("command send", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	user := fs.String("user", "", "send the command on the channel of the macOS user with this UserID")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: micromdm-webhook command send [flags] <udid> <request_type> [key=value ...]
+
+Each key=value sets a field of the command. Values that parse as JSON are
+sent as such, e.g. queries='["OSVersion","Model"]'; others are sent as
+strings.`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args[1:])
+	if fs.NArg() < 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	udid := fs.Arg(0)
+	cmd := client.Command{"request_type": fs.Arg(1)}
+	for _, kv := range fs.Args()[2:] {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("invalid field %q: want key=value", kv)
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			v = value
+		}
+		cmd[key] = v
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	var q client.QueuedCommand
+	var err error
+	if *user != "" {
+		q, err = newClient().SendUserCommand(ctx, udid, *user, cmd)
+	} else {
+		q, err = newClient().SendCommand(ctx, udid, cmd)
+	}
+	if err != nil {
+		return err
+	}
+	printQueued(q)
+	return nil
+}
+
+// printQueued prints the command q, queued for a device or one of its users.
+func printQueued(q client.QueuedCommand) {
+	if q.UserID != "" {
+		fmt.Printf("queued %s command %s for user %s of device %s\n", q.RequestType, q.CommandUUID, q.UserID, q.UDID)
+		return
+	}
+	fmt.Printf("queued %s command %s for device %s\n", q.RequestType, q.CommandUUID, q.UDID)
+}
+
+func runInstallProfile(args []string) error {
+	fs := flag.NewFlagSet("command install-profile", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	user := fs.String("user", "", "install a user profile on the channel of the macOS user with this UserID")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: micromdm-webhook command install-profile [flags] <udid> <file.mobileconfig>
+
+XML profiles are Go templates the server executes with the device, e.g.
+{{.Info.SerialNumber}}. Signed profiles are installed as they are.`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	profile, err := os.ReadFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	var q client.QueuedCommand
+	if *user != "" {
+		q, err = newClient().InstallUserProfile(ctx, fs.Arg(0), *user, profile)
+	} else {
+		q, err = newClient().InstallProfile(ctx, fs.Arg(0), profile)
+	}
+	if err != nil {
+		return err
+	}
+	printQueued(q)
+	return nil
+}
+
+func runRemoveProfile(args []string) error {
+	fs := flag.NewFlagSet("command remove-profile", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: micromdm-webhook command remove-profile [flags] <udid> <identifier>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	q, err := newClient().RemoveProfile(ctx, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("queued %s command %s for device %s\n", q.RequestType, q.CommandUUID, q.UDID)
+	return nil
+}
+
+func runLock(args []string) error {
+	fs := flag.NewFlagSet("command lock", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	var (
+		flMessage = fs.String("message", "", "message to show on the locked device")
+		flPhone   = fs.String("phone-number", "", "phone number to show on the locked device")
+	)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: micromdm-webhook command lock [flags] <udid>
+
+Macs are locked with a PIN the server generates and escrows; show it with
+"micromdm-webhook devices lock-pins <udid>".`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	q, err := newClient().LockDevice(ctx, fs.Arg(0), client.LockOptions{Message: *flMessage, PhoneNumber: *flPhone})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("queued %s command %s for device %s\n", q.RequestType, q.CommandUUID, q.UDID)
+	return nil
+}
+
+func runClearPasscode(args []string) error {
+	fs := flag.NewFlagSet("command clear-passcode", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: micromdm-webhook command clear-passcode [flags] <udid>
+
+The server must have stored the UnlockToken the device sent when it
+enrolled, with its -escrow-key. Requests are logged with -admin-user.`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	q, err := newClient().ClearPasscode(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("queued %s command %s for device %s\n", q.RequestType, q.CommandUUID, q.UDID)
+	return nil
+}
+
+func runLostMode(args []string) error {
+	fs := flag.NewFlagSet("command lost-mode", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	var (
+		flMessage  = fs.String("message", "", "message to show on the lock screen")
+		flPhone    = fs.String("phone-number", "", "phone number to show on the lock screen")
+		flFootnote = fs.String("footnote", "", "footnote to show on the lock screen")
+		flDisable  = fs.Bool("disable", false, "take the device out of Lost Mode instead")
+	)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: micromdm-webhook command lost-mode [flags] <udid>
+
+Lost Mode needs a -message or -phone-number. Once the device confirms it,
+the server asks for its location; see "micromdm-webhook devices show".`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	var q client.QueuedCommand
+	var err error
+	if *flDisable {
+		q, err = newClient().DisableLostMode(ctx, fs.Arg(0))
+	} else {
+		q, err = newClient().EnableLostMode(ctx, fs.Arg(0), client.LostModeOptions{Message: *flMessage, PhoneNumber: *flPhone, Footnote: *flFootnote})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("queued %s command %s for device %s\n", q.RequestType, q.CommandUUID, q.UDID)
+	return nil
+}
+
+func runRotateFileVaultKey(args []string) error {
+	fs := flag.NewFlagSet("command rotate-filevault-key", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: micromdm-webhook command rotate-filevault-key [flags] <udid>")
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	q, err := newClient().RotateFileVaultKey(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("queued %s command %s for device %s\n", q.RequestType, q.CommandUUID, q.UDID)
+	return nil
+}
+
+func runLocate(args []string) error {
+	fs := flag.NewFlagSet("command locate", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: micromdm-webhook command locate [flags] <udid>")
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	q, err := newClient().RequestDeviceLocation(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("queued %s command %s for device %s\n", q.RequestType, q.CommandUUID, q.UDID)
+	return nil
+}
+
+func runPush(args []string) error {
+	fs := flag.NewFlagSet("command push", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	user := fs.String("user", "", "push the channel of the macOS user with this UserID")
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: micromdm-webhook command push [flags] <udid>")
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	p, err := newClient().PushDevice(ctx, fs.Arg(0), client.PushDeviceParams{UserID: *user})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("pushed device %s through %s\n", p.UDID, p.Via)
+	return nil
+}
+
+// runPower runs the command restart or shutdown.
+func runPower(name string, args []string) error {
+	fs := flag.NewFlagSet("command "+name, flag.ExitOnError)
+	newClient := adminFlags(fs)
+	var flNotify *bool
+	if name == "restart" {
+		flNotify = fs.Bool("notify-user", false, "on macOS, let the user save their work and postpone the restart")
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: micromdm-webhook command %s [flags] <udid>\n\nDevices other than Macs must be supervised.\n", name)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	var q client.QueuedCommand
+	var err error
+	if flNotify != nil {
+		q, err = newClient().RestartDevice(ctx, fs.Arg(0), client.RestartOptions{NotifyUser: *flNotify})
+	} else {
+		q, err = newClient().ShutDownDevice(ctx, fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("queued %s command %s for device %s\n", q.RequestType, q.CommandUUID, q.UDID)
+	return nil
+}
+
+func runSettings(args []string) error {
+	fs := flag.NewFlagSet("command settings", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	var settings client.DeviceSettings
+	fs.StringVar(&settings.DeviceName, "device-name", "", `device name, a template executed with the device, e.g. '{{.Info.SerialNumber}} – {{.TagValue "user"}}'`)
+	fs.StringVar(&settings.HostName, "hostname", "", "hostname of a Mac, a template like -device-name")
+	toggles := map[string]**bool{
+		"data-roaming":          &settings.DataRoaming,
+		"voice-roaming":         &settings.VoiceRoaming,
+		"personal-hotspot":      &settings.PersonalHotspot,
+		"bluetooth":             &settings.Bluetooth,
+		"diagnostic-submission": &settings.DiagnosticSubmission,
+		"app-analytics":         &settings.AppAnalytics,
+	}
+	for name, field := range toggles {
+		fs.Func(name, "turn "+strings.ReplaceAll(name, "-", " ")+" on (true) or off (false)", func(v string) error {
+			on, err := strconv.ParseBool(v)
+			if err != nil {
+				return err
+			}
+			*field = &on
+			return nil
+		})
+	}
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: micromdm-webhook command settings [flags] <udid>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	q, err := newClient().ChangeSettings(ctx, fs.Arg(0), settings)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("queued %s command %s for device %s\n", q.RequestType, q.CommandUUID, q.UDID)
+	return nil
+}
+
+func runInstallApp(args []string) error {
+	fs := flag.NewFlagSet("command install-app", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	var (
+		flVPP       = fs.Bool("vpp", false, "install a VPP-licensed app (purchase method 1); apps given by bundle ID must be")
+		flRemove    = fs.Bool("remove-with-mdm", false, "remove the app when the device leaves MDM")
+		flNoBackups = fs.Bool("prevent-backup", false, "keep the app's data out of backups")
+		user        = fs.String("user", "", "install the app on the channel of the macOS user with this UserID, with a license assigned to the user")
+	)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: micromdm-webhook command install-app [flags] <udid> <itunes_store_id|bundle_id>
+
+The install is confirmed once the device's ManagedApplicationList shows the
+app as Managed; "micromdm-webhook devices show <udid>" has its state, and
+"micromdm-webhook devices licenses" the VPP licenses the installs took.`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	var req client.AppInstallRequest
+	if id, err := strconv.ParseInt(fs.Arg(1), 10, 64); err == nil {
+		req.ITunesStoreID = id
+	} else {
+		req.Identifier = fs.Arg(1)
+	}
+	if *flVPP {
+		req.PurchaseMethod = 1
+	}
+	if *flRemove {
+		req.ManagementFlags |= 1
+	}
+	if *flNoBackups {
+		req.ManagementFlags |= 4
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	var (
+		q   client.QueuedCommand
+		err error
+	)
+	if *user != "" {
+		q, err = newClient().InstallUserApplication(ctx, fs.Arg(0), *user, req)
+	} else {
+		q, err = newClient().InstallApplication(ctx, fs.Arg(0), req)
+	}
+	if err != nil {
+		return err
+	}
+	printQueued(q)
+	return nil
+}
+
+func runInstallEnterpriseApp(args []string) error {
+	fs := flag.NewFlagSet("command install-enterprise-app", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: micromdm-webhook command install-enterprise-app [flags] <udid> <package>
+
+The package is the name of a .pkg or .ipa file in the server's -app-dir.
+Without a package, the hosted packages are listed.`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	ctx, cancel := cliContext()
+	defer cancel()
+	if fs.NArg() == 0 {
+		apps, err := newClient().ListEnterpriseApps(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSIZE\tMODIFIED")
+		for _, a := range apps {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", a.Name, a.Size, a.ModifiedAt.Local().Format(time.RFC3339))
+		}
+		return tw.Flush()
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	q, err := newClient().InstallEnterpriseApp(ctx, fs.Arg(0), client.EnterpriseAppRequest{Name: fs.Arg(1)})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("queued %s command %s for device %s\n", q.RequestType, q.CommandUUID, q.UDID)
+	return nil
+}
+
+func runOSUpdate(args []string) error {
+	fs := flag.NewFlagSet("command os-update", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	var (
+		flAction   = fs.String("action", "Default", "install action: Default, DownloadOnly, NotifyOnly, InstallASAP, InstallLater, or InstallForceRestart")
+		flDeadline = fs.Duration("deadline", 0, "force the install if the update has not completed this long from now, e.g. 72h (none when 0)")
+	)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: micromdm-webhook command os-update [flags] <udid> <product_key>
+
+The product key must be one of the device's available updates, as shown by
+"micromdm-webhook devices show <udid>" once it answered AvailableOSUpdates.`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	req := client.OSUpdateRequest{ProductKey: fs.Arg(1), InstallAction: *flAction}
+	if *flDeadline > 0 {
+		deadline := time.Now().Add(*flDeadline).UTC()
+		req.Deadline = &deadline
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	q, err := newClient().ScheduleOSUpdate(ctx, fs.Arg(0), req)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("queued %s command %s for device %s\n", q.RequestType, q.CommandUUID, q.UDID)
+	return nil
+}
+
+func runErase(args []string) error {
+	fs := flag.NewFlagSet("command erase", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	var (
+		flConfirm   = fs.String("confirm", "", "token of an earlier erase request to confirm, sending the command")
+		flPIN       = fs.String("pin", "", "Find My PIN for Macs (generated and escrowed when empty, with the server's -escrow-key)")
+		flDataPlan  = fs.Bool("preserve-data-plan", false, "keep the device's cellular data plan")
+		flProximity = fs.Bool("disallow-proximity-setup", false, "do not let the erased device be set up from one nearby")
+	)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: micromdm-webhook command erase [flags] <udid>
+
+Erasing takes two steps: without -confirm, the server is asked to erase the
+device and answers with a token; run the command again with -confirm <token>
+before it expires to send EraseDevice.`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	c := newClient()
+	if *flConfirm != "" {
+		q, err := c.ConfirmErase(ctx, fs.Arg(0), client.EraseConfirmation{Token: *flConfirm})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("queued %s command %s for device %s\n", q.RequestType, q.CommandUUID, q.UDID)
+		return nil
+	}
+	req, err := c.RequestErase(ctx, fs.Arg(0), client.EraseOptions{PIN: *flPIN, PreserveDataPlan: *flDataPlan, DisallowProximitySetup: *flProximity})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("to erase device %s, run before %s:\n  micromdm-webhook command erase -confirm %s %s\n", req.UDID, req.ExpiresAt.Local().Format(time.RFC3339), req.Token, req.UDID)
+	return nil
+}
+
+func runStatic(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: micromdm-webhook static list|url")
+	}
+	switch args[0] {
+	case "list":
+		return staticList(args[1:])
+	case "url":
+		return staticURL(args[1:])
+	}
+	return fmt.Errorf("unknown static command %q", args[0])
+}
+
+func staticList(args []string) error {
+	fs := flag.NewFlagSet("static list", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	flJSON := fs.Bool("json", false, "print the files as JSON")
+	parseFlags(fs, args)
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	files, err := newClient().ListStaticFiles(ctx)
+	if err != nil {
+		return err
+	}
+	if *flJSON {
+		return printJSON(files)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PATH\tSIZE\tMODIFIED")
+	for _, f := range files {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", f.Path, f.Size, f.ModifiedAt.Local().Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func staticURL(args []string) error {
+	fs := flag.NewFlagSet("static url", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	flTTL := fs.Duration("ttl", 0, "how long the URL stays valid (the server's -static-url-ttl when 0)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: micromdm-webhook static url [flags] <path>
+
+The path is that of a file under the server's -static-dir, as static list
+prints it. The signed URL is printed, for the payloads of commands.`)
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	req := client.StaticURLRequest{Path: fs.Arg(0)}
+	if *flTTL > 0 {
+		req.TTL = flTTL.String()
+	}
+	u, err := newClient().SignStaticURL(ctx, req)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "expires %s\n", u.ExpiresAt.Local().Format(time.RFC3339))
+	fmt.Println(u.URL)
+	return nil
+}
+
+func runEvents(args []string) error {
+	if len(args) == 0 || args[0] != "tail" {
+		return fmt.Errorf("usage: micromdm-webhook events tail [flags]")
+	}
+	fs := flag.NewFlagSet("events tail", flag.ExitOnError)
+	newClient := adminFlags(fs)
+	var (
+		flUDID = fs.String("udid", "", "only print events for this device")
+		flJSON = fs.Bool("json", false, "print each event as a line of JSON")
+	)
+	parseFlags(fs, args[1:])
+
+	ctx, cancel := cliContext()
+	defer cancel()
+	enc := json.NewEncoder(os.Stdout)
+	err := newClient().StreamEvents(ctx, client.StreamEventsParams{UDID: *flUDID}, func(e client.Event) error {
+		if *flJSON {
+			return enc.Encode(e)
+		}
+		line := fmt.Sprintf("%s  %-18s %s", e.Time.Local().Format(time.RFC3339), e.Topic, e.UDID)
+		if e.CommandUUID != "" {
+			line += fmt.Sprintf("  %s %s", e.Status, e.CommandUUID)
+		}
+		fmt.Println(line)
+		return nil
+	})
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
+
+func printJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
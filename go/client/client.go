@@ -0,0 +1,189 @@
+// Package client is a Go client for the micromdm-webhook admin API described
+// in openapi.yaml. Its types and methods are generated from the spec into
+// types.gen.go and client.gen.go; this file holds the transport they share.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Client calls the admin API of a micromdm-webhook server.
+type Client struct {
+	// BaseURL is the URL of the webhook server, e.g. https://webhook.example.com.
+	BaseURL string
+	// Token is the server's admin token.
+	Token string
+	// User, if set, names the caller in the server's audit logs. It is sent
+	// as the basic auth user name, with Token as the password.
+	User string
+	// HTTPClient is used for requests. http.DefaultClient is used when nil.
+	HTTPClient *http.Client
+}
+
+// New returns a Client for the server at baseURL.
+func New(baseURL, token string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), Token: token}
+}
+
+// Error is returned when the server answers with a non-2xx status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("admin API returned %d: %s", e.StatusCode, e.Message)
+}
+
+// ListAllDevices follows the pagination of ListDevices and returns every
+// matching device. params.Cursor is ignored.
+func (c *Client) ListAllDevices(ctx context.Context, params ListDevicesParams) ([]Device, error) {
+	params.Cursor = ""
+	var all []Device
+	for {
+		page, next, err := c.ListDevices(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page...)
+		if next == "" {
+			return all, nil
+		}
+		params.Cursor = next
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// rawBody is a request body sent as it is rather than encoded as JSON.
+type rawBody struct {
+	contentType string
+	data        []byte
+}
+
+// authorize sets the credentials of req.
+func (c *Client) authorize(req *http.Request) {
+	if c.User != "" {
+		req.SetBasicAuth(c.User, c.Token)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+}
+
+// do sends a request with in as its body and decodes the response into out.
+// A *[]byte out receives the response body as it is.
+func (c *Client) do(ctx context.Context, method, path string, in, out interface{}) (*http.Response, error) {
+	var body io.Reader
+	contentType := "application/json"
+	switch in := in.(type) {
+	case nil:
+	case rawBody:
+		body, contentType = bytes.NewReader(in.data), in.contentType
+	default:
+		b, err := json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("encode request: %v", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(req)
+	if _, raw := out.(*[]byte); !raw {
+		req.Header.Set("Accept", "application/json")
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return resp, &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	switch out := out.(type) {
+	case nil:
+	case *[]byte:
+		if *out, err = io.ReadAll(resp.Body); err != nil {
+			return resp, fmt.Errorf("read response: %v", err)
+		}
+	default:
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("decode response: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+// stream calls fn with the data of each server-sent event of a GET of path,
+// until ctx is done, the stream ends, or fn returns an error.
+func (c *Client) stream(ctx context.Context, path string, fn func(data []byte) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	c.authorize(req)
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return &Error{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if err := fn([]byte(data)); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return scanner.Err()
+}
+
+var nextLink = regexp.MustCompile(`<([^>]*)>;\s*rel="next"`)
+
+// nextCursor extracts the cursor parameter from the rel="next" URL of a Link
+// header.
+func nextCursor(link string) string {
+	m := nextLink.FindStringSubmatch(link)
+	if m == nil {
+		return ""
+	}
+	u, err := url.Parse(m[1])
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("cursor")
+}
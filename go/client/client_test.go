@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// specPaths returns a pattern for the requests of each operation of
+// ../openapi.yaml, keyed by the operations as in "GET /api/devices/{udid}".
+func specPaths(t *testing.T) map[string]*regexp.Regexp {
+	t.Helper()
+	b, err := os.ReadFile("../openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec struct {
+		Paths map[string]map[string]yaml.Node `yaml:"paths"`
+	}
+	if err := yaml.Unmarshal(b, &spec); err != nil {
+		t.Fatalf("parse openapi.yaml: %v", err)
+	}
+	param := regexp.MustCompile(`\\\{[^}]+\}`)
+	ops := make(map[string]*regexp.Regexp)
+	for path, item := range spec.Paths {
+		re := regexp.MustCompile("^/api" + param.ReplaceAllString(regexp.QuoteMeta(path), "[^/]+") + "$")
+		for method := range item {
+			switch method {
+			case "get", "put", "post", "delete", "patch":
+				ops[strings.ToUpper(method)+" /api"+path] = re
+			}
+		}
+	}
+	return ops
+}
+
+// TestClientMatchesSpec calls every method of Client and checks that each
+// request it makes is an operation of openapi.yaml, and that every
+// operation is made by some method.
+func TestClientMatchesSpec(t *testing.T) {
+	ops := specPaths(t)
+	var (
+		mu   sync.Mutex
+		seen = make(map[string]bool)
+		req  string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		req = r.Method + " " + r.URL.EscapedPath()
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL, "token")
+	v := reflect.ValueOf(c)
+	for i := 0; i < v.NumMethod(); i++ {
+		m, name := v.Method(i), v.Type().Method(i).Name
+		args := make([]reflect.Value, m.Type().NumIn())
+		for j := range args {
+			switch typ := m.Type().In(j); {
+			case typ.Implements(reflect.TypeOf((*context.Context)(nil)).Elem()):
+				args[j] = reflect.ValueOf(context.Background())
+			case typ.Kind() == reflect.String:
+				args[j] = reflect.ValueOf("x").Convert(typ)
+			case typ.Kind() == reflect.Func:
+				args[j] = reflect.MakeFunc(typ, func([]reflect.Value) []reflect.Value {
+					out := make([]reflect.Value, typ.NumOut())
+					for k := range out {
+						out[k] = reflect.Zero(typ.Out(k))
+					}
+					return out
+				})
+			default:
+				args[j] = reflect.Zero(typ)
+			}
+		}
+		mu.Lock()
+		req = ""
+		mu.Unlock()
+		m.Call(args)
+		mu.Lock()
+		got := req
+		mu.Unlock()
+		if got == "" {
+			t.Errorf("%s made no request", name)
+			continue
+		}
+		method, path, _ := strings.Cut(got, " ")
+		matched := false
+		for op, re := range ops {
+			if strings.HasPrefix(op, method+" ") && re.MatchString(path) {
+				seen[op], matched = true, true
+			}
+		}
+		if !matched {
+			t.Errorf("%s requests %q, which is not in openapi.yaml", name, got)
+		}
+	}
+	for op := range ops {
+		if !seen[op] {
+			t.Errorf("openapi.yaml operation %q has no Client method", op)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestGenerated checks that the generated files checked in are those
+// openapi.yaml generates, so that go generate was run after editing it.
+func TestGenerated(t *testing.T) {
+	spec, err := os.ReadFile("../../openapi.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, err := generate(spec)
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	for _, name := range sortedKeys(files) {
+		got, err := os.ReadFile(filepath.Join("../..", name))
+		if err != nil {
+			t.Errorf("%s: %v; run go generate", name, err)
+			continue
+		}
+		if !bytes.Equal(got, files[name]) {
+			t.Errorf("%s is out of date with openapi.yaml; run go generate", name)
+		}
+	}
+}
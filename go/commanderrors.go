@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/micromdm/micromdm/mdm"
+	"github.com/sirupsen/logrus"
+)
+
+// The classes of command failures.
+const (
+	// errorTransient failures, such as the device losing its network while
+	// downloading, may succeed if the command is sent again.
+	errorTransient = "transient"
+	// errorPermanent failures would only fail again.
+	errorPermanent = "permanent"
+)
+
+// commandErrorVars counts the commands devices failed, by class, and how
+// many were sent again (retried) or given up on after failing transiently
+// as many times as Server.ErrorRetry allows (exhausted).
+var commandErrorVars = expvar.NewMap("command_errors")
+
+// defaultTransientErrors are the error domains whose failures are retried
+// unless -transient-errors says otherwise: network errors.
+const defaultTransientErrors = "NSURLErrorDomain,NSPOSIXErrorDomain,kCFErrorDomainCFNetwork"
+
+// defaultErrorRetryPolicy resends commands that failed transiently twice,
+// after about 5 and 10 minutes.
+var defaultErrorRetryPolicy = RetryPolicy{MaxAttempts: 3, Backoff: 5 * time.Minute, MaxBackoff: 6 * time.Hour}
+
+// errorMatcher matches the ErrorChain entries of a domain and, if code is
+// set, of that error code only.
+type errorMatcher struct {
+	domain string
+	code   *int
+}
+
+// parseErrorMatchers parses a comma-separated list of error domains, or
+// domain:code pairs.
+func parseErrorMatchers(list string) ([]errorMatcher, error) {
+	var matchers []errorMatcher
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		domain, code, found := strings.Cut(s, ":")
+		m := errorMatcher{domain: domain}
+		if found {
+			n, err := strconv.Atoi(code)
+			if err != nil {
+				return nil, fmt.Errorf("error %q: code is not a number", s)
+			}
+			m.code = &n
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+func (m errorMatcher) matches(e mdm.ErrorChainItem) bool {
+	return e.ErrorDomain == m.domain && (m.code == nil || *m.code == e.ErrorCode)
+}
+
+// classifyError returns the class of the failure ack reports, or "" if the
+// device did not fail the command. CommandFormatError responses are
+// permanent, as are errors none of whose ErrorChain entries match
+// s.TransientErrors.
+func (s *Server) classifyError(ack acknowledgment) string {
+	switch ack.Status {
+	case "CommandFormatError":
+		return errorPermanent
+	case "Error":
+		for _, e := range ack.ErrorChain {
+			for _, m := range s.TransientErrors {
+				if m.matches(e) {
+					return errorTransient
+				}
+			}
+		}
+		return errorPermanent
+	}
+	return ""
+}
+
+// CommandFailure is the error a device answered a command with.
+type CommandFailure struct {
+	Status     string               `json:"status"`
+	Class      string               `json:"class"`
+	ErrorChain []mdm.ErrorChainItem `json:"error_chain,omitempty"`
+	Time       time.Time            `json:"time"`
+}
+
+// failPending records the failure ack reports against pending, the command
+// it answers. A transient failure of a command that has been sent fewer
+// times than s.ErrorRetry allows is kept pending and sent again after a
+// backoff, and failPending reports true; other failed commands stop being
+// tracked.
+func (s *Server) failPending(ctx context.Context, pending PendingCommand, ack acknowledgment, class string) bool {
+	logger := logFor(ctx).WithFields(logrus.Fields{
+		"request_type": pending.RequestType,
+		"status":       ack.Status,
+		"error_class":  class,
+		"error_chain":  ack.ErrorChain,
+		"attempt":      pending.Attempt,
+	})
+	if class != errorTransient || pending.command == nil || pending.Attempt >= s.ErrorRetry.MaxAttempts {
+		s.Pending.Remove(pending.UUID)
+		if class == errorTransient && pending.command != nil {
+			commandErrorVars.Add("exhausted", 1)
+		}
+		logger.Warn("device failed command")
+		return false
+	}
+	wait := s.ErrorRetry.delay(pending.Attempt)
+	retryAt := ack.Time.Add(wait)
+	pending.Failure = &CommandFailure{Status: ack.Status, Class: class, ErrorChain: ack.ErrorChain, Time: ack.Time}
+	pending.RetryAt = &retryAt
+	s.Pending.Add(pending)
+	logger.WithField("retry_in", wait.String()).Warn("device failed command, retrying")
+	ctx = context.WithoutCancel(ctx)
+	time.AfterFunc(wait, func() { s.retryCommand(ctx, pending.UUID) })
+	return true
+}
+
+// retryCommand sends the failed pending command with the given UUID again.
+// It does nothing if the command is no longer tracked.
+func (s *Server) retryCommand(ctx context.Context, uuid string) {
+	pending, ok := s.Pending.Remove(uuid)
+	if !ok {
+		return
+	}
+	logger := logFor(ctx).WithFields(logrus.Fields{"udid": pending.UDID, "request_type": pending.RequestType, "retry_of": pending.firstUUID()})
+	newUUID, err := s.postCommandTo(ctx, pending.UDID, pending.UserID, pending.RequestType, pending.command)
+	if err != nil {
+		logger.WithError(err).Error("resend failed command")
+		return
+	}
+	commandErrorVars.Add("retried", 1)
+	if resent, ok := s.Pending.Get(newUUID); ok {
+		resent.Attempt = pending.Attempt + 1
+		resent.RetryOf = pending.firstUUID()
+		resent.Failure = pending.Failure
+		s.Pending.Add(resent)
+	}
+	logger.WithFields(logrus.Fields{"command_uuid": newUUID, "attempt": pending.Attempt + 1}).Info("resent failed command")
+}
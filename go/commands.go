@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PendingCommand is a command queued in MicroMDM that the device has not
+// answered yet.
+type PendingCommand struct {
+	UUID        string    `json:"command_uuid"`
+	UDID        string    `json:"udid"`
+	RequestType string    `json:"request_type"`
+	SentAt      time.Time `json:"sent_at"`
+
+	// UserID is set for commands sent on the channel of a macOS user.
+	UserID string `json:"user_id,omitempty"`
+
+	// Attempt counts the times the command was sent, from 1. A command
+	// sent again after failing transiently has a new UUID; RetryOf is that
+	// of its first attempt, and Failure the error of the previous one.
+	Attempt int             `json:"attempt"`
+	RetryOf string          `json:"retry_of,omitempty"`
+	Failure *CommandFailure `json:"failure,omitempty"`
+
+	// RetryAt is when a failed command is sent again.
+	RetryAt *time.Time `json:"retry_at,omitempty"`
+
+	// Deferrals counts the NotNow responses to the command, the last at
+	// DeferredAt. PushAt is when the device is pushed to check in again.
+	Deferrals  int        `json:"deferrals,omitempty"`
+	DeferredAt *time.Time `json:"deferred_at,omitempty"`
+	PushAt     *time.Time `json:"push_at,omitempty"`
+
+	// command is the body the command was sent with, kept to send it again
+	// if it fails transiently.
+	command json.RawMessage
+}
+
+// firstUUID returns the UUID of the first attempt of c.
+func (c PendingCommand) firstUUID() string {
+	if c.RetryOf != "" {
+		return c.RetryOf
+	}
+	return c.UUID
+}
+
+// commandTracker keeps the commands awaiting a response, keyed by
+// CommandUUID.
+type commandTracker struct {
+	mu      sync.Mutex
+	pending map[string]PendingCommand
+}
+
+func newCommandTracker() *commandTracker {
+	return &commandTracker{pending: make(map[string]PendingCommand)}
+}
+
+// Add records a newly sent command.
+func (t *commandTracker) Add(c PendingCommand) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[c.UUID] = c
+}
+
+// Get returns the pending command with the given UUID.
+func (t *commandTracker) Get(uuid string) (PendingCommand, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.pending[uuid]
+	return c, ok
+}
+
+// Remove stops tracking the command with the given UUID and returns it.
+func (t *commandTracker) Remove(uuid string) (PendingCommand, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.pending[uuid]
+	delete(t.pending, uuid)
+	return c, ok
+}
+
+// List returns the pending commands, oldest first.
+func (t *commandTracker) List() []PendingCommand {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cmds := make([]PendingCommand, 0, len(t.pending))
+	for _, c := range t.pending {
+		cmds = append(cmds, c)
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].SentAt.Before(cmds[j].SentAt) })
+	return cmds
+}
+
+// ForDevice returns the pending commands of the device with the given UDID,
+// oldest first.
+func (t *commandTracker) ForDevice(udid string) []PendingCommand {
+	var cmds []PendingCommand
+	for _, c := range t.List() {
+		if c.UDID == udid {
+			cmds = append(cmds, c)
+		}
+	}
+	return cmds
+}
+
+// Expire stops tracking and returns the commands sent before cutoff, other
+// than those waiting to be sent again.
+func (t *commandTracker) Expire(cutoff time.Time) []PendingCommand {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var expired []PendingCommand
+	for uuid, c := range t.pending {
+		if c.RetryAt == nil && c.SentAt.Before(cutoff) {
+			expired = append(expired, c)
+			delete(t.pending, uuid)
+		}
+	}
+	return expired
+}
+
+// expirePendingLoop periodically drops commands that have gone unanswered
+// for longer than timeout, logging each one.
+func (s *Server) expirePendingLoop(ctx context.Context, timeout time.Duration) {
+	interval := timeout / 10
+	if interval < time.Minute {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, c := range s.Pending.Expire(time.Now().Add(-timeout)) {
+			logrus.WithFields(logrus.Fields{
+				"udid":         c.UDID,
+				"request_type": c.RequestType,
+				"command_uuid": c.UUID,
+				"sent_at":      c.SentAt,
+			}).Warn("device never answered command")
+		}
+	}
+}
+
+// defaultResponsiveWindow is how recently devices must have answered Idle
+// to count as responsive unless -responsive-window says otherwise.
+const defaultResponsiveWindow = 24 * time.Hour
+
+// idleVars counts the Idle responses of devices that drained their command
+// queue (drained), and of those with commands still pending, e.g. deferred
+// with NotNow (pending).
+var idleVars = expvar.NewMap("idle")
+
+// recordIdle records that d answered Idle at time at: MicroMDM had no more
+// commands for it, so the device is working through its queue.
+func (s *Server) recordIdle(ctx context.Context, d *Device, at time.Time) {
+	d.IdleAt = &at
+	if pending := s.Pending.ForDevice(d.UDID); len(pending) > 0 {
+		idleVars.Add("pending", 1)
+		logFor(ctx).WithFields(logrus.Fields{"pending": len(pending), "oldest_sent_at": pending[0].SentAt}).Info("device idle with commands pending")
+		return
+	}
+	idleVars.Add("drained", 1)
+	logFor(ctx).Debug("device drained its command queue")
+}
+
+// isResponsive reports whether d is enrolled and has drained its command
+// queue, answering Idle, since the given time.
+func isResponsive(d Device, since time.Time) bool {
+	return d.Enrolled && d.IdleAt != nil && !d.IdleAt.Before(since)
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+)
+
+// complianceVars counts, by policy, the devices that fell out of compliance
+// with it.
+var complianceVars = expvar.NewMap("compliance")
+
+// CompliancePolicy is a requirement on the inventory of devices, evaluated
+// whenever the webhook handles an event of a device it applies to.
+type CompliancePolicy struct {
+	// Name identifies the policy in device statuses, logs, notifications,
+	// and /debug/vars.
+	Name string `yaml:"name"`
+
+	// Tags and Platform limit the policy to the devices matching the tag
+	// expression, and on one of the platforms, if they are set.
+	Tags     *TagExpr     `yaml:"tags"`
+	Platform RulePatterns `yaml:"platform"`
+
+	// MinOSVersion is the oldest OS version allowed, e.g. "17.4".
+	MinOSVersion string `yaml:"min-os-version"`
+
+	// FileVault requires FileVault to be on, and Passcode a passcode to be
+	// set, as reported by SecurityInfo.
+	FileVault bool `yaml:"filevault"`
+	Passcode  bool `yaml:"passcode"`
+
+	// Profiles are the identifiers of profiles that must be installed, as
+	// reported by ProfileList.
+	Profiles []string `yaml:"profiles"`
+
+	// Then are actions, like those of rules, run when a device falls out
+	// of compliance with the policy; notifications carry the violations.
+	Then []RuleAction `yaml:"then"`
+
+	// rule runs Then.
+	rule *rule
+}
+
+func (p CompliancePolicy) validate() error {
+	if p.MinOSVersion == "" && !p.FileVault && !p.Passcode && len(p.Profiles) == 0 {
+		return fmt.Errorf("no requirements; set min-os-version, filevault, passcode, or profiles")
+	}
+	if p.MinOSVersion != "" {
+		if _, err := parseVersion(p.MinOSVersion); err != nil {
+			return fmt.Errorf("invalid min-os-version %q", p.MinOSVersion)
+		}
+	}
+	for _, platform := range p.Platform {
+		if !slices.ContainsFunc(platforms, func(known string) bool { return strings.EqualFold(platform, known) }) {
+			return fmt.Errorf("unknown platform %q; want one of %s", platform, strings.Join(platforms, ", "))
+		}
+	}
+	for i, a := range p.Then {
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("action %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether the policy is for d.
+func (p *CompliancePolicy) appliesTo(d Device) bool {
+	if p.Tags != nil && !p.Tags.matches(d) {
+		return false
+	}
+	return len(p.Platform) == 0 || slices.ContainsFunc(p.Platform, func(platform string) bool { return strings.EqualFold(platform, d.Platform) })
+}
+
+// evaluate returns the status of d under the policy and its violations. A
+// requirement the device has not reported the inventory for makes it
+// unknown, unless another one is violated.
+func (p *CompliancePolicy) evaluate(d Device) (string, []string) {
+	var violations []string
+	unknown := false
+	if p.MinOSVersion != "" {
+		if d.Info == nil || d.Info.OSVersion == "" {
+			unknown = true
+		} else if !versionMatches(d.Info.OSVersion, ">=", p.MinOSVersion) {
+			violations = append(violations, fmt.Sprintf("OS version %s is older than %s", d.Info.OSVersion, p.MinOSVersion))
+		}
+	}
+	if p.FileVault || p.Passcode {
+		switch {
+		case d.Security == nil:
+			unknown = true
+		case p.FileVault && !d.Security.FDEEnabled:
+			violations = append(violations, "FileVault is off")
+		}
+		if d.Security != nil && p.Passcode && !d.Security.PasscodePresent {
+			violations = append(violations, "no passcode is set")
+		}
+	}
+	if len(p.Profiles) > 0 {
+		if d.Profiles == nil {
+			unknown = true
+		}
+		for _, id := range p.Profiles {
+			if d.Profiles != nil && !slices.ContainsFunc(d.Profiles, func(ip store.InstalledProfile) bool { return ip.Identifier == id }) {
+				violations = append(violations, fmt.Sprintf("profile %s is not installed", id))
+			}
+		}
+	}
+	switch {
+	case len(violations) > 0:
+		return store.ComplianceNoncompliant, violations
+	case unknown:
+		return store.ComplianceUnknown, nil
+	}
+	return store.ComplianceCompliant, nil
+}
+
+// addCompliance sets up policies to be evaluated on the server's events,
+// adding the notifiers and hooks of their actions as addRules does.
+func (s *Server) addCompliance(policies []CompliancePolicy, smtp EmailOptions) error {
+	for i := range policies {
+		p := &policies[i]
+		r, err := s.newRule("compliance", Rule{Name: p.Name, Then: p.Then}, smtp)
+		if err != nil {
+			return fmt.Errorf("compliance: %v", err)
+		}
+		p.rule = r
+		complianceVars.Set(p.Name, r.matched)
+		s.Compliance = append(s.Compliance, p)
+	}
+	return nil
+}
+
+// checkCompliance evaluates the compliance policies against the device an
+// event is about, as stored once it was handled, and runs the actions of
+// those it fell out of compliance with. body is the event as received, for
+// hooks. Devices that are not enrolled keep their last status.
+func (s *Server) checkCompliance(ctx context.Context, event webhook.Event, udid string, body []byte) {
+	if len(s.Compliance) == 0 || udid == "" {
+		return
+	}
+	d, exists, err := s.loadDevice(udid)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device for compliance")
+		return
+	}
+	if !exists || !d.Enrolled || isRetired(d) {
+		return
+	}
+	now := eventTime(event)
+	prev := make(map[string]store.PolicyStatus)
+	if d.Compliance != nil {
+		for _, ps := range d.Compliance.Policies {
+			prev[ps.Policy] = ps
+		}
+	}
+	c := &store.Compliance{Status: store.ComplianceCompliant, Policies: []store.PolicyStatus{}}
+	changed := d.Compliance == nil
+	var violated []*CompliancePolicy
+	for _, p := range s.Compliance {
+		if !p.appliesTo(d) {
+			continue
+		}
+		status, violations := p.evaluate(d)
+		ps := store.PolicyStatus{Policy: p.Name, Status: status, Violations: violations, Since: now}
+		old, ok := prev[p.Name]
+		if ok && old.Status == status {
+			ps.Since = old.Since
+		}
+		if !ok || old.Status != status || !slices.Equal(old.Violations, violations) {
+			changed = true
+		}
+		if status == store.ComplianceNoncompliant && old.Status != status {
+			violated = append(violated, p)
+		} else if ok && old.Status == store.ComplianceNoncompliant && status == store.ComplianceCompliant {
+			logFor(ctx).WithField("policy", p.Name).Info("device is compliant again")
+		}
+		c.Policies = append(c.Policies, ps)
+		switch {
+		case status == store.ComplianceNoncompliant:
+			c.Status = status
+		case status == store.ComplianceUnknown && c.Status == store.ComplianceCompliant:
+			c.Status = status
+		}
+	}
+	if len(c.Policies) != len(prev) {
+		changed = true
+	}
+	if !changed {
+		return
+	}
+	c.UpdatedAt = now
+	if len(c.Policies) == 0 {
+		c = nil
+	}
+	d.Compliance = c
+	for _, p := range violated {
+		p.rule.matched.Add(1)
+		ps := c.Policies[slices.IndexFunc(c.Policies, func(ps store.PolicyStatus) bool { return ps.Policy == p.Name })]
+		ctx := withLogger(ctx, logFor(ctx).WithField("policy", p.Name))
+		logFor(ctx).WithField("violations", ps.Violations).Warn("device fell out of compliance")
+		n := s.newNotification(notifyNoncompliant, d, event)
+		n.Policy, n.Violations = p.Name, ps.Violations
+		if s.Notifiers != nil {
+			s.Notifiers.notify(ctx, n)
+		}
+		s.runActions(ctx, p.rule, &d, event, n, body)
+	}
+	if err := s.Devices.Save(d); err != nil {
+		logFor(ctx).WithError(err).Error("save device compliance")
+	}
+}
+
+// ComplianceReport is how the devices measure up to the compliance policies.
+type ComplianceReport struct {
+	Policies []PolicyReport `json:"policies"`
+	Devices  []DeviceReport `json:"devices"`
+}
+
+// PolicyReport counts the devices by their status under a policy.
+type PolicyReport struct {
+	Name         string `json:"name"`
+	Compliant    int    `json:"compliant"`
+	Noncompliant int    `json:"noncompliant"`
+	Unknown      int    `json:"unknown"`
+}
+
+// DeviceReport is the compliance of one device.
+type DeviceReport struct {
+	UDID string `json:"udid"`
+	Name string `json:"device_name,omitempty"`
+	store.Compliance
+}
+
+// handleGetComplianceReport returns a ComplianceReport of the devices, limited
+// to the status given by the status parameter, under the policy given by the
+// policy parameter, if they are set. The counts of each policy are of every
+// device.
+func (s *Server) handleGetComplianceReport(w http.ResponseWriter, r *http.Request) {
+	status, policy := r.URL.Query().Get("status"), r.URL.Query().Get("policy")
+	switch status {
+	case "", store.ComplianceCompliant, store.ComplianceNoncompliant, store.ComplianceUnknown:
+	default:
+		http.Error(w, fmt.Sprintf("invalid status %q; want compliant, noncompliant, or unknown", status), http.StatusBadRequest)
+		return
+	}
+	devices, err := s.Devices.List()
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("list devices")
+		http.Error(w, fmt.Sprintf("list devices: %v", err), http.StatusInternalServerError)
+		return
+	}
+	report := ComplianceReport{Policies: []PolicyReport{}, Devices: []DeviceReport{}}
+	counts := make(map[string]*PolicyReport)
+	for _, p := range s.Compliance {
+		report.Policies = append(report.Policies, PolicyReport{Name: p.Name})
+	}
+	for i := range report.Policies {
+		counts[report.Policies[i].Name] = &report.Policies[i]
+	}
+	for _, d := range devices {
+		if d.Compliance == nil {
+			continue
+		}
+		match := status == "" || d.Compliance.Status == status
+		if policy != "" {
+			match = false
+		}
+		for _, ps := range d.Compliance.Policies {
+			if pr := counts[ps.Policy]; pr != nil {
+				switch ps.Status {
+				case store.ComplianceCompliant:
+					pr.Compliant++
+				case store.ComplianceNoncompliant:
+					pr.Noncompliant++
+				default:
+					pr.Unknown++
+				}
+			}
+			if ps.Policy == policy && (status == "" || ps.Status == status) {
+				match = true
+			}
+		}
+		if !match {
+			continue
+		}
+		dr := DeviceReport{UDID: d.UDID, Compliance: *d.Compliance}
+		if d.Info != nil {
+			dr.Name = d.Info.DeviceName
+		}
+		report.Devices = append(report.Devices, dr)
+	}
+	writeJSON(w, http.StatusOK, report)
+}
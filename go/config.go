@@ -0,0 +1,541 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/micromdm/micromdm/mdm"
+	"gopkg.in/yaml.v3"
+)
+
+// TopicConfig changes how the webhook handles one event topic.
+type TopicConfig struct {
+	// Ignore drops events of the topic without processing them.
+	Ignore bool `yaml:"ignore" toml:"ignore"`
+
+	// Commands, for mdm.TokenUpdate, replaces the request types sent to a
+	// device when it enrolls.
+	Commands []string `yaml:"commands" toml:"commands"`
+
+	// RoutingKey, when set, replaces -amqp-routing-key for events of the
+	// topic.
+	RoutingKey string `yaml:"routing-key" toml:"routing-key"`
+
+	// Notify sends messages rendered from templates for every event of the
+	// topic.
+	Notify []TopicNotifier `yaml:"notify" toml:"notify"`
+
+	// Exec runs programs for every event of the topic.
+	Exec []ExecHook `yaml:"exec" toml:"exec"`
+}
+
+// TenantConfig is one MicroMDM server served by the webhook alongside (or
+// instead of) the one given by -server-url.
+type TenantConfig struct {
+	ServerURL string `yaml:"server-url"`
+	APIToken  string `yaml:"api-token"`
+
+	// The remaining settings, when set, replace the flags of the same name
+	// for the tenant.
+	AdminToken      string `yaml:"admin-token"`
+	WebhookSecret   string `yaml:"webhook-secret"`
+	WebhookUser     string `yaml:"webhook-user"`
+	WebhookPassword string `yaml:"webhook-password"`
+	WebhookToken    string `yaml:"webhook-token"`
+}
+
+// fileConfig holds the settings of a config file that do not map to flags.
+type fileConfig struct {
+	Topics  map[string]TopicConfig
+	Tenants map[string]TenantConfig
+	Forward []ForwardTarget
+	Email   []EmailRoute
+	Rules   []Rule
+
+	Blueprints []Blueprint
+
+	Decommission *Decommission
+
+	Compliance []CompliancePolicy
+
+	CertRenewal *CertRenewal
+}
+
+// defaultEnrollCommands are sent to a device on its TokenUpdate unless the
+// config file says otherwise.
+var defaultEnrollCommands = []string{
+	"InstalledApplicationList",
+	"DeviceInformation",
+	"SecurityInfo",
+	"ProfileList",
+	"CertificateList",
+}
+
+// loadConfigFile applies the config file named by the -config flag in args,
+// if any, to fs. Every key other than topics, tenants, forward, email, rules,
+// blueprints, decommission, compliance, and cert-renewal names a flag of fs; nested tables are joined with "-", so
+//
+//	redis:
+//	  addr: localhost:6379
+//
+// sets -redis-addr. Lists are joined with commas. Values are set before the
+// command line is parsed, so flags given explicitly take precedence.
+func loadConfigFile(fs *flag.FlagSet, args []string) (fileConfig, error) {
+	return readConfigFile(fs, args, true)
+}
+
+// loadSharedConfigFile is like loadConfigFile, but ignores the settings fs
+// has no flag for, so commands other than serve can read the server's
+// config file.
+func loadSharedConfigFile(fs *flag.FlagSet, args []string) (fileConfig, error) {
+	return readConfigFile(fs, args, false)
+}
+
+func readConfigFile(fs *flag.FlagSet, args []string, strict bool) (fileConfig, error) {
+	var fc fileConfig
+	path := configPath(args)
+	if path == "" {
+		return fc, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fc, fmt.Errorf("read config file: %v", err)
+	}
+
+	var raw map[string]interface{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".toml":
+		err = toml.Unmarshal(b, &raw)
+	default:
+		return fc, fmt.Errorf("config file %s: unsupported format %q, want .yaml, .yml, or .toml", path, ext)
+	}
+	if err != nil {
+		return fc, fmt.Errorf("parse config file %s: %v", path, err)
+	}
+
+	if t, ok := raw["topics"]; ok {
+		delete(raw, "topics")
+		if fc.Topics, err = decodeTopics(t); err != nil {
+			return fc, fmt.Errorf("config file %s: %v", path, err)
+		}
+	}
+	if t, ok := raw["tenants"]; ok {
+		delete(raw, "tenants")
+		if fc.Tenants, err = decodeTenants(t); err != nil {
+			return fc, fmt.Errorf("config file %s: %v", path, err)
+		}
+	}
+	if t, ok := raw["forward"]; ok {
+		delete(raw, "forward")
+		if fc.Forward, err = decodeForward(t); err != nil {
+			return fc, fmt.Errorf("config file %s: %v", path, err)
+		}
+	}
+	if t, ok := raw["email"]; ok {
+		delete(raw, "email")
+		if fc.Email, err = decodeEmailRoutes(t); err != nil {
+			return fc, fmt.Errorf("config file %s: %v", path, err)
+		}
+	}
+
+	if t, ok := raw["rules"]; ok {
+		delete(raw, "rules")
+		if fc.Rules, err = decodeRules(t, filepath.Dir(path)); err != nil {
+			return fc, fmt.Errorf("config file %s: %v", path, err)
+		}
+	}
+	if t, ok := raw["decommission"]; ok {
+		delete(raw, "decommission")
+		if fc.Decommission, err = decodeDecommission(t); err != nil {
+			return fc, fmt.Errorf("config file %s: %v", path, err)
+		}
+	}
+	if t, ok := raw["compliance"]; ok {
+		delete(raw, "compliance")
+		if fc.Compliance, err = decodeCompliance(t, filepath.Dir(path)); err != nil {
+			return fc, fmt.Errorf("config file %s: %v", path, err)
+		}
+	}
+	if t, ok := raw["cert-renewal"]; ok {
+		delete(raw, "cert-renewal")
+		if fc.CertRenewal, err = decodeCertRenewal(t, filepath.Dir(path)); err != nil {
+			return fc, fmt.Errorf("config file %s: %v", path, err)
+		}
+	}
+	if t, ok := raw["blueprints"]; ok {
+		delete(raw, "blueprints")
+		if fc.Blueprints, err = decodeBlueprints(t, filepath.Dir(path)); err != nil {
+			return fc, fmt.Errorf("config file %s: %v", path, err)
+		}
+	}
+
+	values := make(map[string]string)
+	if err := flattenConfig("", raw, values); err != nil {
+		return fc, fmt.Errorf("config file %s: %v", path, err)
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "config" || fs.Lookup(k) == nil {
+			if !strict {
+				continue
+			}
+			return fc, fmt.Errorf("config file %s: unknown setting %q", path, k)
+		}
+		if err := fs.Set(k, values[k]); err != nil {
+			return fc, fmt.Errorf("config file %s: %s: %v", path, k, err)
+		}
+	}
+	return fc, nil
+}
+
+// envAliases are environment variables accepted in place of the generic
+// WEBHOOK_ name of a flag.
+var envAliases = map[string]string{
+	"server-url": "MICROMDM_URL",
+	"api-token":  "MICROMDM_API_TOKEN",
+}
+
+// envName returns the environment variable for a flag: WEBHOOK_ followed by
+// the flag name in upper case with dashes replaced by underscores, e.g.
+// WEBHOOK_REDIS_ADDR for -redis-addr.
+func envName(flag string) string {
+	return "WEBHOOK_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// applyEnv sets each flag of fs from its environment variable, if set. It is
+// called after loadConfigFile and before parsing the command line, so the
+// environment overrides the config file and flags override both.
+func applyEnv(fs *flag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+		names := []string{envName(f.Name)}
+		if alias, ok := envAliases[f.Name]; ok {
+			names = append([]string{alias}, names...)
+		}
+		for _, name := range names {
+			v, ok := os.LookupEnv(name)
+			if !ok {
+				continue
+			}
+			if e := fs.Set(f.Name, v); e != nil {
+				err = fmt.Errorf("environment variable %s: %v", name, e)
+			}
+			return
+		}
+	})
+	return err
+}
+
+// requireSetting returns an error naming every way to provide the flag if
+// its value is empty.
+func requireSetting(value, flag, what string) error {
+	if value != "" {
+		return nil
+	}
+	env := envName(flag)
+	if alias, ok := envAliases[flag]; ok {
+		env = alias + " or " + env
+	}
+	return fmt.Errorf("missing %s: set -%s, %s, or %s in the config file", what, flag, env, flag)
+}
+
+// configPath returns the value of the -config flag in args without parsing
+// the rest of them, falling back to its environment variable.
+func configPath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if v, ok := strings.CutPrefix(name, "config="); ok {
+			return v
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv(envName("config"))
+}
+
+func flattenConfig(prefix string, v interface{}, out map[string]string) error {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			key := k
+			if prefix != "" {
+				key = prefix + "-" + k
+			}
+			if err := flattenConfig(key, child, out); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			if _, ok := item.(map[string]interface{}); ok {
+				return fmt.Errorf("%s: lists of tables are not supported", prefix)
+			}
+			items[i] = fmt.Sprint(item)
+		}
+		out[prefix] = strings.Join(items, ",")
+	default:
+		out[prefix] = fmt.Sprint(v)
+	}
+	return nil
+}
+
+// redecode converts a table of the config file into out by re-encoding it,
+// which works the same for YAML and TOML input.
+func redecode(v interface{}, out interface{}) error {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(strings.NewReader(string(b)))
+	dec.KnownFields(true)
+	return dec.Decode(out)
+}
+
+// knownTopic reports whether the webhook handles events of topic: those
+// MicroMDM sends, and any a handler is registered for.
+func knownTopic(topic string) bool {
+	_, ok := topicHandlers[topic]
+	return ok
+}
+
+func decodeTopics(v interface{}) (map[string]TopicConfig, error) {
+	var topics map[string]TopicConfig
+	if err := redecode(v, &topics); err != nil {
+		return nil, fmt.Errorf("topics: %v", err)
+	}
+	for topic, tc := range topics {
+		if !knownTopic(topic) {
+			return nil, fmt.Errorf("topics: unknown topic %q", topic)
+		}
+		if len(tc.Commands) > 0 && topic != mdm.TokenUpdateTopic {
+			return nil, fmt.Errorf("topics: %s: commands can only be set for %s", topic, mdm.TokenUpdateTopic)
+		}
+		for _, requestType := range tc.Commands {
+			if _, _, err := parseCommandPayload([]byte(fmt.Sprintf(`{"request_type": %q}`, requestType))); err != nil {
+				return nil, fmt.Errorf("topics: %s: %v", topic, err)
+			}
+		}
+		for i, tn := range tc.Notify {
+			if err := tn.validate(); err != nil {
+				return nil, fmt.Errorf("topics: %s: notify entry %d: %v", topic, i+1, err)
+			}
+		}
+		for i, h := range tc.Exec {
+			if err := h.validate(); err != nil {
+				return nil, fmt.Errorf("topics: %s: exec entry %d: %v", topic, i+1, err)
+			}
+		}
+	}
+	return topics, nil
+}
+
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func decodeTenants(v interface{}) (map[string]TenantConfig, error) {
+	var tenants map[string]TenantConfig
+	if err := redecode(v, &tenants); err != nil {
+		return nil, fmt.Errorf("tenants: %v", err)
+	}
+	for name, tc := range tenants {
+		if !tenantName.MatchString(name) {
+			return nil, fmt.Errorf("tenants: invalid tenant name %q: use lower-case letters, digits, dashes, and underscores", name)
+		}
+		if tc.ServerURL == "" || tc.APIToken == "" {
+			return nil, fmt.Errorf("tenants: %s: server-url and api-token are required", name)
+		}
+	}
+	return tenants, nil
+}
+
+func decodeForward(v interface{}) ([]ForwardTarget, error) {
+	var targets []ForwardTarget
+	if err := redecode(v, &targets); err != nil {
+		return nil, fmt.Errorf("forward: %v", err)
+	}
+	for i, t := range targets {
+		u, err := url.Parse(t.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("forward: entry %d: url must be an http or https URL", i+1)
+		}
+		for _, topic := range t.Topics {
+			if !knownTopic(topic) {
+				return nil, fmt.Errorf("forward: entry %d: unknown topic %q", i+1, topic)
+			}
+		}
+		if (t.User == "") != (t.Password == "") {
+			return nil, fmt.Errorf("forward: entry %d: user and password must be set together", i+1)
+		}
+	}
+	return targets, nil
+}
+
+func decodeEmailRoutes(v interface{}) ([]EmailRoute, error) {
+	var routes []EmailRoute
+	if err := redecode(v, &routes); err != nil {
+		return nil, fmt.Errorf("email: %v", err)
+	}
+	for i, r := range routes {
+		if len(r.To) == 0 {
+			return nil, fmt.Errorf("email: entry %d: no recipients", i+1)
+		}
+		for _, to := range r.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return nil, fmt.Errorf("email: entry %d: recipient %q: %v", i+1, to, err)
+			}
+		}
+		for _, e := range r.Events {
+			if !slices.Contains(notifyEvents, e) {
+				return nil, fmt.Errorf("email: entry %d: unknown event %q", i+1, e)
+			}
+		}
+	}
+	return routes, nil
+}
+
+// decodeRules decodes the rules of a config file in dir, reading the
+// profiles of their profile actions.
+func decodeRules(v interface{}, dir string) ([]Rule, error) {
+	var rules []Rule
+	if err := redecode(v, &rules); err != nil {
+		return nil, fmt.Errorf("rules: %v", err)
+	}
+	names := make(map[string]bool)
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rules: entry %d: no name", i+1)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("rules: duplicate rule %q", r.Name)
+		}
+		names[r.Name] = true
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("rules: %s: %v", r.Name, err)
+		}
+		if err := loadActionProfiles(r.Then, dir); err != nil {
+			return nil, fmt.Errorf("rules: %s: %v", r.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+// loadActionProfiles reads the profiles of the profile actions of a config
+// file in dir.
+func loadActionProfiles(actions []RuleAction, dir string) error {
+	for i := range actions {
+		a := &actions[i]
+		if a.Profile == "" {
+			continue
+		}
+		var err error
+		if a.profile, err = loadProfile(a.Profile, dir); err != nil {
+			return fmt.Errorf("action %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// decodeCompliance decodes the compliance policies of a config file in dir,
+// reading the profiles of their profile actions.
+func decodeCompliance(v interface{}, dir string) ([]CompliancePolicy, error) {
+	var policies []CompliancePolicy
+	if err := redecode(v, &policies); err != nil {
+		return nil, fmt.Errorf("compliance: %v", err)
+	}
+	names := make(map[string]bool)
+	for i, p := range policies {
+		if p.Name == "" {
+			return nil, fmt.Errorf("compliance: entry %d: no name", i+1)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("compliance: duplicate policy %q", p.Name)
+		}
+		names[p.Name] = true
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("compliance: %s: %v", p.Name, err)
+		}
+		if err := loadActionProfiles(p.Then, dir); err != nil {
+			return nil, fmt.Errorf("compliance: %s: %v", p.Name, err)
+		}
+	}
+	return policies, nil
+}
+
+// decodeCertRenewal decodes the cert-renewal table of a config file in dir,
+// reading the profiles of its profile actions.
+func decodeCertRenewal(v interface{}, dir string) (*CertRenewal, error) {
+	var cr CertRenewal
+	if err := redecode(v, &cr); err != nil {
+		return nil, fmt.Errorf("cert-renewal: %v", err)
+	}
+	if err := cr.validate(); err != nil {
+		return nil, fmt.Errorf("cert-renewal: %v", err)
+	}
+	if err := loadActionProfiles(cr.Then, dir); err != nil {
+		return nil, fmt.Errorf("cert-renewal: %v", err)
+	}
+	return &cr, nil
+}
+
+func decodeDecommission(v interface{}) (*Decommission, error) {
+	var dc Decommission
+	if err := redecode(v, &dc); err != nil {
+		return nil, fmt.Errorf("decommission: %v", err)
+	}
+	if err := dc.validate(); err != nil {
+		return nil, fmt.Errorf("decommission: %v", err)
+	}
+	return &dc, nil
+}
+
+// decodeBlueprints decodes the blueprints of a config file in dir, reading
+// their profiles.
+func decodeBlueprints(v interface{}, dir string) ([]Blueprint, error) {
+	var blueprints []Blueprint
+	if err := redecode(v, &blueprints); err != nil {
+		return nil, fmt.Errorf("blueprints: %v", err)
+	}
+	names := make(map[string]bool)
+	for i := range blueprints {
+		b := &blueprints[i]
+		if b.Name == "" {
+			return nil, fmt.Errorf("blueprints: entry %d: no name", i+1)
+		}
+		if names[b.Name] {
+			return nil, fmt.Errorf("blueprints: duplicate blueprint %q", b.Name)
+		}
+		names[b.Name] = true
+		if err := b.validate(); err != nil {
+			return nil, fmt.Errorf("blueprints: %s: %v", b.Name, err)
+		}
+		if err := b.loadProfiles(dir); err != nil {
+			return nil, fmt.Errorf("blueprints: %s: %v", b.Name, err)
+		}
+	}
+	return blueprints, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a cron spec of five fields, minute, hour, day of month,
+// month, and day of week, each *, a value, a range a-b, or a list of them,
+// any of which can take a step, as in */15 or 1-5/2. Days of the week run
+// from 0 (or 7) for Sunday. As in cron, a time matches when both day fields
+// do, or either does if neither is *. The specs @hourly, @daily (or
+// @midnight), @weekly, and @monthly stand for the usual fields, and
+// @every <duration> for a fixed interval, like 6h.
+type cronSchedule struct {
+	spec string
+
+	// every is the interval of an @every spec.
+	every time.Duration
+
+	// The values of each field that match, as bits.
+	minute, hour, dom, month, dow uint64
+
+	// anyDOM and anyDOW are set if the day fields are *.
+	anyDOM, anyDOW bool
+}
+
+var cronShorthands = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// parseCronSchedule parses a cron spec.
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	c := &cronSchedule{spec: spec}
+	if every, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(every))
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("schedule %q: want @every and a duration of at least 1s", spec)
+		}
+		c.every = d
+		return c, nil
+	}
+	fields := strings.Fields(spec)
+	if s, ok := cronShorthands[spec]; ok {
+		fields = strings.Fields(s)
+	}
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: want 5 fields (minute hour day-of-month month day-of-week), or @hourly, @daily, @weekly, @monthly, or @every <duration>", spec)
+	}
+	var err error
+	for i, f := range []struct {
+		name     string
+		bits     *uint64
+		min, max int
+	}{
+		{"minute", &c.minute, 0, 59},
+		{"hour", &c.hour, 0, 23},
+		{"day of month", &c.dom, 1, 31},
+		{"month", &c.month, 1, 12},
+		{"day of week", &c.dow, 0, 7},
+	} {
+		if *f.bits, err = parseCronField(fields[i], f.min, f.max); err != nil {
+			return nil, fmt.Errorf("schedule %q: %s: %v", spec, f.name, err)
+		}
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.anyDOM, c.anyDOW = fields[2] == "*", fields[4] == "*"
+	return c, nil
+}
+
+// parseCronField returns the values of field, between min and max, as bits.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			loText, hiText, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loText); err != nil {
+				return 0, fmt.Errorf("invalid value %q", loText)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiText); err != nil {
+					return 0, fmt.Errorf("invalid value %q", hiText)
+				}
+			} else if hasStep {
+				hi = max
+			}
+			if lo < min || hi > max || lo > hi {
+				return 0, fmt.Errorf("%q is out of range %d-%d", rng, min, max)
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// next returns the first time after t the schedule matches, or the zero time
+// if it never does, as with February 30.
+func (c *cronSchedule) next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Add(c.every)
+	}
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			// Past the end of daylight saving time, the next hour can
+			// come out as the one repeated.
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			if !next.After(t) {
+				next = t.Truncate(time.Minute).Add(time.Duration(60-t.Minute()) * time.Minute)
+			}
+			t = next
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom, dow := c.dom&(1<<t.Day()) != 0, c.dow&(1<<int(t.Weekday())) != 0
+	if c.anyDOM || c.anyDOW {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (c *cronSchedule) String() string {
+	return c.spec
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "* * * * *"},
+		{spec: "*/15 9-17 * * 1-5"},
+		{spec: "0,30 0 1,15 */2 7"},
+		{spec: "5/10 * * * *"},
+		{spec: "@daily"},
+		{spec: " @hourly "},
+		{spec: "@every 6h"},
+		{spec: "", wantErr: true},
+		{spec: "* * * *", wantErr: true},
+		{spec: "* * * * * *", wantErr: true},
+		{spec: "60 * * * *", wantErr: true},
+		{spec: "* 24 * * *", wantErr: true},
+		{spec: "* * 0 * *", wantErr: true},
+		{spec: "* * * 13 *", wantErr: true},
+		{spec: "* * * * 8", wantErr: true},
+		{spec: "5-1 * * * *", wantErr: true},
+		{spec: "*/0 * * * *", wantErr: true},
+		{spec: "a * * * *", wantErr: true},
+		{spec: "@yearly", wantErr: true},
+		{spec: "@every", wantErr: true},
+		{spec: "@every 500ms", wantErr: true},
+		{spec: "@every soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		c, err := parseCronSchedule(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseCronSchedule(%q) error = %v, want error %v", tt.spec, err, tt.wantErr)
+		}
+		if err == nil && c.String() == "" {
+			t.Errorf("parseCronSchedule(%q).String() is empty", tt.spec)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("load time zone: %v", err)
+	}
+	date := func(loc *time.Location, year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"* * * * *", date(time.UTC, 2026, 10, 14, 12, 0).Add(30 * time.Second), date(time.UTC, 2026, 10, 14, 12, 1)},
+		{"*/15 * * * *", date(time.UTC, 2026, 10, 14, 12, 0), date(time.UTC, 2026, 10, 14, 12, 15)},
+		{"30 2 * * *", date(time.UTC, 2026, 10, 14, 3, 0), date(time.UTC, 2026, 10, 15, 2, 30)},
+		{"@hourly", date(time.UTC, 2026, 10, 14, 23, 59), date(time.UTC, 2026, 10, 15, 0, 0)},
+		{"@monthly", date(time.UTC, 2026, 12, 14, 0, 0), date(time.UTC, 2027, 1, 1, 0, 0)},
+		// 2026-10-14 is a Wednesday.
+		{"0 9 * * 1-5", date(time.UTC, 2026, 10, 16, 10, 0), date(time.UTC, 2026, 10, 19, 9, 0)},
+		{"0 0 * * 7", date(time.UTC, 2026, 10, 14, 0, 0), date(time.UTC, 2026, 10, 18, 0, 0)},
+		// Either day field matches when neither is *.
+		{"0 0 13 * 5", date(time.UTC, 2026, 10, 14, 0, 0), date(time.UTC, 2026, 10, 16, 0, 0)},
+		// Both must when one is.
+		{"0 0 13 * *", date(time.UTC, 2026, 10, 14, 0, 0), date(time.UTC, 2026, 11, 13, 0, 0)},
+		{"0 0 29 2 *", date(time.UTC, 2026, 3, 1, 0, 0), date(time.UTC, 2028, 2, 29, 0, 0)},
+		{"0 0 30 2 *", date(time.UTC, 2026, 1, 1, 0, 0), time.Time{}},
+		{"@every 90m", date(time.UTC, 2026, 10, 14, 12, 0), date(time.UTC, 2026, 10, 14, 13, 30)},
+		// 2:30 does not exist on 2026-03-08 in New York.
+		{"30 2 * * *", date(newYork, 2026, 3, 7, 12, 0), date(newYork, 2026, 3, 9, 2, 30)},
+		{"0 3 * * *", date(newYork, 2026, 3, 7, 12, 0), date(newYork, 2026, 3, 8, 3, 0)},
+		// 1:00 comes twice on 2026-11-01.
+		{"0 2 * * *", date(newYork, 2026, 11, 1, 0, 30), date(newYork, 2026, 11, 1, 2, 0)},
+	}
+	for _, tt := range tests {
+		c, err := parseCronSchedule(tt.spec)
+		if err != nil {
+			t.Fatalf("parseCronSchedule(%q): %v", tt.spec, err)
+		}
+		if got := c.next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("%q next(%v) = %v, want %v", tt.spec, tt.from, got, tt.want)
+		}
+	}
+}
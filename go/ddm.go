@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// ddmVars counts the declaration syncs and status reports of devices, and
+// the devices sent fallback profiles instead of declarations.
+var ddmVars = expvar.NewMap("declarative_management")
+
+// declarativeManagementTopic is the topic of the DeclarativeManagement
+// check-ins of devices, for MDM servers that post them to the webhook.
+const declarativeManagementTopic = "mdm.DeclarativeManagement"
+
+// enrollmentIDHeader carries the UDID of the device whose
+// DeclarativeManagement check-in is passed on to /declarative-management/, as
+// NanoMDM's -dm option sends it.
+const enrollmentIDHeader = "X-Enrollment-ID"
+
+// declarationKinds maps the type prefixes of declarations to their kind, as
+// named in status reports and declaration/<kind>/<identifier> endpoints.
+var declarationKinds = map[string]string{
+	"com.apple.activation.":    "activation",
+	"com.apple.configuration.": "configuration",
+	"com.apple.asset.":         "asset",
+	"com.apple.management.":    "management",
+}
+
+// declaration is a Declarative Device Management declaration as served to
+// devices.
+type declaration struct {
+	Type        string          `json:"Type"`
+	Identifier  string          `json:"Identifier"`
+	ServerToken string          `json:"ServerToken"`
+	Payload     json.RawMessage `json:"Payload"`
+
+	kind string
+}
+
+func declarationKind(typ string) string {
+	for prefix, kind := range declarationKinds {
+		if strings.HasPrefix(typ, prefix) {
+			return kind
+		}
+	}
+	return ""
+}
+
+// declarationSet is the declarations of a blueprint, and the token devices
+// tell whether they have them all by.
+type declarationSet struct {
+	items     []declaration
+	token     string
+	timestamp time.Time
+}
+
+// emptyDeclarations are served to devices whose blueprint has no
+// declarations, which removes any they were sent before.
+var emptyDeclarations = newDeclarationSet(nil)
+
+func newDeclarationSet(items []declaration) *declarationSet {
+	tokens := make([]string, len(items))
+	for i, d := range items {
+		tokens[i] = d.kind + "/" + d.Identifier + ":" + d.ServerToken
+	}
+	sort.Strings(tokens)
+	sum := sha256.Sum256([]byte(strings.Join(tokens, "\n")))
+	return &declarationSet{items: items, token: hex.EncodeToString(sum[:16]), timestamp: time.Now().UTC().Truncate(time.Second)}
+}
+
+// loadDeclarations reads the declarations of the blueprint called name, JSON
+// files at paths relative to dir. ServerTokens default to a hash of the
+// file. Unless the files include an activation, one activating all their
+// configurations is added.
+func loadDeclarations(name string, paths []string, dir string) (*declarationSet, error) {
+	var items []declaration
+	seen := make(map[string]bool)
+	activations, configurations := 0, []string{}
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read declaration: %v", err)
+		}
+		var d declaration
+		if err := json.Unmarshal(raw, &d); err != nil {
+			return nil, fmt.Errorf("declaration %s: %v", filepath.Base(path), err)
+		}
+		if d.kind = declarationKind(d.Type); d.kind == "" {
+			return nil, fmt.Errorf("declaration %s: unknown Type %q", filepath.Base(path), d.Type)
+		}
+		if d.Identifier == "" {
+			return nil, fmt.Errorf("declaration %s: no Identifier", filepath.Base(path))
+		}
+		if seen[d.Identifier] {
+			return nil, fmt.Errorf("declaration %s: duplicate Identifier %q", filepath.Base(path), d.Identifier)
+		}
+		seen[d.Identifier] = true
+		if len(d.Payload) == 0 {
+			d.Payload = json.RawMessage("{}")
+		}
+		if d.ServerToken == "" {
+			sum := sha256.Sum256(raw)
+			d.ServerToken = hex.EncodeToString(sum[:8])
+		}
+		switch d.kind {
+		case "activation":
+			activations++
+		case "configuration":
+			configurations = append(configurations, d.Identifier)
+		}
+		items = append(items, d)
+	}
+	if activations == 0 && len(configurations) > 0 {
+		payload, err := json.Marshal(map[string][]string{"StandardConfigurations": configurations})
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(payload)
+		items = append(items, declaration{
+			Type:        "com.apple.activation.simple",
+			Identifier:  "micromdm-webhook.blueprint." + name + ".activation",
+			ServerToken: hex.EncodeToString(sum[:8]),
+			Payload:     payload,
+			kind:        "activation",
+		})
+	}
+	return newDeclarationSet(items), nil
+}
+
+// syncTokens is the body of tokens responses, and the Data of
+// DeclarativeManagement commands.
+func (ds *declarationSet) syncTokens() interface{} {
+	return map[string]interface{}{
+		"SyncTokens": map[string]interface{}{
+			"DeclarationsToken": ds.token,
+			"Timestamp":         ds.timestamp,
+		},
+	}
+}
+
+type declarationItem struct {
+	Identifier  string `json:"Identifier"`
+	ServerToken string `json:"ServerToken"`
+}
+
+// declarationItems is the body of declaration-items responses.
+func (ds *declarationSet) declarationItems() interface{} {
+	lists := map[string][]declarationItem{"Activations": {}, "Configurations": {}, "Assets": {}, "Management": {}}
+	keys := map[string]string{"activation": "Activations", "configuration": "Configurations", "asset": "Assets", "management": "Management"}
+	for _, d := range ds.items {
+		lists[keys[d.kind]] = append(lists[keys[d.kind]], declarationItem{Identifier: d.Identifier, ServerToken: d.ServerToken})
+	}
+	return map[string]interface{}{"Declarations": lists, "DeclarationsToken": ds.token}
+}
+
+func (ds *declarationSet) lookup(kind, identifier string) (declaration, bool) {
+	for _, d := range ds.items {
+		if d.kind == kind && d.Identifier == identifier {
+			return d, true
+		}
+	}
+	return declaration{}, false
+}
+
+// declarationsFor returns the declarations of d's blueprint.
+func (s *Server) declarationsFor(d Device) *declarationSet {
+	if b := s.blueprintNamed(d.Blueprint); b != nil && b.declarations != nil {
+		return b.declarations
+	}
+	return emptyDeclarations
+}
+
+// hasDeclarations reports whether any blueprint has declarations.
+func (s *Server) hasDeclarations() bool {
+	for _, b := range s.Blueprints {
+		if b.declarations != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// supportsDeclarativeManagement reports whether d is new enough for
+// declarations: macOS 13 and iOS, iPadOS, and tvOS 16 are. Devices that did
+// not report a version are given the benefit of the doubt.
+func supportsDeclarativeManagement(d Device) bool {
+	if d.Info == nil {
+		return true
+	}
+	if _, err := parseVersion(d.Info.OSVersion); err != nil {
+		return true
+	}
+	oldest := "16"
+	if mac, _ := isMac(d); mac {
+		oldest = "13"
+	}
+	return compareVersions(d.Info.OSVersion, oldest) >= 0
+}
+
+// declarativeManagementCommand returns the DeclarativeManagement command
+// that turns on Declarative Device Management on d, with the sync tokens of
+// ds, so the device fetches its declarations.
+func declarativeManagementCommand(d Device, ds *declarationSet) (Command, error) {
+	data, err := json.Marshal(ds.syncTokens())
+	if err != nil {
+		return Command{}, fmt.Errorf("encode sync tokens: %v", err)
+	}
+	return Command{UDID: d.UDID, RequestType: "DeclarativeManagement", Data: data}, nil
+}
+
+// applyStatusReport merges the declaration statuses of data, the body of a
+// status report d sent at time at, into d's state.
+func applyStatusReport(ctx context.Context, d *Device, data []byte, at time.Time) error {
+	statuses, err := webhook.ParseStatusReport(data)
+	if err != nil {
+		return err
+	}
+	if d.DeclarativeManagement == nil {
+		d.DeclarativeManagement = &store.DeclarativeManagement{}
+	}
+	dm := d.DeclarativeManagement
+	for _, status := range statuses {
+		status.UpdatedAt = at
+		if status.Valid == "invalid" || !status.Active && len(status.Reasons) > 0 {
+			logFor(ctx).WithFields(logrus.Fields{"declaration": status.Type + "/" + status.Identifier, "valid": status.Valid, "reasons": status.Reasons}).Warn("device did not apply declaration")
+		}
+		i := 0
+		for ; i < len(dm.Declarations); i++ {
+			if dm.Declarations[i].Type == status.Type && dm.Declarations[i].Identifier == status.Identifier {
+				break
+			}
+		}
+		if i < len(dm.Declarations) {
+			dm.Declarations[i] = status
+		} else {
+			dm.Declarations = append(dm.Declarations, status)
+		}
+	}
+	dm.StatusAt = &at
+	ddmVars.Add("status_reports", 1)
+	logFor(ctx).WithField("declarations", len(statuses)).Info("device reported declaration status")
+	return nil
+}
+
+// declarativeManagementHandler returns the endpoint DeclarativeManagement
+// check-ins are passed on to, with the checks of the webhook in front of
+// it.
+func (s *Server) declarativeManagementHandler() http.Handler {
+	return s.withWebhookChecks(http.HandlerFunc(s.serveDeclarativeManagement))
+}
+
+// serveDeclarativeManagement answers a DeclarativeManagement check-in passed
+// on by the MDM server, which MicroMDM does not answer itself: the device's
+// UDID is in X-Enrollment-ID, the path below /declarative-management/ is
+// the Endpoint of the check-in, and the body its Data. The declarations
+// served are those of the device's blueprint.
+func (s *Server) serveDeclarativeManagement(w http.ResponseWriter, r *http.Request) {
+	udid := r.Header.Get(enrollmentIDHeader)
+	if udid == "" {
+		http.Error(w, "missing "+enrollmentIDHeader+" header", http.StatusBadRequest)
+		return
+	}
+	endpoint := r.PathValue("endpoint")
+	ctx := withLogger(r.Context(), logFor(r.Context()).WithFields(logrus.Fields{"udid": udid, "endpoint": endpoint}))
+	d, exists, err := s.loadDevice(udid)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device")
+		http.Error(w, fmt.Sprintf("load device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, fmt.Sprintf("device %s not found", udid), http.StatusNotFound)
+		return
+	}
+	ds := s.declarationsFor(d)
+	now := time.Now().UTC()
+	switch {
+	case endpoint == "tokens":
+		writeJSON(w, http.StatusOK, ds.syncTokens())
+	case endpoint == "declaration-items":
+		if d.DeclarativeManagement == nil {
+			d.DeclarativeManagement = &store.DeclarativeManagement{}
+		}
+		d.DeclarativeManagement.DeclarationsToken, d.DeclarativeManagement.SyncedAt = ds.token, &now
+		if err := s.Devices.Save(d); err != nil {
+			logFor(ctx).WithError(err).Error("save declarations sync")
+		}
+		ddmVars.Add("syncs", 1)
+		writeJSON(w, http.StatusOK, ds.declarationItems())
+	case strings.HasPrefix(endpoint, "declaration/"):
+		kind, identifier, _ := strings.Cut(strings.TrimPrefix(endpoint, "declaration/"), "/")
+		decl, ok := ds.lookup(kind, identifier)
+		if !ok {
+			http.Error(w, fmt.Sprintf("no %s declaration %q for device %s", kind, identifier, udid), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, decl)
+	case endpoint == "status":
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("read request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := applyStatusReport(ctx, &d, data, now); err != nil {
+			logFor(ctx).WithError(err).Error("apply status report")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.Devices.Save(d); err != nil {
+			logFor(ctx).WithError(err).Error("save declaration status")
+			http.Error(w, fmt.Sprintf("save device: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, fmt.Sprintf("unknown DeclarativeManagement endpoint %q", endpoint), http.StatusNotFound)
+	}
+}
+
+// handleDeclarativeManagement stores the status reports of the
+// DeclarativeManagement check-ins MDM servers post to the webhook. The
+// other check-ins fetch declarations, which the webhook cannot answer; they
+// are served by /declarative-management/.
+func (s *Server) handleDeclarativeManagement(ctx context.Context, event webhook.Event, w http.ResponseWriter) {
+	if event.CheckinEvent == nil {
+		logFor(ctx).Error("The event has no CheckinEvent")
+		http.Error(w, "The event has no CheckinEvent", http.StatusBadRequest)
+		return
+	}
+	msg, err := webhook.DecodeDeclarativeManagement(event.CheckinEvent.RawPayload)
+	if err != nil {
+		decodeFailures.Add(1)
+		logFor(ctx).Error(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d, _, err := s.loadDevice(event.CheckinEvent.UDID)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device")
+		http.Error(w, fmt.Sprintf("load device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	d.LastSeen = eventTime(event)
+	if msg.Endpoint != "status" {
+		logFor(ctx).WithField("endpoint", msg.Endpoint).Debug("device fetched declarations")
+	} else if err := applyStatusReport(ctx, &d, msg.Data, eventTime(event)); err != nil {
+		logFor(ctx).WithError(err).Error("apply status report")
+	}
+	if err := s.Devices.Save(d); err != nil {
+		logFor(ctx).WithError(err).Error("save device")
+		http.Error(w, fmt.Sprintf("save device: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
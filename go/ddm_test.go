@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+)
+
+func TestDeclarativeManagementSignature(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("secret"))
+	signed := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	tests := []struct {
+		name      string
+		signature string
+		want      int
+	}{
+		{name: "unsigned", want: http.StatusUnauthorized},
+		{name: "invalid", signature: "sha256=00", want: http.StatusUnauthorized},
+		// The device is unknown, so a signed request gets as far as the
+		// lookup.
+		{name: "signed", signature: signed, want: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer("http://mdm.invalid", "", store.NewMemoryStore())
+			s.WebhookSecret = []byte("secret")
+			r := httptest.NewRequest(http.MethodGet, "/declarative-management/tokens", nil)
+			r.SetPathValue("endpoint", "tokens")
+			r.Header.Set(enrollmentIDHeader, "UDID-1")
+			if tt.signature != "" {
+				r.Header.Set(defaultSignatureHeader, tt.signature)
+			}
+			w := httptest.NewRecorder()
+			s.declarativeManagementHandler().ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+)
+
+// debugHandler serves the net/http/pprof profiles under /debug/pprof/ and
+// the expvar variables, including runtime memory statistics, at
+// /debug/vars. Neither requires credentials, so it is served on its own
+// listener, which should only be reachable by operators.
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// decommissionVars counts the devices decommissioned, and those whose
+// sensitive data was purged.
+var decommissionVars = expvar.NewMap("decommission")
+
+// decommissionCheckInterval is how often decommissioned devices are checked
+// for data due to be purged.
+const decommissionCheckInterval = time.Hour
+
+// Decommission is what happens to a device when it checks out of MDM, in
+// addition to it being marked unenrolled.
+type Decommission struct {
+	// Retire marks the device retired, until it enrolls again.
+	Retire bool `yaml:"retire"`
+
+	// Untag are glob patterns (as in path.Match) of the tags to remove from
+	// the device, e.g. those grouping it for bulk commands.
+	Untag RulePatterns `yaml:"untag"`
+
+	// Notify sends decommissioned notifications, as the notifiers of topics
+	// do, e.g. to an asset management system.
+	Notify []TopicNotifier `yaml:"notify"`
+
+	// PurgeAfter, if set, is how long after checking out the device's
+	// escrowed secrets and location are deleted, unless it enrolled again:
+	// its lock PINs, UnlockToken, Activation Lock bypass code, FileVault
+	// recovery key, bootstrap token, and last reported location.
+	PurgeAfter time.Duration `yaml:"purge-after"`
+
+	// notify holds the notifier queues of Notify.
+	notify []*notifyQueue
+}
+
+func (dc Decommission) validate() error {
+	for _, p := range dc.Untag {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("untag: pattern %q: %v", p, err)
+		}
+	}
+	for i, tn := range dc.Notify {
+		if err := tn.validate(); err != nil {
+			return fmt.Errorf("notify entry %d: %v", i+1, err)
+		}
+	}
+	if dc.PurgeAfter < 0 {
+		return fmt.Errorf("purge-after must not be negative")
+	}
+	return nil
+}
+
+// addDecommission sets up dc to run when devices check out, adding the
+// notifiers of its notify entries. Email notifiers send through the SMTP
+// server of smtp.
+func (s *Server) addDecommission(dc *Decommission, smtp EmailOptions) error {
+	for i, tn := range dc.Notify {
+		name := fmt.Sprintf("decommission/%s-%d", tn.Type, i+1)
+		n, err := newTopicNotifier(name, tn, smtp)
+		if err != nil {
+			return fmt.Errorf("decommission: notify entry %d: %v", i+1, err)
+		}
+		dc.notify = append(dc.notify, s.addNotifier(name, n, nil))
+	}
+	s.Decommission = dc
+	return nil
+}
+
+// decommission runs s.Decommission on d, which checked out at time at, and
+// records what it did in d.Decommissioned.
+func (s *Server) decommission(ctx context.Context, d *Device, at time.Time) {
+	dc := s.Decommission
+	rec := &store.Decommission{CheckedOutAt: at, Retired: dc.Retire}
+	for _, tag := range d.Tags {
+		if matchAny(dc.Untag, tag) {
+			rec.RemovedTags = append(rec.RemovedTags, tag)
+		}
+	}
+	for _, tag := range rec.RemovedTags {
+		d.RemoveTag(tag)
+	}
+	if dc.PurgeAfter > 0 {
+		purgeAt := at.Add(dc.PurgeAfter)
+		rec.PurgeAt = &purgeAt
+	}
+	d.Decommissioned = rec
+	decommissionVars.Add("decommissioned", 1)
+	logFor(ctx).WithFields(logrus.Fields{"retired": rec.Retired, "removed_tags": rec.RemovedTags, "purge_at": rec.PurgeAt}).Info("decommissioned device")
+}
+
+// notifyDecommission sends d, decommissioned by event, to the notifiers of
+// s.Decommission.
+func (s *Server) notifyDecommission(ctx context.Context, d Device, event webhook.Event) {
+	if len(s.Decommission.notify) == 0 {
+		return
+	}
+	n := s.newNotification(notifyDecommissioned, d, event)
+	for _, q := range s.Decommission.notify {
+		s.Notifiers.enqueue(ctx, q, n)
+	}
+}
+
+// purgeDevice deletes the escrowed secrets and location of d.
+func purgeDevice(d *Device, at time.Time) {
+	d.LockPINs = nil
+	d.UnlockToken = nil
+	d.ActivationLockBypassCode = nil
+	d.FileVault = nil
+	d.BootstrapToken = nil
+	d.Location = nil
+	d.Decommissioned.PurgedAt = &at
+}
+
+// decommissionLoop periodically purges the data of decommissioned devices
+// whose retention period is over.
+func (s *Server) decommissionLoop(ctx context.Context) {
+	ticker := time.NewTicker(decommissionCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.purgeDecommissioned(ctx)
+	}
+}
+
+func (s *Server) purgeDecommissioned(ctx context.Context) {
+	devices, err := s.Devices.List()
+	if err != nil {
+		logrus.WithError(err).Error("list devices to purge")
+		return
+	}
+	now := time.Now().UTC()
+	for _, d := range devices {
+		rec := d.Decommissioned
+		if d.Enrolled || rec == nil || rec.PurgeAt == nil || rec.PurgedAt != nil || now.Before(*rec.PurgeAt) {
+			continue
+		}
+		purgeDevice(&d, now)
+		logger := logFor(ctx).WithField("udid", d.UDID)
+		if err := s.Devices.Save(d); err != nil {
+			logger.WithError(err).Error("save purged device")
+			continue
+		}
+		decommissionVars.Add("purged", 1)
+		logger.Warn("purged escrowed secrets and location of decommissioned device")
+	}
+}
+
+// isRetired reports whether d was retired when it checked out, and has not
+// enrolled since.
+func isRetired(d Device) bool {
+	return d.Decommissioned != nil && d.Decommissioned.Retired
+}
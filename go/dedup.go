@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+)
+
+// duplicateEvents counts the webhook events skipped as redeliveries.
+var duplicateEvents = expvar.NewInt("webhook_duplicate_events")
+
+// eventDedup remembers the events handled within the last window, so events
+// MicroMDM delivers again are not handled twice. It is kept in memory, so
+// each webhook instance deduplicates only the events it receives itself.
+type eventDedup struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastSweep time.Time
+}
+
+func newEventDedup(window time.Duration) *eventDedup {
+	return &eventDedup{window: window, seen: make(map[string]time.Time), lastSweep: time.Now()}
+}
+
+// eventKey identifies event: by its ID if MicroMDM gave it one, and
+// otherwise by a hash of its topic, device, payload, and creation time.
+func eventKey(event webhook.Event) string {
+	if event.EventID != "" {
+		return "id:" + event.EventID
+	}
+	h := sha256.New()
+	h.Write([]byte(event.Topic))
+	h.Write([]byte{0})
+	h.Write([]byte(event.CreatedAt.UTC().Format(time.RFC3339Nano)))
+	h.Write([]byte{0})
+	switch {
+	case event.AcknowledgeEvent != nil:
+		h.Write([]byte(event.AcknowledgeEvent.UDID))
+		h.Write([]byte{0})
+		h.Write([]byte(event.AcknowledgeEvent.CommandUUID))
+		h.Write([]byte{0})
+		h.Write(event.AcknowledgeEvent.RawPayload)
+	case event.CheckinEvent != nil:
+		h.Write([]byte(event.CheckinEvent.UDID))
+		h.Write([]byte{0})
+		h.Write(event.CheckinEvent.RawPayload)
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// seenBefore reports whether key was seen within the window, and otherwise
+// records it.
+func (d *eventDedup) seenBefore(key string) bool {
+	now := time.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.lastSweep) >= d.window {
+		for k, t := range d.seen {
+			if now.Sub(t) >= d.window {
+				delete(d.seen, k)
+			}
+		}
+		d.lastSweep = now
+	}
+	if t, ok := d.seen[key]; ok && now.Sub(t) < d.window {
+		return true
+	}
+	d.seen[key] = now
+	return false
+}
+
+// forget removes key, so an event that could not be handled is handled
+// again when MicroMDM retries it.
+func (d *eventDedup) forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.seen, key)
+}
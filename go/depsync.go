@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/dep"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// depVars counts the DEP syncs (syncs) and those that failed (failed), the
+// device records created for serial numbers DEP listed ahead of enrollment
+// (precreated), the devices DEP stopped listing (unassigned), and the
+// enrollments of serial numbers it does not list (not_in_dep).
+var depVars = expvar.NewMap("dep")
+
+// depPlaceholderPrefix starts the UDIDs of the device records created for
+// the serial numbers DEP lists, until the devices enroll and their records
+// are taken over by those of their real UDIDs.
+const depPlaceholderPrefix = "dep:"
+
+// depPlaceholderUDID returns the UDID of the placeholder record of the
+// device with the given serial number.
+func depPlaceholderUDID(serial string) string {
+	return depPlaceholderPrefix + serial
+}
+
+// isDEPPlaceholder reports whether d is the placeholder record of a device
+// that has not enrolled yet.
+func isDEPPlaceholder(d Device) bool {
+	return strings.HasPrefix(d.UDID, depPlaceholderPrefix)
+}
+
+// depSync keeps the devices DEP lists in step with the device store: it
+// fetches them all once, and then the changes since the cursor of the last
+// fetch or sync.
+type depSync struct {
+	url string
+
+	// token, if set, is the OAuth token of the -dep-token file. Otherwise
+	// the token is that of the server's MicroMDM.
+	token *dep.Token
+
+	// mu is held through each sync.
+	mu     sync.Mutex
+	client *dep.Client
+	cursor string
+
+	// listed is set once DEP has been listed, so that serial numbers
+	// missing from it are known not to be assigned to the server.
+	listed atomic.Bool
+}
+
+func newDEPSync(url string, token *dep.Token) *depSync {
+	return &depSync{url: url, token: token}
+}
+
+// loadDEPToken reads a DEP OAuth token file.
+func loadDEPToken(file string) (*dep.Token, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("read DEP token: %v", err)
+	}
+	var token dep.Token
+	if err := json.Unmarshal(b, &token); err != nil {
+		return nil, fmt.Errorf("decode DEP token %s: %v", file, err)
+	}
+	if token.ConsumerKey == "" || token.AccessToken == "" {
+		return nil, fmt.Errorf("DEP token %s has no consumer_key or access_token", file)
+	}
+	return &token, nil
+}
+
+// depSyncLoop syncs the devices DEP lists now and then every interval.
+func (s *Server) depSyncLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.syncDEP(ctx); err != nil && ctx.Err() == nil {
+			depVars.Add("failed", 1)
+			logrus.WithError(err).Error("sync DEP devices")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// depClient returns the client of DEP, made with the token of MicroMDM
+// unless one was given.
+func (s *Server) depClient(ctx context.Context) (*dep.Client, error) {
+	if s.DEP.client != nil {
+		return s.DEP.client, nil
+	}
+	token := s.DEP.token
+	if token == nil {
+		tokens, err := s.mdm().DEPTokens(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(tokens) == 0 {
+			return nil, fmt.Errorf("MicroMDM has no DEP token; add one with mdmctl apply dep-tokens or set -dep-token")
+		}
+		token = &tokens[0]
+	}
+	s.DEP.client = &dep.Client{URL: s.DEP.url, Token: *token}
+	return s.DEP.client, nil
+}
+
+// syncDEP lists the devices DEP added, changed, or removed since the last
+// sync, or all of them the first time and whenever DEP expires the cursor,
+// and applies them to the device store.
+func (s *Server) syncDEP(ctx context.Context) error {
+	s.DEP.mu.Lock()
+	defer s.DEP.mu.Unlock()
+	client, err := s.depClient(ctx)
+	if err != nil {
+		return err
+	}
+	full := s.DEP.cursor == ""
+	cursor := s.DEP.cursor
+	var listed []dep.Device
+	for {
+		var page dep.Page
+		if full {
+			page, err = client.Fetch(ctx, cursor)
+		} else {
+			page, err = client.Sync(ctx, cursor)
+		}
+		if errors.Is(err, dep.ErrCursorExpired) && !full {
+			logrus.Info("DEP cursor expired; fetching all devices again")
+			full, cursor, listed = true, "", nil
+			continue
+		}
+		if err != nil {
+			if s.DEP.token == nil {
+				// MicroMDM may have been given a new token since.
+				s.DEP.client = nil
+			}
+			return err
+		}
+		listed = append(listed, page.Devices...)
+		cursor = page.Cursor
+		if !page.MoreToFollow {
+			break
+		}
+	}
+	if err := s.applyDEPDevices(ctx, listed, full); err != nil {
+		return err
+	}
+	s.DEP.cursor = cursor
+	s.DEP.listed.Store(true)
+	depVars.Add("syncs", 1)
+	return nil
+}
+
+// applyDEPDevices records what DEP listed on the devices with the listed
+// serial numbers, creating placeholder records for those that have not
+// enrolled. A full listing also unassigns the devices it leaves out.
+func (s *Server) applyDEPDevices(ctx context.Context, listed []dep.Device, full bool) error {
+	devices, err := s.Devices.List()
+	if err != nil {
+		return fmt.Errorf("list devices: %v", err)
+	}
+	bySerial := devicesBySerial(devices)
+	now := time.Now().UTC()
+	seen := make(map[string]bool)
+	var created, updated, unassigned int
+	for _, dd := range listed {
+		if dd.SerialNumber == "" {
+			continue
+		}
+		seen[dd.SerialNumber] = true
+		d, ok := bySerial[dd.SerialNumber]
+		if dd.OpType == "deleted" {
+			if ok && s.unassignDEP(ctx, d, now) {
+				unassigned++
+			}
+			continue
+		}
+		if !ok {
+			d = Device{
+				UDID:     depPlaceholderUDID(dd.SerialNumber),
+				LastSeen: now,
+				Info:     &DeviceInfo{SerialNumber: dd.SerialNumber, ModelName: dd.Model},
+				Platform: detectPlatform(&DeviceInfo{ProductName: dd.DeviceFamily, ModelName: dd.Model}),
+			}
+			created++
+		} else {
+			updated++
+		}
+		d.DEP = depAssignment(dd, now)
+		if err := s.Devices.Save(d); err != nil {
+			logFor(ctx).WithError(err).WithField("serial_number", dd.SerialNumber).Error("save DEP device")
+		}
+	}
+	if full {
+		for _, d := range bySerial {
+			if d.DEP != nil && d.DEP.Assigned && !seen[d.DEP.SerialNumber] && s.unassignDEP(ctx, d, now) {
+				unassigned++
+			}
+		}
+	}
+	depVars.Add("precreated", int64(created))
+	depVars.Add("unassigned", int64(unassigned))
+	logrus.WithFields(logrus.Fields{"full": full, "created": created, "updated": updated, "unassigned": unassigned}).Info("synced DEP devices")
+	return nil
+}
+
+// unassignDEP records that DEP no longer lists d, deleting it if it is a
+// placeholder. It reports whether d was assigned.
+func (s *Server) unassignDEP(ctx context.Context, d Device, now time.Time) bool {
+	logger := logFor(ctx).WithFields(logrus.Fields{"udid": d.UDID, "serial_number": deviceSerial(d)})
+	if isDEPPlaceholder(d) {
+		if err := s.Devices.Delete(d.UDID); err != nil {
+			logger.WithError(err).Error("delete DEP placeholder")
+		}
+		return true
+	}
+	if d.DEP == nil || !d.DEP.Assigned {
+		return false
+	}
+	d.DEP.Assigned, d.DEP.UpdatedAt = false, now
+	if err := s.Devices.Save(d); err != nil {
+		logger.WithError(err).Error("save DEP device")
+	}
+	logger.Warn("device is no longer assigned to this server in DEP")
+	return true
+}
+
+func depAssignment(dd dep.Device, now time.Time) *store.DEPAssignment {
+	a := &store.DEPAssignment{
+		Assigned:      true,
+		SerialNumber:  dd.SerialNumber,
+		Model:         dd.Model,
+		Description:   dd.Description,
+		Color:         dd.Color,
+		AssetTag:      dd.AssetTag,
+		OS:            dd.OS,
+		DeviceFamily:  dd.DeviceFamily,
+		ProfileStatus: dd.ProfileStatus,
+		ProfileUUID:   dd.ProfileUUID,
+		AssignedBy:    dd.DeviceAssignedBy,
+		UpdatedAt:     now,
+	}
+	if !dd.DeviceAssignedDate.IsZero() {
+		a.AssignedAt = &dd.DeviceAssignedDate
+	}
+	return a
+}
+
+// devicesBySerial maps the serial numbers of devices to their records,
+// preferring those of enrolled devices to placeholders.
+func devicesBySerial(devices []Device) map[string]Device {
+	bySerial := make(map[string]Device)
+	for _, d := range devices {
+		serial := deviceSerial(d)
+		if prev, ok := bySerial[serial]; serial == "" || (ok && !isPlaceholder(prev)) {
+			continue
+		}
+		bySerial[serial] = d
+	}
+	return bySerial
+}
+
+func deviceSerial(d Device) string {
+	if d.Info != nil {
+		return d.Info.SerialNumber
+	}
+	return ""
+}
+
+// matchDEP reports false if DEP has been synced and does not list d, an
+// enrolling device that took over its placeholders, marking it unassigned.
+func (s *Server) matchDEP(d *Device) bool {
+	if s.DEP == nil || deviceSerial(*d) == "" || (d.DEP != nil && d.DEP.Assigned) || !s.DEP.listed.Load() {
+		return true
+	}
+	if d.DEP == nil {
+		d.DEP = &store.DEPAssignment{SerialNumber: deviceSerial(*d)}
+	}
+	d.DEP.UpdatedAt = d.LastSeen
+	return false
+}
+
+// notifyNotListed logs, counts, and notifies about the enrollment of d, a
+// device DEP does not list, once it is saved.
+func (s *Server) notifyNotListed(ctx context.Context, d Device, event webhook.Event) {
+	depVars.Add("not_in_dep", 1)
+	logFor(ctx).WithField("serial_number", deviceSerial(d)).Warn("device enrolling is not assigned to this server in DEP")
+	s.notify(ctx, notifyNotInDEP, d, event)
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// deviceQuery is a filtered, sorted, paginated view of the device list,
+// parsed from the query string of GET /api/devices.
+type deviceQuery struct {
+	Enrolled  *bool
+	Retired   *bool
+	DEP       *bool
+	Expected  *bool
+	OSOp      string // one of =, >, >=, <, <=, or "" for a prefix match
+	OSVersion string
+	Model     string
+	Platform  string
+	Tag       string
+	Owner     string
+
+	// OsqueryHostID matches the Mac whose osquery host it was linked to.
+	OsqueryHostID string
+
+	// Responsive matches devices that drained their command queue since
+	// RespondedSince, which the caller sets.
+	Responsive     *bool
+	RespondedSince time.Time
+
+	SortBy string // udid, last_seen, os_version, or model
+	Desc   bool
+
+	Limit  int
+	Cursor *deviceCursor
+}
+
+// deviceCursor marks the last device of a page. The next page starts at the
+// first device that sorts after it.
+type deviceCursor struct {
+	UDID      string    `json:"u"`
+	LastSeen  time.Time `json:"t,omitempty"`
+	OSVersion string    `json:"v,omitempty"`
+	Model     string    `json:"m,omitempty"`
+}
+
+func (c deviceCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeDeviceCursor(s string) (*deviceCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var c deviceCursor
+	if err := json.Unmarshal(b, &c); err != nil || c.UDID == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &c, nil
+}
+
+// device returns a placeholder Device with the cursor's sort keys, so it can
+// be compared against real devices.
+func (c deviceCursor) device() Device {
+	d := Device{UDID: c.UDID, LastSeen: c.LastSeen}
+	if c.OSVersion != "" || c.Model != "" {
+		d.Info = &DeviceInfo{OSVersion: c.OSVersion, Model: c.Model}
+	}
+	return d
+}
+
+func cursorFor(d Device) deviceCursor {
+	c := deviceCursor{UDID: d.UDID, LastSeen: d.LastSeen}
+	if d.Info != nil {
+		c.OSVersion = d.Info.OSVersion
+		c.Model = d.Info.Model
+	}
+	return c
+}
+
+// parseDeviceQuery reads the filter, sort, and pagination parameters:
+//
+//	enrolled=true|false
+//	retired=true|false
+//	dep=true|false         (listed by DEP as assigned to the server)
+//	expected=true|false    (on an imported list of expected devices)
+//	responsive=true|false  (answered Idle within -responsive-window)
+//	os_version=17          (17, 17.1, ... )
+//	os_version=>=17        (likewise >, <, <=, =; >= and <= also written os_version>=17)
+//	model=MacBookPro18,3   (matches the model identifier or model name)
+//	platform=macOS         (macOS, iOS, iPadOS, or tvOS)
+//	tag=lab
+//	owner=alice            (the owner an imported list gave)
+//	osquery_host_id=...    (the osquery host the device was linked to)
+//	sort=last_seen         (udid, last_seen, os_version, model; prefix - for descending)
+//	limit=100
+//	cursor=...             (the next cursor from a previous page)
+func parseDeviceQuery(v url.Values) (deviceQuery, error) {
+	q := deviceQuery{SortBy: "udid", Limit: defaultPageSize}
+
+	if s := v.Get("enrolled"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid enrolled value %q", s)
+		}
+		q.Enrolled = &b
+	}
+	if s := v.Get("retired"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid retired value %q", s)
+		}
+		q.Retired = &b
+	}
+	if s := v.Get("dep"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid dep value %q", s)
+		}
+		q.DEP = &b
+	}
+	if s := v.Get("expected"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid expected value %q", s)
+		}
+		q.Expected = &b
+	}
+	if s := v.Get("responsive"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid responsive value %q", s)
+		}
+		q.Responsive = &b
+	}
+
+	// url.ParseQuery splits os_version>=17 into the key "os_version>" and the
+	// value "17", so accept the operator on either side of the '='.
+	for key, op := range map[string]string{"os_version>": ">=", "os_version<": "<="} {
+		if s := v.Get(key); s != "" {
+			q.OSOp, q.OSVersion = op, s
+		}
+	}
+	if s := v.Get("os_version"); s != "" {
+		q.OSOp, q.OSVersion = splitVersionOp(s)
+	}
+	// Without an '=', os_version>17 is the key "os_version>17" and no value,
+	// which would otherwise filter nothing.
+	for key := range v {
+		if strings.HasPrefix(key, "os_version") && !slices.Contains([]string{"os_version", "os_version>", "os_version<"}, key) {
+			return q, fmt.Errorf("invalid os_version filter %q; write it as os_version=>17 or os_version>=17", key)
+		}
+	}
+	if q.OSVersion != "" {
+		if _, err := parseVersion(q.OSVersion); err != nil {
+			return q, fmt.Errorf("invalid os_version %q", q.OSVersion)
+		}
+	}
+
+	q.Model = v.Get("model")
+	q.Platform = v.Get("platform")
+	q.Tag = v.Get("tag")
+	q.Owner = v.Get("owner")
+	q.OsqueryHostID = v.Get("osquery_host_id")
+
+	if s := v.Get("sort"); s != "" {
+		q.Desc = strings.HasPrefix(s, "-")
+		q.SortBy = strings.TrimPrefix(s, "-")
+		switch q.SortBy {
+		case "udid", "last_seen", "os_version", "model":
+		default:
+			return q, fmt.Errorf("invalid sort field %q", q.SortBy)
+		}
+	}
+
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxPageSize {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.Limit = n
+	}
+
+	if s := v.Get("cursor"); s != "" {
+		c, err := decodeDeviceCursor(s)
+		if err != nil {
+			return q, err
+		}
+		q.Cursor = c
+	}
+	return q, nil
+}
+
+func splitVersionOp(s string) (op, version string) {
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(s, op) {
+			return op, strings.TrimPrefix(s, op)
+		}
+	}
+	return "", s
+}
+
+// Apply filters and sorts devices and returns one page of them, along with
+// the cursor for the next page, which is empty on the last page.
+func (q deviceQuery) Apply(devices []Device) ([]Device, string) {
+	matched := make([]Device, 0, len(devices))
+	for _, d := range devices {
+		if q.matches(d) {
+			matched = append(matched, d)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return q.less(matched[i], matched[j]) })
+
+	if q.Cursor != nil {
+		after := q.Cursor.device()
+		start := sort.Search(len(matched), func(i int) bool { return q.less(after, matched[i]) })
+		matched = matched[start:]
+	}
+
+	if len(matched) <= q.Limit {
+		return matched, ""
+	}
+	page := matched[:q.Limit]
+	return page, cursorFor(page[len(page)-1]).encode()
+}
+
+func (q deviceQuery) matches(d Device) bool {
+	if q.Enrolled != nil && d.Enrolled != *q.Enrolled {
+		return false
+	}
+	if q.Retired != nil && isRetired(d) != *q.Retired {
+		return false
+	}
+	if q.DEP != nil && (d.DEP != nil && d.DEP.Assigned) != *q.DEP {
+		return false
+	}
+	if q.Expected != nil && (d.Asset != nil && d.Asset.Expected) != *q.Expected {
+		return false
+	}
+	if q.Owner != "" && (d.Asset == nil || !strings.EqualFold(d.Asset.Owner, q.Owner)) {
+		return false
+	}
+	if q.OsqueryHostID != "" && (d.Osquery == nil || d.Osquery.OsqueryHostID != q.OsqueryHostID) {
+		return false
+	}
+	if q.Responsive != nil && isResponsive(d, q.RespondedSince) != *q.Responsive {
+		return false
+	}
+	if q.Tag != "" && !d.HasTag(q.Tag) {
+		return false
+	}
+	if q.Platform != "" && !strings.EqualFold(d.Platform, q.Platform) {
+		return false
+	}
+	if q.Model != "" {
+		if d.Info == nil || !(strings.EqualFold(d.Info.Model, q.Model) || strings.EqualFold(d.Info.ModelName, q.Model)) {
+			return false
+		}
+	}
+	if q.OSVersion != "" {
+		if d.Info == nil || !versionMatches(d.Info.OSVersion, q.OSOp, q.OSVersion) {
+			return false
+		}
+	}
+	return true
+}
+
+// less orders devices by the sort field, breaking ties by UDID so the order
+// is total and cursors are stable.
+func (q deviceQuery) less(a, b Device) bool {
+	var c int
+	switch q.SortBy {
+	case "last_seen":
+		c = a.LastSeen.Compare(b.LastSeen)
+	case "os_version":
+		c = compareVersions(infoField(a, func(i *DeviceInfo) string { return i.OSVersion }),
+			infoField(b, func(i *DeviceInfo) string { return i.OSVersion }))
+	case "model":
+		c = strings.Compare(infoField(a, func(i *DeviceInfo) string { return i.Model }),
+			infoField(b, func(i *DeviceInfo) string { return i.Model }))
+	}
+	if c == 0 {
+		c = strings.Compare(a.UDID, b.UDID)
+	}
+	if q.Desc {
+		return c > 0
+	}
+	return c < 0
+}
+
+func infoField(d Device, f func(*DeviceInfo) string) string {
+	if d.Info == nil {
+		return ""
+	}
+	return f(d.Info)
+}
+
+// parseVersion splits a dotted version such as 17.4.1 into its numeric
+// components.
+func parseVersion(s string) ([]int, error) {
+	parts := strings.Split(s, ".")
+	v := make([]int, len(parts))
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, err
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// compareVersions compares dotted versions numerically, treating missing
+// components as zero. Versions that do not parse sort before those that do.
+func compareVersions(a, b string) int {
+	va, errA := parseVersion(a)
+	vb, errB := parseVersion(b)
+	switch {
+	case errA != nil && errB != nil:
+		return strings.Compare(a, b)
+	case errA != nil:
+		return -1
+	case errB != nil:
+		return 1
+	}
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		var x, y int
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionMatches(version, op, want string) bool {
+	if _, err := parseVersion(version); err != nil {
+		return false
+	}
+	c := compareVersions(version, want)
+	switch op {
+	case "=":
+		return c == 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return version == want || strings.HasPrefix(version, want+".")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseDeviceQueryOSVersion(t *testing.T) {
+	tests := []struct {
+		query   string
+		op      string
+		version string
+		wantErr bool
+	}{
+		{query: "os_version=17", version: "17"},
+		{query: "os_version=>17", op: ">", version: "17"},
+		{query: "os_version=<17.4", op: "<", version: "17.4"},
+		{query: "os_version>=17", op: ">=", version: "17"},
+		{query: "os_version<=17", op: "<=", version: "17"},
+		{query: "os_version>17", wantErr: true},
+		{query: "os_version<17", wantErr: true},
+		{query: "os_version~17=1", wantErr: true},
+		{query: "os_version=~17", wantErr: true},
+	}
+	for _, tt := range tests {
+		v, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := parseDeviceQuery(v)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: parsed as %q %q, want an error", tt.query, q.OSOp, q.OSVersion)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.query, err)
+			continue
+		}
+		if q.OSOp != tt.op || q.OSVersion != tt.version {
+			t.Errorf("%s: got %q %q, want %q %q", tt.query, q.OSOp, q.OSVersion, tt.op, tt.version)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/micromdm/mdm/appmanifest"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultAppURLTTL is how long signed app URLs stay valid.
+const defaultAppURLTTL = 24 * time.Hour
+
+// appExtensions are the package files an appHost serves: flat packages for
+// macOS and app archives for iOS.
+var appExtensions = []string{".pkg", ".ipa"}
+
+// appHost serves the packages of a directory, and the manifests
+// InstallEnterpriseApplication commands point devices at, under /apps/. Each
+// URL is signed with an HMAC key and expires, so the packages are only
+// downloadable by the devices told to install them, and only for as long as
+// they need.
+//
+// Manifests are generated from the packages. Metadata, which iOS requires
+// for .ipa files, is taken from a manifest plist next to the package with
+// the same base name, e.g. App.plist for App.ipa, whose asset URLs are
+// replaced with the signed one.
+type appHost struct {
+	urlSigner
+	dir string
+	ttl time.Duration
+
+	mu     sync.Mutex
+	assets map[string]hostedAsset
+}
+
+// hostedAsset is the manifest asset of a package, kept until the package
+// changes since hashing it means reading all of it.
+type hostedAsset struct {
+	size    int64
+	modTime time.Time
+	asset   appmanifest.Asset
+}
+
+// HostedApp is a package served by the appHost.
+type HostedApp struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// EnterpriseAppRequest names the hosted package an
+// InstallEnterpriseApplication command installs.
+type EnterpriseAppRequest struct {
+	Name string `json:"name"`
+}
+
+// newAppHost serves the packages in dir with the URLs of signer.
+func newAppHost(dir string, signer urlSigner, ttl time.Duration) (*appHost, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("app directory: %v", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("app directory: %s is not a directory", dir)
+	}
+	return &appHost{urlSigner: signer, dir: dir, ttl: ttl, assets: make(map[string]hostedAsset)}, nil
+}
+
+// urlSigner signs the URLs of the files this server hosts for devices, with
+// an HMAC key and an expiry.
+type urlSigner struct {
+	baseURL string
+	key     []byte
+}
+
+// newURLSigner signs URLs of baseURL, the URL of this server devices can
+// reach. Without a key, a random one is used, so URLs stop working when the
+// server restarts.
+func newURLSigner(baseURL string, key []byte) (urlSigner, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return urlSigner{}, fmt.Errorf("invalid app base URL %q", baseURL)
+	}
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	return urlSigner{baseURL: strings.TrimSuffix(baseURL, "/"), key: key}, nil
+}
+
+// sign returns the URL of path, valid until expires.
+func (u urlSigner) sign(path string, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	return u.baseURL + path + "?expires=" + exp + "&signature=" + u.signature(path, exp)
+}
+
+func (u urlSigner) signature(path, expires string) string {
+	mac := hmac.New(sha256.New, u.key)
+	io.WriteString(mac, path+"\n"+expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify reports whether r carries a valid signature for its path that has
+// not expired.
+func (u urlSigner) verify(r *http.Request) bool {
+	q := r.URL.Query()
+	exp, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > exp {
+		return false
+	}
+	got, err := hex.DecodeString(q.Get("signature"))
+	want, _ := hex.DecodeString(u.signature(r.URL.EscapedPath(), q.Get("expires")))
+	return err == nil && hmac.Equal(got, want)
+}
+
+// errNoApp is returned for names that are not packages of the directory.
+var errNoApp = errors.New("no such app")
+
+// stat returns the path and details of the package name.
+func (h *appHost) stat(name string) (string, fs.FileInfo, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || !slices.Contains(appExtensions, strings.ToLower(filepath.Ext(name))) {
+		return "", nil, errNoApp
+	}
+	path := filepath.Join(h.dir, name)
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && !info.Mode().IsRegular() {
+		return "", nil, errNoApp
+	}
+	return path, info, err
+}
+
+// list returns the packages of the directory, sorted by name.
+func (h *appHost) list() ([]HostedApp, error) {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read app directory: %v", err)
+	}
+	apps := []HostedApp{}
+	for _, e := range entries {
+		_, info, err := h.stat(e.Name())
+		if err != nil {
+			continue
+		}
+		apps = append(apps, HostedApp{Name: e.Name(), Size: info.Size(), ModifiedAt: info.ModTime().UTC()})
+	}
+	return apps, nil
+}
+
+// manifestURL returns a signed URL of the manifest of the package name.
+func (h *appHost) manifestURL(name string) (string, error) {
+	if _, _, err := h.stat(name); err != nil {
+		return "", err
+	}
+	return h.sign("/apps/"+url.PathEscape(name)+"/manifest.plist", time.Now().Add(h.ttl)), nil
+}
+
+// manifest returns the manifest of the package name, whose asset URL is
+// signed to expire with the manifest's own URL.
+func (h *appHost) manifest(name string, expires time.Time) ([]byte, error) {
+	path, info, err := h.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	asset, err := h.asset(path, info)
+	if err != nil {
+		return nil, err
+	}
+	asset.URL = h.sign("/apps/"+url.PathEscape(name), expires)
+
+	var m appmanifest.Manifest
+	sidecar, err := os.ReadFile(strings.TrimSuffix(path, filepath.Ext(path)) + ".plist")
+	switch {
+	case err == nil:
+		if err := plist.Unmarshal(sidecar, &m); err != nil {
+			return nil, fmt.Errorf("decode manifest of %s: %v", name, err)
+		}
+		if len(m.ManifestItems) == 0 {
+			return nil, fmt.Errorf("manifest of %s has no items", name)
+		}
+	case errors.Is(err, fs.ErrNotExist):
+		m.ManifestItems = []appmanifest.Item{{}}
+	default:
+		return nil, fmt.Errorf("read manifest of %s: %v", name, err)
+	}
+	for i := range m.ManifestItems {
+		m.ManifestItems[i].Assets = []appmanifest.Asset{asset}
+	}
+	return plist.MarshalIndent(m, "\t")
+}
+
+// asset returns the manifest asset of the package at path, without its URL.
+func (h *appHost) asset(path string, info fs.FileInfo) (appmanifest.Asset, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if a, ok := h.assets[path]; ok && a.size == info.Size() && a.modTime.Equal(info.ModTime()) {
+		return a.asset, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return appmanifest.Asset{}, fmt.Errorf("open app: %v", err)
+	}
+	defer f.Close()
+	m, err := appmanifest.Create(sizedFile{f, info.Size()}, "")
+	if err != nil {
+		return appmanifest.Asset{}, err
+	}
+	a := m.ManifestItems[0].Assets[0]
+	h.assets[path] = hostedAsset{size: info.Size(), modTime: info.ModTime(), asset: a}
+	return a, nil
+}
+
+// sizedFile is an appmanifest.File.
+type sizedFile struct {
+	io.Reader
+	size int64
+}
+
+func (f sizedFile) Size() int64 { return f.size }
+
+// handler serves the manifests and packages to requests with a valid
+// signature.
+func (h *appHost) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /apps/{name}/manifest.plist", func(w http.ResponseWriter, r *http.Request) {
+		exp, _ := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+		b, err := h.manifest(r.PathValue("name"), time.Unix(exp, 0))
+		if err == errNoApp {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			logFor(r.Context()).WithError(err).Error("serve app manifest")
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write(b)
+	})
+	mux.HandleFunc("GET /apps/{name}", func(w http.ResponseWriter, r *http.Request) {
+		path, info, err := h.stat(r.PathValue("name"))
+		if err == errNoApp {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			logFor(r.Context()).WithError(err).Error("serve app")
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.verify(r) {
+			logFor(r.Context()).WithFields(logrus.Fields{"path": r.URL.Path, "remote_addr": r.RemoteAddr}).Warn("rejected app download: missing, invalid, or expired signature")
+			http.Error(w, "invalid or expired signature", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// enterpriseAppCommand returns the InstallEnterpriseApplication command
+// installing the app of manifestURL on the device udid.
+func enterpriseAppCommand(udid, manifestURL string) Command {
+	return Command{UDID: udid, RequestType: "InstallEnterpriseApplication", ManifestURL: manifestURL}
+}
+
+// packageCommand returns the InstallEnterpriseApplication command installing
+// pkg, a package of -app-dir, or else the package of manifestURL on d.
+func (s *Server) packageCommand(d Device, pkg, manifestURL string) (Command, error) {
+	if pkg == "" {
+		return enterpriseAppCommand(d.UDID, manifestURL), nil
+	}
+	if s.EnterpriseApps == nil {
+		return Command{}, fmt.Errorf("enterprise apps are not hosted; set -app-dir")
+	}
+	u, err := s.EnterpriseApps.manifestURL(pkg)
+	if err != nil {
+		return Command{}, err
+	}
+	return enterpriseAppCommand(d.UDID, u), nil
+}
+
+// handleListEnterpriseApps lists the packages of -app-dir.
+func (s *Server) handleListEnterpriseApps(w http.ResponseWriter, r *http.Request) {
+	if s.EnterpriseApps == nil {
+		http.Error(w, "enterprise apps are not hosted; set -app-dir", http.StatusServiceUnavailable)
+		return
+	}
+	apps, err := s.EnterpriseApps.list()
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("list enterprise apps")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, apps)
+}
+
+// handleInstallEnterpriseApp sends a device an InstallEnterpriseApplication
+// command for a package of -app-dir, with a signed manifest URL. The body is
+// a JSON EnterpriseAppRequest.
+func (s *Server) handleInstallEnterpriseApp(w http.ResponseWriter, r *http.Request) {
+	if s.EnterpriseApps == nil {
+		http.Error(w, "enterprise apps are not hosted; set -app-dir", http.StatusServiceUnavailable)
+		return
+	}
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	var req EnterpriseAppRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandBody)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	manifestURL, err := s.EnterpriseApps.manifestURL(req.Name)
+	if err == errNoApp {
+		http.Error(w, fmt.Sprintf("no app %q in the app directory", req.Name), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("sign manifest URL")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.postAPICommand(w, r, enterpriseAppCommand(d.UDID, manifestURL))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	signer, err := newURLSigner("https://apps.example.com/", []byte("key"))
+	if err != nil {
+		t.Fatalf("newURLSigner: %v", err)
+	}
+	other, err := newURLSigner("https://apps.example.com", []byte("other key"))
+	if err != nil {
+		t.Fatalf("newURLSigner: %v", err)
+	}
+	later := time.Now().Add(time.Hour)
+	tests := []struct {
+		name   string
+		signer urlSigner
+		url    string
+		want   bool
+	}{
+		{name: "valid", url: signer.sign("/apps/App.pkg", later), want: true},
+		{name: "escaped path", url: signer.sign("/apps/My%20App.pkg", later), want: true},
+		{name: "expired", url: signer.sign("/apps/App.pkg", time.Now().Add(-time.Second))},
+		{name: "other key", signer: other, url: signer.sign("/apps/App.pkg", later)},
+		{name: "other path", url: strings.Replace(signer.sign("/apps/App.pkg", later), "App.pkg", "Other.pkg", 1)},
+		{name: "extended expiry", url: strings.Replace(signer.sign("/apps/App.pkg", later), "expires=", "expires=9", 1)},
+		{name: "no signature", url: "https://apps.example.com/apps/App.pkg?expires=9999999999"},
+		{name: "no expiry", url: "https://apps.example.com/apps/App.pkg"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.signer
+			if s.key == nil {
+				s = signer
+			}
+			if !strings.HasPrefix(tt.url, "https://apps.example.com/apps/") {
+				t.Fatalf("signed URL %q is not under the base URL", tt.url)
+			}
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if got := s.verify(r); got != tt.want {
+				t.Errorf("verify(%q) = %v, want %v", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewURLSigner(t *testing.T) {
+	for _, base := range []string{"", "apps.example.com", "/apps", "https://"} {
+		if _, err := newURLSigner(base, nil); err == nil {
+			t.Errorf("newURLSigner(%q) succeeded", base)
+		}
+	}
+	s, err := newURLSigner("https://apps.example.com", nil)
+	if err != nil {
+		t.Fatalf("newURLSigner: %v", err)
+	}
+	if len(s.key) != 32 {
+		t.Errorf("random key is %d bytes, want 32", len(s.key))
+	}
+}
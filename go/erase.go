@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultEraseWindow is how long an EraseDevice request can be confirmed
+// for.
+const defaultEraseWindow = 5 * time.Minute
+
+// EraseOptions are the fields of an EraseDevice command.
+type EraseOptions struct {
+	// PIN locks a Mac erased with Find My Mac, and is generated and
+	// escrowed like those of DeviceLock commands when it is empty.
+	PIN                    string `json:"pin,omitempty"`
+	PreserveDataPlan       bool   `json:"preserve_data_plan,omitempty"`
+	DisallowProximitySetup bool   `json:"disallow_proximity_setup,omitempty"`
+}
+
+// EraseRequest is an EraseDevice command waiting to be confirmed with its
+// token.
+type EraseRequest struct {
+	UDID      string       `json:"udid"`
+	Token     string       `json:"token"`
+	Options   EraseOptions `json:"options"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+// eraseRequests holds the EraseDevice commands waiting for confirmation, so
+// no single admin API call wipes a device.
+type eraseRequests struct {
+	mu       sync.Mutex
+	window   time.Duration
+	requests map[string]EraseRequest
+}
+
+func newEraseRequests(window time.Duration) *eraseRequests {
+	return &eraseRequests{window: window, requests: make(map[string]EraseRequest)}
+}
+
+// add returns a new request to erase the device udid, replacing any earlier
+// one for it.
+func (e *eraseRequests) add(udid string, opts EraseOptions) EraseRequest {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	now := time.Now().UTC()
+	req := EraseRequest{UDID: udid, Token: hex.EncodeToString(b), Options: opts, ExpiresAt: now.Add(e.window)}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for id, r := range e.requests {
+		if now.After(r.ExpiresAt) {
+			delete(e.requests, id)
+		}
+	}
+	e.requests[udid] = req
+	return req
+}
+
+// confirm removes and returns the request to erase the device udid if token
+// is its token and it has not expired.
+func (e *eraseRequests) confirm(udid, token string) (EraseRequest, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	req, ok := e.requests[udid]
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(req.Token)) != 1 {
+		return EraseRequest{}, false
+	}
+	delete(e.requests, udid)
+	return req, time.Now().Before(req.ExpiresAt)
+}
+
+// requireConfirmation rejects request types the admin API only sends once
+// confirmed, for the endpoints that send any command.
+func requireConfirmation(requestType string) error {
+	if requestType == "EraseDevice" {
+		return fmt.Errorf("EraseDevice must be requested with POST /api/devices/{udid}/erase and then confirmed")
+	}
+	return nil
+}
+
+// handleRequestErase starts erasing a device: it answers with a token that
+// handleConfirmErase takes within s.Erasures' window to send the command.
+// The optional body is a JSON EraseOptions.
+func (s *Server) handleRequestErase(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCommandBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	var opts EraseOptions
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &opts); err != nil {
+			http.Error(w, fmt.Sprintf("invalid erase options: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	req := s.Erasures.add(d.UDID, opts)
+	logFor(r.Context()).WithFields(auditFields(r)).WithFields(logrus.Fields{"udid": d.UDID, "expires_at": req.ExpiresAt}).Warn("EraseDevice requested")
+	writeJSON(w, http.StatusAccepted, req)
+}
+
+// handleConfirmErase sends the EraseDevice command of the request whose
+// token is in the body, {"token": "..."}.
+func (s *Server) handleConfirmErase(w http.ResponseWriter, r *http.Request) {
+	udid := r.PathValue("udid")
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandBody)).Decode(&body); err != nil {
+		http.Error(w, fmt.Sprintf("decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	req, ok := s.Erasures.confirm(udid, body.Token)
+	if !ok {
+		http.Error(w, "invalid or expired erase token; request the erase again", http.StatusForbidden)
+		return
+	}
+	d, err := s.Devices.Get(udid)
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("get device")
+		http.Error(w, fmt.Sprintf("get device: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	c := Command{
+		UDID:                   udid,
+		RequestType:            "EraseDevice",
+		PIN:                    req.Options.PIN,
+		PreserveDataPlan:       req.Options.PreserveDataPlan,
+		DisallowProximitySetup: req.Options.DisallowProximitySetup,
+	}
+	if c.PIN == "" && needsLockPIN(d) && s.Escrow != nil {
+		if c.PIN, err = s.escrowPIN(&d); err != nil {
+			logFor(r.Context()).WithField("udid", udid).WithError(err).Error("escrow erase PIN")
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	logFor(r.Context()).WithFields(auditFields(r)).WithField("udid", udid).Warn("EraseDevice confirmed")
+	s.postAPICommand(w, r, c)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+)
+
+// eventBuffer is how many events a subscriber may fall behind by before
+// further events are dropped for it.
+const eventBuffer = 64
+
+// EventSummary is the part of a webhook event that is passed on to live
+// subscribers.
+type EventSummary struct {
+	ID          string    `json:"event_id"`
+	Topic       string    `json:"topic"`
+	UDID        string    `json:"udid"`
+	Time        time.Time `json:"time"`
+	CommandUUID string    `json:"command_uuid,omitempty"`
+	Status      string    `json:"status,omitempty"`
+}
+
+func summarizeEvent(event webhook.Event) EventSummary {
+	e := EventSummary{ID: event.EventID, Topic: event.Topic, Time: eventTime(event)}
+	switch {
+	case event.AcknowledgeEvent != nil:
+		e.UDID = event.AcknowledgeEvent.UDID
+		e.CommandUUID = event.AcknowledgeEvent.CommandUUID
+		e.Status = event.AcknowledgeEvent.Status
+	case event.CheckinEvent != nil:
+		e.UDID = event.CheckinEvent.UDID
+	}
+	return e
+}
+
+// eventHub fans webhook events out to live subscribers. Slow subscribers
+// miss events rather than holding up the webhook.
+type eventHub struct {
+	mu     sync.Mutex
+	subs   map[chan EventSummary]struct{}
+	closed bool
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{subs: make(map[chan EventSummary]struct{})}
+}
+
+// Subscribe returns a channel of events and a function that ends the
+// subscription and closes the channel. The channel is also closed when the
+// hub is.
+func (h *eventHub) Subscribe() (<-chan EventSummary, func()) {
+	ch := make(chan EventSummary, eventBuffer)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	h.subs[ch] = struct{}{}
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := h.subs[ch]; ok {
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close ends every subscription, so streams of events finish on shutdown.
+func (h *eventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Publish sends e to every subscriber that has room for it.
+func (h *eventHub) Publish(e EventSummary) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
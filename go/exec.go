@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// execQueueSize is how many hook runs can wait for a free slot.
+const execQueueSize = 1000
+
+// execRetryCode is the exit code (EX_TEMPFAIL) with which a hook asks to be
+// run again, like failed commands are retried. Other non-zero codes fail the
+// run for good.
+const execRetryCode = 75
+
+// execOutputLimit caps how much of a hook's output is logged.
+const execOutputLimit = 4096
+
+// execVars counts the runs of each hook, keyed by its name: succeeded,
+// retried, failed (given up on, including timeouts), timed_out, and dropped
+// (the queue was full).
+var execVars = expvar.NewMap("exec_hooks")
+
+// ExecHook runs a program for every event of a topic.
+type ExecHook struct {
+	// Command is the program and its arguments. It is run with the event's
+	// JSON on its standard input, and WEBHOOK_TOPIC, WEBHOOK_EVENT_ID,
+	// WEBHOOK_UDID, and WEBHOOK_TENANT in its environment.
+	Command []string `yaml:"command" toml:"command"`
+
+	// Timeout, when set, replaces -exec-timeout for the hook.
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+}
+
+func (h ExecHook) validate() error {
+	if len(h.Command) == 0 || h.Command[0] == "" {
+		return fmt.Errorf("no command")
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("negative timeout")
+	}
+	return nil
+}
+
+// execHook is an ExecHook ready to run.
+type execHook struct {
+	name    string
+	argv    []string
+	timeout time.Duration
+
+	succeeded, retried, failed, timedOut, dropped expvar.Int
+}
+
+type execJob struct {
+	ctx  context.Context
+	hook *execHook
+	body []byte
+	env  []string
+}
+
+// execHooks runs hooks in the background, at most a fixed number at a time,
+// retrying those exiting with execRetryCode according to retry.
+type execHooks struct {
+	jobs    chan execJob
+	timeout time.Duration
+	retry   RetryPolicy
+	wg      sync.WaitGroup
+
+	// ctx is cancelled to abandon runs when shutdown times out.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newExecHooks starts concurrency workers running hooks, each for up to
+// timeout unless the hook says otherwise.
+func newExecHooks(concurrency int, timeout time.Duration, retry RetryPolicy) *execHooks {
+	h := &execHooks{jobs: make(chan execJob, execQueueSize), timeout: timeout, retry: retry}
+	h.ctx, h.cancel = context.WithCancel(context.Background())
+	for i := 0; i < concurrency; i++ {
+		h.wg.Add(1)
+		go h.work()
+	}
+	return h
+}
+
+// add returns the hook of c under name, which identifies it in logs and at
+// /debug/vars.
+func (h *execHooks) add(name string, c ExecHook) *execHook {
+	eh := &execHook{name: name, argv: c.Command, timeout: c.Timeout}
+	if eh.timeout == 0 {
+		eh.timeout = h.timeout
+	}
+	vars := new(expvar.Map)
+	vars.Set("succeeded", &eh.succeeded)
+	vars.Set("retried", &eh.retried)
+	vars.Set("failed", &eh.failed)
+	vars.Set("timed_out", &eh.timedOut)
+	vars.Set("dropped", &eh.dropped)
+	execVars.Set(name, vars)
+	return eh
+}
+
+// addTopicHooks sets up the exec hooks of topics, run by s.Hooks.
+func (s *Server) addTopicHooks(topics map[string]TopicConfig) {
+	for topic, tc := range topics {
+		for i, c := range tc.Exec {
+			if s.TopicHooks == nil {
+				s.TopicHooks = make(map[string][]*execHook)
+			}
+			s.TopicHooks[topic] = append(s.TopicHooks[topic], s.Hooks.add(fmt.Sprintf("%s/%d", topic, i+1), c))
+		}
+	}
+}
+
+// runHooks queues the runs of the exec hooks of the event's topic. body is
+// the event as received.
+func (s *Server) runHooks(ctx context.Context, topic, eventID, udid string, body []byte) {
+	for _, eh := range s.TopicHooks[topic] {
+		s.Hooks.run(ctx, eh, s.hookEnv(topic, eventID, udid), body)
+	}
+}
+
+// hookEnv returns the environment hooks are run with for an event.
+func (s *Server) hookEnv(topic, eventID, udid string) []string {
+	return append(os.Environ(),
+		"WEBHOOK_TOPIC="+topic,
+		"WEBHOOK_EVENT_ID="+eventID,
+		"WEBHOOK_UDID="+udid,
+		"WEBHOOK_TENANT="+s.Tenant,
+	)
+}
+
+// run queues a run of eh with body on its standard input. Running keeps the
+// values of ctx, such as its logger, but not its cancellation.
+func (h *execHooks) run(ctx context.Context, eh *execHook, env []string, body []byte) {
+	select {
+	case h.jobs <- execJob{ctx: context.WithoutCancel(ctx), hook: eh, body: body, env: env}:
+	default:
+		eh.dropped.Add(1)
+		logFor(ctx).WithField("hook", eh.name).Error("exec hook queue is full, dropping run")
+	}
+}
+
+func (h *execHooks) work() {
+	defer h.wg.Done()
+	for job := range h.jobs {
+		ctx, cancel := context.WithCancel(job.ctx)
+		stop := context.AfterFunc(h.ctx, cancel)
+		h.runJob(ctx, job)
+		stop()
+		cancel()
+	}
+}
+
+func (h *execHooks) runJob(ctx context.Context, job execJob) {
+	eh := job.hook
+	logger := logFor(ctx).WithField("hook", eh.name)
+	attempts := max(h.retry.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		err := eh.exec(ctx, job.env, job.body)
+		if err == nil {
+			eh.succeeded.Add(1)
+			return
+		}
+		var retry *execRetryError
+		if !errors.As(err, &retry) || attempt >= attempts || ctx.Err() != nil {
+			eh.failed.Add(1)
+			logger.WithError(err).WithField("attempts", attempt).Error("exec hook failed")
+			return
+		}
+		eh.retried.Add(1)
+		wait := h.retry.delay(attempt)
+		logger.WithError(err).WithFields(logrus.Fields{"attempt": attempt, "retry_in": wait.String()}).Warn("exec hook asked to be retried")
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// execRetryError is returned for runs that exited with execRetryCode.
+type execRetryError struct {
+	err error
+}
+
+func (e *execRetryError) Error() string { return e.err.Error() }
+
+// exec runs eh once, killing it after its timeout. A failure includes the
+// end of what the program wrote.
+func (eh *execHook) exec(ctx context.Context, env []string, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, eh.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, eh.argv[0], eh.argv[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Env = env
+	var out bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &out
+	// Do not wait forever for children that keep the output open.
+	cmd.WaitDelay = 5 * time.Second
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	output := strings.TrimSpace(out.String())
+	if len(output) > execOutputLimit {
+		output = "..." + output[len(output)-execOutputLimit:]
+	}
+	if output != "" {
+		err = fmt.Errorf("%v: %s", err, output)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		eh.timedOut.Add(1)
+		return fmt.Errorf("timed out after %s: %v", eh.timeout, err)
+	}
+	if cmd.ProcessState != nil && cmd.ProcessState.ExitCode() == execRetryCode {
+		return &execRetryError{err}
+	}
+	return err
+}
+
+// close stops accepting runs and waits for the queued ones to finish. If
+// ctx ends first, the running hooks are killed and ctx's error is returned.
+// Nothing may be run after close is called.
+func (h *execHooks) close(ctx context.Context) error {
+	close(h.jobs)
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		h.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+	"github.com/groob/plist"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// fileVaultVars counts the FileVault recovery keys escrowed, disclosed, and
+// rotated.
+var fileVaultVars = expvar.NewMap("filevault")
+
+// fileVaultCheckInterval is how often devices are checked for FileVault
+// keys due for rotation.
+const fileVaultCheckInterval = time.Hour
+
+// fileVaultRotationTimeout is how long a RotateFileVaultKey command is
+// waited for before the rotation is tried again. Devices that fail the
+// command do not say so in a way the server tracks.
+const fileVaultRotationTimeout = 24 * time.Hour
+
+// FileVaultUnlock unlocks the FileVault key a RotateFileVaultKey command
+// rotates, in the format of MicroMDM's /v1/commands endpoint.
+type FileVaultUnlock struct {
+	Password string `json:"password,omitempty"`
+}
+
+// fileVaultEscrow is the identity Macs encrypt their FileVault personal
+// recovery keys to: the certificate of the FDERecoveryKeyEscrow payload of
+// their FileVault profile, and its private key.
+type fileVaultEscrow struct {
+	cert *x509.Certificate
+	key  crypto.PrivateKey
+}
+
+// loadFileVaultEscrow reads the PEM certificate and private key of the
+// FileVault escrow identity.
+func loadFileVaultEscrow(certFile, keyFile string) (*fileVaultEscrow, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load FileVault escrow identity: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse FileVault escrow certificate: %v", err)
+	}
+	return &fileVaultEscrow{cert: cert, key: pair.PrivateKey}, nil
+}
+
+// decrypt returns the recovery key in cms, a CMS envelope encrypted to the
+// escrow certificate. The envelope holds the key either as it is or as a
+// plist with a RecoveryKey or NewRecoveryKey string.
+func (e *fileVaultEscrow) decrypt(cms []byte) (string, error) {
+	p7, err := pkcs7.Parse(cms)
+	if err != nil {
+		return "", fmt.Errorf("parse recovery key envelope: %v", err)
+	}
+	content, err := p7.Decrypt(e.cert, e.key)
+	if err != nil {
+		return "", fmt.Errorf("decrypt recovery key: %v", err)
+	}
+	var msg struct{ RecoveryKey, NewRecoveryKey string }
+	if err := plist.Unmarshal(content, &msg); err == nil {
+		if msg.NewRecoveryKey != "" {
+			return msg.NewRecoveryKey, nil
+		}
+		if msg.RecoveryKey != "" {
+			return msg.RecoveryKey, nil
+		}
+	}
+	key := strings.TrimSpace(string(content))
+	if key == "" {
+		return "", fmt.Errorf("empty recovery key")
+	}
+	return key, nil
+}
+
+// fileVaultEnabled reports whether FileVault keys can be escrowed: it takes
+// both the escrow identity and the key the keys are stored encrypted with.
+func (s *Server) fileVaultEnabled() bool {
+	return s.FileVault != nil && s.Escrow != nil
+}
+
+// openFileVaultKey decrypts the recovery key escrowed for d.
+func (s *Server) openFileVaultKey(d Device) (string, error) {
+	if d.FileVault == nil || d.FileVault.Key == nil {
+		return "", fmt.Errorf("no FileVault recovery key is escrowed for device %s", d.UDID)
+	}
+	return s.Escrow.open(d.UDID, d.FileVault.Key.Sealed)
+}
+
+// setFileVaultKey seals cms, the envelope of a recovery key d reported at
+// time at, as d's escrowed key. It reports whether the key changed.
+func (s *Server) setFileVaultKey(ctx context.Context, d *Device, cms []byte, at time.Time) (bool, error) {
+	key, err := s.FileVault.decrypt(cms)
+	if err != nil {
+		return false, err
+	}
+	if current, err := s.openFileVaultKey(*d); err == nil && current == key {
+		return false, nil
+	}
+	sealed, err := s.Escrow.seal(d.UDID, key)
+	if err != nil {
+		return false, fmt.Errorf("seal recovery key: %v", err)
+	}
+	if d.FileVault == nil {
+		d.FileVault = &store.FileVault{}
+	}
+	d.FileVault.Key = &store.EscrowedPIN{Sealed: sealed, CreatedAt: at}
+	d.FileVault.DisclosedAt = nil
+	fileVaultVars.Add("escrowed", 1)
+	logFor(ctx).Info("escrowed FileVault recovery key")
+	return true, nil
+}
+
+// escrowFileVaultKey escrows the personal recovery key of a SecurityInfo
+// response, which Macs with an FDERecoveryKeyEscrow payload report.
+func (s *Server) escrowFileVaultKey(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	cms, err := webhook.ParseFileVaultRecoveryKey(ack.Raw)
+	if err != nil || len(cms) == 0 {
+		return false, err
+	}
+	if !s.fileVaultEnabled() {
+		logFor(ctx).Warn("not storing FileVault recovery key: set -filevault-cert, -filevault-key, and -escrow-key")
+		return false, nil
+	}
+	return s.setFileVaultKey(ctx, d, cms, ack.Time)
+}
+
+// applyRotateFileVaultKey escrows the new recovery key of a
+// RotateFileVaultKey response and completes the rotation.
+func (s *Server) applyRotateFileVaultKey(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	cms, err := webhook.ParseRotateFileVaultKey(ack.Raw)
+	if err != nil || len(cms) == 0 {
+		return false, err
+	}
+	if !s.fileVaultEnabled() {
+		logFor(ctx).Error("cannot store rotated FileVault recovery key: set -filevault-cert, -filevault-key, and -escrow-key")
+		return false, nil
+	}
+	if _, err := s.setFileVaultKey(ctx, d, cms, ack.Time); err != nil {
+		return false, err
+	}
+	r := d.FileVault.Rotation
+	if r == nil || r.CommandUUID != ack.CommandUUID {
+		r = &store.FileVaultRotation{CommandUUID: ack.CommandUUID, RequestedAt: ack.Time}
+		d.FileVault.Rotation = r
+	}
+	r.CompletedAt = &ack.Time
+	fileVaultVars.Add("rotated", 1)
+	logFor(ctx).Info("device rotated FileVault recovery key")
+	return true, nil
+}
+
+// rotateFileVaultKey sends d a RotateFileVaultKey command for a new personal
+// recovery key, unlocked with the escrowed one and encrypted to the escrow
+// certificate, and saves the rotation with d. The command is sent at once
+// rather than through s.Queue and is not kept to be retried, and dead letters
+// and logs leave the current key out, so it is only kept in the escrow.
+func (s *Server) rotateFileVaultKey(ctx context.Context, d *Device, reason string) (string, error) {
+	key, err := s.openFileVaultKey(*d)
+	if err != nil {
+		return "", err
+	}
+	c := Command{
+		UDID:                       d.UDID,
+		RequestType:                "RotateFileVaultKey",
+		KeyType:                    "personal",
+		FileVaultUnlock:            &FileVaultUnlock{Password: key},
+		ReplyEncryptionCertificate: s.FileVault.cert.Raw,
+	}
+	uuid, err := s.postCommand(ctx, c.UDID, c.RequestType, c)
+	if err != nil {
+		return "", err
+	}
+	d.FileVault.Rotation = &store.FileVaultRotation{CommandUUID: uuid, Reason: reason, RequestedAt: time.Now().UTC()}
+	if err := s.Devices.Save(*d); err != nil {
+		logFor(ctx).WithError(err).Error("save FileVault rotation")
+	}
+	fileVaultVars.Add("rotations_requested", 1)
+	return uuid, nil
+}
+
+// rotationDue returns why the key escrowed for fv should be rotated at now,
+// or "" if it should not: keys that were disclosed, and keys older than
+// maxAge if it is set. Rotations still in progress are waited for.
+func rotationDue(fv *store.FileVault, maxAge time.Duration, now time.Time) string {
+	if fv == nil || fv.Key == nil {
+		return ""
+	}
+	if r := fv.Rotation; r != nil && r.CompletedAt == nil && now.Sub(r.RequestedAt) < fileVaultRotationTimeout {
+		return ""
+	}
+	switch {
+	case fv.DisclosedAt != nil:
+		return "disclosed"
+	case maxAge > 0 && now.Sub(fv.Key.CreatedAt) > maxAge:
+		return "max age"
+	}
+	return ""
+}
+
+// fileVaultLoop periodically rotates the FileVault keys that are due.
+func (s *Server) fileVaultLoop(ctx context.Context) {
+	ticker := time.NewTicker(fileVaultCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.checkFileVaultRotations(ctx)
+	}
+}
+
+func (s *Server) checkFileVaultRotations(ctx context.Context) {
+	devices, err := s.Devices.List()
+	if err != nil {
+		logrus.WithError(err).Error("list devices for FileVault rotation")
+		return
+	}
+	now := time.Now()
+	for _, d := range devices {
+		reason := rotationDue(d.FileVault, s.FileVaultKeyMaxAge, now)
+		if reason == "" {
+			continue
+		}
+		logger := logFor(ctx).WithFields(logrus.Fields{"udid": d.UDID, "reason": reason})
+		uuid, err := s.rotateFileVaultKey(ctx, &d, reason)
+		if err != nil {
+			logger.WithError(err).Error("rotate FileVault recovery key")
+			continue
+		}
+		logger.WithField("command_uuid", uuid).Warn("RotateFileVaultKey scheduled")
+	}
+}
+
+// FileVaultKeyRequest is the reason for disclosing a Mac's FileVault
+// recovery key, which is logged.
+type FileVaultKeyRequest struct {
+	Reason string `json:"reason"`
+}
+
+// FileVaultKey is an escrowed FileVault recovery key as disclosed by the
+// admin API.
+type FileVaultKey struct {
+	RecoveryKey string    `json:"recovery_key"`
+	EscrowedAt  time.Time `json:"escrowed_at"`
+}
+
+// handleGetFileVaultKey discloses the FileVault recovery key escrowed for a Mac.
+// Like the Activation Lock bypass code, it takes a POST with a reason, which
+// is logged with who made the request. The disclosed key is rotated by
+// fileVaultLoop.
+func (s *Server) handleGetFileVaultKey(w http.ResponseWriter, r *http.Request) {
+	if !s.fileVaultEnabled() {
+		http.Error(w, "FileVault key escrow is not configured; set -filevault-cert, -filevault-key, and -escrow-key", http.StatusServiceUnavailable)
+		return
+	}
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	var req FileVaultKeyRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandBody)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		http.Error(w, "a reason is required", http.StatusBadRequest)
+		return
+	}
+	if d.FileVault == nil || d.FileVault.Key == nil {
+		http.Error(w, fmt.Sprintf("no FileVault recovery key is escrowed for device %s", d.UDID), http.StatusNotFound)
+		return
+	}
+	key, err := s.openFileVaultKey(d)
+	if err != nil {
+		logFor(r.Context()).WithField("udid", d.UDID).WithError(err).Error("open escrowed FileVault recovery key")
+		http.Error(w, fmt.Sprintf("open escrowed FileVault recovery key: %v", err), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	d.FileVault.DisclosedAt = &now
+	if err := s.Devices.Save(d); err != nil {
+		logFor(r.Context()).WithError(err).Error("save FileVault disclosure")
+		http.Error(w, fmt.Sprintf("save device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	fileVaultVars.Add("disclosed", 1)
+	logFor(r.Context()).WithFields(auditFields(r)).WithFields(logrus.Fields{"udid": d.UDID, "reason": req.Reason}).Warn("disclosed escrowed FileVault recovery key")
+	writeJSON(w, http.StatusOK, FileVaultKey{RecoveryKey: key, EscrowedAt: d.FileVault.Key.CreatedAt})
+}
+
+// handleRotateFileVaultKey sends a Mac a RotateFileVaultKey command for its
+// escrowed recovery key.
+func (s *Server) handleRotateFileVaultKey(w http.ResponseWriter, r *http.Request) {
+	if !s.fileVaultEnabled() {
+		http.Error(w, "FileVault key escrow is not configured; set -filevault-cert, -filevault-key, and -escrow-key", http.StatusServiceUnavailable)
+		return
+	}
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	if d.FileVault == nil || d.FileVault.Key == nil {
+		http.Error(w, fmt.Sprintf("no FileVault recovery key is escrowed for device %s", d.UDID), http.StatusConflict)
+		return
+	}
+	uuid, err := s.rotateFileVaultKey(r.Context(), &d, "requested")
+	if err != nil {
+		logFor(r.Context()).WithFields(logrus.Fields{"udid": d.UDID, "request_type": "RotateFileVaultKey"}).WithError(err).Error("rotate FileVault recovery key")
+		http.Error(w, fmt.Sprintf("rotate FileVault recovery key: %v", err), http.StatusBadGateway)
+		return
+	}
+	logFor(r.Context()).WithFields(auditFields(r)).WithFields(logrus.Fields{"udid": d.UDID, "command_uuid": uuid}).Warn("RotateFileVaultKey requested")
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"command_uuid": uuid,
+		"request_type": "RotateFileVaultKey",
+		"udid":         d.UDID,
+	})
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// forwardQueueSize is how many events can wait for delivery to each target.
+const forwardQueueSize = 1000
+
+// forwardVars counts the events forwarded to each target, keyed by its URL:
+// delivered, retried, failed (given up on), and dropped (the target's queue
+// was full).
+var forwardVars = expvar.NewMap("forward")
+
+// forwardClient delivers forwarded events.
+var forwardClient = &http.Client{Timeout: 30 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// ForwardTarget is a downstream webhook events are posted on to, unchanged.
+type ForwardTarget struct {
+	URL string `yaml:"url"`
+
+	// Topics, UDIDs, and Tenants, when set, limit the events forwarded to
+	// those of the listed topics, devices, and tenants. The untenanted
+	// server's events match the tenant "".
+	Topics  []string `yaml:"topics"`
+	UDIDs   []string `yaml:"udids"`
+	Tenants []string `yaml:"tenants"`
+
+	// Secret signs each event with HMAC-SHA256, sent as
+	// X-Webhook-Signature: sha256=<hex>.
+	Secret string `yaml:"secret"`
+	// Token is sent as a bearer token, or User and Password with basic
+	// auth.
+	Token    string `yaml:"token"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	// Headers are added to every request.
+	Headers map[string]string `yaml:"headers"`
+}
+
+func (t ForwardTarget) matches(tenant, topic, udid string) bool {
+	return (len(t.Topics) == 0 || slices.Contains(t.Topics, topic)) &&
+		(len(t.UDIDs) == 0 || slices.Contains(t.UDIDs, udid)) &&
+		(len(t.Tenants) == 0 || slices.Contains(t.Tenants, tenant))
+}
+
+// forwardedEvent is an event waiting to be delivered to a target.
+type forwardedEvent struct {
+	ctx    context.Context
+	tenant string
+	body   []byte
+}
+
+// forwardTarget delivers events to one ForwardTarget from a queue of its
+// own, so a slow target does not hold up the others.
+type forwardTarget struct {
+	ForwardTarget
+	name   string
+	events chan forwardedEvent
+
+	delivered, retried, failed, dropped expvar.Int
+}
+
+// forwarder posts webhook events on to downstream webhooks, retrying
+// failed deliveries according to retry.
+type forwarder struct {
+	targets []*forwardTarget
+	retry   RetryPolicy
+	wg      sync.WaitGroup
+
+	// ctx is cancelled to abandon deliveries when shutdown times out.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newForwarder(targets []ForwardTarget, retry RetryPolicy) *forwarder {
+	f := &forwarder{retry: retry}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	for _, t := range targets {
+		ft := &forwardTarget{ForwardTarget: t, events: make(chan forwardedEvent, forwardQueueSize)}
+		ft.name = t.URL
+		if u, err := url.Parse(t.URL); err == nil {
+			ft.name = u.Redacted()
+		}
+		vars := new(expvar.Map)
+		vars.Set("delivered", &ft.delivered)
+		vars.Set("retried", &ft.retried)
+		vars.Set("failed", &ft.failed)
+		vars.Set("dropped", &ft.dropped)
+		vars.Set("queued", expvar.Func(func() any { return len(ft.events) }))
+		forwardVars.Set(ft.name, vars)
+		f.targets = append(f.targets, ft)
+
+		f.wg.Add(1)
+		go f.deliverAll(ft)
+	}
+	return f
+}
+
+// forward queues body, the raw JSON of an event, for delivery to each target
+// it matches. The deliveries keep the values of ctx, such as its logger, but
+// not its cancellation.
+func (f *forwarder) forward(ctx context.Context, tenant, topic, udid string, body []byte) {
+	for _, t := range f.targets {
+		if !t.matches(tenant, topic, udid) {
+			continue
+		}
+		select {
+		case t.events <- forwardedEvent{ctx: context.WithoutCancel(ctx), tenant: tenant, body: body}:
+		default:
+			t.dropped.Add(1)
+			logFor(ctx).WithField("target", t.name).Error("forward queue is full, dropping event")
+		}
+	}
+}
+
+func (f *forwarder) deliverAll(t *forwardTarget) {
+	defer f.wg.Done()
+	for e := range t.events {
+		ctx, cancel := context.WithCancel(e.ctx)
+		stop := context.AfterFunc(f.ctx, cancel)
+		f.deliver(ctx, t, e)
+		stop()
+		cancel()
+	}
+}
+
+// deliver posts e to t, retrying connection errors, 5xx, and 429 responses.
+func (f *forwarder) deliver(ctx context.Context, t *forwardTarget, e forwardedEvent) {
+	logger := logFor(ctx).WithField("target", t.name)
+	attempts := max(f.retry.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		err := t.post(ctx, e)
+		var perm *permanentError
+		if err == nil {
+			t.delivered.Add(1)
+			return
+		}
+		if errors.As(err, &perm) || attempt >= attempts || ctx.Err() != nil {
+			t.failed.Add(1)
+			logger.WithError(err).WithField("attempts", attempt).Error("giving up forwarding event")
+			return
+		}
+		t.retried.Add(1)
+		wait := f.retry.delay(attempt)
+		logger.WithError(err).WithFields(logrus.Fields{"attempt": attempt, "retry_in": wait.String()}).Warn("forward event failed, retrying")
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (t *forwardTarget) post(ctx context.Context, e forwardedEvent) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", t.URL, bytes.NewReader(e.body))
+	if err != nil {
+		return &permanentError{fmt.Errorf("create request: %v", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.Headers {
+		req.Header.Set(k, v)
+	}
+	if e.tenant != "" {
+		req.Header.Set("X-Webhook-Tenant", e.tenant)
+	}
+	if id := requestIDFrom(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	if t.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(t.Secret))
+		mac.Write(e.body)
+		req.Header.Set(defaultSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	switch {
+	case t.Token != "":
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	case t.User != "":
+		req.SetBasicAuth(t.User, t.Password)
+	}
+	resp, err := forwardClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("target returned %s", resp.Status)
+	case resp.StatusCode >= 400:
+		return &permanentError{fmt.Errorf("target returned %s", resp.Status)}
+	}
+	return nil
+}
+
+// close stops accepting events and waits for the queued ones to be
+// delivered. If ctx ends first, the remaining deliveries are cancelled and
+// ctx's error is returned. Nothing may be forwarded after close is called.
+func (f *forwarder) close(ctx context.Context) error {
+	for _, t := range f.targets {
+		close(t.events)
+	}
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		f.cancel()
+		return ctx.Err()
+	}
+}
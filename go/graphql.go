@@ -0,0 +1,292 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+)
+
+// graphQLSchema is the schema of the /graphql endpoint.
+//
+//go:embed schema.graphql
+var graphQLSchema string
+
+// Limits on query cost, so a single request cannot walk the whole inventory
+// many times over.
+const (
+	graphQLMaxDepth       = 8
+	graphQLMaxParallelism = 10
+)
+
+// graphQLHandler returns the /graphql endpoint, which requires s.AdminToken
+// on every request.
+func (s *Server) graphQLHandler() http.Handler {
+	schema := graphql.MustParseSchema(graphQLSchema, &queryResolver{s: s},
+		graphql.MaxDepth(graphQLMaxDepth),
+		graphql.MaxParallelism(graphQLMaxParallelism),
+	)
+	return s.requireAdmin(&relay.Handler{Schema: schema})
+}
+
+type queryResolver struct {
+	s *Server
+}
+
+func (q *queryResolver) Devices(args struct {
+	Enrolled  *bool
+	OsVersion *string
+	Model     *string
+	Platform  *string
+	Tag       *string
+	Sort      *string
+	First     *int32
+	After     *string
+}) (*deviceConnectionResolver, error) {
+	v := url.Values{}
+	if args.Enrolled != nil {
+		v.Set("enrolled", strconv.FormatBool(*args.Enrolled))
+	}
+	for key, val := range map[string]*string{
+		"os_version": args.OsVersion,
+		"model":      args.Model,
+		"platform":   args.Platform,
+		"tag":        args.Tag,
+		"sort":       args.Sort,
+		"cursor":     args.After,
+	} {
+		if val != nil && *val != "" {
+			v.Set(key, *val)
+		}
+	}
+	if args.First != nil {
+		v.Set("limit", strconv.Itoa(int(*args.First)))
+	}
+	query, err := parseDeviceQuery(v)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := q.s.Devices.List()
+	if err != nil {
+		return nil, err
+	}
+	page, next := query.Apply(devices)
+	conn := &deviceConnectionResolver{next: optString(next)}
+	for _, d := range page {
+		conn.nodes = append(conn.nodes, &deviceResolver{s: q.s, d: d})
+	}
+	return conn, nil
+}
+
+func (q *queryResolver) Device(args struct{ UDID string }) (*deviceResolver, error) {
+	d, err := q.s.Devices.Get(args.UDID)
+	if err == store.ErrDeviceNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &deviceResolver{s: q.s, d: d}, nil
+}
+
+type deviceConnectionResolver struct {
+	nodes []*deviceResolver
+	next  *string
+}
+
+func (c *deviceConnectionResolver) Nodes() []*deviceResolver { return c.nodes }
+func (c *deviceConnectionResolver) NextCursor() *string      { return c.next }
+
+type deviceResolver struct {
+	s *Server
+	d Device
+}
+
+func (r *deviceResolver) UDID() string            { return r.d.UDID }
+func (r *deviceResolver) Enrolled() bool          { return r.d.Enrolled }
+func (r *deviceResolver) LastSeen() *graphql.Time { return optTime(r.d.LastSeen) }
+func (r *deviceResolver) Platform() *string       { return optString(r.d.Platform) }
+func (r *deviceResolver) Tags() []string          { return nonNil(r.d.Tags) }
+func (r *deviceResolver) Info() *deviceInfoResolver {
+	if r.d.Info == nil {
+		return nil
+	}
+	return &deviceInfoResolver{r.d.Info}
+}
+
+func (r *deviceResolver) Security() *securityResolver {
+	if r.d.Security == nil {
+		return nil
+	}
+	return &securityResolver{r.d.Security}
+}
+
+func (r *deviceResolver) InstalledApps(args struct{ Identifier *string }) []*installedAppResolver {
+	apps := []*installedAppResolver{}
+	for i := range r.d.InstalledApps {
+		a := &r.d.InstalledApps[i]
+		if args.Identifier != nil && a.Identifier != *args.Identifier {
+			continue
+		}
+		apps = append(apps, &installedAppResolver{a})
+	}
+	return apps
+}
+
+func (r *deviceResolver) Profiles() []*profileResolver {
+	profiles := make([]*profileResolver, len(r.d.Profiles))
+	for i := range r.d.Profiles {
+		profiles[i] = &profileResolver{&r.d.Profiles[i]}
+	}
+	return profiles
+}
+
+func (r *deviceResolver) Certificates() []*certificateResolver {
+	certs := make([]*certificateResolver, len(r.d.Certificates))
+	for i := range r.d.Certificates {
+		certs[i] = &certificateResolver{&r.d.Certificates[i]}
+	}
+	return certs
+}
+
+func (r *deviceResolver) Commands(args struct{ Last *int32 }) ([]*commandRecordResolver, error) {
+	records, err := r.s.History.CommandHistory(r.d.UDID)
+	if err != nil {
+		return nil, err
+	}
+	if args.Last != nil && int(*args.Last) >= 0 && int(*args.Last) < len(records) {
+		records = records[len(records)-int(*args.Last):]
+	}
+	cmds := make([]*commandRecordResolver, len(records))
+	for i := range records {
+		cmds[i] = &commandRecordResolver{&records[i]}
+	}
+	return cmds, nil
+}
+
+type installedAppResolver struct{ a *InstalledApp }
+
+func (r *installedAppResolver) Identifier() string    { return r.a.Identifier }
+func (r *installedAppResolver) Name() string          { return r.a.Name }
+func (r *installedAppResolver) ShortVersion() *string { return optString(r.a.ShortVersion) }
+func (r *installedAppResolver) Version() *string      { return optString(r.a.Version) }
+func (r *installedAppResolver) BundleSize() *float64  { return optFloat(float64(r.a.BundleSize)) }
+func (r *installedAppResolver) DynamicSize() *float64 { return optFloat(float64(r.a.DynamicSize)) }
+
+type deviceInfoResolver struct{ i *DeviceInfo }
+
+func (r *deviceInfoResolver) DeviceName() *string    { return optString(r.i.DeviceName) }
+func (r *deviceInfoResolver) OsVersion() *string     { return optString(r.i.OSVersion) }
+func (r *deviceInfoResolver) BuildVersion() *string  { return optString(r.i.BuildVersion) }
+func (r *deviceInfoResolver) ProductName() *string   { return optString(r.i.ProductName) }
+func (r *deviceInfoResolver) Model() *string         { return optString(r.i.Model) }
+func (r *deviceInfoResolver) ModelName() *string     { return optString(r.i.ModelName) }
+func (r *deviceInfoResolver) SerialNumber() *string  { return optString(r.i.SerialNumber) }
+func (r *deviceInfoResolver) BatteryLevel() *float64 { return optFloat(r.i.BatteryLevel) }
+func (r *deviceInfoResolver) DeviceCapacity() *float64 {
+	return optFloat(r.i.DeviceCapacity)
+}
+func (r *deviceInfoResolver) AvailableDeviceCapacity() *float64 {
+	return optFloat(r.i.AvailableDeviceCapacity)
+}
+func (r *deviceInfoResolver) IsSupervised() bool       { return r.i.IsSupervised }
+func (r *deviceInfoResolver) WifiMAC() *string         { return optString(r.i.WiFiMAC) }
+func (r *deviceInfoResolver) BluetoothMAC() *string    { return optString(r.i.BluetoothMAC) }
+func (r *deviceInfoResolver) UpdatedAt() *graphql.Time { return optTime(r.i.UpdatedAt) }
+
+type securityResolver struct{ p *SecurityPosture }
+
+func (r *securityResolver) PasscodePresent() bool           { return r.p.PasscodePresent }
+func (r *securityResolver) PasscodeCompliant() bool         { return r.p.PasscodeCompliant }
+func (r *securityResolver) FdeEnabled() bool                { return r.p.FDEEnabled }
+func (r *securityResolver) FdeHasPersonalRecoveryKey() bool { return r.p.FDEHasPersonalRecoveryKey }
+func (r *securityResolver) FdeHasInstitutionalRecoveryKey() bool {
+	return r.p.FDEHasInstitutionalKey
+}
+func (r *securityResolver) FirewallEnabled() bool          { return r.p.FirewallEnabled }
+func (r *securityResolver) FirewallBlockAllIncoming() bool { return r.p.FirewallBlockAllIncoming }
+func (r *securityResolver) FirewallStealthMode() bool      { return r.p.FirewallStealthMode }
+func (r *securityResolver) SipEnabled() *bool              { return r.p.SIPEnabled }
+func (r *securityResolver) UpdatedAt() *graphql.Time       { return optTime(r.p.UpdatedAt) }
+
+type profileResolver struct{ p *InstalledProfile }
+
+func (r *profileResolver) Identifier() string    { return r.p.Identifier }
+func (r *profileResolver) UUID() string          { return r.p.UUID }
+func (r *profileResolver) Organization() *string { return optString(r.p.Organization) }
+func (r *profileResolver) DisplayName() *string  { return optString(r.p.DisplayName) }
+func (r *profileResolver) IsManaged() bool       { return r.p.IsManaged }
+
+type certificateResolver struct{ c *DeviceCertificate }
+
+func (r *certificateResolver) CommonName() string      { return r.c.CommonName }
+func (r *certificateResolver) Subject() string         { return r.c.Subject }
+func (r *certificateResolver) Issuer() string          { return r.c.Issuer }
+func (r *certificateResolver) NotBefore() graphql.Time { return graphql.Time{Time: r.c.NotBefore} }
+func (r *certificateResolver) NotAfter() graphql.Time  { return graphql.Time{Time: r.c.NotAfter} }
+func (r *certificateResolver) IsIdentity() bool        { return r.c.IsIdentity }
+
+type commandRecordResolver struct{ r *CommandRecord }
+
+func (r *commandRecordResolver) CommandUUID() string  { return r.r.CommandUUID }
+func (r *commandRecordResolver) RequestType() *string { return optString(r.r.RequestType) }
+func (r *commandRecordResolver) UserID() *string      { return optString(r.r.UserID) }
+func (r *commandRecordResolver) Status() string       { return r.r.Status }
+func (r *commandRecordResolver) ErrorClass() *string  { return optString(r.r.ErrorClass) }
+func (r *commandRecordResolver) Retrying() bool       { return r.r.Retrying }
+func (r *commandRecordResolver) Time() graphql.Time   { return graphql.Time{Time: r.r.Time} }
+func (r *commandRecordResolver) ErrorChain() []*errorChainItemResolver {
+	items := make([]*errorChainItemResolver, len(r.r.ErrorChain))
+	for i, e := range r.r.ErrorChain {
+		items[i] = &errorChainItemResolver{
+			code:      int32(e.ErrorCode),
+			domain:    e.ErrorDomain,
+			localized: e.LocalizedDescription,
+			usEnglish: e.USEnglishDescription,
+		}
+	}
+	return items
+}
+
+type errorChainItemResolver struct {
+	code                         int32
+	domain, localized, usEnglish string
+}
+
+func (r *errorChainItemResolver) ErrorCode() int32              { return r.code }
+func (r *errorChainItemResolver) ErrorDomain() *string          { return optString(r.domain) }
+func (r *errorChainItemResolver) LocalizedDescription() *string { return optString(r.localized) }
+func (r *errorChainItemResolver) UsEnglishDescription() *string { return optString(r.usEnglish) }
+
+func optString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func optFloat(f float64) *float64 {
+	if f == 0 {
+		return nil
+	}
+	return &f
+}
+
+func optTime(t time.Time) *graphql.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &graphql.Time{Time: t}
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,298 @@
+package main
+
+//go:generate buf generate
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/kurtpeek/micromdm-webhook-blueprints/go/proto/adminv1"
+)
+
+// grpcServer implements the gRPC admin services on top of a Server.
+type grpcServer struct {
+	pb.UnimplementedDeviceServiceServer
+	pb.UnimplementedCommandServiceServer
+	s *Server
+}
+
+// newGRPCServer returns a gRPC server exposing the admin services, which
+// require s.AdminToken on every call.
+func (s *Server) newGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	srv := grpc.NewServer(append(opts,
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := s.authorizeGRPC(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := s.authorizeGRPC(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)...)
+	g := &grpcServer{s: s}
+	pb.RegisterDeviceServiceServer(srv, g)
+	pb.RegisterCommandServiceServer(srv, g)
+	return srv
+}
+
+// authorizeGRPC checks the bearer token in the call's authorization metadata.
+func (s *Server) authorizeGRPC(ctx context.Context) error {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			token = strings.TrimPrefix(v[0], "Bearer ")
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+		addr := "unknown"
+		if p, ok := peer.FromContext(ctx); ok {
+			addr = p.Addr.String()
+		}
+		logFor(ctx).WithField("remote_addr", addr).Warn("rejected gRPC admin request: invalid credentials")
+		return status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return nil
+}
+
+func (g *grpcServer) ListDevices(ctx context.Context, req *pb.ListDevicesRequest) (*pb.ListDevicesResponse, error) {
+	v := url.Values{}
+	if req.Enrolled != nil {
+		v.Set("enrolled", strconv.FormatBool(*req.Enrolled))
+	}
+	for key, val := range map[string]string{
+		"os_version": req.OsVersion,
+		"model":      req.Model,
+		"platform":   req.Platform,
+		"tag":        req.Tag,
+		"sort":       req.Sort,
+		"cursor":     req.PageToken,
+	} {
+		if val != "" {
+			v.Set(key, val)
+		}
+	}
+	if req.PageSize > 0 {
+		v.Set("limit", strconv.Itoa(int(req.PageSize)))
+	}
+	q, err := parseDeviceQuery(v)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	devices, err := g.s.Devices.List()
+	if err != nil {
+		logFor(ctx).WithError(err).Error("list devices")
+		return nil, status.Errorf(codes.Internal, "list devices: %v", err)
+	}
+	page, next := q.Apply(devices)
+	resp := &pb.ListDevicesResponse{NextPageToken: next}
+	for _, d := range page {
+		resp.Devices = append(resp.Devices, deviceToProto(d))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) GetDevice(ctx context.Context, req *pb.GetDeviceRequest) (*pb.Device, error) {
+	d, err := g.s.Devices.Get(req.Udid)
+	if err == store.ErrDeviceNotFound {
+		return nil, status.Error(codes.NotFound, err.Error())
+	} else if err != nil {
+		logFor(ctx).WithError(err).Error("get device")
+		return nil, status.Errorf(codes.Internal, "get device: %v", err)
+	}
+	return deviceToProto(d), nil
+}
+
+func (g *grpcServer) SendCommand(ctx context.Context, req *pb.SendCommandRequest) (*pb.SendCommandResponse, error) {
+	cmd := req.GetCommand()
+	if cmd.GetUdid() == "" {
+		return nil, status.Error(codes.InvalidArgument, "command has no udid")
+	}
+	fields := cmd.GetParams().AsMap()
+	fields["request_type"] = cmd.RequestType
+	body, err := json.Marshal(fields)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "encode command: %v", err)
+	}
+	requestType, payload, err := parseCommandPayload(body)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := requireConfirmation(requestType); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	payload["udid"] = cmd.Udid
+	if d, exists, err := g.s.loadDevice(cmd.Udid); err == nil && exists {
+		if err := checkPlatform(d, requestType); err != nil {
+			return nil, status.Error(codes.FailedPrecondition, err.Error())
+		}
+	}
+
+	uuid, err := g.s.postCommand(ctx, cmd.Udid, requestType, payload)
+	if err != nil {
+		logFor(ctx).WithFields(logrus.Fields{"udid": cmd.Udid, "request_type": requestType}).WithError(err).Error("send command")
+		return nil, status.Errorf(codes.Unavailable, "send command: %v", err)
+	}
+	return &pb.SendCommandResponse{CommandUuid: uuid, RequestType: requestType, Udid: cmd.Udid}, nil
+}
+
+func (g *grpcServer) GetCommandHistory(ctx context.Context, req *pb.GetCommandHistoryRequest) (*pb.GetCommandHistoryResponse, error) {
+	records, err := g.s.History.CommandHistory(req.Udid)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load command history")
+		return nil, status.Errorf(codes.Internal, "load command history: %v", err)
+	}
+	resp := &pb.GetCommandHistoryResponse{}
+	for _, r := range records {
+		resp.Records = append(resp.Records, commandRecordToProto(r))
+	}
+	return resp, nil
+}
+
+func (g *grpcServer) StreamEvents(req *pb.StreamEventsRequest, stream pb.CommandService_StreamEventsServer) error {
+	events, unsubscribe := g.s.Events.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if req.Udid != "" && e.UDID != req.Udid {
+				continue
+			}
+			err := stream.Send(&pb.Event{
+				EventId:     e.ID,
+				Topic:       e.Topic,
+				Udid:        e.UDID,
+				Time:        timestamp(e.Time),
+				CommandUuid: e.CommandUUID,
+				Status:      e.Status,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func deviceToProto(d Device) *pb.Device {
+	p := &pb.Device{
+		Udid:     d.UDID,
+		Enrolled: d.Enrolled,
+		LastSeen: timestamp(d.LastSeen),
+		Tags:     d.Tags,
+		Platform: d.Platform,
+	}
+	for _, a := range d.InstalledApps {
+		p.InstalledApps = append(p.InstalledApps, &pb.InstalledApp{
+			Identifier:   a.Identifier,
+			Name:         a.Name,
+			ShortVersion: a.ShortVersion,
+			Version:      a.Version,
+			BundleSize:   a.BundleSize,
+			DynamicSize:  a.DynamicSize,
+		})
+	}
+	if i := d.Info; i != nil {
+		p.Info = &pb.DeviceInfo{
+			DeviceName:              i.DeviceName,
+			OsVersion:               i.OSVersion,
+			BuildVersion:            i.BuildVersion,
+			ProductName:             i.ProductName,
+			Model:                   i.Model,
+			ModelName:               i.ModelName,
+			SerialNumber:            i.SerialNumber,
+			BatteryLevel:            i.BatteryLevel,
+			DeviceCapacity:          i.DeviceCapacity,
+			AvailableDeviceCapacity: i.AvailableDeviceCapacity,
+			IsSupervised:            i.IsSupervised,
+			WifiMac:                 i.WiFiMAC,
+			BluetoothMac:            i.BluetoothMAC,
+			UpdatedAt:               timestamp(i.UpdatedAt),
+		}
+	}
+	if sec := d.Security; sec != nil {
+		p.Security = &pb.SecurityPosture{
+			PasscodePresent:                sec.PasscodePresent,
+			PasscodeCompliant:              sec.PasscodeCompliant,
+			FdeEnabled:                     sec.FDEEnabled,
+			FdeHasPersonalRecoveryKey:      sec.FDEHasPersonalRecoveryKey,
+			FdeHasInstitutionalRecoveryKey: sec.FDEHasInstitutionalKey,
+			FirewallEnabled:                sec.FirewallEnabled,
+			FirewallBlockAllIncoming:       sec.FirewallBlockAllIncoming,
+			FirewallStealthMode:            sec.FirewallStealthMode,
+			SipEnabled:                     sec.SIPEnabled,
+			UpdatedAt:                      timestamp(sec.UpdatedAt),
+		}
+	}
+	for _, prof := range d.Profiles {
+		p.Profiles = append(p.Profiles, &pb.InstalledProfile{
+			Identifier:   prof.Identifier,
+			Uuid:         prof.UUID,
+			Organization: prof.Organization,
+			DisplayName:  prof.DisplayName,
+			IsManaged:    prof.IsManaged,
+		})
+	}
+	for _, c := range d.Certificates {
+		p.Certificates = append(p.Certificates, &pb.DeviceCertificate{
+			CommonName: c.CommonName,
+			Subject:    c.Subject,
+			Issuer:     c.Issuer,
+			NotBefore:  timestamp(c.NotBefore),
+			NotAfter:   timestamp(c.NotAfter),
+			IsIdentity: c.IsIdentity,
+		})
+	}
+	return p
+}
+
+func commandRecordToProto(r CommandRecord) *pb.CommandRecord {
+	p := &pb.CommandRecord{
+		Udid:        r.UDID,
+		CommandUuid: r.CommandUUID,
+		RequestType: r.RequestType,
+		UserId:      r.UserID,
+		Status:      r.Status,
+		ErrorClass:  r.ErrorClass,
+		Retrying:    r.Retrying,
+		Time:        timestamp(r.Time),
+	}
+	for _, e := range r.ErrorChain {
+		p.ErrorChain = append(p.ErrorChain, &pb.ErrorChainItem{
+			ErrorCode:            int32(e.ErrorCode),
+			ErrorDomain:          e.ErrorDomain,
+			LocalizedDescription: e.LocalizedDescription,
+			UsEnglishDescription: e.USEnglishDescription,
+		})
+	}
+	return p
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// version is the release of the binary, set at build time with
+// -ldflags "-X main.version=v1.2.3".
+var version = "dev"
+
+// readyTimeout bounds each readiness check.
+const readyTimeout = 5 * time.Second
+
+// pinger is implemented by stores backed by a database or service that can
+// become unreachable.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// health serves the probe endpoints for the store and webhook servers.
+type health struct {
+	store   DeviceStore
+	servers []*Server
+}
+
+// handler returns the /healthz, /readyz, and /version endpoints. They need
+// no credentials, so they reveal nothing beyond whether each check passed;
+// the errors of failed checks are only logged.
+func (h *health) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("GET /readyz", h.handleReadyz)
+	mux.HandleFunc("GET /version", handleVersion)
+	return mux
+}
+
+// handleReadyz reports whether the store and every MicroMDM server are
+// reachable. It responds 503 if any of them is not.
+func (h *health) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		checks = make(map[string]string)
+		ready  = true
+	)
+	check := func(name string, fn func(context.Context) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := "ok"
+			if err := fn(ctx); err != nil {
+				logFor(ctx).WithField("check", name).WithError(err).Warn("readiness check failed")
+				result = "failed"
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			checks[name] = result
+			ready = ready && result == "ok"
+		}()
+	}
+	if p, ok := h.store.(pinger); ok {
+		check("store", p.Ping)
+	}
+	for _, s := range h.servers {
+		if s.MDMServerURL == "" {
+			continue
+		}
+		name := "micromdm"
+		if s.Tenant != "" {
+			name += "/" + s.Tenant
+		}
+		check(name, s.pingMicroMDM)
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{"status": status, "checks": checks})
+}
+
+// pingMicroMDM checks that the MicroMDM server answers and accepts the API
+// token.
+func (s *Server) pingMicroMDM(ctx context.Context) error {
+	return s.mdm().Ping(ctx)
+}
+
+// handleVersion reports the release and build of the binary.
+func handleVersion(w http.ResponseWriter, _ *http.Request) {
+	v := map[string]string{"version": version, "go_version": runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				v["commit"] = setting.Value
+			case "vcs.time":
+				v["commit_time"] = setting.Value
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, v)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// inventoryVars counts the scheduled inventory refreshes (runs), the devices
+// they were for (devices), and the commands they sent (commands).
+var inventoryVars = expvar.NewMap("inventory_refresh")
+
+// defaultInventoryCommands are the commands sent by inventory refreshes, by
+// default: those whose responses the stored inventory is made of.
+const defaultInventoryCommands = "DeviceInformation,InstalledApplicationList,SecurityInfo"
+
+// inventoryLoop sends commands to the enrolled devices at the times of
+// schedule, so their inventory does not go stale between enrollments.
+func (s *Server) inventoryLoop(ctx context.Context, schedule *cronSchedule, commands []string) {
+	for {
+		now := time.Now()
+		next := schedule.next(now)
+		if next.IsZero() {
+			logrus.WithField("schedule", schedule.String()).Error("inventory schedule never matches; not refreshing inventory")
+			return
+		}
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.refreshInventory(ctx, commands)
+	}
+}
+
+// refreshInventory sends commands to the enrolled devices that are not
+// retired, leaving out those their platforms do not take. DeviceInformation
+// asks for webhook.DeviceInformationQueries.
+func (s *Server) refreshInventory(ctx context.Context, commands []string) {
+	devices, err := s.Devices.List()
+	if err != nil {
+		logrus.WithError(err).Error("list devices for inventory refresh")
+		return
+	}
+	inventoryVars.Add("runs", 1)
+	refreshed := 0
+	for _, d := range devices {
+		if !d.Enrolled || isRetired(d) {
+			continue
+		}
+		for _, requestType := range commands {
+			if requestType == "DeviceInformation" {
+				s.requestDeviceInformation(ctx, d)
+			} else if skipUnsupported(ctx, d, requestType) {
+				continue
+			} else {
+				s.sendCommand(ctx, Command{UDID: d.UDID, RequestType: requestType})
+			}
+			inventoryVars.Add("commands", 1)
+		}
+		refreshed++
+	}
+	inventoryVars.Add("devices", int64(refreshed))
+	logrus.WithFields(logrus.Fields{"devices": refreshed, "commands": commands}).Info("refreshed inventory")
+}
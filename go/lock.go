@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+)
+
+// pinEscrow encrypts the PINs of DeviceLock commands, the UnlockTokens of
+// ClearPasscode ones, and Activation Lock bypass codes before they are stored
+// with the device, with AES-256-GCM and the device's UDID as additional
+// data, so a secret cannot be read from the store, or moved to another
+// device's record, without the key.
+type pinEscrow struct {
+	aead cipher.AEAD
+}
+
+// loadPINEscrow reads the escrow key, 32 bytes in base64 as printed by
+// "openssl rand -base64 32", from the file at path.
+func loadPINEscrow(path string) (*pinEscrow, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read escrow key: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("decode escrow key: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("escrow key is %d bytes, want 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &pinEscrow{aead: aead}, nil
+}
+
+// seal encrypts the PIN of a command sent to the device udid.
+func (e *pinEscrow) seal(udid, pin string) ([]byte, error) {
+	nonce := make([]byte, e.aead.NonceSize(), e.aead.NonceSize()+len(pin)+e.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return e.aead.Seal(nonce, nonce, []byte(pin), []byte(udid)), nil
+}
+
+// open decrypts a PIN sealed for the device udid.
+func (e *pinEscrow) open(udid string, sealed []byte) (string, error) {
+	n := e.aead.NonceSize()
+	if len(sealed) < n {
+		return "", fmt.Errorf("sealed PIN too short")
+	}
+	pin, err := e.aead.Open(nil, sealed[:n], sealed[n:], []byte(udid))
+	if err != nil {
+		return "", fmt.Errorf("decrypt PIN: %v", err)
+	}
+	return string(pin), nil
+}
+
+// generatePIN returns a random 6-digit PIN.
+func generatePIN() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", fmt.Errorf("generate PIN: %v", err)
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// isMac reports whether d is a Mac, and whether d has said what it is yet.
+func isMac(d Device) (mac, known bool) {
+	if d.Platform != "" {
+		return d.Platform == platformMacOS, true
+	}
+	if d.Info == nil || d.Info.ProductName == "" && d.Info.Model == "" {
+		return false, false
+	}
+	return strings.Contains(d.Info.ProductName, "Mac") || strings.Contains(d.Info.Model, "Mac"), true
+}
+
+// needsLockPIN reports whether a DeviceLock command for d takes a PIN, as it
+// must for Macs. Devices that have not said what they are yet are assumed to
+// be Macs, since other devices ignore the PIN.
+func needsLockPIN(d Device) bool {
+	mac, known := isMac(d)
+	return mac || !known
+}
+
+// LockOptions are what a DeviceLock command shows on the locked device.
+type LockOptions struct {
+	Message     string `json:"message,omitempty" yaml:"message"`
+	PhoneNumber string `json:"phone_number,omitempty" yaml:"phone-number"`
+}
+
+// lockDevice sends d a DeviceLock command. For Macs it generates the PIN that
+// unlocks the device, and escrows it in d's record before the command is
+// sent: a Mac locked with a PIN nobody knows can only be unlocked by Apple.
+// d is reloaded once saved, so callers can go on to save it themselves.
+//
+// The command is sent at once rather than through s.Queue and is not kept to
+// be retried, and dead letters and logs leave the PIN out, so it is only kept
+// in the escrow.
+func (s *Server) lockDevice(ctx context.Context, d *Device, opts LockOptions) (string, error) {
+	c := Command{UDID: d.UDID, RequestType: "DeviceLock", Message: opts.Message, PhoneNumber: opts.PhoneNumber}
+	if needsLockPIN(*d) {
+		if s.Escrow == nil {
+			return "", fmt.Errorf("locking a Mac requires a PIN escrow key; set -escrow-key")
+		}
+		pin, err := s.escrowPIN(d)
+		if err != nil {
+			return "", err
+		}
+		c.PIN = pin
+	}
+	return s.postCommand(ctx, c.UDID, c.RequestType, c)
+}
+
+// escrowPIN generates a PIN and saves it, sealed, with d, which is then
+// reloaded. s.Escrow must be set.
+func (s *Server) escrowPIN(d *Device) (string, error) {
+	pin, err := generatePIN()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := s.Escrow.seal(d.UDID, pin)
+	if err != nil {
+		return "", fmt.Errorf("seal PIN: %v", err)
+	}
+	d.LockPINs = append(d.LockPINs, store.EscrowedPIN{Sealed: sealed, CreatedAt: time.Now().UTC()})
+	if err := s.Devices.Save(*d); err != nil {
+		return "", fmt.Errorf("escrow PIN: %v", err)
+	}
+	if *d, err = s.Devices.Get(d.UDID); err != nil {
+		return "", fmt.Errorf("escrow PIN: %v", err)
+	}
+	return pin, nil
+}
+
+// LockPIN is an escrowed PIN as disclosed by the admin API.
+type LockPIN struct {
+	PIN       string    `json:"pin"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// lockPINs decrypts the PINs escrowed for d, newest first.
+func (s *Server) lockPINs(d Device) ([]LockPIN, error) {
+	pins := make([]LockPIN, 0, len(d.LockPINs))
+	for i := len(d.LockPINs) - 1; i >= 0; i-- {
+		p := d.LockPINs[i]
+		pin, err := s.Escrow.open(d.UDID, p.Sealed)
+		if err != nil {
+			return nil, err
+		}
+		pins = append(pins, LockPIN{PIN: pin, CreatedAt: p.CreatedAt})
+	}
+	return pins, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestEscrow(t *testing.T) *pinEscrow {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "escrow.key")
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	e, err := loadPINEscrow(path)
+	if err != nil {
+		t.Fatalf("loadPINEscrow: %v", err)
+	}
+	return e
+}
+
+func TestPINEscrow(t *testing.T) {
+	e := newTestEscrow(t)
+	tests := []struct {
+		name    string
+		sealFor string
+		pin     string
+		openFor string
+		tamper  func(sealed []byte) []byte
+		wantErr string
+	}{
+		{name: "PIN", sealFor: "UDID-1", pin: "123456", openFor: "UDID-1"},
+		{name: "empty", sealFor: "UDID-1", pin: "", openFor: "UDID-1"},
+		{name: "unlock token", sealFor: "UDID-1", pin: strings.Repeat("\x00\xff", 64), openFor: "UDID-1"},
+		{name: "wrong UDID", sealFor: "UDID-1", pin: "123456", openFor: "UDID-2", wantErr: "decrypt PIN"},
+		{
+			name: "modified", sealFor: "UDID-1", pin: "123456", openFor: "UDID-1", wantErr: "decrypt PIN",
+			tamper: func(sealed []byte) []byte { sealed[len(sealed)-1] ^= 1; return sealed },
+		},
+		{
+			name: "truncated", sealFor: "UDID-1", pin: "123456", openFor: "UDID-1", wantErr: "too short",
+			tamper: func(sealed []byte) []byte { return sealed[:4] },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := e.seal(tt.sealFor, tt.pin)
+			if err != nil {
+				t.Fatalf("seal: %v", err)
+			}
+			if tt.pin != "" && strings.Contains(string(sealed), tt.pin) {
+				t.Errorf("sealed %q contains the PIN", sealed)
+			}
+			if tt.tamper != nil {
+				sealed = tt.tamper(sealed)
+			}
+			got, err := e.open(tt.openFor, sealed)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("open error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			if got != tt.pin {
+				t.Errorf("open = %q, want %q", got, tt.pin)
+			}
+		})
+	}
+}
+
+func TestPINEscrowOtherKey(t *testing.T) {
+	sealed, err := newTestEscrow(t).seal("UDID-1", "123456")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if _, err := newTestEscrow(t).open("UDID-1", sealed); err == nil {
+		t.Error("open with another key succeeded")
+	}
+}
+
+func TestLoadPINEscrow(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr string
+	}{
+		{name: "short key", key: base64.StdEncoding.EncodeToString(make([]byte, 16)), wantErr: "want 32"},
+		{name: "not base64", key: "not base64!", wantErr: "decode escrow key"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "escrow.key")
+			if err := os.WriteFile(path, []byte(tt.key), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadPINEscrow(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("loadPINEscrow error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/sirupsen/logrus"
+)
+
+// setupLogging sets the level and format of the logger used throughout the
+// server. Messages of dependencies that use the standard log package are
+// passed through it too.
+func setupLogging(level, format string) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q: want debug, info, warn, or error", level)
+	}
+	logrus.SetLevel(lvl)
+	switch format {
+	case "text":
+		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	case "json":
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("invalid log format %q: want text or json", format)
+	}
+	log.SetFlags(0)
+	log.SetOutput(logrus.StandardLogger().WriterLevel(logrus.InfoLevel))
+	return nil
+}
+
+type loggerKey struct{}
+
+// withLogger returns a copy of ctx carrying entry, so everything done on
+// behalf of a request or event logs with the same fields.
+func withLogger(ctx context.Context, entry *logrus.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, entry)
+}
+
+// logFor returns the logger carried by ctx, or the standard logger.
+func logFor(ctx context.Context) *logrus.Entry {
+	if entry, ok := ctx.Value(loggerKey{}).(*logrus.Entry); ok {
+		return entry
+	}
+	return logrus.NewEntry(logrus.StandardLogger())
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// LostModeOptions are what an EnableLostMode command shows on the device's
+// lock screen. A message or a phone number is required.
+type LostModeOptions struct {
+	Message     string `json:"message,omitempty"`
+	PhoneNumber string `json:"phone_number,omitempty"`
+	Footnote    string `json:"footnote,omitempty"`
+}
+
+func (o LostModeOptions) validate() error {
+	if o.Message == "" && o.PhoneNumber == "" {
+		return fmt.Errorf("set a message or a phone_number")
+	}
+	return nil
+}
+
+// checkLostMode returns an error if d would reject requestType, a Lost Mode
+// command: only supervised iOS devices take them. Devices that have not said
+// what they are yet are given the benefit of the doubt.
+func checkLostMode(d Device, requestType string) error {
+	mac, known := isMac(d)
+	switch {
+	case mac:
+		return fmt.Errorf("%s is not supported on Macs", requestType)
+	case known && !d.Info.IsSupervised:
+		return fmt.Errorf("%s requires a supervised device, and %s is not supervised", requestType, d.UDID)
+	}
+	return nil
+}
+
+// handleEnableLostMode sends a device an EnableLostMode command and records
+// the device's Lost Mode state, confirmed once the device acknowledges it.
+// The body is a JSON LostModeOptions.
+func (s *Server) handleEnableLostMode(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	var opts LostModeOptions
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandBody)).Decode(&opts); err != nil {
+		http.Error(w, fmt.Sprintf("decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := opts.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := checkLostMode(d, "EnableLostMode"); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	c := Command{UDID: d.UDID, RequestType: "EnableLostMode", Message: opts.Message, PhoneNumber: opts.PhoneNumber, Footnote: opts.Footnote}
+	s.postLostModeCommand(w, r, d, c, store.LostMode{Enabled: true, Message: opts.Message, PhoneNumber: opts.PhoneNumber, Footnote: opts.Footnote})
+}
+
+// handleDisableLostMode sends a device a DisableLostMode command.
+func (s *Server) handleDisableLostMode(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	if err := checkLostMode(d, "DisableLostMode"); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.postLostModeCommand(w, r, d, Command{UDID: d.UDID, RequestType: "DisableLostMode"}, store.LostMode{})
+}
+
+// postLostModeCommand sends c, an EnableLostMode or DisableLostMode command,
+// and saves state, completed with c's CommandUUID, as d's Lost Mode state.
+// Requests are logged with who made them, since a device in Lost Mode can
+// be located.
+func (s *Server) postLostModeCommand(w http.ResponseWriter, r *http.Request, d Device, c Command, state store.LostMode) {
+	uuid, err := s.postCommand(r.Context(), c.UDID, c.RequestType, c)
+	if err != nil {
+		logFor(r.Context()).WithFields(logrus.Fields{"udid": c.UDID, "request_type": c.RequestType}).WithError(err).Error("send command")
+		http.Error(w, fmt.Sprintf("send command: %v", err), http.StatusBadGateway)
+		return
+	}
+	logFor(r.Context()).WithFields(auditFields(r)).WithFields(logrus.Fields{"udid": c.UDID, "request_type": c.RequestType}).Warn("Lost Mode changed")
+	state.CommandUUID, state.RequestedAt = uuid, time.Now().UTC()
+	d.LostMode = &state
+	if err := s.Devices.Save(d); err != nil {
+		logFor(r.Context()).WithError(err).Error("save Lost Mode")
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"command_uuid": uuid,
+		"request_type": c.RequestType,
+		"udid":         c.UDID,
+	})
+}
+
+// handleRequestDeviceLocation sends a DeviceLocation command to a device in Lost
+// Mode, the only state devices report their location in. The location is
+// stored with the device once it answers.
+func (s *Server) handleRequestDeviceLocation(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	if d.LostMode == nil || !d.LostMode.Enabled {
+		http.Error(w, fmt.Sprintf("device %s is not in Lost Mode", d.UDID), http.StatusConflict)
+		return
+	}
+	logFor(r.Context()).WithFields(auditFields(r)).WithField("udid", d.UDID).Warn("DeviceLocation requested")
+	s.postAPICommand(w, r, Command{UDID: d.UDID, RequestType: "DeviceLocation"})
+}
+
+// applyLostMode confirms the Lost Mode state of EnableLostMode and
+// DisableLostMode responses. Commands the admin API did not send, such as
+// those of rules, set the state from their response on. A device that
+// entered Lost Mode is asked for its location.
+func (s *Server) applyLostMode(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	enabled := ack.RequestType == "EnableLostMode"
+	if d.LostMode == nil || d.LostMode.CommandUUID != ack.CommandUUID {
+		d.LostMode = &store.LostMode{Enabled: enabled, CommandUUID: ack.CommandUUID, RequestedAt: ack.Time}
+	}
+	d.LostMode.ConfirmedAt = &ack.Time
+	logFor(ctx).WithField("enabled", enabled).Info("device confirmed Lost Mode")
+	if enabled {
+		s.sendCommandToDevice(ctx, *d, "DeviceLocation")
+	}
+	return true, nil
+}
+
+// applyDeviceLocation stores the location a DeviceLocation response
+// reports.
+func (s *Server) applyDeviceLocation(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	loc, err := webhook.ParseDeviceLocation(ack.Raw)
+	if err != nil {
+		return false, err
+	}
+	loc.UpdatedAt = ack.Time
+	d.Location = &loc
+	logFor(ctx).WithField("horizontal_accuracy", loc.HorizontalAccuracy).Info("device reported its location")
+	return true, nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/mdmclient"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// mdmRequestVars counts requests to MicroMDM's command API by outcome: ok,
+// failed, timeout (MicroMDM did not answer within s.MDMTimeout), or
+// canceled (the command was abandoned).
+var mdmRequestVars = expvar.NewMap("mdm_command_requests")
+
+// mdmClient is shared by all requests to MicroMDM, so their connections are
+// reused. Its transport records client spans and passes on the trace
+// context. serve replaces it with one made by newMDMClient.
+var mdmClient = newPooledClient(http.DefaultTransport.(*http.Transport).Clone(), defaultMDMConns)
+
+// defaultMDMConns is the default limit of connections to each MicroMDM
+// server.
+const defaultMDMConns = 64
+
+// newMDMClient returns the client for requests to MicroMDM, opening at most
+// maxConns connections to each server. Requests go through proxyURL if it is
+// set, and otherwise through the proxy named by HTTPS_PROXY, HTTP_PROXY, and
+// NO_PROXY. The certificates in caFile are trusted in addition to the system
+// roots. insecure turns off verification of MicroMDM's certificate
+// altogether, and is meant only for development.
+func newMDMClient(proxyURL, caFile string, insecure bool, maxConns int) (*http.Client, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL %q", proxyURL)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+	if caFile != "" || insecure {
+		cfg, err := clientTLSConfig(caFile, insecure)
+		if err != nil {
+			return nil, fmt.Errorf("MicroMDM TLS: %v", err)
+		}
+		t.TLSClientConfig = cfg
+	}
+	return newPooledClient(t, maxConns), nil
+}
+
+// newPooledClient tunes t for many concurrent commands to the same server:
+// the standard transport keeps only two idle connections per host, so
+// bursts of commands would otherwise keep opening new ones. HTTP/2 is used
+// whenever the server offers it, even with a custom TLS configuration.
+func newPooledClient(t *http.Transport, maxConns int) *http.Client {
+	t.ForceAttemptHTTP2 = true
+	t.MaxConnsPerHost = maxConns
+	t.MaxIdleConnsPerHost = maxConns
+	t.MaxIdleConns = 4 * maxConns
+	t.IdleConnTimeout = 90 * time.Second
+	return &http.Client{Transport: otelhttp.NewTransport(t)}
+}
+
+// RetryPolicy controls how commands are retried when MicroMDM cannot be
+// reached or fails with a server error.
+type RetryPolicy struct {
+	// MaxAttempts is the number of tries before a command is given up on.
+	MaxAttempts int
+
+	// Backoff is the longest wait before the second try. It doubles for
+	// every further try, up to MaxBackoff; the actual wait is a random
+	// duration up to that.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+var defaultRetryPolicy = RetryPolicy{MaxAttempts: 5, Backoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second}
+
+// delay returns how long to wait after the given failed try, counting from
+// 1, using exponential backoff with full jitter.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff << (attempt - 1)
+	if d > p.MaxBackoff || d <= 0 {
+		d = p.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return rand.N(d)
+}
+
+// permanentError is a failure that retrying would not fix, e.g. MicroMDM
+// rejecting the command.
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// deliverCommand posts body to MicroMDM's /v1/commands, no faster than
+// s.Limiter allows, retrying failures according to s.Retry and giving up
+// early if s.Breaker opens. It returns
+// the CommandUUID assigned by MicroMDM and the number of tries made.
+func (s *Server) deliverCommand(ctx context.Context, body []byte) (uuid string, attempts int, err error) {
+	policy := s.Retry
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	for attempts = 1; ; attempts++ {
+		if s.Limiter != nil {
+			if err := s.Limiter.Wait(ctx); err != nil {
+				return "", attempts - 1, &permanentError{fmt.Errorf("wait for MicroMDM rate limit: %v", err)}
+			}
+		}
+		if err := s.Breaker.admit(ctx); err != nil {
+			return "", attempts - 1, &permanentError{err}
+		}
+		uuid, err = s.postCommandOnce(ctx, body)
+		var perm *permanentError
+		permanent := errors.As(err, &perm)
+		s.Breaker.record(ctx, err != nil && !permanent && ctx.Err() == nil)
+		if err == nil || permanent || attempts >= policy.MaxAttempts {
+			return uuid, attempts, err
+		}
+		wait := policy.delay(attempts)
+		logFor(ctx).WithError(err).WithFields(logrus.Fields{"attempt": attempts, "retry_in": wait.String()}).Warn("send command failed, retrying")
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", attempts)))
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return "", attempts, fmt.Errorf("%v (gave up retrying: %v)", err, ctx.Err())
+		}
+	}
+}
+
+// postCommandOnce makes a single POST of body to MicroMDM's /v1/commands,
+// waiting at most s.MDMTimeout for the response.
+func (s *Server) postCommandOnce(ctx context.Context, body []byte) (uuid string, err error) {
+	parent := ctx
+	if s.MDMTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.MDMTimeout)
+		defer cancel()
+	}
+	defer func() {
+		switch {
+		case err == nil:
+			mdmRequestVars.Add("ok", 1)
+		case parent.Err() != nil:
+			mdmRequestVars.Add("canceled", 1)
+		case ctx.Err() != nil:
+			mdmRequestVars.Add("timeout", 1)
+			timeout := fmt.Errorf("MicroMDM did not respond within %v", s.MDMTimeout)
+			if perm := (*permanentError)(nil); errors.As(err, &perm) {
+				timeout = &permanentError{timeout}
+			}
+			err = timeout
+		default:
+			mdmRequestVars.Add("failed", 1)
+		}
+	}()
+
+	uuid, err = s.mdm().PostCommand(ctx, body)
+	if perm := (*mdmclient.PermanentError)(nil); errors.As(err, &perm) {
+		err = &permanentError{perm.Err}
+	}
+	return uuid, err
+}
+
+// mdm returns the client for s's MicroMDM server.
+func (s *Server) mdm() *mdmclient.Client {
+	return &mdmclient.Client{URL: s.MDMServerURL, APIKey: s.MDMAPIKey, HTTP: mdmClient, RequestID: requestIDFrom}
+}
+
+// DeadLetter is a command that could not be delivered to MicroMDM.
+type DeadLetter struct {
+	Time        time.Time       `json:"time"`
+	Tenant      string          `json:"tenant,omitempty"`
+	UDID        string          `json:"udid"`
+	RequestType string          `json:"request_type"`
+	Attempts    int             `json:"attempts"`
+	Error       string          `json:"error"`
+	Command     json.RawMessage `json:"command"`
+
+	// Redacted lists the secret fields left out of Command, which has to
+	// be given them again to be resent.
+	Redacted []string `json:"redacted,omitempty"`
+
+	// secrets are the fields of Command that carry secrets besides the
+	// commandSecrets.
+	secrets []string
+}
+
+// commandSecrets are the fields of command bodies that carry secrets: the
+// PINs of DeviceLock and EraseDevice commands, the UnlockTokens of
+// ClearPasscode ones, and the FileVault recovery keys RotateFileVaultKey
+// commands are unlocked with. They are escrowed encrypted, so they are left
+// out of dead letters and logs, and the commands are not kept to be retried.
+var commandSecrets = []string{"pin", "unlock_token", "filevault_unlock"}
+
+// payloadSecrets returns the fields of the body of the command payload that
+// carry secrets besides the commandSecrets: the profile of commands with
+// SecretPayload set.
+func payloadSecrets(payload interface{}) []string {
+	if c, ok := payload.(Command); ok && c.SecretPayload {
+		return []string{"payload"}
+	}
+	return nil
+}
+
+// hasSecrets reports whether c carries secrets redactCommand leaves out.
+func (c Command) hasSecrets() bool {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return true
+	}
+	_, secrets := redactCommand(body, payloadSecrets(c)...)
+	return secrets != nil
+}
+
+// redactCommand returns the command body without its commandSecrets and
+// the other fields named in secrets, and the names of those it had. Bodies
+// that are not JSON objects are left out whole.
+func redactCommand(body []byte, secrets ...string) (json.RawMessage, []string) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, []string{"command"}
+	}
+	var redacted []string
+	for _, name := range slices.Concat(commandSecrets, secrets) {
+		if _, ok := fields[name]; ok {
+			delete(fields, name)
+			redacted = append(redacted, name)
+		}
+	}
+	if redacted == nil {
+		return body, nil
+	}
+	b, err := json.Marshal(fields)
+	if err != nil {
+		return nil, []string{"command"}
+	}
+	return b, redacted
+}
+
+// deadLetterLog appends undeliverable commands to a file, one JSON object
+// per line, so they can be inspected and resent.
+type deadLetterLog struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newDeadLetterLog(path string) *deadLetterLog {
+	return &deadLetterLog{path: path}
+}
+
+func (l *deadLetterLog) add(d DeadLetter) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("encode dead letter: %v", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("open dead letter file: %v", err)
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("write dead letter file: %v", err)
+	}
+	return f.Close()
+}
+
+// deadLetter logs a command that could not be delivered and, if
+// s.DeadLetters is set, records it there without its secrets.
+func (s *Server) deadLetter(ctx context.Context, d DeadLetter) {
+	d.Time, d.Tenant = time.Now().UTC(), s.Tenant
+	d.Command, d.Redacted = redactCommand(d.Command, d.secrets...)
+	logFor(ctx).WithFields(logrus.Fields{
+		"udid":         d.UDID,
+		"request_type": d.RequestType,
+		"attempts":     d.Attempts,
+		"error":        d.Error,
+	}).Error("giving up on command")
+	if s.DeadLetters == nil {
+		return
+	}
+	if err := s.DeadLetters.add(d); err != nil {
+		logFor(ctx).WithError(err).Error("record dead letter")
+	}
+}
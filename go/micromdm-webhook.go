@@ -1,82 +1,503 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"expvar"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/apns"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/dep"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
 	"github.com/micromdm/micromdm/mdm"
-	"github.com/micromdm/micromdm/workflow/webhook"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-// Device represents a device
-type Device struct {
-	UDID     string
-	Enrolled bool
-}
+// The device records and the stores keeping them are defined in package
+// store.
+type (
+	Device            = store.Device
+	DeviceInfo        = store.DeviceInfo
+	InstalledApp      = store.InstalledApp
+	SecurityPosture   = store.SecurityPosture
+	InstalledProfile  = store.InstalledProfile
+	DeviceCertificate = store.DeviceCertificate
+	DeviceStore       = store.DeviceStore
+	CommandRecord     = store.CommandRecord
+	CommandHistory    = store.CommandHistory
+)
 
 // Server represents an MDM server
 type Server struct {
 	MDMServerURL string
 	MDMAPIKey    string
-	Devices      map[string]Device
+	Devices      DeviceStore
+	History      CommandHistory
+	Pending      *commandTracker
+
+	// Tenant names the tenant the server handles, or is empty for the
+	// untenanted server.
+	Tenant string
+
+	// Retry controls how commands are retried when MicroMDM fails.
+	// Commands that still fail are logged, and added to DeadLetters if it
+	// is set.
+	Retry       RetryPolicy
+	DeadLetters *deadLetterLog
+
+	// ErrorRetry controls how commands that devices fail with an error
+	// matching TransientErrors are sent again. Other failures, and commands
+	// still failing on their last attempt, are notified.
+	ErrorRetry      RetryPolicy
+	TransientErrors []errorMatcher
+
+	// ResponsiveWindow is how recently a device must have drained its
+	// command queue, answering Idle, to count as responsive.
+	ResponsiveWindow time.Duration
+
+	// NotNowRetry controls the pushes that make devices that answered a
+	// command NotNow, e.g. because they were locked, check in again.
+	NotNowRetry RetryPolicy
+
+	// Breaker stops commands from being sent while MicroMDM keeps failing.
+	Breaker *circuitBreaker
+
+	// MDMTimeout limits how long each request to MicroMDM's command API
+	// may take; zero means no limit.
+	MDMTimeout time.Duration
+
+	// Archives keep the raw JSON of every event received.
+	Archives []archiver
+
+	// Forward, if set, posts events on to downstream webhooks.
+	Forward *forwarder
+
+	// Sinks, if set, publishes events to message systems such as Kafka.
+	Sinks *sinks
+
+	// Notifiers, if set, tells people about device lifecycle events, e.g.
+	// on Slack.
+	Notifiers *notifiers
+
+	// FailureThreshold is how many commands in a row a device may fail
+	// before a repeated-failures notification; zero disables them.
+	// Failures counts them.
+	FailureThreshold int
+	Failures         *failureCounter
+
+	// SkipCommands logs commands instead of sending them, e.g. when
+	// replaying archived events.
+	SkipCommands bool
+
+	// Dedup, if set, skips events delivered again within its window.
+	Dedup *eventDedup
+
+	// Limiter, if set, limits the rate of requests to MicroMDM's command
+	// API, across webhooks, the admin API, and bulk jobs.
+	Limiter *rate.Limiter
+
+	// APNs, if set, pushes devices through APNs directly with the push
+	// tokens they sent in TokenUpdate, rather than through MicroMDM.
+	// APNSTopic is the topic of pushes for tokens sent without one.
+	APNs      *apns.Client
+	APNSTopic string
+
+	// DEP, if set, keeps the devices Apple's device enrollment program
+	// lists in the device store, with placeholder records for those that
+	// have not enrolled, and flags enrollments of devices it does not list.
+	DEP *depSync
+
+	// Queue, if set, sends the commands triggered by webhook events in the
+	// background.
+	Queue *commandQueue
+
+	// ExpectedProfiles are the profile identifiers every device should
+	// have installed. ProfileList responses are checked against them.
+	ExpectedProfiles []string
+
+	// CertExpiryWarning is how far ahead of expiry an identity certificate
+	// reported in a CertificateList response is logged as expiring, and
+	// notifiers are told of it.
+	CertExpiryWarning time.Duration
+
+	// CertRenewal, if set, runs on devices whose identity certificate is
+	// found expiring.
+	CertRenewal *CertRenewal
+
+	// AdminToken authenticates requests to the admin API.
+	AdminToken string
+
+	// Erasures holds the EraseDevice commands requested through the admin
+	// API until they are confirmed.
+	Erasures *eraseRequests
+
+	// Escrow, if set, encrypts the PINs of DeviceLock commands, which are
+	// kept with the devices for the admin API to disclose, the
+	// UnlockTokens of ClearPasscode commands, Activation Lock bypass
+	// codes, and the bootstrap tokens of Macs.
+	Escrow *pinEscrow
+
+	// FileVault, if set with Escrow, decrypts the FileVault recovery keys
+	// Macs escrow, which are then kept encrypted with Escrow and rotated
+	// once disclosed or, if FileVaultKeyMaxAge is set, older than it.
+	FileVault          *fileVaultEscrow
+	FileVaultKeyMaxAge time.Duration
+
+	// ProfileSigner, if set, signs the XML profiles sent to devices, which
+	// are not sent if they cannot be signed.
+	ProfileSigner profileSigner
+
+	// ProfileSecrets, if set, are the values profile templates read with
+	// .Secret, such as Wi-Fi and 802.1X passwords.
+	ProfileSecrets *profileSecrets
+
+	// BootstrapTokenGrace, if set, is how long an enrolled Mac can go
+	// without escrowing a bootstrap token before notifiers are told.
+	BootstrapTokenGrace time.Duration
+
+	// EnterpriseApps, if set, hosts the packages InstallEnterpriseApplication
+	// commands install.
+	EnterpriseApps *appHost
+
+	// Static, if set, hosts the files of a content directory at signed
+	// URLs.
+	Static *staticHost
+
+	// BulkRate caps how many commands per second a bulk job sends. Zero
+	// means no limit.
+	BulkRate float64
+	BulkJobs *bulkJobs
+
+	// Events receives every webhook event, for live subscribers such as
+	// the gRPC event stream.
+	Events *eventHub
+
+	// Topics overrides how individual event topics are handled.
+	Topics map[string]TopicConfig
+
+	// Rules run actions on the events matching their conditions.
+	Rules []*rule
+
+	// Scripts are Starlark handlers called for every event.
+	Scripts []*script
+
+	// Blueprints set up devices on their first TokenUpdate.
+	Blueprints []*Blueprint
+
+	// Decommission, if set, runs on devices that check out.
+	Decommission *Decommission
+
+	// Compliance are the policies devices are evaluated against.
+	Compliance []*CompliancePolicy
+
+	// Hooks, if set, runs the programs of TopicHooks, by topic, and of
+	// rules' hook actions.
+	Hooks      *execHooks
+	TopicHooks map[string][]*execHook
+
+	// WebhookSecret, when set, is the HMAC-SHA256 key incoming webhooks
+	// must be signed with, in the SignatureHeader header.
+	WebhookSecret   []byte
+	SignatureHeader string
+
+	// WebhookUser and WebhookPassword, or WebhookToken, are the basic auth
+	// credentials or bearer token incoming webhooks must present.
+	WebhookUser     string
+	WebhookPassword string
+	WebhookToken    string
+
+	// ClientCertRequired rejects webhooks that did not present a client
+	// certificate verified against -client-ca. ClientCertNames, if set,
+	// limits which certificates are accepted.
+	ClientCertRequired bool
+	ClientCertNames    []string
+
+	// AllowedNets, if set, are the only networks webhooks are accepted
+	// from. TrustedProxies are load balancers whose X-Forwarded-For header
+	// is believed.
+	AllowedNets    []netip.Prefix
+	TrustedProxies []netip.Prefix
+}
+
+// NewServer returns a Server that talks to the MicroMDM server at serverURL
+// and tracks devices in store. If store also implements CommandHistory, the
+// command history is kept there too; otherwise it is kept in memory.
+func NewServer(serverURL, apiKey string, store DeviceStore) *Server {
+	s := &Server{
+		MDMServerURL: strings.TrimRight(serverURL, "/"),
+		MDMAPIKey:    apiKey,
+		Devices:      store,
+		Pending:      newCommandTracker(),
+		BulkJobs:     newBulkJobs(),
+		Erasures:     newEraseRequests(defaultEraseWindow),
+		Events:       newEventHub(),
+		History:      historyFor(store),
+		Retry:        defaultRetryPolicy,
+		ErrorRetry:   defaultErrorRetryPolicy,
+		NotNowRetry:  defaultNotNowRetryPolicy,
+		Failures:     newFailureCounter(),
+	}
+	s.ResponsiveWindow = defaultResponsiveWindow
+	return s
+}
+
+// historyFor returns backend if it also keeps command history, and a new
+// in-memory history otherwise.
+func historyFor(backend DeviceStore) CommandHistory {
+	if h, ok := backend.(CommandHistory); ok {
+		return h
+	}
+	return store.NewMemoryHistory()
 }
 
 // Command represents an MDM command
 type Command struct {
-	UDID        string `json:"udid"`
-	RequestType string `json:"request_type"`
+	UDID        string   `json:"udid"`
+	RequestType string   `json:"request_type"`
+	Queries     []string `json:"queries,omitempty"`
+
+	// UserID, if set, sends the command on the channel of that macOS user
+	// of the device rather than the device's own.
+	UserID string `json:"user_id,omitempty"`
+
+	// Payload is the profile of InstallProfile commands, and Identifier
+	// that of RemoveProfile ones.
+	Payload    []byte `json:"payload,omitempty"`
+	Identifier string `json:"identifier,omitempty"`
+
+	// SecretPayload is set for InstallProfile commands whose profile
+	// carries secrets, such as templates reading .Secret, so that Payload
+	// is treated like the commandSecrets.
+	SecretPayload bool `json:"-"`
+
+	// ManifestURL, ITunesStoreID, or Identifier is the app of
+	// InstallApplication commands, installed with ManagementFlags and,
+	// for App Store apps, Options.
+	ManifestURL     string             `json:"manifest_url,omitempty"`
+	ITunesStoreID   int64              `json:"itunes_store_id,omitempty"`
+	ManagementFlags int                `json:"management_flags,omitempty"`
+	Options         *InstallAppOptions `json:"options,omitempty"`
+
+	// PIN, Message, and PhoneNumber are those of DeviceLock commands,
+	// Message, PhoneNumber, and Footnote those of EnableLostMode ones, and
+	// PIN, PreserveDataPlan, and DisallowProximitySetup those of
+	// EraseDevice ones.
+	PIN                    string `json:"pin,omitempty"`
+	Message                string `json:"message,omitempty"`
+	PhoneNumber            string `json:"phone_number,omitempty"`
+	Footnote               string `json:"footnote,omitempty"`
+	PreserveDataPlan       bool   `json:"preserve_data_plan,omitempty"`
+	DisallowProximitySetup bool   `json:"disallow_proximity_setup,omitempty"`
+
+	// UnlockToken is the token of ClearPasscode commands, which the device
+	// sent when it enrolled.
+	UnlockToken []byte `json:"unlock_token,omitempty"`
+
+	// KeyType, FileVaultUnlock, and ReplyEncryptionCertificate are those of
+	// RotateFileVaultKey commands.
+	KeyType                    string           `json:"key_type,omitempty"`
+	FileVaultUnlock            *FileVaultUnlock `json:"filevault_unlock,omitempty"`
+	ReplyEncryptionCertificate []byte           `json:"reply_encryption_certificate,omitempty"`
+
+	// NotifyUser asks macOS to let the user save their work before a
+	// RestartDevice command restarts the Mac.
+	NotifyUser bool `json:"notify_user,omitempty"`
+
+	// Updates are the updates of ScheduleOSUpdate commands.
+	Updates []OSUpdate `json:"updates,omitempty"`
+
+	// Settings are the items of Settings commands.
+	Settings []Setting `json:"settings,omitempty"`
+
+	// Data is the JSON sync tokens of DeclarativeManagement commands.
+	Data []byte `json:"data,omitempty"`
+}
+
+// InstallAppOptions are the options of InstallApplication commands.
+type InstallAppOptions struct {
+	PurchaseMethod int64 `json:"purchase_method,omitempty"`
 }
 
+// OSUpdate is an update to install with ScheduleOSUpdate.
+type OSUpdate struct {
+	ProductKey    string `json:"product_key"`
+	InstallAction string `json:"install_action"`
+}
+
+// decodeFailures counts the webhook events and command responses that could
+// not be decoded.
+var decodeFailures = expvar.NewInt("webhook_decode_failures")
+
 func (s *Server) handleWebhook(w http.ResponseWriter, r *http.Request) {
-	var event webhook.Event
-	err := json.NewDecoder(r.Body).Decode(&event)
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody+1))
 	if err != nil {
-		logrus.Errorf("decode JSON: %v", err)
+		logFor(r.Context()).WithError(err).Error("read webhook event")
+		http.Error(w, fmt.Sprintf("read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxWebhookBody {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	event, err := webhook.DecodeEvent(body)
+	if err != nil {
+		decodeFailures.Add(1)
+		logFor(r.Context()).WithError(err).Error("decode webhook event")
 		http.Error(w, fmt.Sprintf("decode JSON: %v", err), http.StatusBadRequest)
 		return
 	}
+	summary := summarizeEvent(event)
+	if !store.ValidUDID(summary.UDID) {
+		logFor(r.Context()).WithField("udid", summary.UDID).Warn("rejected webhook event: invalid UDID")
+		http.Error(w, fmt.Sprintf("invalid UDID %q", summary.UDID), http.StatusBadRequest)
+		return
+	}
+	trace.SpanFromContext(r.Context()).SetAttributes(
+		attribute.String("mdm.topic", event.Topic),
+		attribute.String("mdm.udid", summary.UDID),
+		attribute.String("mdm.event_id", event.EventID),
+	)
 
-	switch event.Topic {
-	case mdm.AuthenticateTopic:
-		s.handleAuthenticate(event, w)
-	case mdm.TokenUpdateTopic:
-		s.handleTokenUpdate(event, w)
-	case mdm.ConnectTopic:
-		s.handleConnect(event, w)
-	case mdm.CheckoutTopic:
-		s.handleCheckOut(event, w)
-	default:
-		logrus.Warnf("The event's topic was not mdm.Authenticate, mdm.TokenUpdate, mdm.Connect, or mdm.Checkout. It was %q", event.Topic)
+	fields := logrus.Fields{"topic": event.Topic, "udid": summary.UDID, "event_id": event.EventID}
+	if s.Tenant != "" {
+		fields["tenant"] = s.Tenant
+	}
+	logger := logFor(r.Context()).WithFields(fields)
+	ctx := withLogger(r.Context(), logger)
+	// The bypass codes and tokens the webhook escrows are only passed on to
+	// exec hooks, which get the event as received.
+	redactedBody, redacted, err := webhook.RedactEvent(body, event)
+	if err != nil {
+		logger.WithError(err).Error("redact webhook event")
+		http.Error(w, fmt.Sprintf("redact event: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, a := range s.Archives {
+		if err := a.write(redactedBody); err != nil {
+			logger.WithError(err).Error("archive event")
+		}
+	}
+	if s.Dedup != nil {
+		key := eventKey(event)
+		if s.Dedup.seenBefore(key) {
+			duplicateEvents.Add(1)
+			logger.Info("skipping duplicate event")
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			if rec.status >= 500 {
+				s.Dedup.forget(key)
+			}
+		}()
+		w = rec
+	}
+	defer recoverPanic(ctx, w)
+	s.Events.Publish(summary)
+	if s.Forward != nil {
+		s.Forward.forward(ctx, s.Tenant, event.Topic, summary.UDID, redactedBody)
+	}
+	if s.Sinks != nil {
+		s.Sinks.publish(ctx, newSinkMessage(s.Tenant, redacted, summary))
+	}
+
+	if s.Topics[event.Topic].Ignore {
+		logger.Debug("ignoring event")
+		return
+	}
+	logger.WithField("event", redacted).Debug("received event")
+
+	handlers, ok := topicHandlers[event.Topic]
+	if !ok {
+		logger.Warn("ignoring event with unknown topic")
 	}
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	for _, handle := range handlers {
+		if handle(s, ctx, event, rec); rec.wroteHeader && rec.status >= 400 {
+			break
+		}
+	}
+	s.notifyTopic(ctx, event, summary.UDID)
+	s.runHooks(ctx, event.Topic, event.EventID, summary.UDID, body)
+	s.applyRules(ctx, event, summary.UDID, body)
+	s.checkCompliance(ctx, event, summary.UDID, body)
+	s.runScripts(ctx, event, summary.UDID)
 }
 
 // Authenticate messages are sent when the device is installing a MDM payload.
-func (s *Server) handleAuthenticate(event webhook.Event, w http.ResponseWriter) {
-	logrus.Infof("handleAuthenticate for event %+v", event)
+func (s *Server) handleAuthenticate(ctx context.Context, event webhook.Event, w http.ResponseWriter) {
 	if event.CheckinEvent == nil {
-		logrus.Error("The event has no CheckinEvent")
+		logFor(ctx).Error("The event has no CheckinEvent")
 		http.Error(w, "The event has no CheckinEvent", http.StatusBadRequest)
 		return
 	}
 
-	d, exists := s.Devices[event.CheckinEvent.UDID]
-	d.UDID = event.CheckinEvent.UDID
+	d, exists, err := s.loadDevice(event.CheckinEvent.UDID)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device")
+		http.Error(w, fmt.Sprintf("load device: %v", err), http.StatusInternalServerError)
+		return
+	}
 	d.Enrolled = false
-	s.Devices[event.CheckinEvent.UDID] = d
+	d.LastSeen = eventTime(event)
+	// A device enrolling again is back in service: it is no longer retired,
+	// and its data no longer due to be purged.
+	d.Decommissioned = nil
+	if info, err := checkinInfo(event.CheckinEvent.RawPayload); err == nil {
+		if d.Info == nil {
+			// Blueprints are chosen before the device answers
+			// DeviceInformation.
+			info.UpdatedAt = d.LastSeen
+			d.Info = &info
+		}
+		if p := detectPlatform(&info); p != "" {
+			d.Platform = p
+		}
+	}
+	placeholders := s.adoptPlaceholders(ctx, &d)
+	listed := s.matchDEP(&d)
+	expected := s.matchAssets(ctx, &d)
+	if err := s.Devices.Save(d); err != nil {
+		logFor(ctx).WithError(err).Error("save device")
+		http.Error(w, fmt.Sprintf("save device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, udid := range placeholders {
+		if err := s.Devices.Delete(udid); err != nil {
+			logFor(ctx).WithError(err).WithField("placeholder", udid).Error("delete placeholder")
+		}
+	}
+	if !listed {
+		s.notifyNotListed(ctx, d, event)
+	}
+	if !expected {
+		s.notifyUnexpectedDevice(ctx, d, event)
+	}
 
 	if exists {
-		log.Println("re-enrolling device", d.UDID)
+		logFor(ctx).Info("re-enrolling device")
+		s.notify(ctx, notifyReenrolled, d, event)
 	} else {
-		log.Println("enrolling new device", d.UDID)
+		logFor(ctx).Info("enrolling new device")
+		s.notify(ctx, notifyEnrolled, d, event)
 	}
 }
 
@@ -85,100 +506,1079 @@ func (s *Server) handleAuthenticate(event webhook.Event, w http.ResponseWriter)
 // token update message to the server when it has installed the MDM payload.
 // The server should send push messages to the device only after receiving the
 // first token update message.
-func (s *Server) handleTokenUpdate(event webhook.Event, w http.ResponseWriter) {
-	logrus.Infof("handleTokenUpdate for event %+v", event)
+func (s *Server) handleTokenUpdate(ctx context.Context, event webhook.Event, w http.ResponseWriter) {
 	if event.CheckinEvent == nil {
-		logrus.Error("The event has no CheckinEvent")
+		logFor(ctx).Error("The event has no CheckinEvent")
 		http.Error(w, "The event has no CheckinEvent", http.StatusBadRequest)
 		return
 	}
 
-	d := s.Devices[event.CheckinEvent.UDID]
-	d.UDID = event.CheckinEvent.UDID
+	if uc, ok := userCheckin(event.CheckinEvent.RawPayload); ok {
+		s.handleUserTokenUpdate(ctx, event, w, uc)
+		return
+	}
+
+	d, _, err := s.loadDevice(event.CheckinEvent.UDID)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device")
+		http.Error(w, fmt.Sprintf("load device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	var blueprint *Blueprint
+	if !d.Enrolled {
+		if blueprint = s.blueprintFor(d, awaitingConfiguration(event.CheckinEvent.RawPayload)); blueprint != nil {
+			d.Blueprint = blueprint.Name
+			if blueprint.declarations != nil {
+				d.DeclarativeManagement = &store.DeclarativeManagement{Fallback: !supportsDeclarativeManagement(d)}
+			}
+			if blueprint.Osquery != nil && d.Platform == platformMacOS {
+				d.Osquery = &store.OsqueryEnrollment{EnrolledAt: time.Now().UTC()}
+			}
+		}
+	}
 	d.Enrolled = true
-	s.Devices[event.CheckinEvent.UDID] = d
+	d.LastSeen = eventTime(event)
+	if err := s.escrowUnlockToken(&d, event.CheckinEvent.RawPayload); err != nil {
+		logFor(ctx).WithError(err).Warn("not storing UnlockToken")
+	}
+	if pt := pushToken(event.CheckinEvent.RawPayload, d.LastSeen); pt != nil {
+		d.Push = pt
+	}
+	if err := s.Devices.Save(d); err != nil {
+		logFor(ctx).WithError(err).Error("save device")
+		http.Error(w, fmt.Sprintf("save device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if blueprint != nil {
+		s.applyBlueprint(ctx, d, blueprint)
+	}
 
-	s.sendCommandToDevice(d, "InstalledApplicationList")
+	commands := defaultEnrollCommands
+	if tc, ok := s.Topics[mdm.TokenUpdateTopic]; ok && tc.Commands != nil {
+		commands = tc.Commands
+	}
+	for _, requestType := range commands {
+		if requestType == "DeviceInformation" {
+			s.requestDeviceInformation(ctx, d)
+		} else {
+			s.sendCommandToDevice(ctx, d, requestType)
+		}
+	}
 }
 
 // Connect events occur when a device is responding to a MDM command. They
 // contain the raw responses from the device.
 //
 // https://developer.apple.com/enterprise/documentation/MDM-Protocol-Reference.pdf
-func (s *Server) handleConnect(event webhook.Event, w http.ResponseWriter) {
-	logrus.Infof("handleConnect for event %+v", event)
+func (s *Server) handleConnect(ctx context.Context, event webhook.Event, w http.ResponseWriter) {
 	if event.AcknowledgeEvent == nil {
-		logrus.Error("The event has no AcknowledgeEvent")
+		logFor(ctx).Error("The event has no AcknowledgeEvent")
 		http.Error(w, "The event has no AcknowledgeEvent", http.StatusBadRequest)
 		return
 	}
 
-	xml := string(event.AcknowledgeEvent.RawPayload)
-	if strings.Contains(xml, "InstalledApplicationList") {
-		log.Println(xml)
+	d, exists, err := s.loadDevice(event.AcknowledgeEvent.UDID)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device")
+		http.Error(w, fmt.Sprintf("load device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	d.LastSeen = eventTime(event)
+	save := exists
+
+	ack, err := webhook.DecodeAcknowledgment(event.AcknowledgeEvent.RawPayload)
+	if err != nil {
+		decodeFailures.Add(1)
+		logFor(ctx).Error(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if ack.CommandUUID != "" {
+		ctx = withLogger(ctx, logFor(ctx).WithField("command_uuid", ack.CommandUUID))
+	}
+	if ack.UserID != "" {
+		ctx = withLogger(ctx, logFor(ctx).WithField("user_id", ack.UserID))
+	}
+	ack.Time = eventTime(event)
+	class := s.classifyError(ack)
+	if class != "" {
+		commandErrorVars.Add(class, 1)
+	}
+	// Failures that will be retried are not notified.
+	pending, retrying := s.resolvePending(ctx, &ack, class)
+	if ack.Status != "Idle" {
+		s.recordCommand(ctx, CommandRecord{
+			UDID:        ack.UDID,
+			CommandUUID: ack.CommandUUID,
+			RequestType: ack.RequestType,
+			UserID:      ack.UserID,
+			Status:      ack.Status,
+			ErrorChain:  ack.ErrorChain,
+			ErrorClass:  class,
+			Retrying:    retrying,
+			Time:        ack.Time,
+		})
+	}
+	if !retrying {
+		s.notifyCommandResult(ctx, d, event, ack, class, pending.Attempt)
+	}
+	var changed bool
+	if ack.UserID != "" {
+		// Responses on a user's channel describe the user, not the device.
+		changed, err = s.applyUserAcknowledgment(ctx, &d, ack)
+	} else {
+		if ack.Status == "Idle" {
+			s.recordIdle(ctx, &d, ack.Time)
+		}
+		changed, err = s.dispatchAcknowledgment(ctx, &d, ack)
+	}
+	if err != nil {
+		decodeFailures.Add(1)
+		logFor(ctx).WithError(err).WithField("request_type", ack.RequestType).Error("handle command response")
+		http.Error(w, fmt.Sprintf("handle %s response: %v", ack.RequestType, err), http.StatusBadRequest)
+		return
+	}
+	if changed {
+		save = true
+	}
+
+	if save {
+		if err := s.Devices.Save(d); err != nil {
+			logFor(ctx).WithError(err).Error("save device")
+			http.Error(w, fmt.Sprintf("save device: %v", err), http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
 // In iOS 5.0 and later, and in macOS v10.9, if the CheckOutWhenRemoved key in
 // the MDM payload is set to true, the device attempts to send a CheckOut
 // message when the MDM profile is removed.
-func (s *Server) handleCheckOut(event webhook.Event, w http.ResponseWriter) {
-	logrus.Infof("handeCheckOUt for event %+v", event)
+func (s *Server) handleCheckOut(ctx context.Context, event webhook.Event, w http.ResponseWriter) {
 	if event.CheckinEvent == nil {
-		logrus.Error("The event has no CheckinEvent")
+		logFor(ctx).Error("The event has no CheckinEvent")
 		http.Error(w, "The event has no CheckinEvent", http.StatusBadRequest)
 		return
 	}
 
-	d := s.Devices[event.CheckinEvent.UDID]
-	d.UDID = event.CheckinEvent.UDID
+	d, _, err := s.loadDevice(event.CheckinEvent.UDID)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device")
+		http.Error(w, fmt.Sprintf("load device: %v", err), http.StatusInternalServerError)
+		return
+	}
 	d.Enrolled = false
-	s.Devices[event.CheckinEvent.UDID] = d
+	d.LastSeen = eventTime(event)
+	if s.Decommission != nil {
+		s.decommission(ctx, &d, d.LastSeen)
+	}
+	if err := s.Devices.Save(d); err != nil {
+		logFor(ctx).WithError(err).Error("save device")
+		http.Error(w, fmt.Sprintf("save device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	s.notify(ctx, notifyCheckedOut, d, event)
+	if s.Decommission != nil {
+		s.notifyDecommission(ctx, d, event)
+	}
+}
+
+// loadDevice returns the stored device with the given UDID, or a new Device if
+// the UDID has not been seen before.
+func (s *Server) loadDevice(udid string) (Device, bool, error) {
+	d, err := s.Devices.Get(udid)
+	switch err {
+	case nil:
+		return d, true, nil
+	case store.ErrDeviceNotFound:
+		return Device{UDID: udid}, false, nil
+	default:
+		return Device{}, false, err
+	}
+}
+
+// eventTime returns when MicroMDM created the event, falling back to the
+// current time for events without a timestamp.
+func eventTime(event webhook.Event) time.Time {
+	if event.CreatedAt.IsZero() {
+		return time.Now().UTC()
+	}
+	return event.CreatedAt
 }
 
-func (s *Server) sendCommandToDevice(d Device, requestType string) {
-	c := Command{
+func (s *Server) sendCommandToDevice(ctx context.Context, d Device, requestType string) {
+	if skipUnsupported(ctx, d, requestType) {
+		return
+	}
+	s.sendCommand(ctx, Command{
 		UDID:        d.UDID,
 		RequestType: requestType,
+	})
+}
+
+// requestDeviceInformation asks the device for webhook.DeviceInformationQueries.
+func (s *Server) requestDeviceInformation(ctx context.Context, d Device) {
+	s.sendCommand(ctx, Command{
+		UDID:        d.UDID,
+		RequestType: "DeviceInformation",
+		Queries:     webhook.DeviceInformationQueries,
+	})
+}
+
+// recordCommand adds r to the command history, logging any error.
+func (s *Server) recordCommand(ctx context.Context, r CommandRecord) {
+	if err := s.History.RecordCommand(r); err != nil {
+		logFor(ctx).WithError(err).Error("record command history")
 	}
-	b := new(bytes.Buffer)
-	json.NewEncoder(b).Encode(c)
+}
+
+// sendCommand queues c in MicroMDM, logging any error, which it also
+// returns for callers that record the command as sent. With s.Queue set, c
+// is only added to that queue, to be sent in the background, and the error
+// is that of adding it. The durable queue stores commands unencrypted, so
+// those carrying secrets are sent at once rather than added to it.
+func (s *Server) sendCommand(ctx context.Context, c Command) error {
+	if s.Queue == nil || s.Queue.durable != nil && c.hasSecrets() {
+		return s.sendCommandNow(ctx, c)
+	}
+	if err := s.Queue.enqueue(ctx, s, c); err != nil {
+		body, _ := json.Marshal(c)
+		s.deadLetter(ctx, DeadLetter{UDID: c.UDID, RequestType: c.RequestType, Error: err.Error(), Command: body, secrets: payloadSecrets(c)})
+		return err
+	}
+	return nil
+}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("POST", s.MDMServerURL+"/v1/commands", b)
-	req.SetBasicAuth("micromdm", s.MDMAPIKey)
-	_, err = client.Do(req)
+// sendCommandNow queues c in MicroMDM, logging and returning any error.
+func (s *Server) sendCommandNow(ctx context.Context, c Command) error {
+	_, err := s.postCommandTo(ctx, c.UDID, c.UserID, c.RequestType, c)
 	if err != nil {
-		logrus.Errorf("send command to device: %v", err)
-		log.Fatal(err)
+		logFor(ctx).WithFields(logrus.Fields{"udid": c.UDID, "user_id": c.UserID, "request_type": c.RequestType}).WithError(err).Error("send command")
 	}
+	return err
 }
 
-func main() {
+// postCommand queues a command in MicroMDM and tracks it until the device
+// answers. payload is the JSON body for /v1/commands and must carry the same
+// udid and request_type. It returns the CommandUUID assigned by MicroMDM.
+func (s *Server) postCommand(ctx context.Context, udid, requestType string, payload interface{}) (string, error) {
+	return s.postCommandTo(ctx, udid, "", requestType, payload)
+}
+
+// postCommandTo is postCommand for commands sent on the channel of the
+// device's macOS user with the given UserID, or the device's own if userID
+// is empty.
+func (s *Server) postCommandTo(ctx context.Context, udid, userID, requestType string, payload interface{}) (uuid string, err error) {
+	ctx, span := tracer.Start(ctx, "send command", trace.WithAttributes(mdmAttributes(udid, requestType)...))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(attribute.String("mdm.command_uuid", uuid))
+		}
+		span.End()
+	}()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("encode command: %v", err)
+	}
+	if userID != "" {
+		if body, err = userChannelBody(body, userID); err != nil {
+			return "", err
+		}
+	}
+	secrets := payloadSecrets(payload)
+	if s.SkipCommands {
+		redacted, _ := redactCommand(body, secrets...)
+		logFor(ctx).WithFields(logrus.Fields{"udid": udid, "user_id": userID, "request_type": requestType, "command": redacted}).Info("not sending command")
+		return "", nil
+	}
+	uuid, attempts, err := s.deliverCommand(ctx, body)
+	span.SetAttributes(attribute.Int("mdm.attempts", attempts))
+	if err != nil {
+		s.deadLetter(ctx, DeadLetter{UDID: udid, RequestType: requestType, Attempts: attempts, Error: err.Error(), Command: body, secrets: secrets})
+		return "", err
+	}
+	now := time.Now().UTC()
+	pending := PendingCommand{
+		UUID:        uuid,
+		UDID:        udid,
+		RequestType: requestType,
+		SentAt:      now,
+		UserID:      userID,
+		Attempt:     1,
+	}
+	// Commands carrying secrets are not kept to be retried, so the
+	// secrets stay only in the encrypted escrow or the profile secrets
+	// file.
+	if _, redacted := redactCommand(body, secrets...); s.ErrorRetry.MaxAttempts > 1 && len(s.TransientErrors) > 0 && redacted == nil {
+		pending.command = body
+	}
+	s.Pending.Add(pending)
+	logFor(ctx).WithFields(logrus.Fields{"udid": udid, "user_id": userID, "request_type": requestType, "command_uuid": uuid}).Info("queued command")
+
+	s.recordCommand(ctx, CommandRecord{
+		UDID:        udid,
+		CommandUUID: uuid,
+		RequestType: requestType,
+		UserID:      userID,
+		Status:      store.StatusSent,
+		Time:        now,
+	})
+	return uuid, nil
+}
+
+// storeOptions holds the command line configuration for the DeviceStore.
+type storeOptions struct {
+	Kind   string
+	DBPath string
+	Redis  store.RedisOptions
+	Dynamo store.DynamoDBOptions
+}
+
+// storeFlags adds the flags selecting the device store to fs and returns a
+// function that builds the options once fs is parsed.
+func storeFlags(fs *flag.FlagSet) func() storeOptions {
 	var (
-		flPort      = flag.Int("port", 80, "port for the webhook server to listen on")
-		flServerURL = flag.String("server-url", "", "public HTTPS url of your MicroMDM server")
-		flAPIKey    = flag.String("api-token", "", "API Token for your MicroMDM server")
+		flDBPath    = fs.String("db-path", "", "path to a database file for persisting devices (default in-memory)")
+		flStore     = fs.String("store", "", "device store backend: memory, bolt, sqlite, redis, or dynamodb (default bolt with -db-path, otherwise memory)")
+		flRedisAddr = fs.String("redis-addr", "localhost:6379", "address of the Redis server for -store=redis")
+		flRedisPass = fs.String("redis-password", "", "password for the Redis server")
+		flRedisDB   = fs.Int("redis-db", 0, "Redis database number")
+		flRedisTTL  = fs.Duration("redis-ttl", 0, "expire device keys in Redis after this long without an update (0 disables expiry)")
+		flDynTable  = fs.String("dynamodb-table", "micromdm-webhook-devices", "DynamoDB table for -store=dynamodb")
+		flDynCreate = fs.Bool("dynamodb-create-table", false, "create the DynamoDB table with on-demand capacity if it does not exist")
+		flDynURL    = fs.String("dynamodb-endpoint", "", "override the DynamoDB endpoint, e.g. for DynamoDB Local")
 	)
-	flag.Parse()
+	return func() storeOptions {
+		return storeOptions{
+			Kind:   *flStore,
+			DBPath: *flDBPath,
+			Redis: store.RedisOptions{
+				Addr:     *flRedisAddr,
+				Password: *flRedisPass,
+				DB:       *flRedisDB,
+				TTL:      *flRedisTTL,
+			},
+			Dynamo: store.DynamoDBOptions{
+				Table:       *flDynTable,
+				Endpoint:    *flDynURL,
+				CreateTable: *flDynCreate,
+			},
+		}
+	}
+}
+
+// openStore returns the DeviceStore selected on the command line. When no
+// backend is named, a BoltDB store is used if a database path is given and
+// devices are kept in memory otherwise.
+func openStore(opts storeOptions) (DeviceStore, error) {
+	kind := opts.Kind
+	if kind == "" {
+		kind = "memory"
+		if opts.DBPath != "" {
+			kind = "bolt"
+		}
+	}
+	switch kind {
+	case "memory":
+		return store.NewMemoryStore(), nil
+	case "bolt", "sqlite":
+		if opts.DBPath == "" {
+			return nil, fmt.Errorf("the %s store requires -db-path", kind)
+		}
+		if kind == "bolt" {
+			return store.NewBoltStore(opts.DBPath)
+		}
+		return store.NewSQLiteStore(opts.DBPath)
+	case "redis":
+		return store.NewRedisStore(opts.Redis)
+	case "dynamodb":
+		return store.NewDynamoDBStore(opts.Dynamo)
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
+
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		serve(args)
+		return
+	}
 
-	if *flServerURL == "" || *flAPIKey == "" {
-		flag.PrintDefaults()
+	var err error
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "serve":
+		serve(args)
+	case "devices":
+		err = runDevices(args)
+	case "command":
+		err = runCommand(args)
+	case "static":
+		err = runStatic(args)
+	case "events":
+		err = runEvents(args)
+	case "replay":
+		err = runReplay(args)
+	case "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+}
 
-	s := &Server{
-		MDMServerURL: strings.TrimRight(*flServerURL, "/"),
-		MDMAPIKey:    *flAPIKey,
-		Devices:      make(map[string]Device),
+// serve runs the webhook server. It is the default subcommand.
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	storeOpts := storeFlags(fs)
+	kafkaOpts := kafkaFlags(fs)
+	natsOpts := natsFlags(fs)
+	awsSinkOpts := awsSinkFlags(fs)
+	pubSubOpts := pubSubFlags(fs)
+	amqpOpts := amqpFlags(fs)
+	slackOpts := slackFlags(fs)
+	teamsOpts := teamsFlags(fs)
+	emailOpts := emailFlags(fs)
+	pagerDutyOpts := pagerDutyFlags(fs)
+	var (
+		flPort      = fs.Int("port", 80, "port for the webhook server to listen on")
+		flServerURL = fs.String("server-url", "", "public HTTPS url of your MicroMDM server")
+		flAPIKey    = fs.String("api-token", "", "API Token for your MicroMDM server")
+		flSnapPath  = fs.String("snapshot-path", "", "periodically snapshot the in-memory device store to this JSON file and restore it on startup")
+		flSnapEvery = fs.Duration("snapshot-interval", time.Minute, "how often to write the -snapshot-path file")
+		flProfiles  = fs.String("expected-profiles", "", "comma-separated profile identifiers every device should have installed")
+		flCmdExpiry = fs.Duration("command-timeout", 24*time.Hour, "log and stop tracking commands the device has not answered after this long")
+		flIdleWin   = fs.Duration("responsive-window", defaultResponsiveWindow, "count devices as responsive if they drained their command queue, answering Idle, within this long")
+		flAdminTok  = fs.String("admin-token", "", "bearer token required for the /api/ and /graphql admin endpoints (both are disabled when empty)")
+		flCertWarn  = fs.Duration("cert-expiry-warning", 30*24*time.Hour, "warn and notify about device identity certificates expiring within this window (0 disables the notifications)")
+		flEscrowKey = fs.String("escrow-key", "", "file holding the base64-encoded 32-byte key the PINs of DeviceLock commands, the UnlockTokens of iOS devices, Activation Lock bypass codes, FileVault recovery keys, and bootstrap tokens are encrypted with, e.g. from openssl rand -base64 32 (Macs cannot be locked and passcodes cannot be cleared when empty)")
+		flFVCert    = fs.String("filevault-cert", "", "PEM certificate of the FDERecoveryKeyEscrow payload Macs encrypt their FileVault recovery keys to (with -filevault-key and -escrow-key; recovery keys are not escrowed when empty)")
+		flFVKey     = fs.String("filevault-key", "", "PEM private key of -filevault-cert")
+		flFVMaxAge  = fs.Duration("filevault-key-max-age", 0, "rotate escrowed FileVault recovery keys older than this (0 only rotates keys once disclosed)")
+		flSignCert  = fs.String("profile-signing-cert", "", "PEM certificate, followed by its intermediates, to sign the XML profiles sent to devices with (with -profile-signing-key; profiles are sent unsigned when empty)")
+		flSignKey   = fs.String("profile-signing-key", "", "PEM RSA private key of -profile-signing-cert")
+		flSignURL   = fs.String("profile-signing-url", "", "URL of a service that signs the XML profiles sent to devices, which are posted to it and answered signed, instead of -profile-signing-cert")
+		flSignToken = fs.String("profile-signing-token", "", "bearer token of -profile-signing-url requests")
+		flSecrets   = fs.String("profile-secrets", "", "YAML file of the secrets profile templates read with {{.Secret \"name\"}}, each a string or a mapping of serial numbers or UDIDs to strings, reloaded when it changes")
+		flAPNSCert  = fs.String("apns-cert", "", "PEM MDM push certificate to push devices through APNs directly with, rather than through MicroMDM (with -apns-key)")
+		flAPNSKey   = fs.String("apns-key", "", "PEM private key of -apns-cert")
+		flAPNSAuth  = fs.String("apns-auth-key", "", ".p8 APNs authentication key to push devices through APNs directly with token authentication (with -apns-key-id and -apns-team-id)")
+		flAPNSKeyID = fs.String("apns-key-id", "", "key ID of -apns-auth-key")
+		flAPNSTeam  = fs.String("apns-team-id", "", "ID of the team -apns-auth-key belongs to")
+		flAPNSTopic = fs.String("apns-topic", "", "topic of direct pushes for push tokens sent without one, e.g. com.apple.mgmt.External.<uuid>")
+		flAPNSDev   = fs.Bool("apns-sandbox", false, "push through the APNs development environment")
+		flDEPSync   = fs.Duration("dep-sync-interval", 0, "how often to sync the devices DEP assigned to the MicroMDM server, creating records for them ahead of enrollment and flagging enrollments of devices it does not list (0 disables it)")
+		flDEPToken  = fs.String("dep-token", "", "JSON DEP OAuth token file, as from mdmctl get dep-tokens -export-token (the token of the MicroMDM server when empty)")
+		flDEPURL    = fs.String("dep-url", dep.DefaultURL, "base URL of Apple's DEP API")
+		flBootGrace = fs.Duration("bootstrap-token-grace", defaultBootstrapTokenGrace, "notify about enrolled Macs that have not escrowed a bootstrap token after this long (0 disables it)")
+		flOSUpdates = fs.Duration("os-update-interval", 15*time.Minute, "how often to ask devices with scheduled OS updates for their progress, and to send again those past their deadline (0 disables it)")
+		flInventory = fs.String("inventory-schedule", "", "cron spec, e.g. \"0 3 * * *\" or \"@every 12h\", of when to send -inventory-commands to the enrolled devices, in the server's time zone (disabled when empty)")
+		flInventCmd = fs.String("inventory-commands", defaultInventoryCommands, "comma-separated request types sent to the enrolled devices on -inventory-schedule")
+		flAppChecks = fs.Duration("app-install-interval", 15*time.Minute, "how often to send ManagedApplicationList to devices with App Store installs not yet confirmed (0 disables it)")
+		flAppDir    = fs.String("app-dir", "", "directory of .pkg and .ipa files to host for InstallEnterpriseApplication commands, with optional manifest plists of the same base names (disabled when empty; requires -app-base-url)")
+		flAppURL    = fs.String("app-base-url", "", "public URL of this server that devices download the apps of -app-dir and the files of -static-dir from, e.g. https://webhook.example.com")
+		flAppSecret = fs.String("app-url-secret", "", "key the URLs of -app-dir apps and -static-dir files are signed with (random when empty, so URLs stop working on restart)")
+		flAppTTL    = fs.Duration("app-url-ttl", defaultAppURLTTL, "how long the signed URLs of -app-dir apps stay valid")
+		flStaticDir = fs.String("static-dir", "", "directory of files, such as profiles, packages, and images, to serve under /static/ at signed URLs that expire (disabled when empty; requires -app-base-url)")
+		flStaticTTL = fs.Duration("static-url-ttl", defaultStaticURLTTL, "how long the signed URLs of -static-dir files stay valid, unless the admin API asks for another lifetime")
+		flEraseWin  = fs.Duration("erase-confirm-window", defaultEraseWindow, "how long an EraseDevice command requested through the admin API can be confirmed for")
+		flGRPCPort  = fs.Int("grpc-port", 0, "port for the gRPC admin API (disabled when 0; requires -admin-token)")
+		flHMACKey   = fs.String("webhook-secret", "", "require webhooks to carry an HMAC-SHA256 signature of the body made with this secret")
+		flHMACHdr   = fs.String("webhook-signature-header", defaultSignatureHeader, "header holding the webhook signature, as sha256=<hex>")
+		flHookUser  = fs.String("webhook-user", "", "basic auth user name incoming webhooks must present (with -webhook-password)")
+		flHookPass  = fs.String("webhook-password", "", "basic auth password incoming webhooks must present")
+		flHookToken = fs.String("webhook-token", "", "bearer token incoming webhooks must present")
+		flTLSCert   = fs.String("tls-cert", "", "PEM certificate file; serve HTTPS instead of HTTP (with -tls-key)")
+		flTLSKey    = fs.String("tls-key", "", "PEM private key file for -tls-cert")
+		flRedirect  = fs.Int("http-redirect-port", 0, "with -tls-cert, also listen for plain HTTP on this port and redirect it to HTTPS (disabled when 0)")
+		flClientCA  = fs.String("client-ca", "", "PEM CA bundle; require webhooks to present a client certificate it issued (requires -tls-cert)")
+		flClientCN  = fs.String("client-cert-names", "", "comma-separated common or DNS names of the client certificates accepted with -client-ca")
+		flAllowed   = fs.String("allowed-cidrs", "", "comma-separated networks webhooks are accepted from (all when empty)")
+		flProxies   = fs.String("trusted-proxies", "", "comma-separated networks of load balancers whose X-Forwarded-For header is trusted")
+		flShutdown  = fs.Duration("shutdown-timeout", 30*time.Second, "on SIGINT or SIGTERM, how long to wait for in-flight requests and bulk command jobs before exiting")
+		flOTLP      = fs.String("otlp-endpoint", "", "OTLP/HTTP endpoint to export traces to, e.g. http://otel-collector:4318 (the OTEL_EXPORTER_OTLP_* variables also work)")
+		flDebugAddr = fs.String("debug-addr", "", "address to serve pprof and expvar on, e.g. localhost:6060 (disabled when empty; unauthenticated)")
+		flLogLevel  = fs.String("log-level", "info", "minimum level of messages to log: debug, info, warn, or error")
+		flLogFormat = fs.String("log-format", "text", "log format: text or json")
+		flAttempts  = fs.Int("command-attempts", defaultRetryPolicy.MaxAttempts, "how many times to try sending a command to MicroMDM before giving up on it")
+		flBackoff   = fs.Duration("command-backoff", defaultRetryPolicy.Backoff, "wait before the first retry of a failed command; doubled for each further retry, with jitter")
+		flErrTries  = fs.Int("command-error-attempts", defaultErrorRetryPolicy.MaxAttempts, "how many times to send a command that devices fail with a -transient-errors error before notifying the failure (1 disables retries)")
+		flErrWait   = fs.Duration("command-error-backoff", defaultErrorRetryPolicy.Backoff, "wait before sending a command that failed transiently again; doubled for each further attempt, with jitter")
+		flNotNowN   = fs.Int("notnow-pushes", defaultNotNowRetryPolicy.MaxAttempts, "how many times to push a device that answers a command NotNow, e.g. because it is locked or busy, so it checks in again (0 disables it)")
+		flNotNowWt  = fs.Duration("notnow-backoff", defaultNotNowRetryPolicy.Backoff, "wait before pushing a device that deferred a command; doubled for each further NotNow, with jitter, up to 1h")
+		flTransient = fs.String("transient-errors", defaultTransientErrors, "comma-separated ErrorChain domains, or domain:code pairs, of command failures worth retrying; other failures are permanent and notified right away")
+		flBreakerN  = fs.Int("breaker-failures", defaultBreakerPolicy.Failures, "open the circuit breaker around MicroMDM after this many failed commands in a row (0 disables it)")
+		flBreakerCD = fs.Duration("breaker-cooldown", defaultBreakerPolicy.Cooldown, "how long the circuit breaker stays open before a command is let through to test MicroMDM")
+		flBreakerOn = fs.String("breaker-policy", "drop", "what to do with commands while the circuit breaker is open: drop (dead-letter them) or wait")
+		flWorkers   = fs.Int("command-workers", 4, "number of workers sending the commands triggered by webhook events in the background (0 sends them before answering the event)")
+		flQueueSize = fs.Int("command-queue-size", 1000, "number of commands that can wait for a worker; further ones are given up on")
+		flQueueDir  = fs.String("command-queue-dir", "", "keep queued commands in an embedded JetStream stream in this directory, so those not sent yet are sent after a restart")
+		flArchive   = fs.String("archive-dir", "", "append the raw JSON of every webhook event to NDJSON files in this directory")
+		flArchSize  = fs.Int64("archive-max-size", 100, "start a new archive file once the current one reaches this many megabytes (0 for no limit)")
+		flArchAge   = fs.Duration("archive-max-age", 24*time.Hour, "start a new archive file once the current one is this old (0 for no limit)")
+		flArchGzip  = fs.Bool("archive-compress", true, "gzip archive files once they are finished")
+		flS3Bucket  = fs.String("archive-s3-bucket", "", "also write the raw JSON of every webhook event to this S3 bucket, in batches")
+		flS3Prefix  = fs.String("archive-s3-prefix", "events/", "prefix of the keys of the objects written to archive-s3-bucket")
+		flS3URL     = fs.String("archive-s3-endpoint", "", "override the S3 endpoint, e.g. for MinIO or another S3-compatible store")
+		flS3Batch   = fs.Int("archive-s3-batch-size", 1000, "number of events written to each S3 object")
+		flS3Flush   = fs.Duration("archive-s3-flush-interval", time.Minute, "longest time events are held before being written to S3")
+		flExecN     = fs.Int("exec-concurrency", 4, "how many exec hooks may run at once; others wait in a queue")
+		flExecTO    = fs.Duration("exec-timeout", 30*time.Second, "how long an exec hook may run before it is killed")
+		flScripts   = fs.String("script-dir", "", "directory of Starlark scripts (*.star) whose handle(event, device) function is called for every event")
+		flScriptTO  = fs.Duration("script-timeout", 5*time.Second, "how long each call of a script may run")
+		flDedup     = fs.Duration("dedup-window", 10*time.Minute, "skip webhook events with the same ID as one received within this window (0 disables it)")
+		flMDMProxy  = fs.String("mdm-proxy", "", "HTTP(S) proxy URL for requests to MicroMDM (default from HTTPS_PROXY, HTTP_PROXY, and NO_PROXY)")
+		flMDMCA     = fs.String("mdm-ca", "", "PEM CA bundle to trust, in addition to the system roots, for MicroMDM's certificate")
+		flMDMNoTLS  = fs.Bool("mdm-insecure-skip-verify", false, "do not verify MicroMDM's certificate (for development only)")
+		flMDMConns  = fs.Int("mdm-max-conns", defaultMDMConns, "maximum number of connections to each MicroMDM server")
+		flMDMTime   = fs.Duration("mdm-timeout", 15*time.Second, "how long to wait for MicroMDM to answer each request to send a command before retrying it (0 for no limit)")
+		flMDMRate   = fs.Float64("mdm-rate", 0, "maximum number of commands per second sent to MicroMDM (0 for no limit)")
+		flMDMBurst  = fs.Int("mdm-burst", 10, "number of commands that may be sent to MicroMDM at once before mdm-rate applies")
+		flDeadPath  = fs.String("dead-letter-path", "", "append commands that could not be sent to this file as JSON lines")
+		flBulkRate  = fs.Float64("bulk-rate", 10, "maximum commands per second sent by bulk command jobs (0 for no limit)")
+		flSentryDSN = fs.String("sentry-dsn", "", "Sentry DSN to report errors and handler panics to (disabled when empty)")
+		flSentryEnv = fs.String("sentry-environment", "", "environment reported to Sentry, e.g. production")
+		flFailures  = fs.Int("notify-failure-threshold", 3, "send a repeated-failures notification once a device fails this many commands in a row (0 disables it)")
+	)
+	fs.String("config", "", "YAML or TOML file of settings; flags given on the command line take precedence")
+	fc, err := loadConfigFile(fs, args)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err := applyEnv(fs); err != nil {
+		logrus.Fatal(err)
+	}
+	fs.Parse(args)
+	if err := setupLogging(*flLogLevel, *flLogFormat); err != nil {
+		logrus.Fatal(err)
+	}
+	var flushSentry closerFunc
+	if *flSentryDSN != "" {
+		if flushSentry, err = setupSentry(*flSentryDSN, *flSentryEnv); err != nil {
+			logrus.Fatal(err)
+		}
 	}
 
-	log.Println("webhook server listening on port", *flPort)
-	http.HandleFunc("/webhook", s.handleWebhook)
+	// With tenants configured, the untenanted server is optional.
+	untenanted := len(fc.Tenants) == 0 || *flServerURL != "" || *flAPIKey != ""
+	if untenanted {
+		if err := requireSetting(*flServerURL, "server-url", "MicroMDM server URL"); err != nil {
+			logrus.Fatal(err)
+		}
+		if err := requireSetting(*flAPIKey, "api-token", "MicroMDM API token"); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if *flBreakerOn != "drop" && *flBreakerOn != "wait" {
+		logrus.Fatalf("invalid -breaker-policy %q: want drop or wait", *flBreakerOn)
+	}
+	if *flHookUser != "" && *flHookPass == "" {
+		logrus.Fatal("-webhook-user requires -webhook-password")
+	}
+	if (*flTLSCert == "") != (*flTLSKey == "") {
+		logrus.Fatal("-tls-cert and -tls-key must be set together")
+	}
+	if *flClientCA != "" && *flTLSCert == "" {
+		logrus.Fatal("-client-ca requires -tls-cert and -tls-key")
+	}
+	var tlsConfig *tls.Config
+	if *flTLSCert != "" {
+		if tlsConfig, err = serverTLSConfig(*flTLSCert, *flTLSKey, *flClientCA); err != nil {
+			logrus.Fatal(err)
+		}
+	} else if *flRedirect != 0 {
+		logrus.Fatal("-http-redirect-port requires -tls-cert and -tls-key")
+	}
+	allowedNets, err := parseCIDRs(*flAllowed)
+	if err != nil {
+		logrus.Fatalf("-allowed-cidrs: %v", err)
+	}
+	trustedProxies, err := parseCIDRs(*flProxies)
+	if err != nil {
+		logrus.Fatalf("-trusted-proxies: %v", err)
+	}
+
+	backend, err := openStore(storeOpts())
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	l := &listeners{}
+	stopTracing, err := setupTracing(context.Background(), *flOTLP)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	l.closers = append(l.closers, closerFunc(stopTracing))
+	if c, ok := backend.(io.Closer); ok {
+		l.closers = append(l.closers, c)
+	}
+
+	if *flSnapPath != "" {
+		if _, ok := backend.(*store.MemoryStore); !ok {
+			logrus.Fatal("-snapshot-path can only be used with the memory store")
+		}
+		if err := restoreSnapshot(backend, *flSnapPath); err != nil {
+			logrus.Fatal(err)
+		}
+		l.goLoop(func(ctx context.Context) { snapshotLoop(ctx, backend, *flSnapPath, *flSnapEvery) })
+		l.snapshot = func() error { return writeSnapshot(backend, *flSnapPath) }
+	}
+
+	history := historyFor(backend)
+	devices := backend
+	if len(fc.Tenants) > 0 {
+		devices = store.NewTenantStore("", backend, history)
+	}
+	s := NewServer(*flServerURL, *flAPIKey, devices)
+	s.CertExpiryWarning = *flCertWarn
+	s.ResponsiveWindow = *flIdleWin
+	s.AdminToken = *flAdminTok
+	s.Erasures = newEraseRequests(*flEraseWin)
+	if *flEscrowKey != "" {
+		if s.Escrow, err = loadPINEscrow(*flEscrowKey); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if *flFVCert != "" || *flFVKey != "" {
+		if s.FileVault, err = loadFileVaultEscrow(*flFVCert, *flFVKey); err != nil {
+			logrus.Fatal(err)
+		}
+		if s.Escrow == nil {
+			logrus.Fatal("-filevault-cert requires -escrow-key, which escrowed recovery keys are encrypted with")
+		}
+	}
+	s.FileVaultKeyMaxAge = *flFVMaxAge
+	switch {
+	case *flSignURL != "" && (*flSignCert != "" || *flSignKey != ""):
+		logrus.Fatal("-profile-signing-url and -profile-signing-cert are mutually exclusive")
+	case *flSignURL != "":
+		s.ProfileSigner = newEndpointSigner(*flSignURL, *flSignToken)
+	case *flSignCert != "" || *flSignKey != "":
+		if s.ProfileSigner, err = loadCertSigner(*flSignCert, *flSignKey); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if *flSecrets != "" {
+		if s.ProfileSecrets, err = loadProfileSecrets(*flSecrets); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	s.BootstrapTokenGrace = *flBootGrace
+	apnsURL := apns.ProductionURL
+	if *flAPNSDev {
+		apnsURL = apns.DevelopmentURL
+	}
+	switch {
+	case *flAPNSCert != "" && *flAPNSAuth != "":
+		logrus.Fatal("set either -apns-cert or -apns-auth-key, not both")
+	case *flAPNSCert != "":
+		if s.APNs, err = apns.NewCertClient(apnsURL, *flAPNSCert, *flAPNSKey); err != nil {
+			logrus.Fatal(err)
+		}
+	case *flAPNSAuth != "":
+		signer, err := apns.NewTokenSigner(*flAPNSAuth, *flAPNSKeyID, *flAPNSTeam)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		s.APNs = apns.NewTokenClient(apnsURL, signer)
+	}
+	s.APNSTopic = *flAPNSTopic
+	if *flDEPSync > 0 {
+		var token *dep.Token
+		if *flDEPToken != "" {
+			if token, err = loadDEPToken(*flDEPToken); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		s.DEP = newDEPSync(*flDEPURL, token)
+	}
+	var inventory *cronSchedule
+	if *flInventory != "" {
+		if inventory, err = parseCronSchedule(*flInventory); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	inventoryCommands := strings.Split(*flInventCmd, ",")
+	if *flAppDir != "" || *flStaticDir != "" {
+		if *flAppSecret == "" {
+			logrus.Warn("no -app-url-secret; the URLs of hosted apps and static files stop working when the server restarts")
+		}
+		signer, err := newURLSigner(*flAppURL, []byte(*flAppSecret))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if *flAppDir != "" {
+			if s.EnterpriseApps, err = newAppHost(*flAppDir, signer, *flAppTTL); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+		if *flStaticDir != "" {
+			if s.Static, err = newStaticHost(*flStaticDir, signer, *flStaticTTL); err != nil {
+				logrus.Fatal(err)
+			}
+		}
+	}
+	s.BulkRate = *flBulkRate
+	s.Topics = fc.Topics
+	s.WebhookSecret = []byte(*flHMACKey)
+	s.SignatureHeader = *flHMACHdr
+	s.WebhookUser = *flHookUser
+	s.WebhookPassword = *flHookPass
+	s.WebhookToken = *flHookToken
+	s.ClientCertRequired = *flClientCA != ""
+	s.Retry.MaxAttempts = *flAttempts
+	s.Retry.Backoff = *flBackoff
+	s.ErrorRetry.MaxAttempts = *flErrTries
+	s.ErrorRetry.Backoff = *flErrWait
+	s.NotNowRetry.MaxAttempts = *flNotNowN
+	s.NotNowRetry.Backoff = *flNotNowWt
+	if s.TransientErrors, err = parseErrorMatchers(*flTransient); err != nil {
+		logrus.Fatalf("invalid -transient-errors: %v", err)
+	}
+	if *flDeadPath != "" {
+		s.DeadLetters = newDeadLetterLog(*flDeadPath)
+	}
+	s.MDMTimeout = *flMDMTime
+	if *flDedup > 0 {
+		s.Dedup = newEventDedup(*flDedup)
+	}
+	if *flArchive != "" {
+		a, err := newEventArchive(*flArchive, *flArchSize<<20, *flArchAge, *flArchGzip)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		s.Archives = append(s.Archives, a)
+	}
+	if *flS3Bucket != "" {
+		a, err := newS3Archive(S3ArchiveOptions{
+			Bucket:        *flS3Bucket,
+			Prefix:        *flS3Prefix,
+			Endpoint:      *flS3URL,
+			BatchSize:     *flS3Batch,
+			FlushInterval: *flS3Flush,
+		})
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		s.Archives = append(s.Archives, a)
+	}
+	if mdmClient, err = newMDMClient(*flMDMProxy, *flMDMCA, *flMDMNoTLS, *flMDMConns); err != nil {
+		logrus.Fatal(err)
+	}
+	if *flMDMNoTLS {
+		logrus.Warn("not verifying MicroMDM's certificate; use -mdm-insecure-skip-verify only for development")
+	}
+	if *flMDMRate > 0 {
+		s.Limiter = rate.NewLimiter(rate.Limit(*flMDMRate), max(*flMDMBurst, 1))
+	}
+	switch {
+	case *flWorkers > 0 && *flQueueDir != "":
+		if s.Queue, err = newDurableCommandQueue(*flQueueDir, *flQueueSize, *flWorkers); err != nil {
+			logrus.Fatal(err)
+		}
+	case *flWorkers > 0:
+		s.Queue = newCommandQueue(*flQueueSize, *flWorkers)
+	case *flQueueDir != "":
+		logrus.Fatal("-command-queue-dir requires -command-workers")
+	}
+	if len(fc.Forward) > 0 {
+		s.Forward = newForwarder(fc.Forward, s.Retry)
+	}
+	if ko := kafkaOpts(); len(ko.Brokers) > 0 {
+		k, err := newKafkaSink(ko)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ko.Insecure {
+			logrus.Warn("not verifying the Kafka brokers' certificates; use -kafka-insecure-skip-verify only for development")
+		}
+		s.addSink("kafka", k, nil)
+	}
+	if no := natsOpts(); no.URL != "" {
+		n, err := newNATSSink(no)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if no.Insecure {
+			logrus.Warn("not verifying the NATS server's certificate; use -nats-insecure-skip-verify only for development")
+		}
+		s.addSink("nats", n, nil)
+	}
+	if err := s.addAWSSinks(awsSinkOpts()); err != nil {
+		logrus.Fatal(err)
+	}
+	if po := pubSubOpts(); po.Project != "" {
+		p, err := newPubSubSink(po)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		s.addSink("pubsub", p, nil)
+	}
+	if ao := amqpOpts(); ao.URL != "" {
+		for topic, tc := range fc.Topics {
+			if tc.RoutingKey != "" {
+				if ao.RoutingKeys == nil {
+					ao.RoutingKeys = make(map[string]string)
+				}
+				ao.RoutingKeys[topic] = tc.RoutingKey
+			}
+		}
+		a, err := newAMQPSink(ao)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if ao.Insecure {
+			logrus.Warn("not verifying the AMQP broker's certificate; use -amqp-insecure-skip-verify only for development")
+		}
+		s.addSink("amqp", a, nil)
+	}
+	if so := slackOpts(); so.WebhookURL != "" {
+		sn, events, err := newSlackNotifier(so)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		s.addNotifier("slack", sn, events)
+	}
+	if to := teamsOpts(); to.WebhookURL != "" {
+		tn, events, err := newTeamsNotifier(to)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		s.addNotifier("teams", tn, events)
+	}
+	// -smtp-addr may only be there for the email notifiers of topics and
+	// rules.
+	if eo := emailOpts(); eo.Addr != "" && (eo.To != "" || len(fc.Email) > 0 || !configEmails(fc)) {
+		eo.Routes = fc.Email
+		en, events, err := newEmailNotifier(eo)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		if eo.Insecure {
+			logrus.Warn("not verifying the SMTP server's certificate; use -smtp-insecure-skip-verify only for development")
+		}
+		s.addNotifier("email", en, events)
+	}
+	if err := s.addTopicNotifiers(fc.Topics, emailOpts()); err != nil {
+		logrus.Fatal(err)
+	}
+	s.Hooks = newExecHooks(*flExecN, *flExecTO, s.Retry)
+	s.addTopicHooks(fc.Topics)
+	s.addBlueprints(fc.Blueprints)
+	if fc.Decommission != nil {
+		if err := s.addDecommission(fc.Decommission, emailOpts()); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if err := s.addRules(fc.Rules, emailOpts()); err != nil {
+		logrus.Fatal(err)
+	}
+	if err := s.addCompliance(fc.Compliance, emailOpts()); err != nil {
+		logrus.Fatal(err)
+	}
+	if fc.CertRenewal != nil {
+		if err := s.addCertRenewal(fc.CertRenewal, emailOpts()); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	if *flScripts != "" {
+		if s.Scripts, err = loadScripts(*flScripts, *flScriptTO); err != nil {
+			logrus.Fatal(err)
+		}
+	}
+	s.FailureThreshold = *flFailures
+	s.Breaker = newCircuitBreaker("default", BreakerPolicy{Failures: *flBreakerN, Cooldown: *flBreakerCD, Wait: *flBreakerOn == "wait"})
+	s.AllowedNets = allowedNets
+	s.TrustedProxies = trustedProxies
+	if *flClientCN != "" {
+		s.ClientCertNames = strings.Split(*flClientCN, ",")
+	}
+	if *flProfiles != "" {
+		s.ExpectedProfiles = strings.Split(*flProfiles, ",")
+	}
 
-	http.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+	logrus.WithField("port", *flPort).Info("webhook server listening")
+	// Routes are kept off http.DefaultServeMux, where net/http/pprof
+	// registers itself.
+	mux := http.NewServeMux()
+	if untenanted {
+		l.goLoop(func(ctx context.Context) { s.expirePendingLoop(ctx, *flCmdExpiry) })
+		if *flOSUpdates > 0 {
+			l.goLoop(func(ctx context.Context) { s.osUpdateLoop(ctx, *flOSUpdates) })
+		}
+		if *flAppChecks > 0 {
+			l.goLoop(func(ctx context.Context) { s.appInstallLoop(ctx, *flAppChecks) })
+		}
+		if s.FileVault != nil {
+			l.goLoop(s.fileVaultLoop)
+		}
+		if s.Decommission != nil && s.Decommission.PurgeAfter > 0 {
+			l.goLoop(s.decommissionLoop)
+		}
+		if s.BootstrapTokenGrace > 0 {
+			l.goLoop(s.bootstrapTokenLoop)
+		}
+		if s.CertExpiryWarning > 0 {
+			l.goLoop(s.certExpiryLoop)
+		}
+		if s.hasFleetLinks() {
+			l.goLoop(s.osqueryLoop)
+		}
+		if inventory != nil {
+			l.goLoop(func(ctx context.Context) { s.inventoryLoop(ctx, inventory, inventoryCommands) })
+		}
+		if s.DEP != nil {
+			l.goLoop(func(ctx context.Context) { s.depSyncLoop(ctx, *flDEPSync) })
+		}
+		mux.Handle("/webhook", s.webhookHandler())
+		if s.hasDeclarations() {
+			mux.Handle("/declarative-management/{endpoint...}", s.declarativeManagementHandler())
+		}
+		if *flAdminTok != "" {
+			mux.Handle("/api/", s.apiHandler(""))
+			mux.Handle("/graphql", s.graphQLHandler())
+		} else {
+			logrus.Info("admin API disabled; set -admin-token to enable it")
+		}
+	}
+	l.servers = []*Server{s}
+	l.queue = s.Queue
+	l.forwarder = s.Forward
+	l.sinks = s.Sinks
+	l.notifiers = s.Notifiers
+	l.hooks = s.Hooks
+	for _, ts := range s.serveTenants(mux, fc.Tenants, backend, history) {
+		l.goLoop(func(ctx context.Context) { ts.expirePendingLoop(ctx, *flCmdExpiry) })
+		if *flOSUpdates > 0 {
+			l.goLoop(func(ctx context.Context) { ts.osUpdateLoop(ctx, *flOSUpdates) })
+		}
+		if *flAppChecks > 0 {
+			l.goLoop(func(ctx context.Context) { ts.appInstallLoop(ctx, *flAppChecks) })
+		}
+		if ts.FileVault != nil {
+			l.goLoop(ts.fileVaultLoop)
+		}
+		if ts.Decommission != nil && ts.Decommission.PurgeAfter > 0 {
+			l.goLoop(ts.decommissionLoop)
+		}
+		if ts.BootstrapTokenGrace > 0 {
+			l.goLoop(ts.bootstrapTokenLoop)
+		}
+		if ts.CertExpiryWarning > 0 {
+			l.goLoop(ts.certExpiryLoop)
+		}
+		if ts.hasFleetLinks() {
+			l.goLoop(ts.osqueryLoop)
+		}
+		if inventory != nil {
+			l.goLoop(func(ctx context.Context) { ts.inventoryLoop(ctx, inventory, inventoryCommands) })
+		}
+		if ts.DEP != nil {
+			l.goLoop(func(ctx context.Context) { ts.depSyncLoop(ctx, *flDEPSync) })
+		}
+		l.servers = append(l.servers, ts)
+	}
+	for _, srv := range l.servers {
+		for _, a := range srv.Archives {
+			l.closers = append(l.closers, a)
+		}
+	}
+	if s.Queue != nil {
+		s.Queue.start(l.servers)
+	}
+	if po := pagerDutyOpts(); po.RoutingKey != "" {
+		p, err := newPagerDuty(po, l.servers, s.dropped)
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		p.start()
+		l.closers = append(l.closers, p)
+	}
+	if flushSentry != nil {
+		l.closers = append(l.closers, flushSentry)
+	}
+	errc := make(chan error, 4)
+	if *flGRPCPort != 0 {
+		if !untenanted || *flAdminTok == "" {
+			logrus.Fatal("-grpc-port requires -server-url, -api-token, and -admin-token")
+		}
+		lis, err := net.Listen("tcp", ":"+strconv.Itoa(*flGRPCPort))
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		opts := []grpc.ServerOption{grpc.StatsHandler(otelgrpc.NewServerHandler())}
+		if tlsConfig != nil {
+			// The gRPC API authenticates with the admin token only.
+			grpcTLS := tlsConfig.Clone()
+			grpcTLS.ClientCAs, grpcTLS.ClientAuth = nil, tls.NoClientCert
+			opts = append(opts, grpc.Creds(credentials.NewTLS(grpcTLS)))
+		}
+		logrus.WithField("port", *flGRPCPort).Info("gRPC admin API listening")
+		l.grpc = s.newGRPCServer(opts...)
+		go func() { errc <- l.grpc.Serve(lis) }()
+	}
+
+	if s.EnterpriseApps != nil {
+		mux.Handle("/apps/", s.EnterpriseApps.handler())
+	}
+	if s.Static != nil {
+		mux.Handle("/static/", s.Static.handler())
+	}
+	probes := (&health{store: backend, servers: l.servers}).handler()
+	for _, path := range []string{"/healthz", "/readyz", "/version"} {
+		mux.Handle(path, probes)
+	}
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
 		io.WriteString(w, "Hello, world!")
 	})
 
-	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(*flPort), nil))
+	srv := &http.Server{Addr: ":" + strconv.Itoa(*flPort), Handler: traceHandler(accessLog(recoverPanics(mux))), TLSConfig: tlsConfig}
+	l.http = append(l.http, srv)
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != http.ErrServerClosed {
+			errc <- err
+		}
+	}()
+	if *flRedirect != 0 {
+		logrus.WithField("port", *flRedirect).Info("redirecting HTTP to HTTPS")
+		redirect := &http.Server{Addr: ":" + strconv.Itoa(*flRedirect), Handler: redirectToHTTPS(*flPort)}
+		l.http = append(l.http, redirect)
+		go func() {
+			if err := redirect.ListenAndServe(); err != http.ErrServerClosed {
+				errc <- err
+			}
+		}()
+	}
+
+	if *flDebugAddr != "" {
+		logrus.WithField("addr", *flDebugAddr).Info("debug endpoints listening")
+		debug := &http.Server{Addr: *flDebugAddr, Handler: debugHandler()}
+		l.http = append(l.http, debug)
+		go func() {
+			if err := debug.ListenAndServe(); err != http.ErrServerClosed {
+				errc <- err
+			}
+		}()
+	}
+
+	err = waitForShutdown(errc)
+	l.shutdown(*flShutdown)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.Info("webhook server stopped")
 }
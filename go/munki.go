@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"expvar"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// munkiVars counts the Macs sent the Munki preferences profile (profiles) and
+// package (packages), and the inventory reports posted to MunkiReport
+// (reports) and those that failed (report_failures).
+var munkiVars = expvar.NewMap("munki")
+
+// munkiProfileIdentifier is the PayloadIdentifier of the generated Munki
+// preferences profile.
+const munkiProfileIdentifier = "com.googlecode.munki.ManagedInstalls"
+
+// munkiReportTimeout limits how long MunkiReport may take to take each
+// report.
+const munkiReportTimeout = 30 * time.Second
+
+// munkiReportClient posts inventory to MunkiReport.
+var munkiReportClient = &http.Client{Timeout: munkiReportTimeout, Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// BlueprintMunki bootstraps Munki on the Macs a blueprint sets up: its
+// preferences are installed with a profile after the blueprint's profiles,
+// and then munkitools with InstallEnterpriseApplication, ahead of the
+// blueprint's apps. It is skipped on other platforms.
+type BlueprintMunki struct {
+	// RepoURL is the SoftwareRepoURL of the generated preferences profile.
+	RepoURL string `yaml:"repo-url"`
+
+	// ClientIdentifier, if set, is the manifest Munki asks the repository
+	// for, a template like those of profiles, e.g. "{{.Serial}}".
+	ClientIdentifier string `yaml:"client-identifier"`
+
+	// Preferences are other ManagedInstalls preferences of the generated
+	// profile, e.g. InstallAppleSoftwareUpdates: true.
+	Preferences map[string]interface{} `yaml:"preferences"`
+
+	// Profile, if set, is the path of a preferences profile, relative to
+	// the config file, installed instead of the generated one.
+	Profile string `yaml:"profile"`
+
+	// Package, a package of -app-dir, or ManifestURL is munkitools, e.g. a
+	// bootstrap package of munkitools that has Munki check for updates as
+	// soon as it is installed.
+	Package     string `yaml:"package"`
+	ManifestURL string `yaml:"manifest-url"`
+
+	// MunkiReport, if set, is sent the inventory of the Macs the blueprint
+	// set up.
+	MunkiReport *MunkiReport `yaml:"munkireport"`
+
+	// clientIdentifier is ClientIdentifier parsed, and profile holds the
+	// contents of Profile.
+	clientIdentifier *template.Template
+	profile          *profile
+}
+
+// MunkiReport is a MunkiReport server the DeviceInformation responses of Macs
+// are posted to, as the MunkiReport client on the Macs posts its reports.
+type MunkiReport struct {
+	// URL is the base URL of the server, e.g.
+	// https://munkireport.example.com.
+	URL string `yaml:"url"`
+
+	// Passphrase is one of the server's client_passphrases, if it has
+	// them.
+	Passphrase string `yaml:"passphrase"`
+}
+
+func (m *BlueprintMunki) validate() error {
+	if (m.RepoURL == "") == (m.Profile == "") {
+		return fmt.Errorf("munki: set exactly one of repo-url or profile")
+	}
+	if m.Profile != "" && (m.ClientIdentifier != "" || len(m.Preferences) > 0) {
+		return fmt.Errorf("munki: client-identifier and preferences are for the generated profile of repo-url")
+	}
+	if m.Package != "" && m.ManifestURL != "" {
+		return fmt.Errorf("munki: set at most one of package or manifest-url")
+	}
+	if _, ok := m.Preferences["SoftwareRepoURL"]; ok {
+		return fmt.Errorf("munki: set SoftwareRepoURL with repo-url")
+	}
+	if _, ok := m.Preferences["ClientIdentifier"]; ok {
+		return fmt.Errorf("munki: set ClientIdentifier with client-identifier")
+	}
+	if r := m.MunkiReport; r != nil {
+		u, err := url.Parse(r.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("munki: invalid munkireport url %q", r.URL)
+		}
+	}
+	return nil
+}
+
+// load parses the client identifier template and reads the profile,
+// resolving a relative path against dir.
+func (m *BlueprintMunki) load(dir string) error {
+	if m.Profile != "" {
+		p, err := loadProfile(m.Profile, dir)
+		if err != nil {
+			return fmt.Errorf("munki: %v", err)
+		}
+		m.profile = p
+	}
+	if m.ClientIdentifier != "" {
+		t, err := template.New("client-identifier").Funcs(profileFuncs).Option("missingkey=error").Parse(m.ClientIdentifier)
+		if err != nil {
+			return fmt.Errorf("munki: client-identifier: %v", err)
+		}
+		m.clientIdentifier = t
+	}
+	return nil
+}
+
+// render returns the generated preferences profile of the Mac of v.
+func (m *BlueprintMunki) render(v profileVars) ([]byte, error) {
+	prefs := payloadDict{"SoftwareRepoURL": m.RepoURL}
+	for k, value := range m.Preferences {
+		prefs[k] = value
+	}
+	if m.clientIdentifier != nil {
+		var b bytes.Buffer
+		if err := m.clientIdentifier.Execute(&b, v); err != nil {
+			return nil, fmt.Errorf("render Munki client identifier: %v", err)
+		}
+		prefs["ClientIdentifier"] = b.String()
+	}
+	return generatedProfile(munkiProfileIdentifier, "Munki", payloadDict{
+		"PayloadType":       "com.apple.ManagedClient.preferences",
+		"PayloadIdentifier": munkiProfileIdentifier + ".preferences",
+		"PayloadContent": payloadDict{
+			"ManagedInstalls": payloadDict{
+				"Forced": []payloadDict{{"mcx_preference_settings": prefs}},
+			},
+		},
+	})
+}
+
+// profileIdentifier returns the PayloadIdentifier of the preferences profile
+// of m as installed on d.
+func (m *BlueprintMunki) profileIdentifier(v profileVars) (string, error) {
+	if m.profile == nil {
+		return munkiProfileIdentifier, nil
+	}
+	payload, err := m.profile.render(v)
+	if err != nil {
+		return "", err
+	}
+	return profileIdentifier(payload)
+}
+
+// munkiProfileCommand returns the InstallProfile command installing the
+// preferences profile of m on d, signed if profiles are signed.
+func (s *Server) munkiProfileCommand(ctx context.Context, d Device, m *BlueprintMunki) (Command, error) {
+	if m.profile != nil {
+		return s.installProfileCommand(ctx, d, "", m.profile)
+	}
+	payload, err := m.render(s.profileVars(d, ""))
+	if err != nil {
+		return Command{}, err
+	}
+	if payload, err = s.signProfile(ctx, payload); err != nil {
+		return Command{}, fmt.Errorf("Munki profile: %v", err)
+	}
+	secret := m.clientIdentifier != nil && readsSecret(m.clientIdentifier)
+	return Command{UDID: d.UDID, RequestType: "InstallProfile", Payload: payload, SecretPayload: secret}, nil
+}
+
+// munkiCommands returns the commands bootstrapping Munki on d, a Mac set up
+// with a blueprint with m.
+func (s *Server) munkiCommands(ctx context.Context, d Device, m *BlueprintMunki) []Command {
+	var commands []Command
+	if c, err := s.munkiProfileCommand(ctx, d, m); err != nil {
+		logFor(ctx).WithError(err).Error("bootstrap Munki")
+	} else {
+		commands = append(commands, c)
+		munkiVars.Add("profiles", 1)
+	}
+	if m.Package != "" || m.ManifestURL != "" {
+		if c, err := s.packageCommand(d, m.Package, m.ManifestURL); err != nil {
+			logFor(ctx).WithField("package", m.Package).WithError(err).Error("bootstrap Munki")
+		} else {
+			commands = append(commands, c)
+			munkiVars.Add("packages", 1)
+		}
+	}
+	logFor(ctx).WithFields(logrus.Fields{"repo_url": m.RepoURL, "profile": m.Profile, "package": m.Package}).Info("bootstrapping Munki")
+	return commands
+}
+
+// reportToMunkiReport posts the DeviceInformation response of d, a Mac, to
+// the MunkiReport server of its blueprint, if it has one. The report is
+// posted in the background, so a slow server does not hold up the response.
+func (s *Server) reportToMunkiReport(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	b := s.blueprintNamed(d.Blueprint)
+	if b == nil || b.Munki == nil || b.Munki.MunkiReport == nil || d.Platform != platformMacOS || d.Info == nil {
+		return false, nil
+	}
+	r := b.Munki.MunkiReport
+	info := *d.Info
+	udid := d.UDID
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := r.checkIn(ctx, udid, info); err != nil {
+			munkiVars.Add("report_failures", 1)
+			logFor(ctx).WithError(err).WithField("munkireport", r.URL).Error("report to MunkiReport")
+			return
+		}
+		munkiVars.Add("reports", 1)
+		logFor(ctx).WithField("serial_number", info.SerialNumber).Debug("reported to MunkiReport")
+	}()
+	return false, nil
+}
+
+// checkIn posts the machine and reportdata modules of a Mac's inventory to
+// MunkiReport's report/check_in, as the MunkiReport client does: a form of
+// the passphrase, the serial number, and the modules PHP-serialized as
+// items, each with its data, a plist, and the data's MD5 hash.
+func (r *MunkiReport) checkIn(ctx context.Context, udid string, info DeviceInfo) error {
+	if info.SerialNumber == "" {
+		return fmt.Errorf("device %s has not reported its serial number", udid)
+	}
+	modules := map[string]map[string]string{
+		"machine": {
+			"serial_number": info.SerialNumber,
+			"hostname":      info.DeviceName,
+			"computer_name": info.DeviceName,
+			"machine_model": info.ProductName,
+			"machine_name":  info.ModelName,
+			"os_version":    info.OSVersion,
+			"buildversion":  info.BuildVersion,
+			"platform_UUID": udid,
+		},
+		"reportdata": {
+			"serial_number": info.SerialNumber,
+			"timestamp":     strconv.FormatInt(info.UpdatedAt.Unix(), 10),
+		},
+	}
+	items := make(map[string]map[string]string, len(modules))
+	for name, data := range modules {
+		b, err := plist.MarshalIndent(data, "\t")
+		if err != nil {
+			return fmt.Errorf("encode %s module: %v", name, err)
+		}
+		sum := md5.Sum(b)
+		items[name] = map[string]string{"data": string(b), "hash": hex.EncodeToString(sum[:])}
+	}
+	form := url.Values{
+		"passphrase": {r.Passphrase},
+		"serial":     {info.SerialNumber},
+		"items":      {phpSerialize(items)},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(r.URL, "/")+"/index.php?/report/check_in", strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("create MunkiReport request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := munkiReportClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	// MunkiReport answers errors with 200 and an "Error:" line.
+	if resp.StatusCode != http.StatusOK || bytes.Contains(body, []byte("Error:")) {
+		return fmt.Errorf("MunkiReport returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// phpSerialize encodes items as PHP's serialize does an array of arrays of
+// strings, with the keys sorted. PHP strings are given by their length in
+// bytes and not escaped.
+func phpSerialize(items map[string]map[string]string) string {
+	var b strings.Builder
+	str := func(s string) { fmt.Fprintf(&b, "s:%d:\"%s\";", len(s), s) }
+	fmt.Fprintf(&b, "a:%d:{", len(items))
+	for _, name := range slices.Sorted(maps.Keys(items)) {
+		str(name)
+		fmt.Fprintf(&b, "a:%d:{", len(items[name]))
+		for _, k := range slices.Sorted(maps.Keys(items[name])) {
+			str(k)
+			str(items[name][k])
+		}
+		b.WriteString("}")
+	}
+	b.WriteString("}")
+	return b.String()
+}
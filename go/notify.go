@@ -0,0 +1,570 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/micromdm/micromdm/mdm"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// The device lifecycle events notifiers can be told about.
+const (
+	notifyEnrolled     = "enrolled"
+	notifyReenrolled   = "re-enrolled"
+	notifyCheckedOut   = "checked-out"
+	notifyCommandError = "command-error"
+
+	// notifyRepeatedFailures is sent once a device has failed as many
+	// commands in a row as Server.FailureThreshold.
+	notifyRepeatedFailures = "repeated-failures"
+
+	// notifyDecommissioned is sent to the notifiers of the decommission
+	// config only, after notifyCheckedOut.
+	notifyDecommissioned = "decommissioned"
+
+	// notifyBootstrapTokenMissing is sent once a Mac has been enrolled for
+	// Server.BootstrapTokenGrace without escrowing a bootstrap token.
+	notifyBootstrapTokenMissing = "bootstrap-token-missing"
+
+	// notifyNoncompliant is sent when a device falls out of compliance with
+	// a compliance policy.
+	notifyNoncompliant = "noncompliant"
+
+	// notifyNotInDEP is sent when a device enrolls whose serial number DEP
+	// does not list as assigned to the MDM server.
+	notifyNotInDEP = "not-in-dep"
+
+	// notifyUnexpected is sent when a device enrolls that is not on the
+	// imported lists of expected devices.
+	notifyUnexpected = "unexpected-device"
+
+	// notifyCertExpiring is sent once for each identity certificate of a
+	// device found expiring within Server.CertExpiryWarning.
+	notifyCertExpiring = "cert-expiring"
+)
+
+// notifyEvents lists the lifecycle events, in the order they are documented.
+var notifyEvents = []string{notifyEnrolled, notifyReenrolled, notifyCheckedOut, notifyCommandError, notifyRepeatedFailures, notifyBootstrapTokenMissing, notifyNoncompliant, notifyNotInDEP, notifyUnexpected, notifyCertExpiring}
+
+// notifyQueueSize is how many notifications can wait to be sent by each
+// notifier.
+const notifyQueueSize = 1000
+
+// notifyVars counts the notifications sent by each notifier, keyed by its
+// name: sent, retried, failed (given up on), and dropped (the notifier's
+// queue was full).
+var notifyVars = expvar.NewMap("notifiers")
+
+// notifyClient posts notifications to chat webhooks.
+var notifyClient = &http.Client{Timeout: 30 * time.Second, Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// defaultNotifyTemplate renders the text of a notification when no template
+// is configured.
+const defaultNotifyTemplate = "{{.Summary}}"
+
+// Notification is a device lifecycle event, as given to notifiers and their
+// message templates.
+type Notification struct {
+	Event  string    `json:"event"`
+	Tenant string    `json:"tenant,omitempty"`
+	UDID   string    `json:"udid"`
+	Name   string    `json:"device_name,omitempty"`
+	Serial string    `json:"serial_number,omitempty"`
+	Model  string    `json:"model,omitempty"`
+	Time   time.Time `json:"time"`
+
+	// RequestType, CommandUUID, Status, and Errors describe the failed
+	// command of command-error and repeated-failures notifications,
+	// ErrorClass whether the failure was transient or permanent, Attempts
+	// how many times the command was sent, and Failures how many commands
+	// in a row the device has failed.
+	RequestType string               `json:"request_type,omitempty"`
+	CommandUUID string               `json:"command_uuid,omitempty"`
+	Status      string               `json:"status,omitempty"`
+	Errors      []mdm.ErrorChainItem `json:"error_chain,omitempty"`
+	ErrorClass  string               `json:"error_class,omitempty"`
+	Attempts    int                  `json:"attempts,omitempty"`
+	Failures    int                  `json:"failures,omitempty"`
+
+	// Topic and EventID identify the webhook event, and Payload is what the
+	// device sent, decoded from its plist: a check-in message or a command
+	// response.
+	Topic   string                 `json:"topic"`
+	EventID string                 `json:"event_id,omitempty"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+
+	// Device is the device as stored when the event was handled.
+	Device Device `json:"device"`
+
+	// Rule names the rule whose notify action sent the notification.
+	Rule string `json:"rule,omitempty"`
+
+	// Policy and Violations are the compliance policy of noncompliant
+	// notifications and how the device violates it.
+	Policy     string   `json:"policy,omitempty"`
+	Violations []string `json:"violations,omitempty"`
+
+	// Certificate is the identity certificate of cert-expiring
+	// notifications.
+	Certificate *store.DeviceCertificate `json:"certificate,omitempty"`
+}
+
+// Label names the device for people: its name and serial number when they
+// are known, its UDID otherwise.
+func (n Notification) Label() string {
+	switch {
+	case n.Name != "" && n.Serial != "":
+		return fmt.Sprintf("%s (serial %s)", n.Name, n.Serial)
+	case n.Name != "":
+		return fmt.Sprintf("%s (%s)", n.Name, n.UDID)
+	case n.Serial != "":
+		return "serial " + n.Serial
+	}
+	return n.UDID
+}
+
+// Reason returns the descriptions of the errors the device reported for a
+// failed command.
+func (n Notification) Reason() string {
+	var reasons []string
+	for _, e := range n.Errors {
+		switch {
+		case e.USEnglishDescription != "":
+			reasons = append(reasons, e.USEnglishDescription)
+		case e.LocalizedDescription != "":
+			reasons = append(reasons, e.LocalizedDescription)
+		case e.ErrorDomain != "":
+			reasons = append(reasons, fmt.Sprintf("%s error %d", e.ErrorDomain, e.ErrorCode))
+		}
+	}
+	if len(reasons) == 0 {
+		return n.Status
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// Summary is a one-line description of the event, the default text of
+// notifications.
+func (n Notification) Summary() string {
+	var text string
+	switch n.Event {
+	case notifyEnrolled:
+		text = "New device enrolled: " + n.Label()
+	case notifyReenrolled:
+		text = "Device re-enrolled: " + n.Label()
+	case notifyCheckedOut:
+		text = "Device checked out: " + n.Label()
+	case notifyDecommissioned:
+		text = "Device decommissioned: " + n.Label()
+	case notifyBootstrapTokenMissing:
+		text = "Mac has not escrowed a bootstrap token: " + n.Label()
+	case notifyNoncompliant:
+		text = fmt.Sprintf("%s violates compliance policy %s: %s", n.Label(), n.Policy, strings.Join(n.Violations, "; "))
+	case notifyNotInDEP:
+		text = "Device enrolled that is not assigned to this server in DEP: " + n.Label()
+	case notifyUnexpected:
+		text = "Device enrolled that is not on the list of expected devices: " + n.Label()
+	case notifyCertExpiring:
+		text = "Identity certificate expires soon: " + n.Label()
+		if c := n.Certificate; c != nil {
+			text = fmt.Sprintf("Identity certificate %s of %s expires %s", c.CommonName, n.Label(), c.NotAfter.Format(time.RFC3339))
+		}
+	case notifyCommandError:
+		text = fmt.Sprintf("%s failed on %s: %s", n.command(), n.Label(), n.Reason())
+		if n.Attempts > 1 {
+			text += fmt.Sprintf(" (after %d attempts)", n.Attempts)
+		}
+	case notifyRepeatedFailures:
+		text = fmt.Sprintf("%s has failed %d commands in a row", n.Label(), n.Failures)
+		if n.RequestType != "" {
+			text += ", most recently " + n.RequestType
+		}
+		text += ": " + n.Reason()
+	default:
+		text = fmt.Sprintf("%s: %s", n.Event, n.Label())
+		if n.Rule != "" {
+			text = fmt.Sprintf("%s matched rule %s", n.Label(), n.Rule)
+		}
+	}
+	if n.Tenant != "" {
+		text = fmt.Sprintf("[%s] %s", n.Tenant, text)
+	}
+	return text
+}
+
+func (n Notification) command() string {
+	if n.RequestType == "" {
+		return "Command"
+	}
+	return n.RequestType
+}
+
+// checkinDevice holds the details of the device in a check-in message.
+type checkinDevice struct {
+	DeviceName   string
+	SerialNumber string
+	Model        string
+}
+
+// newNotification describes an event of kind about d, taking the device's
+// name and serial number from the check-in message of event if it has them,
+// and from its last DeviceInformation response otherwise.
+func (s *Server) newNotification(kind string, d Device, event webhook.Event) Notification {
+	n := Notification{
+		Event:   kind,
+		Tenant:  s.Tenant,
+		UDID:    d.UDID,
+		Time:    eventTime(event),
+		Topic:   event.Topic,
+		EventID: event.EventID,
+		Device:  d,
+	}
+	if d.Info != nil {
+		n.Name, n.Serial, n.Model = d.Info.DeviceName, d.Info.SerialNumber, d.Info.Model
+	}
+	var raw []byte
+	switch {
+	case event.CheckinEvent != nil:
+		raw = event.CheckinEvent.RawPayload
+	case event.AcknowledgeEvent != nil:
+		raw = event.AcknowledgeEvent.RawPayload
+	}
+	if len(raw) > 0 {
+		var payload map[string]interface{}
+		if err := plist.Unmarshal(raw, &payload); err == nil {
+			webhook.RedactPayload(payload)
+			n.Payload = payload
+		}
+	}
+	if c := event.CheckinEvent; c != nil && len(c.RawPayload) > 0 {
+		var cd checkinDevice
+		if err := plist.Unmarshal(c.RawPayload, &cd); err == nil {
+			if cd.DeviceName != "" {
+				n.Name = cd.DeviceName
+			}
+			if cd.SerialNumber != "" {
+				n.Serial = cd.SerialNumber
+			}
+			if cd.Model != "" {
+				n.Model = cd.Model
+			}
+		}
+	}
+	return n
+}
+
+// notifyCommandResult tells the notifiers, if any, when the device of event
+// fails the command ack answers, on the given attempt and with an error of
+// the given class, and when it has failed s.FailureThreshold in a row.
+func (s *Server) notifyCommandResult(ctx context.Context, d Device, event webhook.Event, ack acknowledgment, class string, attempt int) {
+	if s.Notifiers == nil {
+		return
+	}
+	switch ack.Status {
+	case "Acknowledged":
+		s.Failures.record(d.UDID, false)
+	case "Error", "CommandFormatError":
+		n := s.newNotification(notifyCommandError, d, event)
+		n.RequestType, n.CommandUUID, n.Status, n.Errors = ack.RequestType, ack.CommandUUID, ack.Status, ack.ErrorChain
+		n.ErrorClass, n.Attempts = class, attempt
+		n.Failures = s.Failures.record(d.UDID, true)
+		s.Notifiers.notify(ctx, n)
+		if s.FailureThreshold > 0 && n.Failures == s.FailureThreshold {
+			n.Event = notifyRepeatedFailures
+			s.Notifiers.notify(ctx, n)
+		}
+	}
+}
+
+// failureCounter counts the commands each device has failed in a row.
+type failureCounter struct {
+	mu     sync.Mutex
+	counts map[string]int // by UDID
+}
+
+func newFailureCounter() *failureCounter {
+	return &failureCounter{counts: make(map[string]int)}
+}
+
+// record counts a command of the device that failed or succeeded, returning
+// how many it has now failed in a row.
+func (c *failureCounter) record(udid string, failed bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !failed {
+		delete(c.counts, udid)
+		return 0
+	}
+	c.counts[udid]++
+	return c.counts[udid]
+}
+
+// notify tells the notifiers, if any, about an event of kind about d.
+func (s *Server) notify(ctx context.Context, kind string, d Device, event webhook.Event) {
+	if s.Notifiers != nil {
+		s.Notifiers.notify(ctx, s.newNotification(kind, d, event))
+	}
+}
+
+// notifyTopic sends event, with the device it is about as stored once it
+// was handled, to the notifiers configured for its topic. Their
+// notifications' Event is the topic.
+func (s *Server) notifyTopic(ctx context.Context, event webhook.Event, udid string) {
+	if s.Notifiers == nil || !s.Notifiers.wants(event.Topic) {
+		return
+	}
+	d, _, err := s.loadDevice(udid)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device to notify about")
+		d = Device{UDID: udid}
+	}
+	s.Notifiers.notify(ctx, s.newNotification(event.Topic, d, event))
+}
+
+// parseNotifyEvents parses the comma-separated lifecycle events of flag
+// name.
+func parseNotifyEvents(name, list string) ([]string, error) {
+	if list == "" {
+		return nil, fmt.Errorf("-%s: no events", name)
+	}
+	events := strings.Split(list, ",")
+	for _, e := range events {
+		if !slices.Contains(notifyEvents, e) {
+			return nil, fmt.Errorf("-%s: unknown event %q, want one of %s", name, e, strings.Join(notifyEvents, ", "))
+		}
+	}
+	return events, nil
+}
+
+// parseNotifyTemplate parses text, the Go template of flag name, which
+// renders notifications.
+func parseNotifyTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("-%s: %v", name, err)
+	}
+	return t, nil
+}
+
+// renderNotification executes t with n.
+func renderNotification(t *template.Template, n Notification) (string, error) {
+	var b strings.Builder
+	if err := t.Execute(&b, n); err != nil {
+		return "", &permanentError{fmt.Errorf("render %s: %v", t.Name(), err)}
+	}
+	return b.String(), nil
+}
+
+// postNotification posts v as JSON to url, the incoming webhook of a chat
+// service.
+func postNotification(ctx context.Context, url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return &permanentError{fmt.Errorf("encode notification: %v", err)}
+	}
+	return postMessage(ctx, url, "application/json", nil, body)
+}
+
+// postMessage posts body to url. Like forwarded events, 5xx and 429
+// responses are worth retrying and other client errors are not.
+func postMessage(ctx context.Context, url, contentType string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{fmt.Errorf("create request: %v", err)}
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	if id := requestIDFrom(ctx); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	case resp.StatusCode >= 400:
+		return &permanentError{fmt.Errorf("webhook returned %s", resp.Status)}
+	}
+	return nil
+}
+
+// notifier tells people about lifecycle events, e.g. in a chat channel.
+// notify is called from one goroutine at a time.
+type notifier interface {
+	notify(ctx context.Context, n Notification) error
+	io.Closer
+}
+
+type queuedNotification struct {
+	ctx context.Context
+	n   Notification
+}
+
+// notifyQueue feeds one notifier from a queue of its own.
+type notifyQueue struct {
+	name     string
+	notifier notifier
+	events   []string
+	msgs     chan queuedNotification
+
+	sent, retried, failed, dropped expvar.Int
+}
+
+// notifiers sends lifecycle events to the notifiers interested in them,
+// retrying failures according to retry.
+type notifiers struct {
+	queues []*notifyQueue
+	retry  RetryPolicy
+	wg     sync.WaitGroup
+
+	// ctx is cancelled to abandon notifications when shutdown times out.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newNotifiers(retry RetryPolicy) *notifiers {
+	ns := &notifiers{retry: retry}
+	ns.ctx, ns.cancel = context.WithCancel(context.Background())
+	return ns
+}
+
+// addNotifier sends the server's lifecycle events of the given kinds to n
+// too, starting s.Notifiers if needed.
+func (s *Server) addNotifier(name string, n notifier, events []string) *notifyQueue {
+	if s.Notifiers == nil {
+		s.Notifiers = newNotifiers(s.Retry)
+	}
+	return s.Notifiers.add(name, n, events)
+}
+
+// add starts sending lifecycle events of the given kinds to n under name,
+// which identifies it in logs and at /debug/vars, and returns n's queue.
+func (ns *notifiers) add(name string, n notifier, events []string) *notifyQueue {
+	q := &notifyQueue{name: name, notifier: n, events: events, msgs: make(chan queuedNotification, notifyQueueSize)}
+	vars := new(expvar.Map)
+	vars.Set("sent", &q.sent)
+	vars.Set("retried", &q.retried)
+	vars.Set("failed", &q.failed)
+	vars.Set("dropped", &q.dropped)
+	vars.Set("queued", expvar.Func(func() any { return len(q.msgs) }))
+	notifyVars.Set(name, vars)
+	ns.queues = append(ns.queues, q)
+	ns.wg.Add(1)
+	go ns.run(q)
+	return q
+}
+
+// wants reports whether any notifier is interested in event.
+func (ns *notifiers) wants(event string) bool {
+	for _, q := range ns.queues {
+		if slices.Contains(q.events, event) {
+			return true
+		}
+	}
+	return false
+}
+
+// notify queues n for every notifier interested in its event. Sending keeps
+// the values of ctx, such as its logger, but not its cancellation.
+func (ns *notifiers) notify(ctx context.Context, n Notification) {
+	for _, q := range ns.queues {
+		if slices.Contains(q.events, n.Event) {
+			ns.enqueue(ctx, q, n)
+		}
+	}
+}
+
+// enqueue queues n for the notifier of q alone, whatever its events.
+func (ns *notifiers) enqueue(ctx context.Context, q *notifyQueue, n Notification) {
+	select {
+	case q.msgs <- queuedNotification{ctx: context.WithoutCancel(ctx), n: n}:
+	default:
+		q.dropped.Add(1)
+		logFor(ctx).WithField("notifier", q.name).Error("notification queue is full, dropping notification")
+	}
+}
+
+func (ns *notifiers) run(q *notifyQueue) {
+	defer ns.wg.Done()
+	for qn := range q.msgs {
+		ctx, cancel := context.WithCancel(qn.ctx)
+		stop := context.AfterFunc(ns.ctx, cancel)
+		ns.send(ctx, q, qn.n)
+		stop()
+		cancel()
+	}
+}
+
+func (ns *notifiers) send(ctx context.Context, q *notifyQueue, n Notification) {
+	logger := logFor(ctx).WithFields(logrus.Fields{"notifier": q.name, "notification": n.Event})
+	attempts := max(ns.retry.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		err := q.notifier.notify(ctx, n)
+		if err == nil {
+			q.sent.Add(1)
+			return
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) || attempt >= attempts || ctx.Err() != nil {
+			q.failed.Add(1)
+			logger.WithError(err).WithField("attempts", attempt).Error("giving up sending notification")
+			return
+		}
+		q.retried.Add(1)
+		wait := ns.retry.delay(attempt)
+		logger.WithError(err).WithFields(logrus.Fields{"attempt": attempt, "retry_in": wait.String()}).Warn("send notification failed, retrying")
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// close stops accepting notifications, waits for the queued ones to be
+// sent, and closes the notifiers. If ctx ends first, sending is cancelled
+// and ctx's error is returned. Nothing may be notified after close is
+// called.
+func (ns *notifiers) close(ctx context.Context) error {
+	for _, q := range ns.queues {
+		close(q.msgs)
+	}
+	done := make(chan struct{})
+	go func() {
+		ns.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		ns.cancel()
+		err = ctx.Err()
+	}
+	for _, q := range ns.queues {
+		if cerr := q.notifier.Close(); cerr != nil {
+			logrus.WithError(cerr).WithField("notifier", q.name).Error("close notifier")
+		}
+	}
+	return err
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultEmailBody is the template of the body of notification emails when
+// no other is configured.
+const defaultEmailBody = `{{.Summary}}
+
+{{if .Name}}Device:        {{.Name}}
+{{end}}{{if .Serial}}Serial number: {{.Serial}}
+{{end}}{{if .Model}}Model:         {{.Model}}
+{{end}}UDID:          {{.UDID}}
+{{if .Tenant}}Tenant:        {{.Tenant}}
+{{end}}{{if .RequestType}}Command:       {{.RequestType}} {{.CommandUUID}}
+{{end}}{{if .Errors}}Error:         {{.Reason}}
+{{end}}{{if gt .Failures 1}}Failed:        {{.Failures}} in a row
+{{end}}Time:          {{.Time.UTC.Format "2006-01-02 15:04:05 MST"}}
+`
+
+// EmailRoute sends notification emails to more recipients, e.g. the team
+// looking after one tenant's devices.
+type EmailRoute struct {
+	To []string `yaml:"to"`
+
+	// Events and Tenants, when set, limit the notifications sent to the
+	// route to those of the listed lifecycle events and tenants. Without
+	// Events, the route gets those of -smtp-events.
+	Events  []string `yaml:"events"`
+	Tenants []string `yaml:"tenants"`
+}
+
+func (r EmailRoute) matches(n Notification, defaultEvents []string) bool {
+	events := r.Events
+	if len(events) == 0 {
+		events = defaultEvents
+	}
+	return slices.Contains(events, n.Event) && (len(r.Tenants) == 0 || slices.Contains(r.Tenants, n.Tenant))
+}
+
+// EmailOptions configures an emailNotifier.
+type EmailOptions struct {
+	// Addr is the SMTP server's host:port.
+	Addr string
+	// TLS is "starttls" to upgrade the connection before authenticating,
+	// "tls" for servers expecting TLS from the start (usually port 465),
+	// or "none".
+	TLS      string
+	CAFile   string
+	Insecure bool
+	User     string
+	Password string
+
+	From string
+	// To receive the notifications of Events; Routes send them to more
+	// recipients.
+	To     string
+	Events string
+	Routes []EmailRoute
+
+	// Subject and Body are the Go templates of the email, executed with a
+	// Notification.
+	Subject string
+	Body    string
+}
+
+// emailFlags defines the flags of the email notifier on fs and returns a
+// function reading them once fs is parsed.
+func emailFlags(fs *flag.FlagSet) func() EmailOptions {
+	var (
+		flAddr     = fs.String("smtp-addr", "", "SMTP server host:port to send notification emails through (disabled when empty)")
+		flTLS      = fs.String("smtp-tls", "starttls", "how to secure the SMTP connection: starttls, tls (TLS from the start, usually port 465), or none")
+		flCA       = fs.String("smtp-ca", "", "PEM CA bundle to trust, in addition to the system roots, for the SMTP server's certificate")
+		flInsecure = fs.Bool("smtp-insecure-skip-verify", false, "do not verify the SMTP server's certificate (for development only)")
+		flUser     = fs.String("smtp-user", "", "user to authenticate to the SMTP server as")
+		flPassword = fs.String("smtp-password", "", "password to authenticate to the SMTP server with")
+		flFrom     = fs.String("smtp-from", "", "From address of notification emails, e.g. MDM <mdm@example.com>")
+		flTo       = fs.String("smtp-to", "", "comma-separated recipients of notification emails")
+		flEvents   = fs.String("smtp-events", notifyCheckedOut+","+notifyRepeatedFailures, "comma-separated lifecycle events to email: "+strings.Join(notifyEvents, ", "))
+		flSubject  = fs.String("smtp-subject", defaultNotifyTemplate, "Go template of the subject of notification emails")
+		flBody     = fs.String("smtp-body", defaultEmailBody, "Go template of the body of notification emails")
+	)
+	return func() EmailOptions {
+		return EmailOptions{
+			Addr:     *flAddr,
+			TLS:      *flTLS,
+			CAFile:   *flCA,
+			Insecure: *flInsecure,
+			User:     *flUser,
+			Password: *flPassword,
+			From:     *flFrom,
+			To:       *flTo,
+			Events:   *flEvents,
+			Subject:  *flSubject,
+			Body:     *flBody,
+		}
+	}
+}
+
+// emailNotifier sends notifications as plain-text emails through an SMTP
+// server, connecting for each.
+type emailNotifier struct {
+	addr     string
+	host     string
+	tlsMode  string
+	tls      *tls.Config
+	auth     smtp.Auth
+	from     *mail.Address
+	to       []string
+	events   []string
+	routes   []EmailRoute
+	subject  *template.Template
+	body     *template.Template
+	hostname string
+}
+
+// newEmailNotifier returns the notifier of opts and the events it sends.
+func newEmailNotifier(opts EmailOptions) (*emailNotifier, []string, error) {
+	host, _, err := net.SplitHostPort(opts.Addr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid -smtp-addr: %v", err)
+	}
+	e := &emailNotifier{addr: opts.Addr, host: host, tlsMode: opts.TLS, routes: opts.Routes}
+	switch opts.TLS {
+	case "starttls", "tls":
+		if e.tls, err = clientTLSConfig(opts.CAFile, opts.Insecure); err != nil {
+			return nil, nil, fmt.Errorf("SMTP TLS: %v", err)
+		}
+		e.tls.ServerName = host
+	case "none":
+	default:
+		return nil, nil, fmt.Errorf("invalid -smtp-tls %q: want starttls, tls, or none", opts.TLS)
+	}
+	if opts.User != "" {
+		// PlainAuth refuses to send the password unencrypted, other than
+		// to localhost.
+		e.auth = smtp.PlainAuth("", opts.User, opts.Password, host)
+	}
+	if e.from, err = mail.ParseAddress(opts.From); err != nil {
+		return nil, nil, fmt.Errorf("invalid -smtp-from: %v", err)
+	}
+	if opts.To != "" {
+		for _, to := range strings.Split(opts.To, ",") {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return nil, nil, fmt.Errorf("-smtp-to: recipient %q: %v", to, err)
+			}
+			e.to = append(e.to, strings.TrimSpace(to))
+		}
+	}
+	if len(e.to) == 0 && len(e.routes) == 0 {
+		return nil, nil, fmt.Errorf("-smtp-addr requires -smtp-to or email routes in the config file")
+	}
+	if e.events, err = parseNotifyEvents("smtp-events", opts.Events); err != nil {
+		return nil, nil, err
+	}
+	if e.subject, err = parseNotifyTemplate("smtp-subject", opts.Subject); err != nil {
+		return nil, nil, err
+	}
+	if e.body, err = parseNotifyTemplate("smtp-body", opts.Body); err != nil {
+		return nil, nil, err
+	}
+	if e.hostname, err = os.Hostname(); err != nil {
+		e.hostname = "localhost"
+	}
+
+	var events []string
+	if len(e.to) > 0 {
+		events = append(events, e.events...)
+	}
+	for _, r := range e.routes {
+		if len(r.Events) == 0 {
+			events = append(events, e.events...)
+		}
+		events = append(events, r.Events...)
+	}
+	slices.Sort(events)
+	return e, slices.Compact(events), nil
+}
+
+// recipients returns who is sent n: -smtp-to for the events of
+// -smtp-events, or for every notification without them, and the recipients
+// of every route n matches.
+func (e *emailNotifier) recipients(n Notification) []string {
+	var to []string
+	if e.events == nil || slices.Contains(e.events, n.Event) {
+		to = append(to, e.to...)
+	}
+	for _, r := range e.routes {
+		if r.matches(n, e.events) {
+			to = append(to, r.To...)
+		}
+	}
+	for i, addr := range to {
+		if a, err := mail.ParseAddress(addr); err == nil {
+			to[i] = a.Address
+		}
+	}
+	slices.Sort(to)
+	return slices.Compact(to)
+}
+
+func (e *emailNotifier) notify(ctx context.Context, n Notification) error {
+	to := e.recipients(n)
+	if len(to) == 0 {
+		return nil
+	}
+	subject, err := renderNotification(e.subject, n)
+	if err != nil {
+		return err
+	}
+	body, err := renderNotification(e.body, n)
+	if err != nil {
+		return err
+	}
+	return e.send(ctx, to, e.message(to, subject, body))
+}
+
+// message returns the email with subject and body, a UTF-8 plain-text
+// message encoded as quoted-printable.
+func (e *emailNotifier) message(to []string, subject, body string) []byte {
+	id := make([]byte, 12)
+	rand.Read(id)
+	// Device names end up in the subject; keep them from adding headers.
+	subject = strings.Join(strings.Fields(subject), " ")
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), e.hostname)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&b)
+	qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+	qp.Close()
+	return b.Bytes()
+}
+
+// send delivers msg to the SMTP server for to.
+func (e *emailNotifier) send(ctx context.Context, to []string, msg []byte) error {
+	d := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", e.addr)
+	if err != nil {
+		return fmt.Errorf("connect to SMTP server: %v", err)
+	}
+	deadline := time.Now().Add(time.Minute)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+	if e.tlsMode == "tls" {
+		conn = tls.Client(conn, e.tls)
+	}
+	c, err := smtp.NewClient(conn, e.host)
+	if err != nil {
+		conn.Close()
+		return smtpError("connect to SMTP server", err)
+	}
+	defer c.Close()
+	if err := c.Hello(e.hostname); err != nil {
+		return smtpError("SMTP HELO", err)
+	}
+	if e.tlsMode == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return &permanentError{fmt.Errorf("SMTP server does not support STARTTLS; set -smtp-tls")}
+		}
+		if err := c.StartTLS(e.tls); err != nil {
+			return smtpError("SMTP STARTTLS", err)
+		}
+	}
+	if e.auth != nil {
+		if err := c.Auth(e.auth); err != nil {
+			var tpErr *textproto.Error
+			if errors.As(err, &tpErr) && tpErr.Code < 500 {
+				return fmt.Errorf("SMTP auth: %v", err)
+			}
+			return &permanentError{fmt.Errorf("SMTP auth: %v", err)}
+		}
+	}
+	if err := c.Mail(e.from.Address); err != nil {
+		return smtpError("SMTP MAIL FROM", err)
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr); err != nil {
+			return smtpError("SMTP RCPT TO "+addr, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return smtpError("SMTP DATA", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return smtpError("send email", err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError("send email", err)
+	}
+	return c.Quit()
+}
+
+// smtpError wraps err from step, marking the SMTP server's permanent
+// rejections, with 5xx reply codes, as such.
+func smtpError(step string, err error) error {
+	var tpErr *textproto.Error
+	if errors.As(err, &tpErr) && tpErr.Code >= 500 {
+		return &permanentError{fmt.Errorf("%s: %v", step, err)}
+	}
+	return fmt.Errorf("%s: %v", step, err)
+}
+
+func (e *emailNotifier) Close() error { return nil }
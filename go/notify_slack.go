@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// SlackOptions configures a slackNotifier.
+type SlackOptions struct {
+	// WebhookURL is a Slack incoming webhook.
+	WebhookURL string
+	// Channel and Username, when set, replace the webhook's defaults, for
+	// webhooks that allow it.
+	Channel  string
+	Username string
+	// Events are the comma-separated lifecycle events to post.
+	Events string
+	// Template is the Go template of the message text, executed with a
+	// Notification.
+	Template string
+}
+
+// slackFlags defines the flags of the Slack notifier on fs and returns a
+// function reading them once fs is parsed.
+func slackFlags(fs *flag.FlagSet) func() SlackOptions {
+	var (
+		flURL      = fs.String("slack-webhook-url", "", "Slack incoming webhook URL to post device lifecycle notifications to (disabled when empty)")
+		flChannel  = fs.String("slack-channel", "", "Slack channel to post to, e.g. #mdm, instead of the webhook's own")
+		flUsername = fs.String("slack-username", "", "name to post to Slack as, instead of the webhook's own")
+		flEvents   = fs.String("slack-events", strings.Join(notifyEvents, ","), "comma-separated lifecycle events to post to Slack: "+strings.Join(notifyEvents, ", "))
+		flTemplate = fs.String("slack-template", defaultNotifyTemplate, "Go template of the Slack message text, e.g. {{.Event}}: {{.Name}} {{.Serial}}")
+	)
+	return func() SlackOptions {
+		return SlackOptions{
+			WebhookURL: *flURL,
+			Channel:    *flChannel,
+			Username:   *flUsername,
+			Events:     *flEvents,
+			Template:   *flTemplate,
+		}
+	}
+}
+
+// slackNotifier posts notifications to a Slack incoming webhook.
+type slackNotifier struct {
+	url      string
+	channel  string
+	username string
+	text     *template.Template
+}
+
+// newSlackNotifier returns the notifier of opts and the events it posts.
+func newSlackNotifier(opts SlackOptions) (*slackNotifier, []string, error) {
+	if u, err := url.Parse(opts.WebhookURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, nil, fmt.Errorf("invalid -slack-webhook-url: want an http:// or https:// URL")
+	}
+	events, err := parseNotifyEvents("slack-events", opts.Events)
+	if err != nil {
+		return nil, nil, err
+	}
+	text, err := parseNotifyTemplate("slack-template", opts.Template)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &slackNotifier{url: opts.WebhookURL, channel: opts.Channel, username: opts.Username, text: text}, events, nil
+}
+
+type slackMessage struct {
+	Text     string `json:"text"`
+	Channel  string `json:"channel,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+func (sn *slackNotifier) notify(ctx context.Context, n Notification) error {
+	text, err := renderNotification(sn.text, n)
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, sn.url, slackMessage{Text: text, Channel: sn.channel, Username: sn.username})
+}
+
+func (sn *slackNotifier) Close() error { return nil }
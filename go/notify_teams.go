@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TeamsOptions configures a teamsNotifier.
+type TeamsOptions struct {
+	// WebhookURL is a Teams incoming webhook, or the URL of a Workflows
+	// "post to a channel when a webhook request is received" flow.
+	WebhookURL string
+	// Events are the comma-separated lifecycle events to post.
+	Events string
+	// Template is the Go template of the card's title, executed with a
+	// Notification.
+	Template string
+}
+
+// teamsFlags defines the flags of the Teams notifier on fs and returns a
+// function reading them once fs is parsed.
+func teamsFlags(fs *flag.FlagSet) func() TeamsOptions {
+	var (
+		flURL      = fs.String("teams-webhook-url", "", "Microsoft Teams incoming webhook URL to post device lifecycle notifications to (disabled when empty)")
+		flEvents   = fs.String("teams-events", strings.Join(notifyEvents, ","), "comma-separated lifecycle events to post to Teams: "+strings.Join(notifyEvents, ", "))
+		flTemplate = fs.String("teams-template", defaultNotifyTemplate, "Go template of the title of the Teams card")
+	)
+	return func() TeamsOptions {
+		return TeamsOptions{WebhookURL: *flURL, Events: *flEvents, Template: *flTemplate}
+	}
+}
+
+// teamsNotifier posts notifications to a Teams incoming webhook as Adaptive
+// Cards: a title rendered from a template over the facts of the event.
+type teamsNotifier struct {
+	url   string
+	title *template.Template
+}
+
+// newTeamsNotifier returns the notifier of opts and the events it posts.
+func newTeamsNotifier(opts TeamsOptions) (*teamsNotifier, []string, error) {
+	if u, err := url.Parse(opts.WebhookURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, nil, fmt.Errorf("invalid -teams-webhook-url: want an http:// or https:// URL")
+	}
+	events, err := parseNotifyEvents("teams-events", opts.Events)
+	if err != nil {
+		return nil, nil, err
+	}
+	title, err := parseNotifyTemplate("teams-template", opts.Template)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &teamsNotifier{url: opts.WebhookURL, title: title}, events, nil
+}
+
+// The parts of the Adaptive Card schema used by teamsNotifier; see
+// https://adaptivecards.io/explorer/.
+type (
+	teamsMessage struct {
+		Type        string            `json:"type"`
+		Attachments []teamsAttachment `json:"attachments"`
+	}
+	teamsAttachment struct {
+		ContentType string       `json:"contentType"`
+		Content     adaptiveCard `json:"content"`
+	}
+	adaptiveCard struct {
+		Schema  string         `json:"$schema"`
+		Type    string         `json:"type"`
+		Version string         `json:"version"`
+		Body    []adaptiveItem `json:"body"`
+	}
+	adaptiveItem struct {
+		Type   string         `json:"type"`
+		Text   string         `json:"text,omitempty"`
+		Weight string         `json:"weight,omitempty"`
+		Size   string         `json:"size,omitempty"`
+		Color  string         `json:"color,omitempty"`
+		Wrap   bool           `json:"wrap,omitempty"`
+		Facts  []adaptiveFact `json:"facts,omitempty"`
+	}
+	adaptiveFact struct {
+		Title string `json:"title"`
+		Value string `json:"value"`
+	}
+)
+
+// teamsCard returns the card for n, titled title.
+func teamsCard(title string, n Notification) teamsMessage {
+	var facts []adaptiveFact
+	add := func(name, value string) {
+		if value != "" {
+			facts = append(facts, adaptiveFact{Title: name, Value: value})
+		}
+	}
+	add("Tenant", n.Tenant)
+	add("Device", n.Name)
+	add("Serial number", n.Serial)
+	add("Model", n.Model)
+	add("UDID", n.UDID)
+	add("Command", n.RequestType)
+	add("Command UUID", n.CommandUUID)
+	if n.Event == notifyCommandError {
+		add("Error", n.Reason())
+	}
+	add("Time", n.Time.UTC().Format(time.RFC3339))
+
+	heading := adaptiveItem{Type: "TextBlock", Text: title, Weight: "Bolder", Size: "Medium", Wrap: true}
+	switch n.Event {
+	case notifyCommandError:
+		heading.Color = "Attention"
+	case notifyCheckedOut:
+		heading.Color = "Warning"
+	}
+	return teamsMessage{
+		Type: "message",
+		Attachments: []teamsAttachment{{
+			ContentType: "application/vnd.microsoft.card.adaptive",
+			Content: adaptiveCard{
+				Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+				Type:    "AdaptiveCard",
+				Version: "1.4",
+				Body:    []adaptiveItem{heading, {Type: "FactSet", Facts: facts}},
+			},
+		}},
+	}
+}
+
+func (tn *teamsNotifier) notify(ctx context.Context, n Notification) error {
+	title, err := renderNotification(tn.title, n)
+	if err != nil {
+		return err
+	}
+	return postNotification(ctx, tn.url, teamsCard(title, n))
+}
+
+func (tn *teamsNotifier) Close() error { return nil }
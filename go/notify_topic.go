@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// TopicNotifier sends a message rendered from a Go template for every event
+// of a topic. The template is executed with a Notification whose Event is
+// the topic.
+type TopicNotifier struct {
+	// Type is http, slack, or email.
+	Type string `yaml:"type" toml:"type"`
+
+	// URL is where http notifiers post, and the incoming webhook of slack
+	// ones.
+	URL string `yaml:"url" toml:"url"`
+	// Headers are added to the requests of http notifiers, sent with
+	// ContentType (by default application/json).
+	Headers     map[string]string `yaml:"headers" toml:"headers"`
+	ContentType string            `yaml:"content-type" toml:"content-type"`
+
+	// To receive the emails of email notifiers, titled from the Subject
+	// template and sent through the -smtp-addr server.
+	To      []string `yaml:"to" toml:"to"`
+	Subject string   `yaml:"subject" toml:"subject"`
+
+	// Template renders the body of requests and emails, or the text of
+	// Slack messages. By default, http notifiers post the notification as
+	// JSON, and the others use the defaults of -slack-template and
+	// -smtp-body.
+	Template string `yaml:"template" toml:"template"`
+}
+
+func (tn TopicNotifier) validate() error {
+	switch tn.Type {
+	case "http", "slack":
+		if u, err := url.Parse(tn.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("url must be an http or https URL")
+		}
+	case "email":
+		if len(tn.To) == 0 {
+			return fmt.Errorf("no recipients")
+		}
+		for _, to := range tn.To {
+			if _, err := mail.ParseAddress(to); err != nil {
+				return fmt.Errorf("recipient %q: %v", to, err)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown type %q, want http, slack, or email", tn.Type)
+	}
+	if _, err := template.New("template").Parse(tn.Template); err != nil {
+		return err
+	}
+	if _, err := template.New("subject").Parse(tn.Subject); err != nil {
+		return err
+	}
+	return nil
+}
+
+// addTopicNotifiers adds the notifiers configured for topics to s. Email
+// notifiers send through the SMTP server of smtp.
+func (s *Server) addTopicNotifiers(topics map[string]TopicConfig, smtp EmailOptions) error {
+	for topic, tc := range topics {
+		for i, tn := range tc.Notify {
+			name := fmt.Sprintf("%s/%s-%d", topic, tn.Type, i+1)
+			n, err := newTopicNotifier(name, tn, smtp)
+			if err != nil {
+				return fmt.Errorf("topics: %s: notify entry %d: %v", topic, i+1, err)
+			}
+			s.addNotifier(name, n, []string{topic})
+		}
+	}
+	return nil
+}
+
+// configEmails reports whether any topic or rule of fc, or its
+// decommission, has an email notifier.
+func configEmails(fc fileConfig) bool {
+	for _, tc := range fc.Topics {
+		for _, tn := range tc.Notify {
+			if tn.Type == "email" {
+				return true
+			}
+		}
+	}
+	for _, r := range fc.Rules {
+		for _, a := range r.Then {
+			if a.Notify != nil && a.Notify.Type == "email" {
+				return true
+			}
+		}
+	}
+	if dc := fc.Decommission; dc != nil {
+		for _, tn := range dc.Notify {
+			if tn.Type == "email" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newTopicNotifier(name string, tn TopicNotifier, smtp EmailOptions) (notifier, error) {
+	text := tn.Template
+	switch tn.Type {
+	case "http":
+		h := &httpNotifier{url: tn.URL, headers: tn.Headers, contentType: tn.ContentType}
+		if h.contentType == "" {
+			h.contentType = "application/json"
+		}
+		if text != "" {
+			var err error
+			if h.body, err = template.New(name).Option("missingkey=error").Parse(text); err != nil {
+				return nil, err
+			}
+		}
+		return h, nil
+	case "slack":
+		if text == "" {
+			text = defaultNotifyTemplate
+		}
+		t, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		return &slackNotifier{url: tn.URL, text: t}, nil
+	case "email":
+		if smtp.Addr == "" {
+			return nil, fmt.Errorf("email notifications require -smtp-addr")
+		}
+		opts := smtp
+		opts.To = strings.Join(tn.To, ",")
+		opts.Routes = nil
+		if tn.Subject != "" {
+			opts.Subject = tn.Subject
+		}
+		if text != "" {
+			opts.Body = text
+		}
+		e, _, err := newEmailNotifier(opts)
+		if err != nil {
+			return nil, err
+		}
+		// Email every notification sent, whatever -smtp-events says.
+		e.events = nil
+		return e, nil
+	}
+	return nil, fmt.Errorf("unknown type %q", tn.Type)
+}
+
+// httpNotifier posts notifications to a URL, rendered from a template or
+// as JSON.
+type httpNotifier struct {
+	url         string
+	headers     map[string]string
+	contentType string
+	body        *template.Template
+}
+
+func (h *httpNotifier) notify(ctx context.Context, n Notification) error {
+	var body []byte
+	if h.body == nil {
+		b, err := json.Marshal(n)
+		if err != nil {
+			return &permanentError{fmt.Errorf("encode notification: %v", err)}
+		}
+		body = b
+	} else {
+		text, err := renderNotification(h.body, n)
+		if err != nil {
+			return err
+		}
+		body = []byte(text)
+	}
+	return postMessage(ctx, h.url, h.contentType, h.headers, body)
+}
+
+func (h *httpNotifier) Close() error { return nil }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// deferredVars counts the commands devices answered NotNow (deferred), and
+// the pushes sent to wake the devices again (pushed) or that MicroMDM
+// failed (push_failed).
+var deferredVars = expvar.NewMap("deferred_commands")
+
+// defaultNotNowRetryPolicy pushes a device that deferred a command up to 5
+// times, about 5 minutes after its first NotNow, and twice as long after
+// each further one, up to an hour.
+var defaultNotNowRetryPolicy = RetryPolicy{MaxAttempts: 5, Backoff: 5 * time.Minute, MaxBackoff: time.Hour}
+
+// deferPending marks pending deferred by ack, a NotNow response. The command
+// stays queued in MicroMDM, which sends it again when the device next checks
+// in; unless the device has deferred it more than s.NotNowRetry.MaxAttempts
+// times, a push is scheduled after a backoff to make it check in.
+func (s *Server) deferPending(ctx context.Context, pending PendingCommand, ack acknowledgment) {
+	deferredVars.Add("deferred", 1)
+	pending.Deferrals++
+	pending.DeferredAt, pending.PushAt = &ack.Time, nil
+	logger := logFor(ctx).WithFields(logrus.Fields{"request_type": pending.RequestType, "deferrals": pending.Deferrals})
+	if pending.Deferrals > s.NotNowRetry.MaxAttempts {
+		s.Pending.Add(pending)
+		logger.Warn("device deferred command; waiting for its next check-in")
+		return
+	}
+	wait := s.NotNowRetry.delay(pending.Deferrals)
+	pushAt := ack.Time.Add(wait)
+	pending.PushAt = &pushAt
+	s.Pending.Add(pending)
+	logger.WithField("push_in", wait.String()).Info("device deferred command")
+	ctx = context.WithoutCancel(ctx)
+	time.AfterFunc(wait, func() { s.pushDeferred(ctx, pending.UUID, pending.Deferrals) })
+}
+
+// pushDeferred pushes the device of the pending command with the given
+// UUID, or the user channel it was sent on, deferred for the deferrals'th
+// time, unless the device has answered the command again since.
+func (s *Server) pushDeferred(ctx context.Context, uuid string, deferrals int) {
+	pending, ok := s.Pending.Get(uuid)
+	if !ok || pending.Deferrals != deferrals {
+		return
+	}
+	logger := logFor(ctx).WithFields(logrus.Fields{"udid": pending.UDID, "request_type": pending.RequestType, "deferrals": deferrals})
+	if _, err := s.pushDevice(ctx, pending.UDID, pending.UserID); err != nil {
+		deferredVars.Add("push_failed", 1)
+		logger.WithError(err).Error("push device to retry deferred command")
+		return
+	}
+	deferredVars.Add("pushed", 1)
+	logger.Info("pushed device to retry deferred command")
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// osqueryVars counts the Macs sent the osquery enrollment profile (profiles)
+// and agent package (packages), those linked to their Fleet host (linked) and
+// moved to the blueprint's team (transferred), and the failed Fleet API
+// requests (link_failures).
+var osqueryVars = expvar.NewMap("osquery")
+
+// osqueryProfileIdentifier is the PayloadIdentifier of the generated fleetd
+// enrollment profile.
+const osqueryProfileIdentifier = "com.fleetdm.fleetd.config"
+
+// osqueryLinkInterval is how often the Macs not yet linked to their Fleet
+// host are looked up, and osqueryLinkWindow how long after their enrollment
+// they are.
+const (
+	osqueryLinkInterval = 5 * time.Minute
+	osqueryLinkWindow   = 7 * 24 * time.Hour
+)
+
+// fleetTimeout limits how long each Fleet API request may take.
+const fleetTimeout = 30 * time.Second
+
+// fleetClient calls the API of Fleet servers.
+var fleetClient = &http.Client{Timeout: fleetTimeout, Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// BlueprintOsquery enrolls the Macs a blueprint sets up in osquery: a profile
+// configuring fleetd, Fleet's osquery agent, is installed after the
+// blueprint's profiles, and then the agent with InstallEnterpriseApplication,
+// ahead of the blueprint's apps. Once the agent has enrolled, the Mac is
+// linked to its host in Fleet. It is skipped on other platforms.
+type BlueprintOsquery struct {
+	// Profile, if set, is the path of an enrollment profile, relative to
+	// the config file, installed instead of the one generated from the
+	// Fleet URL and enroll secret.
+	Profile string `yaml:"profile"`
+
+	// Package, a package of -app-dir, or ManifestURL is the agent, e.g. a
+	// fleetd package built with fleetctl package --use-system-configuration
+	// to read its enroll secret and Fleet URL from the profile.
+	Package     string `yaml:"package"`
+	ManifestURL string `yaml:"manifest-url"`
+
+	// Fleet is the Fleet server the agent enrolls with.
+	Fleet *Fleet `yaml:"fleet"`
+
+	// profile holds the contents of Profile.
+	profile *profile
+}
+
+// Fleet is a Fleet server, formerly Kolide Fleet, managing osquery.
+type Fleet struct {
+	// URL is the base URL of the server, e.g. https://fleet.example.com.
+	URL string `yaml:"url"`
+
+	// EnrollSecret, if set, is the enroll secret of the generated profile.
+	// A team's enroll secret enrolls the Macs in the team.
+	EnrollSecret string `yaml:"enroll-secret"`
+
+	// APIToken, if set, is the token of a Fleet API user the Macs' hosts
+	// are looked up with once enrolled, to link them to the Macs. Observers
+	// can look them up; transferring them takes a maintainer.
+	APIToken string `yaml:"api-token"`
+
+	// TeamID, if set, is the team the linked hosts are transferred to.
+	TeamID uint `yaml:"team-id"`
+}
+
+func (o *BlueprintOsquery) validate() error {
+	f := o.Fleet
+	if o.Profile == "" && o.Package == "" && o.ManifestURL == "" && (f == nil || f.EnrollSecret == "") {
+		return fmt.Errorf("osquery: set a profile, a package or manifest-url, or the fleet enroll-secret")
+	}
+	if o.Package != "" && o.ManifestURL != "" {
+		return fmt.Errorf("osquery: set at most one of package or manifest-url")
+	}
+	if f == nil {
+		return nil
+	}
+	if o.Profile != "" && f.EnrollSecret != "" {
+		return fmt.Errorf("osquery: set at most one of profile or fleet enroll-secret")
+	}
+	u, err := url.Parse(f.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("osquery: invalid fleet url %q", f.URL)
+	}
+	if f.TeamID != 0 && f.APIToken == "" {
+		return fmt.Errorf("osquery: fleet team-id needs an api-token to transfer hosts with")
+	}
+	return nil
+}
+
+// load reads the profile, resolving a relative path against dir.
+func (o *BlueprintOsquery) load(dir string) error {
+	if o.Profile == "" {
+		return nil
+	}
+	p, err := loadProfile(o.Profile, dir)
+	if err != nil {
+		return fmt.Errorf("osquery: %v", err)
+	}
+	o.profile = p
+	return nil
+}
+
+// hasProfile reports whether the Macs are sent an enrollment profile.
+func (o *BlueprintOsquery) hasProfile() bool {
+	return o.profile != nil || o.Fleet != nil && o.Fleet.EnrollSecret != ""
+}
+
+// render returns the generated enrollment profile, which fleetd reads its
+// Fleet URL and enroll secret from.
+func (o *BlueprintOsquery) render() ([]byte, error) {
+	return generatedProfile(osqueryProfileIdentifier, "Fleet osquery", payloadDict{
+		"PayloadType":       "com.fleetdm.fleetd.config",
+		"PayloadIdentifier": osqueryProfileIdentifier + ".fleetd",
+		"EnrollSecret":      o.Fleet.EnrollSecret,
+		"FleetURL":          strings.TrimSuffix(o.Fleet.URL, "/"),
+	})
+}
+
+// profileIdentifier returns the PayloadIdentifier of the enrollment profile
+// of o as installed on the Mac of v.
+func (o *BlueprintOsquery) profileIdentifier(v profileVars) (string, error) {
+	if o.profile == nil {
+		return osqueryProfileIdentifier, nil
+	}
+	payload, err := o.profile.render(v)
+	if err != nil {
+		return "", err
+	}
+	return profileIdentifier(payload)
+}
+
+// osqueryProfileCommand returns the InstallProfile command installing the
+// enrollment profile of o on d, signed if profiles are signed.
+func (s *Server) osqueryProfileCommand(ctx context.Context, d Device, o *BlueprintOsquery) (Command, error) {
+	if o.profile != nil {
+		return s.installProfileCommand(ctx, d, "", o.profile)
+	}
+	payload, err := o.render()
+	if err != nil {
+		return Command{}, err
+	}
+	if payload, err = s.signProfile(ctx, payload); err != nil {
+		return Command{}, fmt.Errorf("osquery profile: %v", err)
+	}
+	// The profile carries the enroll secret.
+	return Command{UDID: d.UDID, RequestType: "InstallProfile", Payload: payload, SecretPayload: true}, nil
+}
+
+// osqueryCommands returns the commands enrolling d, a Mac set up with a
+// blueprint with o, in osquery.
+func (s *Server) osqueryCommands(ctx context.Context, d Device, o *BlueprintOsquery) []Command {
+	var commands []Command
+	if o.hasProfile() {
+		if c, err := s.osqueryProfileCommand(ctx, d, o); err != nil {
+			logFor(ctx).WithError(err).Error("enroll in osquery")
+		} else {
+			commands = append(commands, c)
+			osqueryVars.Add("profiles", 1)
+		}
+	}
+	if o.Package != "" || o.ManifestURL != "" {
+		if c, err := s.packageCommand(d, o.Package, o.ManifestURL); err != nil {
+			logFor(ctx).WithField("package", o.Package).WithError(err).Error("enroll in osquery")
+		} else {
+			commands = append(commands, c)
+			osqueryVars.Add("packages", 1)
+		}
+	}
+	fields := logrus.Fields{"profile": o.Profile, "package": o.Package}
+	if o.Fleet != nil {
+		fields["fleet"] = o.Fleet.URL
+	}
+	logFor(ctx).WithFields(fields).Info("enrolling in osquery")
+	return commands
+}
+
+// hasFleetLinks reports whether any blueprint links Macs to their Fleet
+// hosts.
+func (s *Server) hasFleetLinks() bool {
+	for _, b := range s.Blueprints {
+		if b.Osquery != nil && b.Osquery.Fleet != nil && b.Osquery.Fleet.APIToken != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// osqueryLoop periodically links the Macs enrolled in osquery to their Fleet
+// hosts.
+func (s *Server) osqueryLoop(ctx context.Context) {
+	ticker := time.NewTicker(osqueryLinkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.linkFleetHosts(ctx)
+	}
+}
+
+// linkFleetHosts looks up in Fleet the hosts of the Macs sent the osquery
+// agent within osqueryLinkWindow and not yet linked, by their UDID, which is
+// the hardware UUID osquery reports, or else their serial number. Those
+// found have the host's Fleet and osquery IDs recorded and, if the
+// blueprint has a team, are transferred to it.
+func (s *Server) linkFleetHosts(ctx context.Context) {
+	devices, err := s.Devices.List()
+	if err != nil {
+		logrus.WithError(err).Error("list devices for Fleet")
+		return
+	}
+	now := time.Now().UTC()
+	for _, d := range devices {
+		o := d.Osquery
+		if o == nil || o.LinkedAt != nil || !d.Enrolled || isRetired(d) || now.Sub(o.EnrolledAt) > osqueryLinkWindow {
+			continue
+		}
+		b := s.blueprintNamed(d.Blueprint)
+		if b == nil || b.Osquery == nil || b.Osquery.Fleet == nil || b.Osquery.Fleet.APIToken == "" {
+			continue
+		}
+		f := b.Osquery.Fleet
+		logger := logFor(ctx).WithFields(logrus.Fields{"udid": d.UDID, "fleet": f.URL})
+		var h *fleetHost
+		for _, id := range []string{d.UDID, profileSerial(d)} {
+			if id == "" {
+				continue
+			}
+			if h, err = f.host(ctx, id); err != nil || h != nil {
+				break
+			}
+		}
+		if err != nil {
+			osqueryVars.Add("link_failures", 1)
+			logger.WithError(err).Error("look up Fleet host")
+			continue
+		}
+		if h == nil {
+			// The agent has not enrolled yet.
+			continue
+		}
+		if f.TeamID != 0 && (h.TeamID == nil || *h.TeamID != f.TeamID) {
+			if err := f.transfer(ctx, h.ID); err != nil {
+				osqueryVars.Add("link_failures", 1)
+				logger.WithError(err).WithField("team_id", f.TeamID).Error("transfer Fleet host")
+				continue
+			}
+			osqueryVars.Add("transferred", 1)
+		}
+		d.Osquery = &store.OsqueryEnrollment{EnrolledAt: o.EnrolledAt, FleetHostID: h.ID, OsqueryHostID: h.OsqueryHostID, LinkedAt: &now}
+		if err := s.Devices.Save(d); err != nil {
+			logger.WithError(err).Error("save device")
+			continue
+		}
+		osqueryVars.Add("linked", 1)
+		logger.WithFields(logrus.Fields{"fleet_host_id": h.ID, "osquery_host_id": h.OsqueryHostID}).Info("linked device to its Fleet host")
+	}
+}
+
+// fleetHost is a host as Fleet's API describes it.
+type fleetHost struct {
+	ID            uint   `json:"id"`
+	OsqueryHostID string `json:"osquery_host_id"`
+	TeamID        *uint  `json:"team_id"`
+}
+
+// host returns the host with the identifier, a UUID, serial number, osquery
+// host ID, or hostname, or nil if Fleet has none.
+func (f *Fleet) host(ctx context.Context, identifier string) (*fleetHost, error) {
+	var resp struct {
+		Host fleetHost `json:"host"`
+	}
+	status, err := f.call(ctx, "GET", "/api/v1/fleet/hosts/identifier/"+url.PathEscape(identifier), nil, &resp)
+	if status == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &resp.Host, nil
+}
+
+// transfer moves the host with the Fleet ID to f.TeamID.
+func (f *Fleet) transfer(ctx context.Context, hostID uint) error {
+	body := map[string]interface{}{"team_id": f.TeamID, "hosts": []uint{hostID}}
+	_, err := f.call(ctx, "POST", "/api/v1/fleet/hosts/transfer", body, nil)
+	return err
+}
+
+// call makes a Fleet API request with the JSON of body, if it is not nil,
+// decoding the response into v, if it is not nil. It returns the response's
+// status code, and an error unless it is 200.
+func (f *Fleet) call(ctx context.Context, method, path string, body, v interface{}) (int, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, fmt.Errorf("encode Fleet request: %v", err)
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(f.URL, "/")+path, r)
+	if err != nil {
+		return 0, fmt.Errorf("create Fleet request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+f.APIToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := fleetClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.StatusCode, fmt.Errorf("Fleet returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return resp.StatusCode, fmt.Errorf("decode Fleet response: %v", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// osUpdateVars counts the OS updates scheduled through the admin API, those
+// sent again at their deadline, and those completed.
+var osUpdateVars = expvar.NewMap("os_updates")
+
+// installActions are the InstallAction values of ScheduleOSUpdate.
+var installActions = []string{"Default", "DownloadOnly", "NotifyOnly", "InstallASAP", "InstallLater", "InstallForceRestart"}
+
+// OSUpdateRequest schedules one of the updates a device reported as
+// available.
+type OSUpdateRequest struct {
+	ProductKey string `json:"product_key"`
+	// InstallAction is one of installActions, Default if empty.
+	InstallAction string `json:"install_action,omitempty"`
+	// Deadline, if set, is when the update is sent again to be installed at
+	// once unless it completed, with InstallForceRestart on Macs and
+	// InstallASAP on other devices.
+	Deadline *time.Time `json:"deadline,omitempty"`
+}
+
+func (r *OSUpdateRequest) validate() error {
+	if r.ProductKey == "" {
+		return fmt.Errorf("no product_key")
+	}
+	if r.InstallAction == "" {
+		r.InstallAction = "Default"
+	}
+	if !slices.Contains(installActions, r.InstallAction) {
+		return fmt.Errorf("invalid install_action %q, want one of %s", r.InstallAction, strings.Join(installActions, ", "))
+	}
+	if r.Deadline != nil && !r.Deadline.After(time.Now()) {
+		return fmt.Errorf("deadline %s has passed", r.Deadline.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// osUpdateCommand returns the ScheduleOSUpdate command installing the update
+// productKey on the device udid.
+func osUpdateCommand(udid, productKey, installAction string) Command {
+	return Command{UDID: udid, RequestType: "ScheduleOSUpdate", Updates: []OSUpdate{{ProductKey: productKey, InstallAction: installAction}}}
+}
+
+// osUpdatesOf returns d's OS updates, adding them if d has none yet.
+func osUpdatesOf(d *Device) *store.OSUpdates {
+	if d.OSUpdates == nil {
+		d.OSUpdates = &store.OSUpdates{}
+	}
+	return d.OSUpdates
+}
+
+// handleScheduleOSUpdate sends a device a ScheduleOSUpdate command for an
+// update it reported as available, and tracks the update's progress. The
+// body is a JSON OSUpdateRequest.
+func (s *Server) handleScheduleOSUpdate(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	var req OSUpdateRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandBody)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := req.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Updates are known complete once the device stops listing them, so
+	// only those it listed can be tracked.
+	if d.OSUpdates == nil || !slices.ContainsFunc(d.OSUpdates.Available, func(u store.AvailableOSUpdate) bool { return u.ProductKey == req.ProductKey }) {
+		http.Error(w, fmt.Sprintf("%s is not among the updates the device reported as available; send it AvailableOSUpdates first", req.ProductKey), http.StatusConflict)
+		return
+	}
+
+	c := osUpdateCommand(d.UDID, req.ProductKey, req.InstallAction)
+	uuid, err := s.postCommand(r.Context(), c.UDID, c.RequestType, c)
+	if err != nil {
+		logFor(r.Context()).WithFields(logrus.Fields{"udid": c.UDID, "request_type": c.RequestType}).WithError(err).Error("send command")
+		http.Error(w, fmt.Sprintf("send command: %v", err), http.StatusBadGateway)
+		return
+	}
+	u := osUpdatesOf(&d)
+	u.Scheduled = slices.DeleteFunc(u.Scheduled, func(su store.ScheduledOSUpdate) bool { return su.ProductKey == req.ProductKey })
+	u.Scheduled = append(u.Scheduled, store.ScheduledOSUpdate{
+		ProductKey:    req.ProductKey,
+		InstallAction: req.InstallAction,
+		ScheduledAt:   time.Now().UTC(),
+		Deadline:      req.Deadline,
+	})
+	if err := s.Devices.Save(d); err != nil {
+		logFor(r.Context()).WithError(err).Error("save scheduled OS update")
+	}
+	osUpdateVars.Add("scheduled", 1)
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"command_uuid": uuid,
+		"request_type": c.RequestType,
+		"udid":         c.UDID,
+	})
+}
+
+func (s *Server) applyAvailableOSUpdates(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	available, err := webhook.ParseAvailableOSUpdates(ack.Raw)
+	if err != nil {
+		return false, err
+	}
+	u := osUpdatesOf(d)
+	u.Available = available
+	u.UpdatedAt = ack.Time
+	for i := range u.Scheduled {
+		su := &u.Scheduled[i]
+		if su.CompletedAt != nil || !ack.Time.After(su.ScheduledAt) {
+			continue
+		}
+		if !slices.ContainsFunc(available, func(a store.AvailableOSUpdate) bool { return a.ProductKey == su.ProductKey }) {
+			su.CompletedAt = &ack.Time
+			osUpdateVars.Add("completed", 1)
+			logFor(ctx).WithField("product_key", su.ProductKey).Info("OS update completed")
+		}
+	}
+	logFor(ctx).WithField("updates", len(available)).Info("device reported available OS updates")
+	return true, nil
+}
+
+// applyOSUpdateStatus records the progress of scheduled updates reported in
+// OSUpdateStatus and ScheduleOSUpdate responses.
+func (s *Server) applyOSUpdateStatus(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	progress, err := webhook.ParseOSUpdateStatus(ack.Raw)
+	if err != nil {
+		return false, err
+	}
+	if d.OSUpdates == nil {
+		return false, nil
+	}
+	changed := false
+	for _, p := range progress {
+		i := slices.IndexFunc(d.OSUpdates.Scheduled, func(su store.ScheduledOSUpdate) bool { return su.ProductKey == p.ProductKey })
+		if i < 0 {
+			continue
+		}
+		su := &d.OSUpdates.Scheduled[i]
+		su.Status, su.IsDownloaded, su.DownloadPercent = p.Status, p.IsDownloaded, p.DownloadPercentComplete
+		su.UpdatedAt = &ack.Time
+		changed = true
+		logFor(ctx).WithFields(logrus.Fields{"product_key": p.ProductKey, "status": p.Status, "download_percent": p.DownloadPercentComplete}).Info("device reported OS update progress")
+	}
+	return changed, nil
+}
+
+// osUpdateLoop periodically asks the devices with scheduled updates that
+// have not completed for their progress, and sends updates again whose
+// deadline passed.
+func (s *Server) osUpdateLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.checkOSUpdates(ctx)
+	}
+}
+
+func (s *Server) checkOSUpdates(ctx context.Context) {
+	devices, err := s.Devices.List()
+	if err != nil {
+		logrus.WithError(err).Error("list devices for OS updates")
+		return
+	}
+	now := time.Now()
+	for _, d := range devices {
+		if d.OSUpdates == nil {
+			continue
+		}
+		logger := logrus.WithField("udid", d.UDID)
+		open, forced := false, false
+		for i := range d.OSUpdates.Scheduled {
+			su := &d.OSUpdates.Scheduled[i]
+			if su.CompletedAt != nil {
+				continue
+			}
+			open = true
+			if su.Deadline == nil || su.Forced || now.Before(*su.Deadline) {
+				continue
+			}
+			action := "InstallASAP"
+			if mac, _ := isMac(d); mac {
+				action = "InstallForceRestart"
+			}
+			logger.WithFields(logrus.Fields{"product_key": su.ProductKey, "deadline": *su.Deadline, "install_action": action}).Warn("OS update deadline passed")
+			// An update whose command was not sent is forced on the
+			// next check.
+			if err := s.sendCommand(ctx, osUpdateCommand(d.UDID, su.ProductKey, action)); err != nil {
+				continue
+			}
+			su.Forced, forced = true, true
+			osUpdateVars.Add("forced", 1)
+		}
+		if forced {
+			if err := s.Devices.Save(d); err != nil {
+				logger.WithError(err).Error("save forced OS update")
+			}
+		}
+		if open {
+			s.sendCommandToDevice(ctx, d, "OSUpdateStatus")
+			s.sendCommandToDevice(ctx, d, "AvailableOSUpdates")
+		}
+	}
+}
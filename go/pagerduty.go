@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// pagerDutyCheckInterval is how often the conditions PagerDuty is told about
+// are checked.
+const pagerDutyCheckInterval = 30 * time.Second
+
+// PagerDutyOptions configures a pagerDuty monitor.
+type PagerDutyOptions struct {
+	// RoutingKey is the integration key of a PagerDuty service's Events
+	// API v2 integration.
+	RoutingKey string
+	URL        string
+
+	// Unreachable is how long a MicroMDM server must fail its health check
+	// before an incident is triggered.
+	Unreachable time.Duration
+	// DecodeFailures events or command responses failing to decode within
+	// Window trigger an incident; zero disables it. Queue overflow
+	// incidents are resolved once no event or command has been dropped for
+	// Window.
+	DecodeFailures int
+	Window         time.Duration
+}
+
+// pagerDutyFlags defines the flags of the PagerDuty monitor on fs and
+// returns a function reading them once fs is parsed.
+func pagerDutyFlags(fs *flag.FlagSet) func() PagerDutyOptions {
+	var (
+		flKey      = fs.String("pagerduty-routing-key", "", "PagerDuty Events API v2 integration key to trigger incidents about operational failures with (disabled when empty)")
+		flURL      = fs.String("pagerduty-events-url", "https://events.pagerduty.com/v2/enqueue", "PagerDuty Events API v2 endpoint")
+		flDown     = fs.Duration("pagerduty-unreachable-after", 5*time.Minute, "trigger an incident once MicroMDM has been unreachable for this long")
+		flFailures = fs.Int("pagerduty-decode-failures", 10, "trigger an incident once this many events or command responses fail to decode within -pagerduty-window (0 disables it)")
+		flWindow   = fs.Duration("pagerduty-window", 5*time.Minute, "window decode failures are counted over, and how long queues must go without dropping anything before their incident is resolved")
+	)
+	return func() PagerDutyOptions {
+		return PagerDutyOptions{
+			RoutingKey:     *flKey,
+			URL:            *flURL,
+			Unreachable:    *flDown,
+			DecodeFailures: *flFailures,
+			Window:         *flWindow,
+		}
+	}
+}
+
+// pagerDuty triggers PagerDuty incidents while MicroMDM is unreachable,
+// decode failures spike, or queues overflow, and resolves them once the
+// condition has cleared. Each condition has a dedup key of its own, so it is
+// one incident however long it lasts.
+type pagerDuty struct {
+	opts    PagerDutyOptions
+	servers []*Server
+	source  string
+
+	// dropped returns how many events and commands the queues have turned
+	// away so far.
+	dropped func() int64
+
+	// The state of the conditions, used only by the monitor goroutine.
+	down      map[string]time.Time // by dedup key: when MicroMDM was first found unreachable
+	open      map[string]bool      // dedup keys of the incidents triggered
+	decodes   []decodeSample
+	drops     int64
+	droppedAt time.Time
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// decodeSample is the count of decode failures at a time.
+type decodeSample struct {
+	t time.Time
+	n int64
+}
+
+func newPagerDuty(opts PagerDutyOptions, servers []*Server, dropped func() int64) (*pagerDuty, error) {
+	if u, err := url.Parse(opts.URL); err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("invalid -pagerduty-events-url: want an http:// or https:// URL")
+	}
+	if opts.Window <= 0 {
+		return nil, fmt.Errorf("-pagerduty-window must be positive")
+	}
+	source, err := os.Hostname()
+	if err != nil {
+		source = "micromdm-webhook"
+	}
+	return &pagerDuty{
+		opts:    opts,
+		servers: servers,
+		source:  source,
+		dropped: dropped,
+		down:    make(map[string]time.Time),
+		open:    make(map[string]bool),
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+// start checks the conditions every pagerDutyCheckInterval until Close is
+// called.
+func (p *pagerDuty) start() {
+	p.drops = p.dropped()
+	p.decodes = []decodeSample{{t: time.Now(), n: decodeFailures.Value()}}
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+		t := time.NewTicker(pagerDutyCheckInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case now := <-t.C:
+				p.check(now)
+			}
+		}
+	}()
+}
+
+func (p *pagerDuty) check(now time.Time) {
+	for _, s := range p.servers {
+		if s.MDMServerURL != "" {
+			p.checkMicroMDM(now, s)
+		}
+	}
+	if p.opts.DecodeFailures > 0 {
+		p.checkDecodes(now)
+	}
+	p.checkDrops(now)
+}
+
+func (p *pagerDuty) checkMicroMDM(now time.Time, s *Server) {
+	key := "micromdm-webhook/micromdm-unreachable"
+	if s.Tenant != "" {
+		key += "/" + s.Tenant
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+	err := s.pingMicroMDM(ctx)
+	cancel()
+	if err == nil {
+		delete(p.down, key)
+		p.resolve(key)
+		return
+	}
+	since, ok := p.down[key]
+	if !ok {
+		p.down[key], since = now, now
+	}
+	if now.Sub(since) >= p.opts.Unreachable {
+		details := map[string]interface{}{"since": since.UTC().Format(time.RFC3339), "error": err.Error()}
+		if s.Tenant != "" {
+			details["tenant"] = s.Tenant
+		}
+		p.trigger(key, "critical", fmt.Sprintf("MicroMDM at %s has failed health checks for %s: %v", s.MDMServerURL, now.Sub(since).Round(time.Second), err), details)
+	}
+}
+
+// checkDecodes keeps the counts of decode failures of the last window, and
+// the one from before it to count from.
+func (p *pagerDuty) checkDecodes(now time.Time) {
+	key := "micromdm-webhook/decode-failures"
+	p.decodes = append(p.decodes, decodeSample{t: now, n: decodeFailures.Value()})
+	for len(p.decodes) > 1 && now.Sub(p.decodes[1].t) >= p.opts.Window {
+		p.decodes = p.decodes[1:]
+	}
+	failures := p.decodes[len(p.decodes)-1].n - p.decodes[0].n
+	if failures >= int64(p.opts.DecodeFailures) {
+		p.trigger(key, "error", fmt.Sprintf("%d webhook events or command responses failed to decode in %s", failures, p.opts.Window), map[string]interface{}{
+			"failures": failures,
+		})
+	} else {
+		p.resolve(key)
+	}
+}
+
+func (p *pagerDuty) checkDrops(now time.Time) {
+	key := "micromdm-webhook/queue-overflow"
+	drops := p.dropped()
+	if drops > p.drops {
+		p.trigger(key, "error", fmt.Sprintf("queues full: %d events or commands dropped", drops-p.drops), map[string]interface{}{
+			"dropped": drops - p.drops,
+		})
+		p.droppedAt = now
+		p.drops = drops
+	} else if now.Sub(p.droppedAt) >= p.opts.Window {
+		p.resolve(key)
+	}
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Component     string                 `json:"component"`
+	CustomDetails map[string]interface{} `json:"custom_details,omitempty"`
+}
+
+// trigger opens the incident of key, once. Failures are tried again at the
+// next check.
+func (p *pagerDuty) trigger(key, severity, summary string, details map[string]interface{}) {
+	if p.open[key] {
+		return
+	}
+	err := p.send(pagerDutyEvent{EventAction: "trigger", DedupKey: key, Payload: &pagerDutyPayload{
+		Summary:       summary,
+		Source:        p.source,
+		Severity:      severity,
+		Component:     "micromdm-webhook",
+		CustomDetails: details,
+	}})
+	logger := logrus.WithFields(logrus.Fields{"dedup_key": key, "summary": summary})
+	if err != nil {
+		logger.WithError(err).Error("trigger PagerDuty incident")
+		return
+	}
+	logger.Warn("triggered PagerDuty incident")
+	p.open[key] = true
+}
+
+// resolve closes the incident of key, if it was triggered.
+func (p *pagerDuty) resolve(key string) {
+	if !p.open[key] {
+		return
+	}
+	if err := p.send(pagerDutyEvent{EventAction: "resolve", DedupKey: key}); err != nil {
+		logrus.WithError(err).WithField("dedup_key", key).Error("resolve PagerDuty incident")
+		return
+	}
+	logrus.WithField("dedup_key", key).Info("resolved PagerDuty incident")
+	delete(p.open, key)
+}
+
+func (p *pagerDuty) send(e pagerDutyEvent) error {
+	e.RoutingKey = p.opts.RoutingKey
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return postNotification(ctx, p.opts.URL, e)
+}
+
+// Close stops the checks. Open incidents stay open.
+func (p *pagerDuty) Close() error {
+	close(p.stop)
+	p.done.Wait()
+	return nil
+}
+
+// dropped returns how many commands and events the queues of s have turned
+// away: its command queue, forwarder, sinks, and notifiers, which its
+// tenants share.
+func (s *Server) dropped() int64 {
+	var n int64
+	if s.Queue != nil {
+		n += s.Queue.dropped.Value()
+	}
+	if s.Forward != nil {
+		for _, t := range s.Forward.targets {
+			n += t.dropped.Value()
+		}
+	}
+	if s.Sinks != nil {
+		for _, q := range s.Sinks.queues {
+			n += q.dropped.Value()
+		}
+	}
+	if s.Notifiers != nil {
+		for _, q := range s.Notifiers.queues {
+			n += q.dropped.Value()
+		}
+	}
+	return n
+}
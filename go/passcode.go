@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/groob/plist"
+)
+
+// unlockToken returns the UnlockToken of a TokenUpdate message, which iOS
+// devices send once after enrolling, or nil.
+func unlockToken(raw []byte) []byte {
+	var msg struct{ UnlockToken []byte }
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := plist.Unmarshal(raw, &msg); err != nil {
+		return nil
+	}
+	return msg.UnlockToken
+}
+
+// escrowUnlockToken seals the UnlockToken of the TokenUpdate message raw
+// into d, for ClearPasscode commands. Messages without one leave the stored
+// token alone, since devices do not send it again.
+func (s *Server) escrowUnlockToken(d *Device, raw []byte) error {
+	token := unlockToken(raw)
+	if len(token) == 0 {
+		return nil
+	}
+	if s.Escrow == nil {
+		return fmt.Errorf("storing UnlockTokens requires an escrow key; set -escrow-key")
+	}
+	sealed, err := s.Escrow.seal(d.UDID, string(token))
+	if err != nil {
+		return fmt.Errorf("seal UnlockToken: %v", err)
+	}
+	d.UnlockToken = sealed
+	return nil
+}
+
+// checkClearPasscode returns an error if a ClearPasscode command cannot be
+// sent to d: Macs have no passcode to clear, and other devices need the
+// UnlockToken they sent when enrolling.
+func checkClearPasscode(d Device) error {
+	if mac, _ := isMac(d); mac {
+		return fmt.Errorf("ClearPasscode is not supported on Macs")
+	}
+	if len(d.UnlockToken) == 0 {
+		return fmt.Errorf("no UnlockToken is stored for device %s", d.UDID)
+	}
+	return nil
+}
+
+// handleClearPasscode sends a device a ClearPasscode command with its
+// escrowed UnlockToken. The command is sent at once rather than through
+// s.Queue and is not kept to be retried, and dead letters and logs leave the
+// token out, so it is only kept in the escrow. Every request is logged with
+// who made it.
+func (s *Server) handleClearPasscode(w http.ResponseWriter, r *http.Request) {
+	if s.Escrow == nil {
+		http.Error(w, "UnlockToken escrow is not configured; set -escrow-key", http.StatusServiceUnavailable)
+		return
+	}
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	if err := checkClearPasscode(d); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	token, err := s.Escrow.open(d.UDID, d.UnlockToken)
+	if err != nil {
+		logFor(r.Context()).WithField("udid", d.UDID).WithError(err).Error("open escrowed UnlockToken")
+		http.Error(w, fmt.Sprintf("open escrowed UnlockToken: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logFor(r.Context()).WithFields(auditFields(r)).WithField("udid", d.UDID).Warn("ClearPasscode requested")
+	s.postAPICommand(w, r, Command{UDID: d.UDID, RequestType: "ClearPasscode", UnlockToken: []byte(token)})
+}
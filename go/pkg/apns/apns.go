@@ -0,0 +1,206 @@
+// Package apns sends MDM push notifications through Apple's Push
+// Notification service.
+package apns
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// The base URLs of APNs.
+const (
+	ProductionURL  = "https://api.push.apple.com"
+	DevelopmentURL = "https://api.sandbox.push.apple.com"
+)
+
+// Client sends MDM pushes to APNs over HTTP/2, authenticated either with the
+// TLS client certificate of HTTP or with the tokens of Signer.
+type Client struct {
+	// URL is ProductionURL, DevelopmentURL, or another base URL.
+	URL string
+
+	// HTTP makes the requests. For certificate authentication, it presents
+	// the MDM push certificate.
+	HTTP *http.Client
+
+	// Signer, if set, signs the provider tokens requests are made with.
+	Signer *TokenSigner
+}
+
+// NewCertClient returns a Client authenticated with the PEM MDM push
+// certificate and private key in the given files.
+func NewCertClient(url, certFile, keyFile string) (*Client, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load APNs certificate: %v", err)
+	}
+	return &Client{URL: url, HTTP: newHTTPClient(&tls.Config{Certificates: []tls.Certificate{pair}})}, nil
+}
+
+// NewTokenClient returns a Client authenticated with tokens signed by s.
+func NewTokenClient(url string, s *TokenSigner) *Client {
+	return &Client{URL: url, HTTP: newHTTPClient(&tls.Config{}), Signer: s}
+}
+
+func newHTTPClient(config *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: config, ForceAttemptHTTP2: true},
+	}
+}
+
+// Error is a push APNs rejected.
+type Error struct {
+	StatusCode int
+	// Reason is the reason APNs gave, e.g. BadDeviceToken.
+	Reason string
+	// Timestamp is, for Unregistered tokens, when APNs last knew the token
+	// was valid.
+	Timestamp time.Time
+}
+
+func (e *Error) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("APNs returned %d", e.StatusCode)
+	}
+	return fmt.Sprintf("APNs returned %d: %s", e.StatusCode, e.Reason)
+}
+
+// Unregistered reports whether the device token is no longer valid for the
+// topic, e.g. because the device left MDM, so that pushing it again is
+// pointless until it sends a new one.
+func (e *Error) Unregistered() bool {
+	return e.StatusCode == http.StatusGone || e.Reason == "BadDeviceToken" || e.Reason == "DeviceTokenNotForTopic"
+}
+
+// Temporary reports whether the push could succeed if retried.
+func (e *Error) Temporary() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// Push sends the MDM push of a device or user channel, given its push token,
+// the PushMagic it checked in with, and the topic of the MDM push
+// certificate. Rejected pushes return *Error.
+func (c *Client) Push(ctx context.Context, token []byte, pushMagic, topic string) error {
+	body, err := json.Marshal(map[string]string{"mdm": pushMagic})
+	if err != nil {
+		return fmt.Errorf("encode push: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL+"/3/device/"+hex.EncodeToString(token), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create push request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("apns-topic", topic)
+	req.Header.Set("apns-priority", "10")
+	if c.Signer != nil {
+		jwt, err := c.Signer.token()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "bearer "+jwt)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("push through APNs: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	e := &Error{StatusCode: resp.StatusCode}
+	var reason struct {
+		Reason    string `json:"reason"`
+		Timestamp int64  `json:"timestamp"`
+	}
+	if json.NewDecoder(resp.Body).Decode(&reason) == nil {
+		e.Reason = reason.Reason
+		if reason.Timestamp > 0 {
+			e.Timestamp = time.UnixMilli(reason.Timestamp).UTC()
+		}
+	}
+	return e
+}
+
+// tokenLifetime is how long provider tokens are used for. APNs accepts them
+// for an hour, and rejects new ones made more often than every 20 minutes.
+const tokenLifetime = 40 * time.Minute
+
+// TokenSigner makes the provider tokens, JWTs signed with an APNs
+// authentication key, of token authentication.
+type TokenSigner struct {
+	key    *ecdsa.PrivateKey
+	keyID  string
+	teamID string
+
+	mu       sync.Mutex
+	jwt      string
+	issuedAt time.Time
+}
+
+// NewTokenSigner returns a TokenSigner for the .p8 authentication key in
+// keyFile, with its key ID and the ID of the team it belongs to.
+func NewTokenSigner(keyFile, keyID, teamID string) (*TokenSigner, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read APNs authentication key: %v", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("APNs authentication key %s is not PEM", keyFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse APNs authentication key: %v", err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("APNs authentication key %s is not an ECDSA key", keyFile)
+	}
+	if keyID == "" || teamID == "" {
+		return nil, fmt.Errorf("APNs token authentication requires a key ID and a team ID")
+	}
+	return &TokenSigner{key: key, keyID: keyID, teamID: teamID}, nil
+}
+
+// token returns the current provider token, signing a new one when it is
+// due.
+func (s *TokenSigner) token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.jwt != "" && now.Sub(s.issuedAt) < tokenLifetime {
+		return s.jwt, nil
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "ES256", "kid": s.keyID})
+	claims, _ := json.Marshal(map[string]interface{}{"iss": s.teamID, "iat": now.Unix()})
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, sig, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign APNs provider token: %v", err)
+	}
+	s.jwt = signed + "." + base64.RawURLEncoding.EncodeToString(append(pad32(r), pad32(sig)...))
+	s.issuedAt = now
+	return s.jwt, nil
+}
+
+// pad32 returns n as 32 big-endian bytes, as ES256 signatures encode r and s.
+func pad32(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, 32))
+}
@@ -0,0 +1,254 @@
+// Package dep lists the devices Apple Business Manager or Apple School
+// Manager assigned to an MDM server, through Apple's device enrollment
+// (DEP) API.
+package dep
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultURL is the base URL of Apple's DEP API.
+const DefaultURL = "https://mdmenrollment.apple.com"
+
+// Token is the OAuth token of an MDM server, as exported with mdmctl get
+// dep-tokens -export-token or kept by MicroMDM.
+type Token struct {
+	ConsumerKey    string `json:"consumer_key"`
+	ConsumerSecret string `json:"consumer_secret"`
+	AccessToken    string `json:"access_token"`
+	AccessSecret   string `json:"access_secret"`
+}
+
+// Device is a device assigned to the MDM server, as DEP describes it.
+type Device struct {
+	SerialNumber       string    `json:"serial_number"`
+	Model              string    `json:"model"`
+	Description        string    `json:"description"`
+	Color              string    `json:"color"`
+	AssetTag           string    `json:"asset_tag,omitempty"`
+	OS                 string    `json:"os,omitempty"`
+	DeviceFamily       string    `json:"device_family,omitempty"`
+	ProfileStatus      string    `json:"profile_status"`
+	ProfileUUID        string    `json:"profile_uuid,omitempty"`
+	DeviceAssignedDate time.Time `json:"device_assigned_date,omitempty"`
+	DeviceAssignedBy   string    `json:"device_assigned_by,omitempty"`
+
+	// OpType is, in sync responses, what happened to the device: added,
+	// modified, or deleted, when it was unassigned from the server.
+	OpType string    `json:"op_type,omitempty"`
+	OpDate time.Time `json:"op_date,omitempty"`
+}
+
+// Page is one page of devices.
+type Page struct {
+	Devices      []Device `json:"devices"`
+	Cursor       string   `json:"cursor"`
+	MoreToFollow bool     `json:"more_to_follow"`
+}
+
+// ErrCursorExpired is returned by Sync for cursors DEP no longer accepts;
+// the devices must then be fetched again from the start.
+var ErrCursorExpired = errors.New("DEP cursor expired")
+
+// Client calls the DEP API with the token of one MDM server.
+type Client struct {
+	// URL is DefaultURL, or another base URL.
+	URL   string
+	Token Token
+
+	// HTTP makes the requests, or http.DefaultClient if it is nil.
+	HTTP *http.Client
+
+	mu      sync.Mutex
+	session string
+}
+
+// pageSize is how many devices are asked for per page, the most DEP allows.
+const pageSize = 1000
+
+// Fetch returns the page of the devices assigned to the server after
+// cursor, or the first page if cursor is empty.
+func (c *Client) Fetch(ctx context.Context, cursor string) (Page, error) {
+	return c.devices(ctx, "/server/devices", cursor)
+}
+
+// Sync returns the page of the devices added to, modified on, or deleted
+// from the server since the fetch or sync that returned cursor.
+func (c *Client) Sync(ctx context.Context, cursor string) (Page, error) {
+	return c.devices(ctx, "/devices/sync", cursor)
+}
+
+func (c *Client) devices(ctx context.Context, path, cursor string) (Page, error) {
+	var page Page
+	body, err := json.Marshal(map[string]interface{}{"cursor": cursor, "limit": pageSize})
+	if err != nil {
+		return page, err
+	}
+	resp, err := c.do(ctx, path, body)
+	if err != nil {
+		return page, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if resp.StatusCode == http.StatusBadRequest && (bytes.Contains(msg, []byte("EXPIRED_CURSOR")) || bytes.Contains(msg, []byte("INVALID_CURSOR"))) {
+			return page, ErrCursorExpired
+		}
+		return page, fmt.Errorf("DEP %s returned %s: %s", path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return page, fmt.Errorf("decode DEP %s response: %v", path, err)
+	}
+	return page, nil
+}
+
+// do posts body to path with the current session, starting a new session
+// first if there is none or DEP rejected the current one.
+func (c *Client) do(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		session, err := c.currentSession(ctx, attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", c.URL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("create DEP request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json;charset=UTF8")
+		req.Header.Set("X-Server-Protocol-Version", "3")
+		req.Header.Set("X-ADM-Auth-Session", session)
+		resp, err := c.client().Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("call DEP %s: %v", path, err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			continue
+		}
+		if s := resp.Header.Get("X-ADM-Auth-Session"); s != "" {
+			c.mu.Lock()
+			c.session = s
+			c.mu.Unlock()
+		}
+		return resp, nil
+	}
+}
+
+// currentSession returns the auth session token of requests, which DEP
+// gives in exchange for a request signed with the OAuth token.
+func (c *Client) currentSession(ctx context.Context, renew bool) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.session != "" && !renew {
+		return c.session, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.URL+"/session", nil)
+	if err != nil {
+		return "", fmt.Errorf("create DEP session request: %v", err)
+	}
+	auth, err := c.oauthHeader("GET", req.URL)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", auth)
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("start DEP session: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("start DEP session: DEP returned %s", resp.Status)
+	}
+	var session struct {
+		AuthSessionToken string `json:"auth_session_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil || session.AuthSessionToken == "" {
+		return "", fmt.Errorf("start DEP session: no session token in the response")
+	}
+	c.session = session.AuthSessionToken
+	return c.session, nil
+}
+
+// oauthHeader returns the OAuth 1.0a Authorization header, signed with
+// HMAC-SHA1, of a request without a body.
+func (c *Client) oauthHeader(method string, u *url.URL) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("sign DEP session request: %v", err)
+	}
+	params := map[string]string{
+		"oauth_consumer_key":     c.Token.ConsumerKey,
+		"oauth_nonce":            hex.EncodeToString(nonce),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_token":            c.Token.AccessToken,
+		"oauth_version":          "1.0",
+	}
+	for k, vs := range u.Query() {
+		for _, v := range vs {
+			params[k] = v
+		}
+	}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = percentEncode(k) + "=" + percentEncode(params[k])
+	}
+	base := *u
+	base.RawQuery = ""
+	text := method + "&" + percentEncode(base.String()) + "&" + percentEncode(strings.Join(pairs, "&"))
+	mac := hmac.New(sha1.New, []byte(percentEncode(c.Token.ConsumerSecret)+"&"+percentEncode(c.Token.AccessSecret)))
+	mac.Write([]byte(text))
+	params["oauth_signature"] = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	header := []string{`realm="ADM"`}
+	for _, k := range append(keys, "oauth_signature") {
+		if strings.HasPrefix(k, "oauth_") {
+			header = append(header, fmt.Sprintf(`%s="%s"`, k, percentEncode(params[k])))
+		}
+	}
+	return "OAuth " + strings.Join(header, ", "), nil
+}
+
+// percentEncode encodes s as OAuth requires, escaping all but the unreserved
+// characters of RFC 3986.
+func percentEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case 'A' <= ch && ch <= 'Z', 'a' <= ch && ch <= 'z', '0' <= ch && ch <= '9', ch == '-', ch == '.', ch == '_', ch == '~':
+			b.WriteByte(ch)
+		default:
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
+
+func (c *Client) client() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,163 @@
+// Package mdmclient sends commands to MicroMDM's API.
+package mdmclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/dep"
+)
+
+// Client sends requests to one MicroMDM server.
+type Client struct {
+	// URL is the server's base URL, e.g. https://mdm.example.com.
+	URL string
+	// APIKey is the server's API token.
+	APIKey string
+
+	// HTTP makes the requests, or http.DefaultClient if it is nil.
+	HTTP *http.Client
+
+	// RequestID, if set, returns the request ID passed on to MicroMDM in
+	// the X-Request-ID header of requests made with ctx.
+	RequestID func(ctx context.Context) string
+}
+
+// PermanentError is a failure that retrying would not fix, e.g. MicroMDM
+// rejecting the command.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return e.Err.Error() }
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// commandResponse is the body MicroMDM returns from POST /v1/commands.
+type commandResponse struct {
+	Payload struct {
+		CommandUUID string `json:"command_uuid"`
+	} `json:"payload"`
+}
+
+// PostCommand makes a single POST of body, a command in the format of
+// MicroMDM's /v1/commands endpoint, and returns the CommandUUID MicroMDM
+// assigned it. Errors that retrying would not fix are *PermanentError.
+func (c *Client) PostCommand(ctx context.Context, body []byte) (uuid string, err error) {
+	req, err := c.newRequest(ctx, "POST", "/v1/commands", bytes.NewReader(body))
+	if err != nil {
+		return "", &PermanentError{fmt.Errorf("create command request: %v", err)}
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("post command to MicroMDM: %v", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return "", fmt.Errorf("MicroMDM returned %s", resp.Status)
+	case resp.StatusCode >= 400:
+		return "", &PermanentError{fmt.Errorf("MicroMDM returned %s", resp.Status)}
+	}
+	// The command was queued, so a response that cannot be read must not
+	// cause it to be sent again.
+	var cr commandResponse
+	if err := json.NewDecoder(resp.Body).Decode(&cr); err != nil {
+		return "", &PermanentError{fmt.Errorf("decode MicroMDM command response: %v", err)}
+	}
+	return cr.Payload.CommandUUID, nil
+}
+
+// Push asks MicroMDM to send the device with the given UDID an APNs push,
+// waking it to fetch its queued commands. Errors that retrying would not
+// fix are *PermanentError.
+func (c *Client) Push(ctx context.Context, udid string) error {
+	req, err := c.newRequest(ctx, "GET", "/push/"+url.PathEscape(udid), nil)
+	if err != nil {
+		return &PermanentError{fmt.Errorf("create push request: %v", err)}
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("push through MicroMDM: %v", err)
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("MicroMDM returned %s", resp.Status)
+	case resp.StatusCode >= 400:
+		return &PermanentError{fmt.Errorf("MicroMDM returned %s", resp.Status)}
+	}
+	return nil
+}
+
+// DEPTokens returns the DEP OAuth tokens MicroMDM was given with mdmctl
+// apply dep-tokens, which it keeps for its own DEP sync.
+func (c *Client) DEPTokens(ctx context.Context) ([]dep.Token, error) {
+	req, err := c.newRequest(ctx, "GET", "/v1/dep-tokens", nil)
+	if err != nil {
+		return nil, fmt.Errorf("create DEP tokens request: %v", err)
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("get DEP tokens from MicroMDM: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("MicroMDM returned %s", resp.Status)
+	}
+	var tokens struct {
+		DEPTokens []dep.Token `json:"dep_tokens"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, fmt.Errorf("decode MicroMDM DEP tokens: %v", err)
+	}
+	return tokens.DEPTokens, nil
+}
+
+// Ping checks that the server answers and accepts the API token. Any answer
+// other than an authentication failure or a gateway error counts, since the
+// endpoint used fails on servers without a push certificate.
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := c.newRequest(ctx, "GET", "/v1/config/certificate", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("MicroMDM unreachable")
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("MicroMDM rejected the API token")
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("MicroMDM returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.URL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth("micromdm", c.APIKey)
+	if c.RequestID != nil {
+		if id := c.RequestID(ctx); id != "" {
+			req.Header.Set("X-Request-ID", id)
+		}
+	}
+	return req, nil
+}
+
+func (c *Client) client() *http.Client {
+	if c.HTTP != nil {
+		return c.HTTP
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const (
+	deviceBucket  = "devices"
+	commandBucket = "commands"
+)
+
+// BoltStore is a DeviceStore and CommandHistory backed by a BoltDB file, so
+// enrollment state survives restarts of the webhook server.
+//
+// Command history is kept in a nested bucket per device, keyed by the
+// bucket's sequence number so records iterate in insertion order.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens (creating if necessary) the BoltDB database at path.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open bolt database: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{deviceBucket, commandBucket} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return fmt.Errorf("create %s bucket: %v", name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes the underlying database.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *BoltStore) Save(d Device) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("marshal device: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(deviceBucket)).Put([]byte(d.UDID), b)
+	})
+}
+
+func (s *BoltStore) Get(udid string) (Device, error) {
+	var d Device
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(deviceBucket)).Get([]byte(udid))
+		if b == nil {
+			return ErrDeviceNotFound
+		}
+		return json.Unmarshal(b, &d)
+	})
+	return d, err
+}
+
+func (s *BoltStore) List() ([]Device, error) {
+	var devices []Device
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(deviceBucket)).ForEach(func(_, v []byte) error {
+			var d Device
+			if err := json.Unmarshal(v, &d); err != nil {
+				return err
+			}
+			devices = append(devices, d)
+			return nil
+		})
+	})
+	return devices, err
+}
+
+func (s *BoltStore) Delete(udid string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(deviceBucket)).Delete([]byte(udid))
+	})
+}
+
+func (s *BoltStore) RecordCommand(r CommandRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal command record: %v", err)
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.Bucket([]byte(commandBucket)).CreateBucketIfNotExists([]byte(r.UDID))
+		if err != nil {
+			return err
+		}
+		seq, err := bkt.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return bkt.Put(key, b)
+	})
+}
+
+func (s *BoltStore) CommandHistory(udid string) ([]CommandRecord, error) {
+	var records []CommandRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(commandBucket)).Bucket([]byte(udid))
+		if bkt == nil {
+			return nil
+		}
+		return bkt.ForEach(func(_, v []byte) error {
+			var r CommandRecord
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			records = append(records, r)
+			return nil
+		})
+	})
+	return records, err
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestBoltStore(t *testing.T) *BoltStore {
+	s, err := NewBoltStore(filepath.Join(t.TempDir(), "devices.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestBoltStore(t *testing.T) {
+	testDeviceStore(t, func(t *testing.T) DeviceStore { return newTestBoltStore(t) })
+}
+
+func TestBoltHistory(t *testing.T) {
+	testCommandHistory(t, newTestBoltStore(t))
+}
+
+func TestBoltStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.db")
+	s, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(Device{UDID: "A", Tags: []string{"lab"}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = NewBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	d, err := s.Get("A")
+	if err != nil {
+		t.Fatalf("Get after reopening: %v", err)
+	}
+	if len(d.Tags) != 1 || d.Tags[0] != "lab" {
+		t.Errorf("Get after reopening = %+v", d)
+	}
+}
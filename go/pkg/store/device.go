@@ -0,0 +1,571 @@
+package store
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// Device represents a device
+type Device struct {
+	UDID     string    `json:"udid"`
+	Enrolled bool      `json:"enrolled"`
+	LastSeen time.Time `json:"last_seen"`
+
+	// Platform is macOS, iOS, iPadOS, or tvOS, as told by the product name
+	// or model the device reported, or empty until it has reported one.
+	Platform string `json:"platform,omitempty"`
+
+	// IdleAt is when the device last answered Idle, having run every
+	// command MicroMDM had queued for it, or nil if it never did.
+	IdleAt *time.Time `json:"idle_at,omitempty"`
+
+	// InstalledApps is the inventory from the most recent
+	// InstalledApplicationList response.
+	InstalledApps []InstalledApp `json:"installed_apps,omitempty"`
+
+	// ManagedApps are the apps from the most recent ManagedApplicationList
+	// response.
+	ManagedApps []ManagedApp `json:"managed_apps,omitempty"`
+
+	// AppInstalls are the InstallApplication commands sent to the device
+	// for App Store apps, and how far their installs got.
+	AppInstalls []AppInstall `json:"app_installs,omitempty"`
+
+	// Info holds the answers to the most recent DeviceInformation query, or
+	// nil if the device has not answered one yet.
+	Info *DeviceInfo `json:"info,omitempty"`
+
+	// Security is the posture from the most recent SecurityInfo response,
+	// or nil if the device has not answered one yet.
+	Security *SecurityPosture `json:"security,omitempty"`
+
+	// Profiles are the configuration profiles from the most recent
+	// ProfileList response.
+	Profiles []InstalledProfile `json:"profiles,omitempty"`
+
+	// Certificates are the certificates from the most recent
+	// CertificateList response.
+	Certificates []DeviceCertificate `json:"certificates,omitempty"`
+
+	// CertExpiry is the identity certificate of the device last found
+	// nearing expiry, and its renewal, or nil if none was.
+	CertExpiry *CertExpiry `json:"cert_expiry,omitempty"`
+
+	// Osquery is the osquery enrollment of a Mac set up by a blueprint
+	// enrolling it, and the Fleet host it was linked to, or nil.
+	Osquery *OsqueryEnrollment `json:"osquery,omitempty"`
+
+	// Compliance is how the device measures up to the compliance policies
+	// that apply to it, or nil if none do.
+	Compliance *Compliance `json:"compliance,omitempty"`
+
+	// Users are the macOS users of the device with their own MDM channel.
+	Users []User `json:"users,omitempty"`
+
+	// Tags group devices for bulk commands.
+	Tags []string `json:"tags,omitempty"`
+
+	// DEP is what Apple's device enrollment program says about the device,
+	// or nil if DEP is not synced or has not listed it.
+	DEP *DEPAssignment `json:"dep,omitempty"`
+
+	// Asset is what the imported lists of expected devices say about the
+	// device, or nil if none was imported before it enrolled.
+	Asset *Asset `json:"asset,omitempty"`
+
+	// Blueprint names the blueprint the device was set up with when it
+	// enrolled, if any.
+	Blueprint string `json:"blueprint,omitempty"`
+
+	// OSUpdates are the OS updates the device reported as available, and
+	// those scheduled on it, or nil if it has not reported any.
+	OSUpdates *OSUpdates `json:"os_updates,omitempty"`
+
+	// DeclarativeManagement is how the declarations of the device's
+	// blueprint reach it, and their status as it last reported it, or nil
+	// if its blueprint has none.
+	DeclarativeManagement *DeclarativeManagement `json:"declarative_management,omitempty"`
+
+	// LostMode is the Lost Mode state the device was last put in, or nil
+	// if it never was.
+	LostMode *LostMode `json:"lost_mode,omitempty"`
+
+	// Location is the location from the most recent DeviceLocation
+	// response, or nil if the device has not reported one.
+	Location *DeviceLocation `json:"location,omitempty"`
+
+	// Decommissioned is what was done to the device when it last checked
+	// out, or nil if it has enrolled since.
+	Decommissioned *Decommission `json:"decommissioned,omitempty"`
+
+	// LockPINs are the PINs of the DeviceLock commands sent to the device,
+	// oldest first, encrypted so that only the webhook server can read
+	// them.
+	LockPINs []EscrowedPIN `json:"lock_pins,omitempty"`
+
+	// FileVault is the escrowed FileVault recovery key of a Mac and its
+	// rotation, or nil if the Mac has not reported one.
+	FileVault *FileVault `json:"filevault,omitempty"`
+
+	// BootstrapToken is the bootstrap token state of a Mac, or nil if it
+	// never sent SetBootstrapToken and was not yet found missing one.
+	BootstrapToken *BootstrapToken `json:"bootstrap_token,omitempty"`
+
+	// ActivationLockBypassCode is the code the device reported for clearing
+	// its Activation Lock, encrypted like LockPINs, or nil if it did not
+	// report one.
+	ActivationLockBypassCode *EscrowedPIN `json:"activation_lock_bypass_code,omitempty"`
+
+	// UnlockToken is the token the device sent in TokenUpdate for
+	// ClearPasscode commands, encrypted like LockPINs.
+	UnlockToken []byte `json:"unlock_token,omitempty"`
+
+	// Push is the push token the device last sent in TokenUpdate, for
+	// pushing it through APNs directly, or nil if it has sent none.
+	Push *PushToken `json:"push,omitempty"`
+
+	// Version is incremented by stores that support optimistic
+	// concurrency control. It is zero for devices that were never saved.
+	Version int64 `json:"version,omitempty"`
+}
+
+// HasTag reports whether the device is tagged with tag.
+func (d Device) HasTag(tag string) bool {
+	for _, t := range d.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// TagValue returns the value of the device's first tag of the form
+// key:value, or "" if it has none, e.g. "jdoe" for user:jdoe.
+func (d Device) TagValue(key string) string {
+	for _, t := range d.Tags {
+		if v, ok := strings.CutPrefix(t, key+":"); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// AddTag tags the device with tag, reporting whether it was not already.
+// Tags is copied first, so copies of the device keep their tags.
+func (d *Device) AddTag(tag string) bool {
+	if d.HasTag(tag) {
+		return false
+	}
+	d.Tags = append(slices.Clip(d.Tags), tag)
+	return true
+}
+
+// RemoveTag removes tag from the device, reporting whether it had it. Like
+// AddTag, it leaves copies of the device alone.
+func (d *Device) RemoveTag(tag string) bool {
+	if !d.HasTag(tag) {
+		return false
+	}
+	d.Tags = slices.DeleteFunc(slices.Clone(d.Tags), func(t string) bool { return t == tag })
+	return true
+}
+
+// InstalledApp is one entry of an InstalledApplicationList response.
+type InstalledApp struct {
+	Identifier   string `plist:"Identifier" json:"identifier"`
+	Name         string `plist:"Name" json:"name"`
+	ShortVersion string `plist:"ShortVersion" json:"short_version,omitempty"`
+	Version      string `plist:"Version" json:"version,omitempty"`
+	BundleSize   int64  `plist:"BundleSize" json:"bundle_size,omitempty"`
+	DynamicSize  int64  `plist:"DynamicSize" json:"dynamic_size,omitempty"`
+}
+
+// ManagedApp is one entry of a ManagedApplicationList response.
+type ManagedApp struct {
+	Identifier                string `plist:"-" json:"identifier"`
+	Status                    string `plist:"Status" json:"status"`
+	ManagementFlags           int    `plist:"ManagementFlags" json:"management_flags,omitempty"`
+	HasConfiguration          bool   `plist:"HasConfiguration" json:"has_configuration,omitempty"`
+	HasFeedback               bool   `plist:"HasFeedback" json:"has_feedback,omitempty"`
+	IsValidated               bool   `plist:"IsValidated" json:"is_validated,omitempty"`
+	ExternalVersionIdentifier int64  `plist:"ExternalVersionIdentifier" json:"external_version_identifier,omitempty"`
+}
+
+// AppInstall is an InstallApplication command sent to a device. Identifier
+// is the app's bundle ID, which the device reports when it answers the
+// command if it was sent by iTunes Store ID. State is the state of the
+// install, from the InstallApplication response and then from
+// ManagedApplicationList ones; ConfirmedAt is set once the app is Managed.
+type AppInstall struct {
+	CommandUUID   string `json:"command_uuid"`
+	ITunesStoreID int64  `json:"itunes_store_id,omitempty"`
+	Identifier    string `json:"identifier,omitempty"`
+	// UserID is set for installs on the channel of a macOS user of the
+	// device, which take a license assigned to the user.
+	UserID          string     `json:"user_id,omitempty"`
+	PurchaseMethod  int64      `json:"purchase_method,omitempty"`
+	ManagementFlags int        `json:"management_flags,omitempty"`
+	RequestedAt     time.Time  `json:"requested_at"`
+	State           string     `json:"state,omitempty"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+	ConfirmedAt     *time.Time `json:"confirmed_at,omitempty"`
+	// RemovedAt is when a ManagedApplicationList last left out the app
+	// after it was confirmed, e.g. because it was removed from management.
+	RemovedAt *time.Time `json:"removed_at,omitempty"`
+}
+
+// DeviceInfo holds the fields of a DeviceInformation response.
+type DeviceInfo struct {
+	DeviceName              string    `plist:"DeviceName" json:"device_name,omitempty"`
+	OSVersion               string    `plist:"OSVersion" json:"os_version,omitempty"`
+	BuildVersion            string    `plist:"BuildVersion" json:"build_version,omitempty"`
+	ProductName             string    `plist:"ProductName" json:"product_name,omitempty"`
+	Model                   string    `plist:"Model" json:"model,omitempty"`
+	ModelName               string    `plist:"ModelName" json:"model_name,omitempty"`
+	SerialNumber            string    `plist:"SerialNumber" json:"serial_number,omitempty"`
+	BatteryLevel            float64   `plist:"BatteryLevel" json:"battery_level,omitempty"`
+	DeviceCapacity          float64   `plist:"DeviceCapacity" json:"device_capacity,omitempty"`
+	AvailableDeviceCapacity float64   `plist:"AvailableDeviceCapacity" json:"available_device_capacity,omitempty"`
+	IsSupervised            bool      `plist:"IsSupervised" json:"is_supervised,omitempty"`
+	WiFiMAC                 string    `plist:"WiFiMAC" json:"wifi_mac,omitempty"`
+	BluetoothMAC            string    `plist:"BluetoothMAC" json:"bluetooth_mac,omitempty"`
+	UpdatedAt               time.Time `plist:"-" json:"updated_at"`
+}
+
+// SecurityPosture is the per-device summary of a SecurityInfo response.
+type SecurityPosture struct {
+	PasscodePresent           bool `json:"passcode_present"`
+	PasscodeCompliant         bool `json:"passcode_compliant"`
+	FDEEnabled                bool `json:"fde_enabled"`
+	FDEHasPersonalRecoveryKey bool `json:"fde_has_personal_recovery_key"`
+	FDEHasInstitutionalKey    bool `json:"fde_has_institutional_recovery_key"`
+	FirewallEnabled           bool `json:"firewall_enabled"`
+	FirewallBlockAllIncoming  bool `json:"firewall_block_all_incoming"`
+	FirewallStealthMode       bool `json:"firewall_stealth_mode"`
+	// SIPEnabled is nil when the device did not report System Integrity
+	// Protection status, e.g. on iOS.
+	SIPEnabled *bool     `json:"sip_enabled,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// The compliance statuses of devices and of their policies.
+const (
+	ComplianceCompliant    = "compliant"
+	ComplianceNoncompliant = "noncompliant"
+	// ComplianceUnknown is the status of a device that has not reported
+	// the inventory a policy checks yet.
+	ComplianceUnknown = "unknown"
+)
+
+// Compliance is how a device measures up to the compliance policies that
+// apply to it, evaluated against its stored inventory.
+type Compliance struct {
+	// Status is noncompliant if any policy is, unknown if any other is,
+	// and compliant otherwise.
+	Status   string         `json:"status"`
+	Policies []PolicyStatus `json:"policies"`
+	// UpdatedAt is when the status of a policy last changed.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PolicyStatus is how a device measures up to one compliance policy.
+type PolicyStatus struct {
+	Policy     string   `json:"policy"`
+	Status     string   `json:"status"`
+	Violations []string `json:"violations,omitempty"`
+	// Since is when the device entered Status.
+	Since time.Time `json:"since"`
+}
+
+// User is a macOS user with an MDM channel of their own on a device, which
+// user-scoped commands, such as installing user profiles, are sent on.
+type User struct {
+	UserID    string `json:"user_id"`
+	ShortName string `json:"short_name,omitempty"`
+	LongName  string `json:"long_name,omitempty"`
+	// Enrolled is set once the user's channel sent a TokenUpdate, so that
+	// commands can be sent on it.
+	Enrolled bool `json:"enrolled"`
+	// NotOnConsole is set for users who are not logged in at the Mac
+	// itself, e.g. over screen sharing.
+	NotOnConsole bool      `json:"not_on_console,omitempty"`
+	LastSeen     time.Time `json:"last_seen"`
+	// Profiles are the user profiles from the most recent ProfileList
+	// response on the user's channel.
+	Profiles []InstalledProfile `json:"profiles,omitempty"`
+	// Push is the push token of the user's channel.
+	Push *PushToken `json:"push,omitempty"`
+}
+
+// DEPAssignment is the record of a device in Apple Business Manager or Apple
+// School Manager, as DEP listed it.
+type DEPAssignment struct {
+	// Assigned is set while DEP lists the device as assigned to the MDM
+	// server. Devices that enrolled without being listed, or that were
+	// unassigned since, have it unset.
+	Assigned     bool   `json:"assigned"`
+	SerialNumber string `json:"serial_number"`
+	Model        string `json:"model,omitempty"`
+	Description  string `json:"description,omitempty"`
+	Color        string `json:"color,omitempty"`
+	AssetTag     string `json:"asset_tag,omitempty"`
+	OS           string `json:"os,omitempty"`
+	DeviceFamily string `json:"device_family,omitempty"`
+	// ProfileStatus is whether the device has been assigned the enrollment
+	// profile with ProfileUUID (assigned), has fetched it (pushed), or has
+	// none (empty or removed).
+	ProfileStatus string     `json:"profile_status,omitempty"`
+	ProfileUUID   string     `json:"profile_uuid,omitempty"`
+	AssignedAt    *time.Time `json:"assigned_at,omitempty"`
+	AssignedBy    string     `json:"assigned_by,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// Asset is the entry of a device in an imported list of expected devices.
+type Asset struct {
+	// Expected is set for devices on the list. Devices that enrolled while
+	// a list was imported without being on it have it unset.
+	Expected bool   `json:"expected"`
+	Owner    string `json:"owner,omitempty"`
+	// ImportedAt is when the device's entry was last imported.
+	ImportedAt *time.Time `json:"imported_at,omitempty"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// PushToken is what a device or user channel is pushed with through APNs,
+// as sent in TokenUpdate, and how its last push went.
+type PushToken struct {
+	Token     []byte    `json:"token"`
+	PushMagic string    `json:"push_magic"`
+	Topic     string    `json:"topic"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// PushedAt is when APNs last accepted a push with the token.
+	PushedAt *time.Time `json:"pushed_at,omitempty"`
+	// FailedAt is when APNs last rejected a push with the token, for
+	// Failure, e.g. BadDeviceToken.
+	FailedAt *time.Time `json:"failed_at,omitempty"`
+	Failure  string     `json:"failure,omitempty"`
+	// Unregistered is set once APNs reports the token is no longer valid,
+	// until a TokenUpdate brings a new one.
+	Unregistered bool `json:"unregistered,omitempty"`
+}
+
+// User returns the user of the device with the given UserID, or nil if the
+// device has none.
+func (d *Device) User(userID string) *User {
+	for i := range d.Users {
+		if d.Users[i].UserID == userID {
+			return &d.Users[i]
+		}
+	}
+	return nil
+}
+
+// InstalledProfile is one entry of a ProfileList response.
+type InstalledProfile struct {
+	Identifier   string `plist:"PayloadIdentifier" json:"identifier"`
+	UUID         string `plist:"PayloadUUID" json:"uuid"`
+	Organization string `plist:"PayloadOrganization" json:"organization,omitempty"`
+	DisplayName  string `plist:"PayloadDisplayName" json:"display_name,omitempty"`
+	IsManaged    bool   `plist:"IsManaged" json:"is_managed,omitempty"`
+}
+
+// OSUpdates are the OS updates of a device.
+type OSUpdates struct {
+	// Available is the list from the most recent AvailableOSUpdates
+	// response.
+	Available []AvailableOSUpdate `json:"available,omitempty"`
+	// Scheduled are the updates sent with ScheduleOSUpdate, with their
+	// progress.
+	Scheduled []ScheduledOSUpdate `json:"scheduled,omitempty"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// AvailableOSUpdate is an entry of an AvailableOSUpdates response.
+type AvailableOSUpdate struct {
+	ProductKey         string `plist:"ProductKey" json:"product_key"`
+	HumanReadableName  string `plist:"HumanReadableName" json:"human_readable_name,omitempty"`
+	Version            string `plist:"Version" json:"version,omitempty"`
+	Build              string `plist:"Build" json:"build,omitempty"`
+	IsCritical         bool   `plist:"IsCritical" json:"is_critical,omitempty"`
+	RestartRequired    bool   `plist:"RestartRequired" json:"restart_required,omitempty"`
+	AllowsInstallLater bool   `plist:"AllowsInstallLater" json:"allows_install_later,omitempty"`
+}
+
+// ScheduledOSUpdate is an update sent to a device with ScheduleOSUpdate.
+type ScheduledOSUpdate struct {
+	ProductKey    string    `json:"product_key"`
+	InstallAction string    `json:"install_action"`
+	ScheduledAt   time.Time `json:"scheduled_at"`
+	// Deadline, if set, is when the update is sent again to be installed
+	// at once, restarting the device, unless it completed.
+	Deadline *time.Time `json:"deadline,omitempty"`
+	// Forced is set once the update was sent again at its deadline.
+	Forced bool `json:"forced,omitempty"`
+
+	// Status, IsDownloaded, and DownloadPercent are the progress from the
+	// most recent ScheduleOSUpdate or OSUpdateStatus response, e.g. Status
+	// Idle, Downloading, or Installing.
+	Status          string     `json:"status,omitempty"`
+	IsDownloaded    bool       `json:"is_downloaded,omitempty"`
+	DownloadPercent float64    `json:"download_percent,omitempty"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+
+	// CompletedAt is set once the update is no longer available to the
+	// device.
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Decommission records the decommissioning of a device that checked out:
+// whether it was retired, the tags removed from it, and when its escrowed
+// secrets and location are, or were, purged.
+type Decommission struct {
+	CheckedOutAt time.Time  `json:"checked_out_at"`
+	Retired      bool       `json:"retired,omitempty"`
+	RemovedTags  []string   `json:"removed_tags,omitempty"`
+	PurgeAt      *time.Time `json:"purge_at,omitempty"`
+	PurgedAt     *time.Time `json:"purged_at,omitempty"`
+}
+
+// DeclarativeManagement is the Declarative Device Management state of a
+// device.
+type DeclarativeManagement struct {
+	// Fallback is set for devices too old for declarations, which were
+	// sent their blueprint's fallback profiles instead.
+	Fallback bool `json:"fallback,omitempty"`
+	// DeclarationsToken is the token of the declarations the device last
+	// fetched, at SyncedAt.
+	DeclarationsToken string     `json:"declarations_token,omitempty"`
+	SyncedAt          *time.Time `json:"synced_at,omitempty"`
+	// Declarations are the status of each declaration, merged from the
+	// device's status reports, the last of which arrived at StatusAt.
+	Declarations []DeclarationStatus `json:"declarations,omitempty"`
+	StatusAt     *time.Time          `json:"status_at,omitempty"`
+}
+
+// DeclarationStatus is the status a device reported for a declaration. Type
+// is activation, configuration, asset, or management, and Valid is valid,
+// invalid, or unknown.
+type DeclarationStatus struct {
+	Identifier  string    `json:"identifier"`
+	Type        string    `json:"type"`
+	ServerToken string    `json:"server_token,omitempty"`
+	Valid       string    `json:"valid"`
+	Active      bool      `json:"active"`
+	Reasons     []string  `json:"reasons,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// LostMode is the state of the last EnableLostMode or DisableLostMode command
+// sent to a device. Message, PhoneNumber, and Footnote are only known for
+// commands sent through the admin API.
+type LostMode struct {
+	Enabled     bool       `json:"enabled"`
+	Message     string     `json:"message,omitempty"`
+	PhoneNumber string     `json:"phone_number,omitempty"`
+	Footnote    string     `json:"footnote,omitempty"`
+	CommandUUID string     `json:"command_uuid,omitempty"`
+	RequestedAt time.Time  `json:"requested_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+}
+
+// DeviceLocation is the location reported in a DeviceLocation response.
+// Accuracies are in meters, Speed in meters per second, and Course in degrees
+// from true north.
+type DeviceLocation struct {
+	Latitude           float64 `json:"latitude"`
+	Longitude          float64 `json:"longitude"`
+	HorizontalAccuracy float64 `json:"horizontal_accuracy,omitempty"`
+	VerticalAccuracy   float64 `json:"vertical_accuracy,omitempty"`
+	Altitude           float64 `json:"altitude,omitempty"`
+	Speed              float64 `json:"speed,omitempty"`
+	Course             float64 `json:"course,omitempty"`
+	// Timestamp is when the device determined the location, and UpdatedAt
+	// when it reported it.
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// FileVault is the FileVault personal recovery key escrowed for a Mac.
+type FileVault struct {
+	// Key is the recovery key, encrypted like LockPINs.
+	Key *EscrowedPIN `json:"key,omitempty"`
+	// DisclosedAt is when the admin API last disclosed Key, which is then
+	// due for rotation.
+	DisclosedAt *time.Time `json:"disclosed_at,omitempty"`
+	// Rotation is the last RotateFileVaultKey command sent to the Mac.
+	Rotation *FileVaultRotation `json:"rotation,omitempty"`
+}
+
+// BootstrapToken is the bootstrap token a Mac escrows with MDM, which macOS
+// uses to grant secure tokens to new users and, on Apple silicon, to
+// authorize software updates and kernel extensions.
+type BootstrapToken struct {
+	// Escrowed is set once the Mac sent a bootstrap token, and cleared if
+	// it removes it.
+	Escrowed bool `json:"escrowed"`
+	// Token is the bootstrap token, encrypted like LockPINs, or nil if it
+	// was not kept for want of an escrow key.
+	Token *EscrowedPIN `json:"token,omitempty"`
+	// UpdatedAt is when the Mac last sent SetBootstrapToken, and
+	// RetrievedAt when it last asked for the token with GetBootstrapToken.
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+	RetrievedAt *time.Time `json:"retrieved_at,omitempty"`
+	// MissingSince is when the Mac was first found enrolled without a
+	// bootstrap token, and AlertedAt when notifiers were told of it.
+	MissingSince *time.Time `json:"missing_since,omitempty"`
+	AlertedAt    *time.Time `json:"alerted_at,omitempty"`
+}
+
+// FileVaultRotation is a RotateFileVaultKey command, completed once the Mac
+// answered with its new key.
+type FileVaultRotation struct {
+	CommandUUID string     `json:"command_uuid"`
+	Reason      string     `json:"reason,omitempty"`
+	RequestedAt time.Time  `json:"requested_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// EscrowedPIN is the PIN of a DeviceLock command, or another secret such as
+// an Activation Lock bypass code or a FileVault recovery key, as the sealed
+// box of the server's escrow key.
+type EscrowedPIN struct {
+	Sealed    []byte    `json:"sealed"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CertExpiry is an identity certificate of a device found nearing expiry.
+type CertExpiry struct {
+	CommonName string    `json:"common_name"`
+	NotAfter   time.Time `json:"not_after"`
+	// AlertedAt is when notifiers were told of it and the renewal actions,
+	// if any, were run.
+	AlertedAt time.Time `json:"alerted_at"`
+	// RenewedAt is when the identity certificates the device reported were
+	// first found no longer nearing expiry, e.g. once it was replaced.
+	RenewedAt *time.Time `json:"renewed_at,omitempty"`
+}
+
+// OsqueryEnrollment is the osquery enrollment of a Mac.
+type OsqueryEnrollment struct {
+	// EnrolledAt is when the Mac was sent the osquery agent and its
+	// enrollment profile.
+	EnrolledAt time.Time `json:"enrolled_at"`
+	// FleetHostID and OsqueryHostID identify the host of the Mac in Fleet
+	// and osquery once the agent enrolled and LinkedAt is set.
+	FleetHostID   uint       `json:"fleet_host_id,omitempty"`
+	OsqueryHostID string     `json:"osquery_host_id,omitempty"`
+	LinkedAt      *time.Time `json:"linked_at,omitempty"`
+}
+
+// DeviceCertificate is a certificate reported in a CertificateList response.
+type DeviceCertificate struct {
+	CommonName string    `json:"common_name"`
+	Subject    string    `json:"subject"`
+	Issuer     string    `json:"issuer"`
+	NotBefore  time.Time `json:"not_before"`
+	NotAfter   time.Time `json:"not_after"`
+	IsIdentity bool      `json:"is_identity"`
+}
@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// tableCreateTimeout bounds how long NewDynamoDBStore waits for a newly
+// created table to become active.
+const tableCreateTimeout = 2 * time.Minute
+
+// DynamoDBOptions configures a DynamoDBStore.
+type DynamoDBOptions struct {
+	Table string
+	// Endpoint overrides the DynamoDB endpoint, e.g. for DynamoDB Local.
+	Endpoint string
+	// CreateTable creates the table with on-demand (PAY_PER_REQUEST)
+	// capacity if it does not exist yet.
+	CreateTable bool
+}
+
+// DynamoDBStore is a DeviceStore backed by a DynamoDB table, for stateless
+// deployments such as ECS/Fargate. AWS credentials and region are taken from
+// the default SDK chain (environment, shared config, or task role).
+//
+// Items are keyed by udid and carry a version attribute. Saves are
+// conditional on the version read by Get, so concurrent updates from
+// several instances fail with ErrDeviceConflict instead of clobbering each
+// other.
+type DynamoDBStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// NewDynamoDBStore returns a DynamoDBStore using the table in opts.
+func NewDynamoDBStore(opts DynamoDBOptions) (*DynamoDBStore, error) {
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load AWS config: %v", err)
+	}
+	client := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if opts.Endpoint != "" {
+			o.BaseEndpoint = aws.String(opts.Endpoint)
+		}
+	})
+	s := &DynamoDBStore{client: client, table: opts.Table}
+	if opts.CreateTable {
+		if err := s.createTable(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *DynamoDBStore) createTable(ctx context.Context) error {
+	_, err := s.client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:   aws.String(s.table),
+		BillingMode: types.BillingModePayPerRequest,
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("udid"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String("udid"), KeyType: types.KeyTypeHash},
+		},
+	})
+	var inUse *types.ResourceInUseException
+	if errors.As(err, &inUse) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("create dynamodb table %s: %v", s.table, err)
+	}
+	waiter := dynamodb.NewTableExistsWaiter(s.client)
+	return waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(s.table)}, tableCreateTimeout)
+}
+
+// Ping checks that the table is reachable.
+func (s *DynamoDBStore) Ping(ctx context.Context) error {
+	_, err := s.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(s.table)})
+	return err
+}
+
+func (s *DynamoDBStore) Save(d Device) error {
+	expected := d.Version
+	d.Version++
+	record, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("marshal device: %v", err)
+	}
+
+	input := &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]types.AttributeValue{
+			"udid":    &types.AttributeValueMemberS{Value: d.UDID},
+			"version": &types.AttributeValueMemberN{Value: strconv.FormatInt(d.Version, 10)},
+			"record":  &types.AttributeValueMemberS{Value: string(record)},
+		},
+	}
+	if expected == 0 {
+		input.ConditionExpression = aws.String("attribute_not_exists(udid)")
+	} else {
+		input.ConditionExpression = aws.String("version = :expected")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":expected": &types.AttributeValueMemberN{Value: strconv.FormatInt(expected, 10)},
+		}
+	}
+
+	_, err = s.client.PutItem(context.Background(), input)
+	var failed *types.ConditionalCheckFailedException
+	if errors.As(err, &failed) {
+		return ErrDeviceConflict
+	}
+	return err
+}
+
+func (s *DynamoDBStore) Get(udid string) (Device, error) {
+	out, err := s.client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            map[string]types.AttributeValue{"udid": &types.AttributeValueMemberS{Value: udid}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Device{}, err
+	}
+	if out.Item == nil {
+		return Device{}, ErrDeviceNotFound
+	}
+	return deviceFromDynamoDBItem(out.Item)
+}
+
+func (s *DynamoDBStore) List() ([]Device, error) {
+	var devices []Device
+	paginator := dynamodb.NewScanPaginator(s.client, &dynamodb.ScanInput{TableName: aws.String(s.table)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			d, err := deviceFromDynamoDBItem(item)
+			if err != nil {
+				return nil, err
+			}
+			devices = append(devices, d)
+		}
+	}
+	sortDevices(devices)
+	return devices, nil
+}
+
+func (s *DynamoDBStore) Delete(udid string) error {
+	_, err := s.client.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key:       map[string]types.AttributeValue{"udid": &types.AttributeValueMemberS{Value: udid}},
+	})
+	return err
+}
+
+func deviceFromDynamoDBItem(item map[string]types.AttributeValue) (Device, error) {
+	var d Device
+	record, ok := item["record"].(*types.AttributeValueMemberS)
+	if !ok {
+		return d, errors.New("dynamodb item has no record attribute")
+	}
+	if err := json.Unmarshal([]byte(record.Value), &d); err != nil {
+		return d, err
+	}
+	if v, ok := item["version"].(*types.AttributeValueMemberN); ok {
+		version, err := strconv.ParseInt(v.Value, 10, 64)
+		if err != nil {
+			return d, fmt.Errorf("parse version: %v", err)
+		}
+		d.Version = version
+	}
+	return d, nil
+}
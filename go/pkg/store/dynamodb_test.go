@@ -0,0 +1,59 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestDynamoDBStore creates a table of its own at the DynamoDB endpoint
+// $TEST_DYNAMODB_ENDPOINT, e.g. DynamoDB Local, skipping the test when it is
+// not set.
+func newTestDynamoDBStore(t *testing.T) *DynamoDBStore {
+	endpoint := os.Getenv("TEST_DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_DYNAMODB_ENDPOINT not set")
+	}
+	// DynamoDB Local takes any credentials, but the SDK wants some.
+	for key, value := range map[string]string{"AWS_REGION": "us-east-1", "AWS_ACCESS_KEY_ID": "test", "AWS_SECRET_ACCESS_KEY": "test"} {
+		if os.Getenv(key) == "" {
+			t.Setenv(key, value)
+		}
+	}
+	s, err := NewDynamoDBStore(DynamoDBOptions{
+		Table:       fmt.Sprintf("micromdm-webhook-test-%d", time.Now().UnixNano()),
+		Endpoint:    endpoint,
+		CreateTable: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestDynamoDBStore(t *testing.T) {
+	testDeviceStore(t, func(t *testing.T) DeviceStore { return newTestDynamoDBStore(t) })
+}
+
+func TestDynamoDBStoreConflict(t *testing.T) {
+	s := newTestDynamoDBStore(t)
+	if err := s.Save(Device{UDID: "A"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(Device{UDID: "A"}); !errors.Is(err, ErrDeviceConflict) {
+		t.Errorf("saving a new device over a stored one: %v, want ErrDeviceConflict", err)
+	}
+
+	d, err := s.Get("A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(d); err != nil {
+		t.Fatalf("saving what was read: %v", err)
+	}
+	if err := s.Save(d); !errors.Is(err, ErrDeviceConflict) {
+		t.Errorf("saving a stale read: %v, want ErrDeviceConflict", err)
+	}
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisDevicePrefix = "micromdm-webhook:device:"
+
+// RedisStore is a DeviceStore backed by Redis, allowing several webhook
+// instances behind a load balancer to share device state.
+type RedisStore struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+// RedisOptions configures a RedisStore.
+type RedisOptions struct {
+	Addr     string
+	Password string
+	DB       int
+	// TTL is applied to every device key on save. Zero means keys never
+	// expire.
+	TTL time.Duration
+}
+
+// NewRedisStore connects to Redis. The client reconnects on its own after
+// dropped connections, retrying failed commands with exponential backoff.
+func NewRedisStore(opts RedisOptions) (*RedisStore, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:            opts.Addr,
+		Password:        opts.Password,
+		DB:              opts.DB,
+		DialTimeout:     5 * time.Second,
+		MaxRetries:      5,
+		MinRetryBackoff: 100 * time.Millisecond,
+		MaxRetryBackoff: 5 * time.Second,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("connect to redis at %s: %v", opts.Addr, err)
+	}
+	return &RedisStore{client: client, ttl: opts.TTL}, nil
+}
+
+// Close closes the Redis client.
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+// Ping checks that Redis is reachable.
+func (s *RedisStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *RedisStore) Save(d Device) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("marshal device: %v", err)
+	}
+	return s.client.Set(context.Background(), redisDevicePrefix+d.UDID, b, s.ttl).Err()
+}
+
+func (s *RedisStore) Get(udid string) (Device, error) {
+	b, err := s.client.Get(context.Background(), redisDevicePrefix+udid).Bytes()
+	if err == redis.Nil {
+		return Device{}, ErrDeviceNotFound
+	} else if err != nil {
+		return Device{}, err
+	}
+	var d Device
+	err = json.Unmarshal(b, &d)
+	return d, err
+}
+
+func (s *RedisStore) List() ([]Device, error) {
+	ctx := context.Background()
+	var keys []string
+	iter := s.client.Scan(ctx, 0, redisDevicePrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]Device, 0, len(values))
+	for _, v := range values {
+		str, ok := v.(string)
+		if !ok {
+			// The key expired between SCAN and MGET.
+			continue
+		}
+		var d Device
+		if err := json.Unmarshal([]byte(str), &d); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	sortDevices(devices)
+	return devices, nil
+}
+
+func (s *RedisStore) Delete(udid string) error {
+	return s.client.Del(context.Background(), redisDevicePrefix+udid).Err()
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestRedisStore connects to the Redis server at $TEST_REDIS_ADDR, skipping
+// the test when it is not set, and deletes the devices stored there.
+func newTestRedisStore(t *testing.T, ttl time.Duration) *RedisStore {
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("TEST_REDIS_ADDR not set")
+	}
+	s, err := NewRedisStore(RedisOptions{Addr: addr, TTL: ttl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	devices, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range devices {
+		if err := s.Delete(d.UDID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func TestRedisStore(t *testing.T) {
+	testDeviceStore(t, func(t *testing.T) DeviceStore { return newTestRedisStore(t, 0) })
+}
+
+func TestRedisStoreTTL(t *testing.T) {
+	s := newTestRedisStore(t, time.Hour)
+	if err := s.Save(Device{UDID: "A"}); err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := s.client.TTL(context.Background(), redisDevicePrefix+"A").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > time.Hour {
+		t.Errorf("TTL = %v, want up to an hour", ttl)
+	}
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3" // registers the sqlite3 driver
+)
+
+// sqliteMigrations are applied in order at startup. Each entry is run exactly
+// once and recorded in the schema_migrations table, so new schema changes must
+// be appended rather than edited in place.
+var sqliteMigrations = []string{
+	// 1: devices and command history.
+	`CREATE TABLE devices (
+		udid      TEXT PRIMARY KEY,
+		enrolled  BOOLEAN NOT NULL DEFAULT 0,
+		last_seen DATETIME,
+		record    TEXT NOT NULL
+	);
+	CREATE TABLE commands (
+		id           INTEGER PRIMARY KEY AUTOINCREMENT,
+		udid         TEXT NOT NULL,
+		request_type TEXT NOT NULL,
+		sent_at      DATETIME NOT NULL
+	);
+	CREATE INDEX commands_udid ON commands (udid);`,
+
+	// 2: record responses alongside sent commands.
+	`ALTER TABLE commands RENAME COLUMN sent_at TO recorded_at;
+	ALTER TABLE commands ADD COLUMN command_uuid TEXT NOT NULL DEFAULT '';
+	ALTER TABLE commands ADD COLUMN status TEXT NOT NULL DEFAULT 'Sent';
+	ALTER TABLE commands ADD COLUMN error_chain TEXT;`,
+
+	// 3: classify failed commands.
+	`ALTER TABLE commands ADD COLUMN error_class TEXT NOT NULL DEFAULT '';
+	ALTER TABLE commands ADD COLUMN retrying INTEGER NOT NULL DEFAULT 0;`,
+
+	// 4: record the user channel of commands sent to macOS users.
+	`ALTER TABLE commands ADD COLUMN user_id TEXT NOT NULL DEFAULT '';`,
+}
+
+// SQLiteStore is a DeviceStore and CommandHistory backed by a SQLite database.
+//
+// The full Device is stored as JSON in the record column; frequently queried
+// fields are also kept in their own columns.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens the SQLite database at path and applies any pending
+// schema migrations.
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_foreign_keys=on")
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite database: %v", err)
+	}
+	// SQLite only supports a single writer; serialize access through one
+	// connection rather than surfacing "database is locked" errors.
+	db.SetMaxOpenConns(1)
+
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+func migrateSQLite(db *sql.DB) error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return fmt.Errorf("create schema_migrations table: %v", err)
+	}
+	var current int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("read schema version: %v", err)
+	}
+	for i := current; i < len(sqliteMigrations); i++ {
+		version := i + 1
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("begin migration %d: %v", version, err)
+		}
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("apply migration %d: %v", version, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, version); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("record migration %d: %v", version, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit migration %d: %v", version, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the underlying database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// Ping checks that the database can be used.
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLiteStore) Save(d Device) error {
+	record, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("marshal device: %v", err)
+	}
+	_, err = s.db.Exec(`INSERT INTO devices (udid, enrolled, last_seen, record) VALUES (?, ?, ?, ?)
+		ON CONFLICT (udid) DO UPDATE SET enrolled = excluded.enrolled, last_seen = excluded.last_seen, record = excluded.record`,
+		d.UDID, d.Enrolled, d.LastSeen, string(record))
+	return err
+}
+
+func (s *SQLiteStore) Get(udid string) (Device, error) {
+	var record string
+	err := s.db.QueryRow(`SELECT record FROM devices WHERE udid = ?`, udid).Scan(&record)
+	if err == sql.ErrNoRows {
+		return Device{}, ErrDeviceNotFound
+	} else if err != nil {
+		return Device{}, err
+	}
+	var d Device
+	err = json.Unmarshal([]byte(record), &d)
+	return d, err
+}
+
+func (s *SQLiteStore) List() ([]Device, error) {
+	rows, err := s.db.Query(`SELECT record FROM devices ORDER BY udid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []Device
+	for rows.Next() {
+		var record string
+		if err := rows.Scan(&record); err != nil {
+			return nil, err
+		}
+		var d Device
+		if err := json.Unmarshal([]byte(record), &d); err != nil {
+			return nil, err
+		}
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+func (s *SQLiteStore) Delete(udid string) error {
+	_, err := s.db.Exec(`DELETE FROM devices WHERE udid = ?`, udid)
+	return err
+}
+
+func (s *SQLiteStore) RecordCommand(r CommandRecord) error {
+	var errorChain sql.NullString
+	if len(r.ErrorChain) > 0 {
+		b, err := json.Marshal(r.ErrorChain)
+		if err != nil {
+			return fmt.Errorf("marshal error chain: %v", err)
+		}
+		errorChain = sql.NullString{String: string(b), Valid: true}
+	}
+	_, err := s.db.Exec(`INSERT INTO commands (udid, command_uuid, request_type, user_id, status, error_chain, error_class, retrying, recorded_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.UDID, r.CommandUUID, r.RequestType, r.UserID, r.Status, errorChain, r.ErrorClass, r.Retrying, r.Time)
+	return err
+}
+
+func (s *SQLiteStore) CommandHistory(udid string) ([]CommandRecord, error) {
+	rows, err := s.db.Query(`SELECT command_uuid, request_type, user_id, status, error_chain, error_class, retrying, recorded_at
+		FROM commands WHERE udid = ? ORDER BY id`, udid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []CommandRecord
+	for rows.Next() {
+		r := CommandRecord{UDID: udid}
+		var errorChain sql.NullString
+		if err := rows.Scan(&r.CommandUUID, &r.RequestType, &r.UserID, &r.Status, &errorChain, &r.ErrorClass, &r.Retrying, &r.Time); err != nil {
+			return nil, err
+		}
+		if errorChain.Valid {
+			if err := json.Unmarshal([]byte(errorChain.String), &r.ErrorChain); err != nil {
+				return nil, fmt.Errorf("unmarshal error chain: %v", err)
+			}
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestSQLiteStore(t *testing.T) *SQLiteStore {
+	s, err := NewSQLiteStore(filepath.Join(t.TempDir(), "devices.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testDeviceStore(t, func(t *testing.T) DeviceStore { return newTestSQLiteStore(t) })
+}
+
+func TestSQLiteHistory(t *testing.T) {
+	testCommandHistory(t, newTestSQLiteStore(t))
+}
+
+// TestSQLiteMigrations opens a database at the first schema version, with a
+// command recorded before responses were, and checks that it is migrated and
+// the command kept.
+func TestSQLiteMigrations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.db")
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, stmt := range []string{
+		`CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY)`,
+		sqliteMigrations[0],
+		`INSERT INTO schema_migrations (version) VALUES (1)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO commands (udid, request_type, sent_at) VALUES (?, ?, ?)`, "A", "DeviceInformation", sent); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	var version int
+	if err := s.db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(sqliteMigrations) {
+		t.Errorf("schema version %d, want %d", version, len(sqliteMigrations))
+	}
+	records, err := s.CommandHistory("A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].RequestType != "DeviceInformation" || records[0].Status != StatusSent || !records[0].Time.Equal(sent) {
+		t.Errorf("CommandHistory after migrating = %+v", records)
+	}
+
+	s.Close()
+
+	// Opening it again applies nothing.
+	s, err = NewSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	s.Close()
+}
@@ -0,0 +1,148 @@
+// Package store keeps the devices known to the webhook server, and the
+// commands sent to them, in memory or in a database.
+package store
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/micromdm/micromdm/mdm"
+)
+
+// ErrDeviceNotFound is returned by a DeviceStore when no device with the
+// requested UDID exists.
+var ErrDeviceNotFound = errors.New("device not found")
+
+// ErrDeviceConflict is returned by stores with optimistic concurrency control
+// when a device was modified by another writer after it was read.
+var ErrDeviceConflict = errors.New("device was modified concurrently")
+
+// DeviceStore persists the devices known to the webhook server.
+type DeviceStore interface {
+	// Save adds or replaces a device.
+	Save(d Device) error
+	// Get returns the device with the given UDID or ErrDeviceNotFound.
+	Get(udid string) (Device, error)
+	// List returns all devices sorted by UDID.
+	List() ([]Device, error)
+	// Delete removes the device with the given UDID. Deleting a device that
+	// does not exist is not an error.
+	Delete(udid string) error
+}
+
+// CommandRecord is one entry in a device's command history: a command sent
+// to the device, or the device's response to one.
+type CommandRecord struct {
+	UDID        string `json:"udid"`
+	CommandUUID string `json:"command_uuid"`
+	RequestType string `json:"request_type,omitempty"`
+	// UserID is set for commands sent on the channel of one of the
+	// device's macOS users, and their responses.
+	UserID string `json:"user_id,omitempty"`
+	// Status is "Sent" for commands queued in MicroMDM, and the status the
+	// device reported (Acknowledged, Error, NotNow, ...) for responses.
+	Status     string               `json:"status"`
+	ErrorChain []mdm.ErrorChainItem `json:"error_chain,omitempty"`
+	// ErrorClass is transient or permanent for failed commands, and
+	// Retrying set for failures after which the command is sent again.
+	ErrorClass string    `json:"error_class,omitempty"`
+	Retrying   bool      `json:"retrying,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// StatusSent is the CommandRecord status of a command queued in MicroMDM.
+const StatusSent = "Sent"
+
+// CommandHistory records the commands sent to devices and the responses
+// received. A DeviceStore may optionally implement it.
+type CommandHistory interface {
+	RecordCommand(r CommandRecord) error
+	// CommandHistory returns the records for a device, oldest first.
+	CommandHistory(udid string) ([]CommandRecord, error)
+}
+
+// maxMemoryHistory bounds the number of records memoryHistory keeps per
+// device.
+const maxMemoryHistory = 500
+
+// memoryHistory is a concurrency-safe, in-memory CommandHistory.
+type memoryHistory struct {
+	mu      sync.Mutex
+	records map[string][]CommandRecord
+}
+
+// NewMemoryHistory returns a CommandHistory that keeps the most recent
+// records for each device in memory.
+func NewMemoryHistory() CommandHistory {
+	return &memoryHistory{records: make(map[string][]CommandRecord)}
+}
+
+func (h *memoryHistory) RecordCommand(r CommandRecord) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	records := append(h.records[r.UDID], r)
+	if len(records) > maxMemoryHistory {
+		records = records[len(records)-maxMemoryHistory:]
+	}
+	h.records[r.UDID] = records
+	return nil
+}
+
+func (h *memoryHistory) CommandHistory(udid string) ([]CommandRecord, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]CommandRecord(nil), h.records[udid]...), nil
+}
+
+// MemoryStore is a concurrency-safe, in-memory DeviceStore.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	devices map[string]Device
+}
+
+// NewMemoryStore returns a DeviceStore that keeps devices in memory. All
+// state is lost when the process exits.
+func NewMemoryStore() DeviceStore {
+	return &MemoryStore{devices: make(map[string]Device)}
+}
+
+func (s *MemoryStore) Save(d Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[d.UDID] = d
+	return nil
+}
+
+func (s *MemoryStore) Get(udid string) (Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.devices[udid]
+	if !ok {
+		return Device{}, ErrDeviceNotFound
+	}
+	return d, nil
+}
+
+func (s *MemoryStore) List() ([]Device, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	devices := make([]Device, 0, len(s.devices))
+	for _, d := range s.devices {
+		devices = append(devices, d)
+	}
+	sortDevices(devices)
+	return devices, nil
+}
+
+func (s *MemoryStore) Delete(udid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.devices, udid)
+	return nil
+}
+
+func sortDevices(devices []Device) {
+	sort.Slice(devices, func(i, j int) bool { return devices[i].UDID < devices[j].UDID })
+}
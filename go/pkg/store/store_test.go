@@ -0,0 +1,147 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/micromdm/micromdm/mdm"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testDeviceStore(t, func(t *testing.T) DeviceStore { return NewMemoryStore() })
+}
+
+func TestMemoryHistory(t *testing.T) {
+	testCommandHistory(t, NewMemoryHistory())
+}
+
+// testDeviceStore checks that the stores newStore returns, each one empty,
+// behave as DeviceStore says.
+func testDeviceStore(t *testing.T, newStore func(t *testing.T) DeviceStore) {
+	tests := []struct {
+		name    string
+		save    []Device
+		delete  []string
+		get     string
+		want    Device
+		wantErr error
+		list    []string
+	}{
+		{
+			name:    "empty",
+			get:     "A",
+			wantErr: ErrDeviceNotFound,
+		},
+		{
+			name: "save and get",
+			save: []Device{{UDID: "A", Tags: []string{"kiosk"}}},
+			get:  "A",
+			want: Device{UDID: "A", Tags: []string{"kiosk"}},
+			list: []string{"A"},
+		},
+		{
+			name: "save replaces",
+			save: []Device{{UDID: "A", Tags: []string{"kiosk"}}, {UDID: "A", Tags: []string{"lab"}}},
+			get:  "A",
+			want: Device{UDID: "A", Tags: []string{"lab"}},
+			list: []string{"A"},
+		},
+		{
+			name: "list sorted by UDID",
+			save: []Device{{UDID: "C"}, {UDID: "A"}, {UDID: "B"}},
+			get:  "B",
+			want: Device{UDID: "B"},
+			list: []string{"A", "B", "C"},
+		},
+		{
+			name:    "delete",
+			save:    []Device{{UDID: "A"}, {UDID: "B"}},
+			delete:  []string{"A"},
+			get:     "A",
+			wantErr: ErrDeviceNotFound,
+			list:    []string{"B"},
+		},
+		{
+			name:   "delete missing",
+			save:   []Device{{UDID: "A"}},
+			delete: []string{"B"},
+			get:    "A",
+			want:   Device{UDID: "A"},
+			list:   []string{"A"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStore(t)
+			for _, d := range tt.save {
+				// Save what was read, as the server does, for stores that
+				// check the version.
+				if old, err := s.Get(d.UDID); err == nil {
+					d.Version = old.Version
+				}
+				if err := s.Save(d); err != nil {
+					t.Fatalf("Save(%q): %v", d.UDID, err)
+				}
+			}
+			for _, udid := range tt.delete {
+				if err := s.Delete(udid); err != nil {
+					t.Fatalf("Delete(%q): %v", udid, err)
+				}
+			}
+			got, err := s.Get(tt.get)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Get(%q) error = %v, want %v", tt.get, err, tt.wantErr)
+			}
+			if got.UDID != tt.want.UDID || !slices.Equal(got.Tags, tt.want.Tags) {
+				t.Errorf("Get(%q) = %+v, want %+v", tt.get, got, tt.want)
+			}
+			devices, err := s.List()
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			var udids []string
+			for _, d := range devices {
+				udids = append(udids, d.UDID)
+			}
+			if !slices.Equal(udids, tt.list) {
+				t.Errorf("List = %q, want %q", udids, tt.list)
+			}
+		})
+	}
+}
+
+// testCommandHistory checks that h, which has no records yet, keeps each
+// device's records apart and in the order they were recorded.
+func testCommandHistory(t *testing.T, h CommandHistory) {
+	sent := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := []CommandRecord{
+		{UDID: "A", CommandUUID: "1", RequestType: "DeviceInformation", Status: StatusSent, Time: sent},
+		{UDID: "B", CommandUUID: "2", RequestType: "SecurityInfo", Status: StatusSent, Time: sent},
+		{UDID: "A", CommandUUID: "1", Status: "Error", ErrorClass: "transient", Retrying: true, Time: sent.Add(time.Second),
+			ErrorChain: []mdm.ErrorChainItem{{ErrorCode: 12021, ErrorDomain: "MCMDMErrorDomain"}}},
+	}
+	for _, r := range records {
+		if err := h.RecordCommand(r); err != nil {
+			t.Fatalf("RecordCommand: %v", err)
+		}
+	}
+
+	for udid, want := range map[string][]CommandRecord{"A": {records[0], records[2]}, "B": {records[1]}, "C": nil} {
+		got, err := h.CommandHistory(udid)
+		if err != nil {
+			t.Fatalf("CommandHistory(%q): %v", udid, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("CommandHistory(%q) = %+v, want %+v", udid, got, want)
+		}
+		for i := range want {
+			if got[i].UDID != want[i].UDID || got[i].CommandUUID != want[i].CommandUUID || got[i].RequestType != want[i].RequestType ||
+				got[i].Status != want[i].Status || got[i].ErrorClass != want[i].ErrorClass || got[i].Retrying != want[i].Retrying ||
+				!got[i].Time.Equal(want[i].Time) || len(got[i].ErrorChain) != len(want[i].ErrorChain) {
+				t.Errorf("CommandHistory(%q)[%d] = %+v, want %+v", udid, i, got[i], want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"errors"
+	"strings"
+)
+
+// tenantSeparator joins a tenant name to the UDIDs of its devices in the
+// shared store. UDIDs never contain it, so untenanted devices are told apart
+// by its absence.
+const tenantSeparator = "/"
+
+// ErrInvalidUDID is returned by TenantStore for UDIDs containing the tenant
+// separator, which would name a device of another tenant.
+var ErrInvalidUDID = errors.New("UDID contains " + tenantSeparator)
+
+// ValidUDID reports whether udid can be stored in a TenantStore.
+func ValidUDID(udid string) bool {
+	return !strings.Contains(udid, tenantSeparator)
+}
+
+// TenantStore scopes a shared DeviceStore and CommandHistory to one tenant by
+// prefixing the UDIDs it stores with the tenant name. With an empty tenant it
+// holds the devices of the untenanted server, hiding those of every tenant.
+type TenantStore struct {
+	prefix  string
+	store   DeviceStore
+	history CommandHistory
+}
+
+// NewTenantStore returns the TenantStore of tenant in store and history.
+func NewTenantStore(tenant string, store DeviceStore, history CommandHistory) *TenantStore {
+	t := &TenantStore{store: store, history: history}
+	if tenant != "" {
+		t.prefix = tenant + tenantSeparator
+	}
+	return t
+}
+
+func (t *TenantStore) owns(udid string) bool {
+	if t.prefix == "" {
+		return ValidUDID(udid)
+	}
+	return strings.HasPrefix(udid, t.prefix)
+}
+
+func (t *TenantStore) Save(d Device) error {
+	if !ValidUDID(d.UDID) {
+		return ErrInvalidUDID
+	}
+	d.UDID = t.prefix + d.UDID
+	return t.store.Save(d)
+}
+
+func (t *TenantStore) Get(udid string) (Device, error) {
+	if !ValidUDID(udid) {
+		return Device{}, ErrDeviceNotFound
+	}
+	d, err := t.store.Get(t.prefix + udid)
+	d.UDID = strings.TrimPrefix(d.UDID, t.prefix)
+	return d, err
+}
+
+func (t *TenantStore) List() ([]Device, error) {
+	all, err := t.store.List()
+	if err != nil {
+		return nil, err
+	}
+	var devices []Device
+	for _, d := range all {
+		if t.owns(d.UDID) {
+			d.UDID = strings.TrimPrefix(d.UDID, t.prefix)
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
+
+func (t *TenantStore) Delete(udid string) error {
+	if !ValidUDID(udid) {
+		return nil
+	}
+	return t.store.Delete(t.prefix + udid)
+}
+
+func (t *TenantStore) RecordCommand(r CommandRecord) error {
+	if !ValidUDID(r.UDID) {
+		return ErrInvalidUDID
+	}
+	r.UDID = t.prefix + r.UDID
+	return t.history.RecordCommand(r)
+}
+
+func (t *TenantStore) CommandHistory(udid string) ([]CommandRecord, error) {
+	if !ValidUDID(udid) {
+		return nil, nil
+	}
+	records, err := t.history.CommandHistory(t.prefix + udid)
+	for i := range records {
+		records[i].UDID = udid
+	}
+	return records, err
+}
@@ -0,0 +1,63 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestTenantStoreIsolation(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenant  string
+		udid    string
+		wantErr error
+	}{
+		{name: "untenanted", tenant: "", udid: "X"},
+		{name: "tenant", tenant: "acme", udid: "X"},
+		{name: "untenanted into tenant", tenant: "", udid: "acme/X", wantErr: ErrInvalidUDID},
+		{name: "tenant into tenant", tenant: "other", udid: "acme/X", wantErr: ErrInvalidUDID},
+		{name: "tenant into nested namespace", tenant: "acme", udid: "/X", wantErr: ErrInvalidUDID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, history := NewMemoryStore(), NewMemoryHistory()
+			acme := NewTenantStore("acme", backend, history)
+			if err := acme.Save(Device{UDID: "X", Tags: []string{"acme"}}); err != nil {
+				t.Fatalf("save acme device: %v", err)
+			}
+
+			ts := NewTenantStore(tt.tenant, backend, history)
+			err := ts.Save(Device{UDID: tt.udid, Tags: []string{"intruder"}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Save(%q) error = %v, want %v", tt.udid, err, tt.wantErr)
+			}
+			if err := ts.RecordCommand(CommandRecord{UDID: tt.udid, CommandUUID: "C"}); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RecordCommand(%q) error = %v, want %v", tt.udid, err, tt.wantErr)
+			}
+
+			d, err := acme.Get("X")
+			if err != nil {
+				t.Fatalf("get acme device: %v", err)
+			}
+			if tt.tenant != "acme" && !d.HasTag("acme") {
+				t.Errorf("acme device was overwritten: %+v", d)
+			}
+			records, err := acme.CommandHistory("X")
+			if err != nil {
+				t.Fatalf("acme command history: %v", err)
+			}
+			if tt.tenant != "acme" && len(records) != 0 {
+				t.Errorf("acme command history = %+v, want none", records)
+			}
+			devices, err := ts.List()
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			for _, d := range devices {
+				if d.HasTag("acme") && tt.tenant != "acme" {
+					t.Errorf("tenant %q lists acme device %+v", tt.tenant, d)
+				}
+			}
+		})
+	}
+}
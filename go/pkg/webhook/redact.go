@@ -0,0 +1,90 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/groob/plist"
+)
+
+// secretKeys are the keys of check-in messages and command responses that
+// carry secrets the webhook only stores encrypted: the UnlockToken and
+// BootstrapToken devices check in with, and the ActivationLockBypassCode
+// DeviceInformation responses report in their QueryResponses.
+var secretKeys = []string{"UnlockToken", "ActivationLockBypassCode", "BootstrapToken"}
+
+// RedactPayload removes the secretKeys from a decoded check-in message or
+// command response, and from its QueryResponses, and reports whether it had
+// any.
+func RedactPayload(payload map[string]interface{}) bool {
+	found := false
+	for _, key := range secretKeys {
+		if _, ok := payload[key]; ok {
+			delete(payload, key)
+			found = true
+		}
+		if qr, ok := payload["QueryResponses"].(map[string]interface{}); ok {
+			if _, ok := qr[key]; ok {
+				delete(qr, key)
+				found = true
+			}
+		}
+	}
+	return found
+}
+
+// RedactEvent returns event, and its JSON body, without the secretKeys of
+// its check-in message or command response, for passing the event on to
+// places that must not see them. event is not modified, and body and event
+// are returned as they are if they carry no secrets.
+func RedactEvent(body []byte, event Event) ([]byte, Event, error) {
+	redacted := false
+	if ack := event.AcknowledgeEvent; ack != nil {
+		raw, ok, err := redactPayload(ack.RawPayload)
+		if err != nil {
+			return nil, Event{}, err
+		}
+		if ok {
+			a := *ack
+			a.RawPayload, event.AcknowledgeEvent, redacted = raw, &a, true
+		}
+	}
+	if checkin := event.CheckinEvent; checkin != nil {
+		raw, ok, err := redactPayload(checkin.RawPayload)
+		if err != nil {
+			return nil, Event{}, err
+		}
+		if ok {
+			c := *checkin
+			c.RawPayload, event.CheckinEvent, redacted = raw, &c, true
+		}
+	}
+	if !redacted {
+		return body, event, nil
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return nil, Event{}, fmt.Errorf("encode redacted event: %v", err)
+	}
+	return b, event, nil
+}
+
+// redactPayload returns the plist raw as redacted by RedactPayload, and
+// whether it had any secrets. Payloads that are not dictionaries have none.
+func redactPayload(raw []byte) ([]byte, bool, error) {
+	if len(raw) == 0 {
+		return raw, false, nil
+	}
+	var fields map[string]interface{}
+	if err := plist.Unmarshal(raw, &fields); err != nil {
+		return raw, false, nil
+	}
+	if !RedactPayload(fields) {
+		return raw, false, nil
+	}
+	b, err := plist.Marshal(fields)
+	if err != nil {
+		return nil, false, fmt.Errorf("encode redacted payload: %v", err)
+	}
+	return b, true, nil
+}
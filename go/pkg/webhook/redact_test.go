@@ -0,0 +1,125 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/micromdm/workflow/webhook"
+)
+
+func TestRedactEvent(t *testing.T) {
+	payload := func(fields map[string]interface{}) []byte {
+		b, err := plist.Marshal(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	tests := []struct {
+		name  string
+		event Event
+		keep  string
+		gone  string
+	}{
+		{
+			name: "bypass code",
+			event: Event{Topic: "mdm.Acknowledge", AcknowledgeEvent: &webhook.AcknowledgeEvent{UDID: "U", Status: "Acknowledged",
+				RawPayload: payload(map[string]interface{}{"UDID": "U", "QueryResponses": map[string]interface{}{
+					"DeviceName": "iPad", "ActivationLockBypassCode": "BYPASS-SECRET",
+				}})}},
+			keep: "QueryResponses.DeviceName",
+			gone: "QueryResponses.ActivationLockBypassCode",
+		},
+		{
+			name: "unlock token",
+			event: Event{Topic: "mdm.TokenUpdate", CheckinEvent: &webhook.CheckinEvent{UDID: "U",
+				RawPayload: payload(map[string]interface{}{"MessageType": "TokenUpdate", "UnlockToken": []byte("UNLOCK-SECRET")})}},
+			keep: "MessageType",
+			gone: "UnlockToken",
+		},
+		{
+			name: "bootstrap token",
+			event: Event{Topic: "mdm.SetBootstrapToken", CheckinEvent: &webhook.CheckinEvent{UDID: "U",
+				RawPayload: payload(map[string]interface{}{"MessageType": "SetBootstrapToken", "BootstrapToken": []byte("BOOTSTRAP-SECRET")})}},
+			keep: "MessageType",
+			gone: "BootstrapToken",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			original := append([]byte(nil), body...)
+			redactedBody, redacted, err := RedactEvent(body, tt.event)
+			if err != nil {
+				t.Fatalf("RedactEvent: %v", err)
+			}
+			for _, e := range []Event{redacted, mustDecode(t, redactedBody)} {
+				keys := payloadKeys(t, e)
+				if keys[tt.gone] {
+					t.Errorf("redacted event still carries %s", tt.gone)
+				}
+				if !keys[tt.keep] {
+					t.Errorf("redacted event lost %s", tt.keep)
+				}
+			}
+			if !bytes.Equal(body, original) || !payloadKeys(t, tt.event)[tt.gone] {
+				t.Error("RedactEvent modified the event it was given")
+			}
+		})
+	}
+}
+
+func TestRedactEventWithoutSecrets(t *testing.T) {
+	body := []byte(`{"topic":"mdm.Connect","event_id":"1","created_at":"2026-10-14T10:00:00Z","acknowledge_event":{"udid":"U","status":"Idle","raw_payload":null}}`)
+	event, err := DecodeEvent(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _, err := RedactEvent(body, event)
+	if err != nil {
+		t.Fatalf("RedactEvent: %v", err)
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("RedactEvent changed an event without secrets to %s", got)
+	}
+}
+
+func mustDecode(t *testing.T, body []byte) Event {
+	t.Helper()
+	event, err := DecodeEvent(body)
+	if err != nil {
+		t.Fatalf("decode redacted body: %v", err)
+	}
+	return event
+}
+
+// payloadKeys returns the top-level keys of the raw payload of e, and
+// those of its QueryResponses as QueryResponses.<key>.
+func payloadKeys(t *testing.T, e Event) map[string]bool {
+	t.Helper()
+	var raw []byte
+	if e.AcknowledgeEvent != nil {
+		raw = e.AcknowledgeEvent.RawPayload
+	} else {
+		raw = e.CheckinEvent.RawPayload
+	}
+	var fields map[string]interface{}
+	if err := plist.Unmarshal(raw, &fields); err != nil {
+		t.Fatalf("decode payload: %v", err)
+	}
+	keys := make(map[string]bool, len(fields))
+	for k := range fields {
+		keys[k] = true
+	}
+	if qr, ok := fields["QueryResponses"].(map[string]interface{}); ok {
+		for k := range qr {
+			keys["QueryResponses."+k] = true
+		}
+	}
+	return keys
+}
@@ -0,0 +1,425 @@
+package webhook
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/micromdm/micromdm/mdm"
+)
+
+type installedApplicationListResponse struct {
+	InstalledApplicationList []store.InstalledApp
+}
+
+// ParseInstalledApplicationList decodes the raw plist payload of an
+// InstalledApplicationList acknowledgment.
+func ParseInstalledApplicationList(raw []byte) ([]store.InstalledApp, error) {
+	var resp installedApplicationListResponse
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("decode InstalledApplicationList response: %v", err)
+	}
+	return resp.InstalledApplicationList, nil
+}
+
+// DeviceInformationQueries are the DeviceInformation keys requested from
+// every enrolled device.
+var DeviceInformationQueries = []string{
+	"DeviceName",
+	"OSVersion",
+	"BuildVersion",
+	"ProductName",
+	"Model",
+	"ModelName",
+	"SerialNumber",
+	"BatteryLevel",
+	"DeviceCapacity",
+	"AvailableDeviceCapacity",
+	"IsSupervised",
+	"WiFiMAC",
+	"BluetoothMAC",
+	"ActivationLockBypassCode",
+}
+
+type managedApplicationListResponse struct {
+	ManagedApplicationList map[string]store.ManagedApp
+}
+
+// ParseManagedApplicationList decodes the raw plist payload of a
+// ManagedApplicationList acknowledgment, sorted by identifier.
+func ParseManagedApplicationList(raw []byte) ([]store.ManagedApp, error) {
+	var resp managedApplicationListResponse
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("decode ManagedApplicationList response: %v", err)
+	}
+	apps := make([]store.ManagedApp, 0, len(resp.ManagedApplicationList))
+	for id, app := range resp.ManagedApplicationList {
+		app.Identifier = id
+		apps = append(apps, app)
+	}
+	slices.SortFunc(apps, func(a, b store.ManagedApp) int { return strings.Compare(a.Identifier, b.Identifier) })
+	return apps, nil
+}
+
+// InstallApplicationResult is the app an InstallApplication command
+// installs and the state of the install.
+type InstallApplicationResult struct {
+	Identifier string
+	State      string
+}
+
+// ParseInstallApplication decodes the raw plist payload of an
+// InstallApplication acknowledgment.
+func ParseInstallApplication(raw []byte) (InstallApplicationResult, error) {
+	var resp InstallApplicationResult
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return resp, fmt.Errorf("decode InstallApplication response: %v", err)
+	}
+	return resp, nil
+}
+
+type deviceInformationResponse struct {
+	QueryResponses store.DeviceInfo
+}
+
+// ParseDeviceInformation decodes the raw plist payload of a
+// DeviceInformation acknowledgment.
+func ParseDeviceInformation(raw []byte) (store.DeviceInfo, error) {
+	var resp deviceInformationResponse
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return store.DeviceInfo{}, fmt.Errorf("decode DeviceInformation response: %v", err)
+	}
+	return resp.QueryResponses, nil
+}
+
+// ParseActivationLockBypassCode returns the ActivationLockBypassCode of a
+// DeviceInformation acknowledgment, which supervised devices report until it
+// has been read, or "". It is not part of store.DeviceInfo, so that it is
+// only stored encrypted.
+func ParseActivationLockBypassCode(raw []byte) (string, error) {
+	var resp struct {
+		QueryResponses struct{ ActivationLockBypassCode string }
+	}
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return "", fmt.Errorf("decode DeviceInformation response: %v", err)
+	}
+	return resp.QueryResponses.ActivationLockBypassCode, nil
+}
+
+type securityInfoResponse struct {
+	SecurityInfo struct {
+		PasscodePresent                  bool
+		PasscodeCompliant                bool
+		FDEEnabled                       bool `plist:"FDE_Enabled"`
+		FDEHasPersonalRecoveryKey        bool `plist:"FDE_HasPersonalRecoveryKey"`
+		FDEHasInstitutionalRecoveryKey   bool `plist:"FDE_HasInstitutionalRecoveryKey"`
+		SystemIntegrityProtectionEnabled *bool
+		FirewallSettings                 struct {
+			FirewallEnabled  bool
+			BlockAllIncoming bool
+			StealthMode      bool
+		}
+	}
+}
+
+// ParseSecurityInfo decodes the raw plist payload of a SecurityInfo
+// acknowledgment.
+func ParseSecurityInfo(raw []byte) (store.SecurityPosture, error) {
+	var resp securityInfoResponse
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return store.SecurityPosture{}, fmt.Errorf("decode SecurityInfo response: %v", err)
+	}
+	info := resp.SecurityInfo
+	return store.SecurityPosture{
+		PasscodePresent:           info.PasscodePresent,
+		PasscodeCompliant:         info.PasscodeCompliant,
+		FDEEnabled:                info.FDEEnabled,
+		FDEHasPersonalRecoveryKey: info.FDEHasPersonalRecoveryKey,
+		FDEHasInstitutionalKey:    info.FDEHasInstitutionalRecoveryKey,
+		FirewallEnabled:           info.FirewallSettings.FirewallEnabled,
+		FirewallBlockAllIncoming:  info.FirewallSettings.BlockAllIncoming,
+		FirewallStealthMode:       info.FirewallSettings.StealthMode,
+		SIPEnabled:                info.SystemIntegrityProtectionEnabled,
+	}, nil
+}
+
+// ParseFileVaultRecoveryKey returns the FDE_PersonalRecoveryKeyCMS of a
+// SecurityInfo acknowledgment: the FileVault personal recovery key of a Mac
+// with an FDERecoveryKeyEscrow payload, in a CMS envelope encrypted to the
+// payload's certificate. It is nil for other devices.
+func ParseFileVaultRecoveryKey(raw []byte) ([]byte, error) {
+	var resp struct {
+		SecurityInfo struct {
+			PersonalRecoveryKeyCMS []byte `plist:"FDE_PersonalRecoveryKeyCMS"`
+		}
+	}
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("decode SecurityInfo response: %v", err)
+	}
+	return resp.SecurityInfo.PersonalRecoveryKeyCMS, nil
+}
+
+// ParseRotateFileVaultKey returns the EncryptedNewRecoveryKey of a
+// RotateFileVaultKey acknowledgment, a CMS envelope encrypted to the
+// command's ReplyEncryptionCertificate.
+func ParseRotateFileVaultKey(raw []byte) ([]byte, error) {
+	var resp struct {
+		RotateResult struct {
+			EncryptedNewRecoveryKey []byte
+		}
+	}
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("decode RotateFileVaultKey response: %v", err)
+	}
+	return resp.RotateResult.EncryptedNewRecoveryKey, nil
+}
+
+type profileListResponse struct {
+	ProfileList []store.InstalledProfile
+}
+
+// ParseProfileList decodes the raw plist payload of a ProfileList
+// acknowledgment.
+func ParseProfileList(raw []byte) ([]store.InstalledProfile, error) {
+	var resp profileListResponse
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("decode ProfileList response: %v", err)
+	}
+	return resp.ProfileList, nil
+}
+
+// DiffProfiles compares the installed profiles against the expected profile
+// identifiers. It returns the expected identifiers that are not installed,
+// and the installed identifiers that were not expected.
+func DiffProfiles(installed []store.InstalledProfile, expected []string) (missing, unexpected []string) {
+	have := make(map[string]bool, len(installed))
+	for _, p := range installed {
+		have[p.Identifier] = true
+	}
+	want := make(map[string]bool, len(expected))
+	for _, id := range expected {
+		want[id] = true
+		if !have[id] {
+			missing = append(missing, id)
+		}
+	}
+	for _, p := range installed {
+		if !want[p.Identifier] {
+			unexpected = append(unexpected, p.Identifier)
+		}
+	}
+	return missing, unexpected
+}
+
+type certificateListResponse struct {
+	CertificateList []struct {
+		CommonName string
+		Data       []byte
+		IsIdentity bool
+	}
+}
+
+// ParseCertificateList decodes the raw plist payload of a CertificateList
+// acknowledgment. Certificates that fail to parse are skipped and reported
+// in the returned error alongside the ones that did parse.
+func ParseCertificateList(raw []byte) ([]store.DeviceCertificate, error) {
+	var resp certificateListResponse
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("decode CertificateList response: %v", err)
+	}
+	var (
+		certs   []store.DeviceCertificate
+		skipped int
+	)
+	for _, c := range resp.CertificateList {
+		cert, err := x509.ParseCertificate(c.Data)
+		if err != nil {
+			skipped++
+			continue
+		}
+		certs = append(certs, store.DeviceCertificate{
+			CommonName: c.CommonName,
+			Subject:    cert.Subject.String(),
+			Issuer:     cert.Issuer.String(),
+			NotBefore:  cert.NotBefore,
+			NotAfter:   cert.NotAfter,
+			IsIdentity: c.IsIdentity,
+		})
+	}
+	if skipped > 0 {
+		return certs, fmt.Errorf("skipped %d unparseable certificates", skipped)
+	}
+	return certs, nil
+}
+
+// ExpiringIdentities returns the identity certificates that expire before
+// now+within.
+func ExpiringIdentities(certs []store.DeviceCertificate, now time.Time, within time.Duration) []store.DeviceCertificate {
+	var expiring []store.DeviceCertificate
+	deadline := now.Add(within)
+	for _, c := range certs {
+		if c.IsIdentity && c.NotAfter.Before(deadline) {
+			expiring = append(expiring, c)
+		}
+	}
+	return expiring
+}
+
+type availableOSUpdatesResponse struct {
+	AvailableOSUpdates []store.AvailableOSUpdate
+}
+
+// ParseAvailableOSUpdates decodes the raw plist payload of an
+// AvailableOSUpdates acknowledgment.
+func ParseAvailableOSUpdates(raw []byte) ([]store.AvailableOSUpdate, error) {
+	var resp availableOSUpdatesResponse
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("decode AvailableOSUpdates response: %v", err)
+	}
+	return resp.AvailableOSUpdates, nil
+}
+
+// OSUpdateProgress is the progress of one update, as reported in an
+// OSUpdateStatus or ScheduleOSUpdate response.
+type OSUpdateProgress struct {
+	ProductKey              string
+	Status                  string
+	IsDownloaded            bool
+	DownloadPercentComplete float64
+}
+
+type osUpdateStatusResponse struct {
+	OSUpdateStatus []OSUpdateProgress
+	UpdateResults  []OSUpdateProgress
+}
+
+// ParseOSUpdateStatus decodes the raw plist payload of an OSUpdateStatus
+// acknowledgment, or the UpdateResults of a ScheduleOSUpdate one.
+func ParseOSUpdateStatus(raw []byte) ([]OSUpdateProgress, error) {
+	var resp osUpdateStatusResponse
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("decode OSUpdateStatus response: %v", err)
+	}
+	return append(resp.OSUpdateStatus, resp.UpdateResults...), nil
+}
+
+// SettingResult is the outcome of one item of a Settings command.
+type SettingResult struct {
+	Item       string
+	Status     string
+	ErrorChain []mdm.ErrorChainItem
+}
+
+type settingsResponse struct {
+	Settings []SettingResult
+}
+
+// ParseSettings decodes the raw plist payload of a Settings acknowledgment.
+func ParseSettings(raw []byte) ([]SettingResult, error) {
+	var resp settingsResponse
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return nil, fmt.Errorf("decode Settings response: %v", err)
+	}
+	return resp.Settings, nil
+}
+
+type deviceLocationResponse struct {
+	Latitude           float64
+	Longitude          float64
+	HorizontalAccuracy float64
+	VerticalAccuracy   float64
+	Altitude           float64
+	Speed              float64
+	Course             float64
+	Timestamp          string
+}
+
+// ParseDeviceLocation decodes the raw plist payload of a DeviceLocation
+// acknowledgment. Timestamp is nil when the device did not send a valid
+// one.
+func ParseDeviceLocation(raw []byte) (store.DeviceLocation, error) {
+	var resp deviceLocationResponse
+	if err := plist.Unmarshal(raw, &resp); err != nil {
+		return store.DeviceLocation{}, fmt.Errorf("decode DeviceLocation response: %v", err)
+	}
+	loc := store.DeviceLocation{
+		Latitude:           resp.Latitude,
+		Longitude:          resp.Longitude,
+		HorizontalAccuracy: resp.HorizontalAccuracy,
+		VerticalAccuracy:   resp.VerticalAccuracy,
+		Altitude:           resp.Altitude,
+		Speed:              resp.Speed,
+		Course:             resp.Course,
+	}
+	if t, err := time.Parse(time.RFC3339, resp.Timestamp); err == nil {
+		t = t.UTC()
+		loc.Timestamp = &t
+	}
+	return loc, nil
+}
+
+type statusReport struct {
+	StatusItems struct {
+		Management struct {
+			Declarations map[string][]declarationStatus `json:"declarations"`
+		} `json:"management"`
+	}
+}
+
+type declarationStatus struct {
+	Identifier  string `json:"identifier"`
+	ServerToken string `json:"server-token"`
+	Valid       string `json:"valid"`
+	Active      bool   `json:"active"`
+	Reasons     []struct {
+		Code        string `json:"code"`
+		Description string `json:"description"`
+	} `json:"reasons"`
+}
+
+// declarationTypes maps the keys of the declarations of status reports to
+// the types of declaration they list.
+var declarationTypes = map[string]string{
+	"activations":    "activation",
+	"configurations": "configuration",
+	"assets":         "asset",
+	"management":     "management",
+}
+
+// ParseStatusReport decodes the declaration statuses of a Declarative
+// Device Management status report, the JSON Data of a DeclarativeManagement
+// check-in to the status endpoint. Reports only carry what changed since the
+// last one.
+func ParseStatusReport(data []byte) ([]store.DeclarationStatus, error) {
+	var report statusReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("decode status report: %v", err)
+	}
+	var statuses []store.DeclarationStatus
+	for key, items := range report.StatusItems.Management.Declarations {
+		typ, ok := declarationTypes[key]
+		if !ok {
+			continue
+		}
+		for _, item := range items {
+			s := store.DeclarationStatus{Identifier: item.Identifier, Type: typ, ServerToken: item.ServerToken, Valid: item.Valid, Active: item.Active}
+			for _, r := range item.Reasons {
+				if r.Description != "" {
+					s.Reasons = append(s.Reasons, r.Code+": "+r.Description)
+				} else {
+					s.Reasons = append(s.Reasons, r.Code)
+				}
+			}
+			statuses = append(statuses, s)
+		}
+	}
+	slices.SortFunc(statuses, func(a, b store.DeclarationStatus) int {
+		return strings.Compare(a.Type+"/"+a.Identifier, b.Type+"/"+b.Identifier)
+	})
+	return statuses, nil
+}
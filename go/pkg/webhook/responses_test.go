@@ -0,0 +1,189 @@
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+)
+
+// response wraps the keys of a command response in a plist document.
+func response(keys string) []byte {
+	return []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0"><dict>
+<key>CommandUUID</key><string>C</string>
+<key>Status</key><string>Acknowledged</string>
+<key>UDID</key><string>U</string>
+` + keys + `
+</dict></plist>`)
+}
+
+func TestParseInstalledApplicationList(t *testing.T) {
+	apps, err := ParseInstalledApplicationList(response(`<key>InstalledApplicationList</key><array>
+<dict>
+	<key>Identifier</key><string>com.apple.Pages</string>
+	<key>Name</key><string>Pages</string>
+	<key>ShortVersion</key><string>14.0</string>
+	<key>Version</key><string>7029</string>
+	<key>BundleSize</key><integer>581632000</integer>
+</dict>
+<dict>
+	<key>Identifier</key><string>com.example.tool</string>
+	<key>Name</key><string>Tool</string>
+</dict>
+</array>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []store.InstalledApp{
+		{Identifier: "com.apple.Pages", Name: "Pages", ShortVersion: "14.0", Version: "7029", BundleSize: 581632000},
+		{Identifier: "com.example.tool", Name: "Tool"},
+	}
+	if !reflect.DeepEqual(apps, want) {
+		t.Errorf("got %+v, want %+v", apps, want)
+	}
+
+	if _, err := ParseInstalledApplicationList([]byte("not a plist")); err == nil {
+		t.Error("parsed a response that is not a plist")
+	}
+}
+
+func TestParseDeviceInformation(t *testing.T) {
+	info, err := ParseDeviceInformation(response(`<key>QueryResponses</key><dict>
+	<key>DeviceName</key><string>Lab iPad</string>
+	<key>OSVersion</key><string>17.4</string>
+	<key>Model</key><string>iPad13,18</string>
+	<key>SerialNumber</key><string>DMPXXXXXXX</string>
+	<key>BatteryLevel</key><real>0.75</real>
+	<key>IsSupervised</key><true/>
+</dict>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := store.DeviceInfo{DeviceName: "Lab iPad", OSVersion: "17.4", Model: "iPad13,18", SerialNumber: "DMPXXXXXXX", BatteryLevel: 0.75, IsSupervised: true}
+	if info != want {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+}
+
+func TestParseSecurityInfo(t *testing.T) {
+	enabled := true
+	tests := []struct {
+		name string
+		keys string
+		want store.SecurityPosture
+	}{
+		{
+			name: "mac",
+			keys: `<key>SecurityInfo</key><dict>
+	<key>FDE_Enabled</key><true/>
+	<key>FDE_HasPersonalRecoveryKey</key><true/>
+	<key>FDE_HasInstitutionalRecoveryKey</key><false/>
+	<key>SystemIntegrityProtectionEnabled</key><true/>
+	<key>FirewallSettings</key><dict>
+		<key>FirewallEnabled</key><true/>
+		<key>BlockAllIncoming</key><false/>
+		<key>StealthMode</key><true/>
+	</dict>
+</dict>`,
+			want: store.SecurityPosture{FDEEnabled: true, FDEHasPersonalRecoveryKey: true, FirewallEnabled: true, FirewallStealthMode: true, SIPEnabled: &enabled},
+		},
+		{
+			name: "ipad",
+			keys: `<key>SecurityInfo</key><dict>
+	<key>PasscodePresent</key><true/>
+	<key>PasscodeCompliant</key><true/>
+</dict>`,
+			want: store.SecurityPosture{PasscodePresent: true, PasscodeCompliant: true},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseSecurityInfo(response(tt.keys))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseProfileList(t *testing.T) {
+	installed, err := ParseProfileList(response(`<key>ProfileList</key><array>
+<dict>
+	<key>PayloadIdentifier</key><string>com.example.wifi</string>
+	<key>PayloadUUID</key><string>1111</string>
+	<key>PayloadDisplayName</key><string>Wi-Fi</string>
+	<key>IsManaged</key><true/>
+</dict>
+<dict>
+	<key>PayloadIdentifier</key><string>com.example.vpn</string>
+	<key>PayloadUUID</key><string>2222</string>
+</dict>
+</array>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []store.InstalledProfile{
+		{Identifier: "com.example.wifi", UUID: "1111", DisplayName: "Wi-Fi", IsManaged: true},
+		{Identifier: "com.example.vpn", UUID: "2222"},
+	}
+	if !reflect.DeepEqual(installed, want) {
+		t.Fatalf("got %+v, want %+v", installed, want)
+	}
+
+	missing, unexpected := DiffProfiles(installed, []string{"com.example.wifi", "com.example.restrictions"})
+	if !reflect.DeepEqual(missing, []string{"com.example.restrictions"}) || !reflect.DeepEqual(unexpected, []string{"com.example.vpn"}) {
+		t.Errorf("DiffProfiles = missing %q, unexpected %q", missing, unexpected)
+	}
+}
+
+func TestParseCertificateList(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	certificate := func(name string, notAfter time.Time) string {
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: name}, NotBefore: now.AddDate(-1, 0, 0), NotAfter: notAfter}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(der)
+	}
+	entry := func(name, data string, identity bool) string {
+		return fmt.Sprintf(`<dict><key>CommonName</key><string>%s</string><key>Data</key><data>%s</data><key>IsIdentity</key><%t/></dict>`, name, data, identity)
+	}
+
+	certs, err := ParseCertificateList(response(`<key>CertificateList</key><array>` +
+		entry("Device Identity", certificate("Device Identity", now.AddDate(0, 0, 10)), true) +
+		entry("Root CA", certificate("Root CA", now.AddDate(0, 0, 5)), false) +
+		entry("Wi-Fi", certificate("Wi-Fi", now.AddDate(1, 0, 0)), true) +
+		entry("Broken", base64.StdEncoding.EncodeToString([]byte("not DER")), true) +
+		`</array>`))
+	if err == nil {
+		t.Error("no error for the unparseable certificate")
+	}
+	if len(certs) != 3 {
+		t.Fatalf("parsed %d certificates, want 3: %+v", len(certs), certs)
+	}
+	if c := certs[0]; c.CommonName != "Device Identity" || c.Subject != "CN=Device Identity" || !c.IsIdentity || !c.NotAfter.Equal(now.AddDate(0, 0, 10)) {
+		t.Errorf("first certificate = %+v", c)
+	}
+
+	expiring := ExpiringIdentities(certs, now, 30*24*time.Hour)
+	if len(expiring) != 1 || expiring[0].CommonName != "Device Identity" {
+		t.Errorf("ExpiringIdentities = %+v, want the device identity", expiring)
+	}
+}
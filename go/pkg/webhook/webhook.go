@@ -0,0 +1,129 @@
+// Package webhook decodes the events MicroMDM posts to its webhook, and the
+// command responses devices send in them.
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/micromdm/micromdm/mdm"
+	"github.com/micromdm/micromdm/workflow/webhook"
+)
+
+// Event is an event posted by MicroMDM.
+type Event = webhook.Event
+
+// DecodeEvent decodes the JSON body of a webhook request.
+func DecodeEvent(body []byte) (Event, error) {
+	var event Event
+	err := json.Unmarshal(body, &event)
+	return event, err
+}
+
+// Acknowledgment is a decoded command response from a device.
+type Acknowledgment struct {
+	UDID        string
+	CommandUUID string
+	Status      string
+	// UserID is set for responses on the channel of a macOS user of the
+	// device, rather than the device's own.
+	UserID string
+	// RequestType is the command this is a response to. Devices do not echo
+	// it back, so DecodeAcknowledgment infers it from the keys present in
+	// the payload; callers tracking the commands they sent may replace it
+	// with that of the command with the same UUID.
+	RequestType string
+	ErrorChain  []mdm.ErrorChainItem
+
+	Time time.Time `plist:"-"`
+	Raw  []byte    `plist:"-"`
+}
+
+// responseKey is a top-level key of a command response and the RequestType
+// of the command that produces it.
+type responseKey struct {
+	key, requestType string
+}
+
+// responseKeys are the keys DecodeAcknowledgment looks for, in order, so
+// that a response carrying more than one of them always gets the same
+// request type: that of the first.
+var responseKeys = []responseKey{
+	{"InstalledApplicationList", "InstalledApplicationList"},
+	{"ManagedApplicationList", "ManagedApplicationList"},
+	{"QueryResponses", "DeviceInformation"},
+	{"SecurityInfo", "SecurityInfo"},
+	{"ProfileList", "ProfileList"},
+	{"CertificateList", "CertificateList"},
+	{"Settings", "Settings"},
+	{"AvailableOSUpdates", "AvailableOSUpdates"},
+	{"OSUpdateStatus", "OSUpdateStatus"},
+	{"UpdateResults", "ScheduleOSUpdate"},
+	{"Latitude", "DeviceLocation"},
+	{"RotateResult", "RotateFileVaultKey"},
+}
+
+// RegisterResponseKey makes DecodeAcknowledgment take responses carrying the
+// top-level key for responses to requestType, after the keys registered
+// before it. It must be called before responses are decoded, e.g. from init,
+// and returns an error if key already identifies another request type.
+func RegisterResponseKey(key, requestType string) error {
+	for _, k := range responseKeys {
+		if k.key != key {
+			continue
+		}
+		if k.requestType != requestType {
+			return fmt.Errorf("response key %s already identifies %s", key, k.requestType)
+		}
+		return nil
+	}
+	responseKeys = append(responseKeys, responseKey{key, requestType})
+	return nil
+}
+
+// DecodeAcknowledgment decodes the common fields of a raw command response
+// and determines which command it answers.
+func DecodeAcknowledgment(raw []byte) (Acknowledgment, error) {
+	var ack Acknowledgment
+	if err := plist.Unmarshal(raw, &ack); err != nil {
+		return ack, fmt.Errorf("decode acknowledgment: %v", err)
+	}
+	ack.Raw = raw
+
+	var fields map[string]interface{}
+	if err := plist.Unmarshal(raw, &fields); err != nil {
+		return ack, fmt.Errorf("decode acknowledgment keys: %v", err)
+	}
+	for _, k := range responseKeys {
+		if _, ok := fields[k.key]; ok {
+			ack.RequestType = k.requestType
+			break
+		}
+	}
+	return ack, nil
+}
+
+// DeclarativeManagementMessage is a DeclarativeManagement check-in, with
+// which devices fetch their declarations and send status reports. Endpoint
+// is tokens, declaration-items, declaration/<type>/<identifier>, or status,
+// and Data the JSON body of status reports.
+type DeclarativeManagementMessage struct {
+	UDID     string
+	Endpoint string
+	Data     []byte
+}
+
+// DecodeDeclarativeManagement decodes the raw plist of a
+// DeclarativeManagement check-in.
+func DecodeDeclarativeManagement(raw []byte) (DeclarativeManagementMessage, error) {
+	var msg DeclarativeManagementMessage
+	if err := plist.Unmarshal(raw, &msg); err != nil {
+		return msg, fmt.Errorf("decode DeclarativeManagement message: %v", err)
+	}
+	if msg.Endpoint == "" {
+		return msg, fmt.Errorf("DeclarativeManagement message has no Endpoint")
+	}
+	return msg, nil
+}
@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/groob/plist"
+)
+
+func TestDecodeAcknowledgment(t *testing.T) {
+	defer func(keys []responseKey) { responseKeys = keys }(append([]responseKey(nil), responseKeys...))
+	if err := RegisterResponseKey("Widgets", "WidgetList"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterResponseKey("Widgets", "WidgetList"); err != nil {
+		t.Errorf("registering a key again: %v", err)
+	}
+	if err := RegisterResponseKey("Settings", "WidgetList"); err == nil {
+		t.Error("registered Settings for another request type")
+	}
+
+	tests := []struct {
+		name        string
+		fields      map[string]interface{}
+		requestType string
+	}{
+		{name: "plain", fields: map[string]interface{}{}},
+		{name: "device information", fields: map[string]interface{}{"QueryResponses": map[string]interface{}{"OSVersion": "17.4"}}, requestType: "DeviceInformation"},
+		{name: "registered", fields: map[string]interface{}{"Widgets": []string{"w"}}, requestType: "WidgetList"},
+		{
+			name:        "two marker keys",
+			fields:      map[string]interface{}{"SecurityInfo": map[string]interface{}{}, "QueryResponses": map[string]interface{}{}},
+			requestType: "DeviceInformation",
+		},
+	}
+	for _, tt := range tests {
+		tt.fields["UDID"] = "U"
+		tt.fields["Status"] = "Acknowledged"
+		tt.fields["CommandUUID"] = "C"
+		raw, err := plist.Marshal(tt.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Decode repeatedly, so that an order that depends on map iteration
+		// shows up.
+		for i := 0; i < 20; i++ {
+			ack, err := DecodeAcknowledgment(raw)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			if ack.UDID != "U" || ack.Status != "Acknowledged" || ack.CommandUUID != "C" {
+				t.Fatalf("%s: decoded %+v", tt.name, ack)
+			}
+			if ack.RequestType != tt.requestType {
+				t.Fatalf("%s: request type %q, want %q", tt.name, ack.RequestType, tt.requestType)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The platforms of devices, as stored in Device.Platform.
+const (
+	platformMacOS  = "macOS"
+	platformIOS    = "iOS"
+	platformIPadOS = "iPadOS"
+	platformTVOS   = "tvOS"
+)
+
+// platforms lists the platforms, in the order they are documented.
+var platforms = []string{platformMacOS, platformIOS, platformIPadOS, platformTVOS}
+
+// unsupportedVars counts, by request type, the commands not sent to devices
+// whose platform does not take them.
+var unsupportedVars = expvar.NewMap("unsupported_commands")
+
+// platformPrefixes maps the prefixes of the product names devices report,
+// such as iPhone15,2 or AppleTV11,1, and of their model names, such as iPad
+// or Apple TV, to their platform. Macs are told by "Mac" anywhere in them,
+// as in MacBookPro18,3 or iMac21,1.
+var platformPrefixes = []struct{ prefix, platform string }{
+	{"iPhone", platformIOS},
+	{"iPod", platformIOS},
+	{"iPad", platformIPadOS},
+	{"AppleTV", platformTVOS},
+	{"Apple TV", platformTVOS},
+}
+
+// detectPlatform returns the platform of a device from the product name,
+// model, and model name it reported, in Authenticate or DeviceInformation,
+// or "" if they do not tell.
+func detectPlatform(info *DeviceInfo) string {
+	if info == nil {
+		return ""
+	}
+	for _, name := range []string{info.ProductName, info.Model, info.ModelName} {
+		if strings.Contains(name, "Mac") {
+			return platformMacOS
+		}
+		for _, p := range platformPrefixes {
+			if strings.HasPrefix(name, p.prefix) {
+				return p.platform
+			}
+		}
+	}
+	return ""
+}
+
+// updatePlatform sets d.Platform from d.Info, unless it does not tell.
+func updatePlatform(d *Device) {
+	if p := detectPlatform(d.Info); p != "" {
+		d.Platform = p
+	}
+}
+
+// commandPlatforms lists the platforms that take the request types only some
+// of them do. Other request types are sent to every device.
+var commandPlatforms = map[string][]string{
+	"ActivationLockBypassCode":     {platformMacOS, platformIOS, platformIPadOS},
+	"ClearPasscode":                {platformIOS, platformIPadOS},
+	"ClearRestrictionsPassword":    {platformIOS, platformIPadOS},
+	"DeleteUser":                   {platformMacOS, platformIPadOS},
+	"DeviceLocation":               {platformIOS, platformIPadOS},
+	"DisableLostMode":              {platformIOS, platformIPadOS},
+	"DisableRemoteDesktop":         {platformMacOS},
+	"EnableLostMode":               {platformIOS, platformIPadOS},
+	"EnableRemoteDesktop":          {platformMacOS},
+	"InstallEnterpriseApplication": {platformMacOS},
+	"InstallMedia":                 {platformIOS, platformIPadOS},
+	"LogOutUser":                   {platformIPadOS},
+	"ManagedMediaList":             {platformIOS, platformIPadOS},
+	"PlayLostModeSound":            {platformIOS, platformIPadOS},
+	"RemoveMedia":                  {platformIOS, platformIPadOS},
+	"RotateFileVaultKey":           {platformMacOS},
+	"SetAutoAdminPassword":         {platformMacOS},
+	"SetFirmwarePassword":          {platformMacOS},
+	"SetRecoveryLock":              {platformMacOS},
+	"ShutDownDevice":               {platformMacOS, platformIOS, platformIPadOS},
+	"UserList":                     {platformMacOS},
+	"VerifyFirmwarePassword":       {platformMacOS},
+	"VerifyRecoveryLock":           {platformMacOS},
+}
+
+// checkPlatform returns an error if requestType is not supported on the
+// platform of d. Devices whose platform is not known yet are given the
+// benefit of the doubt.
+func checkPlatform(d Device, requestType string) error {
+	supported, ok := commandPlatforms[requestType]
+	if !ok || d.Platform == "" || slices.Contains(supported, d.Platform) {
+		return nil
+	}
+	return fmt.Errorf("%s is not supported on %s", requestType, d.Platform)
+}
+
+// skipUnsupported reports whether requestType is not supported on the
+// platform of d, logging and counting the command, which is then not sent.
+func skipUnsupported(ctx context.Context, d Device, requestType string) bool {
+	err := checkPlatform(d, requestType)
+	if err == nil {
+		return false
+	}
+	unsupportedVars.Add(requestType, 1)
+	logFor(ctx).WithFields(logrus.Fields{"udid": d.UDID, "request_type": requestType, "platform": d.Platform}).Info("not sending command: " + err.Error())
+	return true
+}
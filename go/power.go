@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// RestartOptions are the fields of a RestartDevice command.
+type RestartOptions struct {
+	NotifyUser bool `json:"notify_user,omitempty"`
+}
+
+// restartCommand returns the RestartDevice command for d.
+func restartCommand(d Device, opts RestartOptions) (Command, error) {
+	if err := checkPowerCommand(d, "RestartDevice"); err != nil {
+		return Command{}, err
+	}
+	return Command{UDID: d.UDID, RequestType: "RestartDevice", NotifyUser: opts.NotifyUser}, nil
+}
+
+// shutDownCommand returns the ShutDownDevice command for d.
+func shutDownCommand(d Device) (Command, error) {
+	if err := checkPowerCommand(d, "ShutDownDevice"); err != nil {
+		return Command{}, err
+	}
+	return Command{UDID: d.UDID, RequestType: "ShutDownDevice"}, nil
+}
+
+// checkPowerCommand returns an error if d would reject requestType, a
+// RestartDevice or ShutDownDevice command: devices other than Macs only take
+// them when supervised. Devices that have not said what they are yet are
+// given the benefit of the doubt.
+func checkPowerCommand(d Device, requestType string) error {
+	if mac, known := isMac(d); known && !mac && !d.Info.IsSupervised {
+		return fmt.Errorf("%s requires a supervised device, and %s is not supervised", requestType, d.UDID)
+	}
+	return nil
+}
+
+// handleRestartDevice queues a RestartDevice command for a supervised
+// device or a Mac. The optional body is a JSON RestartOptions.
+func (s *Server) handleRestartDevice(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCommandBody))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("read request: %v", err), http.StatusBadRequest)
+		return
+	}
+	var opts RestartOptions
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &opts); err != nil {
+			http.Error(w, fmt.Sprintf("invalid restart options: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	c, err := restartCommand(d, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.postAPICommand(w, r, c)
+}
+
+// handleShutDownDevice queues a ShutDownDevice command for a supervised
+// device or a Mac.
+func (s *Server) handleShutDownDevice(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	c, err := shutDownCommand(d)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.postAPICommand(w, r, c)
+}
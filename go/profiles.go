@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/google/uuid"
+	"github.com/groob/plist"
+)
+
+// profile is a configuration profile to install with InstallProfile. XML
+// profiles are templates executed with the profileVars of the device they
+// are installed on, so one file can carry per-device values such as
+// {{.UDID}}, {{xml .Info.DeviceName}}, or {{xml (.Secret "wifi-password")}},
+// and signed once rendered if profile signing is set up. Signed and binary
+// profiles are sent as they are.
+type profile struct {
+	name string
+	raw  []byte
+	tmpl *template.Template
+
+	// secret is set if the template reads secrets.
+	secret bool
+}
+
+// profileFuncs are the functions profile templates can call besides the
+// builtin ones.
+var profileFuncs = template.FuncMap{
+	// xml escapes a value for use in a plist string.
+	"xml": func(v interface{}) (string, error) {
+		var b bytes.Buffer
+		err := xml.EscapeText(&b, []byte(fmt.Sprint(v)))
+		return b.String(), err
+	},
+}
+
+// loadProfile reads the profile at path, resolving a relative path against
+// dir.
+func loadProfile(path, dir string) (*profile, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read profile: %v", err)
+	}
+	return parseProfile(filepath.Base(path), raw)
+}
+
+// parseProfile returns the profile named name with the contents raw.
+func parseProfile(name string, raw []byte) (*profile, error) {
+	p := &profile{name: name, raw: raw}
+	if bytes.HasPrefix(bytes.TrimSpace(bytes.TrimPrefix(raw, []byte("\xef\xbb\xbf"))), []byte("<")) {
+		t, err := template.New(name).Funcs(profileFuncs).Option("missingkey=error").Parse(string(raw))
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %v", name, err)
+		}
+		p.tmpl, p.secret = t, readsSecret(t)
+	}
+	return p, nil
+}
+
+// readsSecret reports whether the template t, or a template it defines,
+// reads a profile secret with .Secret.
+func readsSecret(t *template.Template) bool {
+	var walk func(n parse.Node) bool
+	walk = func(n parse.Node) bool {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			return n != nil && slices.ContainsFunc(n.Nodes, walk)
+		case *parse.ActionNode:
+			return walk(n.Pipe)
+		case *parse.IfNode:
+			return walk(n.Pipe) || walk(n.List) || walk(n.ElseList)
+		case *parse.RangeNode:
+			return walk(n.Pipe) || walk(n.List) || walk(n.ElseList)
+		case *parse.WithNode:
+			return walk(n.Pipe) || walk(n.List) || walk(n.ElseList)
+		case *parse.TemplateNode:
+			return walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return false
+			}
+			for _, c := range n.Cmds {
+				if slices.ContainsFunc(c.Args, walk) {
+					return true
+				}
+			}
+		case *parse.FieldNode:
+			return slices.Contains(n.Ident, "Secret")
+		case *parse.VariableNode:
+			return slices.Contains(n.Ident, "Secret")
+		case *parse.ChainNode:
+			return walk(n.Node) || slices.Contains(n.Field, "Secret")
+		}
+		return false
+	}
+	return slices.ContainsFunc(t.Templates(), func(t *template.Template) bool {
+		return t.Tree != nil && walk(t.Tree.Root)
+	})
+}
+
+// profileVars is what profile templates are executed with: the Device, whose
+// fields they read as {{.UDID}} or {{.Info.OSVersion}}, and the values below.
+type profileVars struct {
+	Device
+
+	// Serial is the serial number of the device, from its Authenticate
+	// message or, before it enrolls, DEP.
+	Serial string
+
+	// User is the user the profile is installed for: the short name of the
+	// macOS user on whose channel it is installed, or else the owner the
+	// device is assigned to in the imported list of expected devices.
+	User string
+
+	secrets *profileSecrets
+	static  *staticHost
+}
+
+// Secret returns the value of the profile secret called name for the device,
+// failing the rendering if there is none. Templates call it as
+// {{xml (.Secret "name")}}.
+func (v profileVars) Secret(name string) (string, error) {
+	return v.secrets.lookup(name, v.Device)
+}
+
+// StaticURL returns a signed URL of the file of -static-dir at path, valid
+// for -static-url-ttl, e.g. for the icon of a web clip. Templates call it as
+// {{xml (.StaticURL "path")}}.
+func (v profileVars) StaticURL(path string) (string, error) {
+	if v.static == nil {
+		return "", fmt.Errorf("no static file %q: -static-dir is not set", path)
+	}
+	u, err := v.static.fileURL(path, v.static.ttl)
+	if err != nil {
+		return "", fmt.Errorf("static file %q: %v", path, err)
+	}
+	return u.URL, nil
+}
+
+// profileVars returns the profileVars of d, for profiles installed on the
+// channel of the user with userID if it is set.
+func (s *Server) profileVars(d Device, userID string) profileVars {
+	v := profileVars{Device: d, Serial: profileSerial(d), secrets: s.ProfileSecrets, static: s.Static}
+	if u := d.User(userID); userID != "" && u != nil {
+		v.User = u.ShortName
+	}
+	if v.User == "" && d.Asset != nil {
+		v.User = d.Asset.Owner
+	}
+	return v
+}
+
+// profileSerial returns the serial number of d, which DEP lists for devices
+// that have not enrolled.
+func profileSerial(d Device) string {
+	if serial := deviceSerial(d); serial != "" {
+		return serial
+	}
+	if d.DEP != nil {
+		return d.DEP.SerialNumber
+	}
+	return ""
+}
+
+// render returns the profile as installed with v.
+func (p *profile) render(v profileVars) ([]byte, error) {
+	if p.tmpl == nil {
+		return p.raw, nil
+	}
+	var b bytes.Buffer
+	if err := p.tmpl.Execute(&b, v); err != nil {
+		return nil, fmt.Errorf("render profile %s: %v", p.name, err)
+	}
+	return b.Bytes(), nil
+}
+
+// profileIdentifier returns the PayloadIdentifier of a rendered profile. The
+// XML of signed profiles is found inside their signature.
+func profileIdentifier(raw []byte) (string, error) {
+	if i := bytes.Index(raw, []byte("<?xml")); i > 0 {
+		raw = raw[i:]
+		if j := bytes.Index(raw, []byte("</plist>")); j >= 0 {
+			raw = raw[:j+len("</plist>")]
+		}
+	}
+	var p struct{ PayloadIdentifier string }
+	if len(raw) == 0 {
+		return "", fmt.Errorf("empty profile")
+	}
+	if err := plist.Unmarshal(raw, &p); err != nil {
+		return "", fmt.Errorf("decode profile: %v", err)
+	}
+	if p.PayloadIdentifier == "" {
+		return "", fmt.Errorf("profile has no PayloadIdentifier")
+	}
+	return p.PayloadIdentifier, nil
+}
+
+// payloadDict is a dictionary of a generated profile.
+type payloadDict map[string]interface{}
+
+// generatedProfile returns the XML of a device profile with the payloads,
+// the dictionaries of which have their PayloadType, PayloadIdentifier, and
+// content set. PayloadUUIDs are derived from the identifiers, the same on
+// every device so that installing the profile again replaces it.
+func generatedProfile(identifier, displayName string, payloads ...payloadDict) ([]byte, error) {
+	for _, p := range payloads {
+		p["PayloadUUID"] = payloadUUID(p["PayloadIdentifier"].(string))
+		p["PayloadVersion"] = 1
+		p["PayloadDisplayName"] = displayName
+	}
+	b, err := plist.MarshalIndent(payloadDict{
+		"PayloadType":        "Configuration",
+		"PayloadIdentifier":  identifier,
+		"PayloadUUID":        payloadUUID(identifier),
+		"PayloadVersion":     1,
+		"PayloadDisplayName": displayName,
+		"PayloadScope":       "System",
+		"PayloadContent":     payloads,
+	}, "\t")
+	if err != nil {
+		return nil, fmt.Errorf("encode %s profile: %v", displayName, err)
+	}
+	return b, nil
+}
+
+// payloadUUID returns the PayloadUUID of the generated payload identifier.
+func payloadUUID(identifier string) string {
+	return strings.ToUpper(uuid.NewSHA1(uuid.NameSpaceURL, []byte(identifier)).String())
+}
+
+// installProfileCommand returns the InstallProfile command installing p on
+// d, on the channel of the user with userID if it is set, signed if
+// profiles are signed.
+func (s *Server) installProfileCommand(ctx context.Context, d Device, userID string, p *profile) (Command, error) {
+	payload, err := p.render(s.profileVars(d, userID))
+	if err != nil {
+		return Command{}, err
+	}
+	c, err := s.profileCommand(ctx, d, p, payload)
+	if err != nil {
+		return Command{}, err
+	}
+	c.UserID = userID
+	return c, nil
+}
+
+// profileCommand returns the InstallProfile command installing payload, p
+// rendered with d. XML profiles are signed with s.ProfileSigner, if it is
+// set, and not sent if they cannot be.
+func (s *Server) profileCommand(ctx context.Context, d Device, p *profile, payload []byte) (Command, error) {
+	if p.tmpl != nil {
+		signed, err := s.signProfile(ctx, payload)
+		if err != nil {
+			return Command{}, fmt.Errorf("profile %s: %v", p.name, err)
+		}
+		payload = signed
+	}
+	return Command{UDID: d.UDID, RequestType: "InstallProfile", Payload: payload, SecretPayload: p.secret}, nil
+}
+
+// removeProfileCommand returns the RemoveProfile command removing the
+// profile with identifier from d.
+func removeProfileCommand(d Device, identifier string) Command {
+	return Command{UDID: d.UDID, RequestType: "RemoveProfile", Identifier: identifier}
+}
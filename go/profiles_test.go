@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestProfileReadsSecret(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     bool
+	}{
+		{name: "no template", template: "binary", want: false},
+		{name: "device fields", template: `<plist>{{.UDID}} {{xml .Info.DeviceName}}</plist>`, want: false},
+		{name: "secret", template: `<plist>{{xml (.Secret "wifi-password")}}</plist>`, want: true},
+		{name: "root variable", template: `<plist>{{$.Secret "wifi-password"}}</plist>`, want: true},
+		{name: "conditional", template: `<plist>{{if .Enrolled}}{{else}}{{.Secret "x"}}{{end}}</plist>`, want: true},
+		{name: "range", template: `<plist>{{range .Tags}}{{$.Secret .}}{{end}}</plist>`, want: true},
+		{name: "defined template", template: `<plist>{{define "p"}}{{.Secret "x"}}{{end}}{{template "p" .}}</plist>`, want: true},
+		{name: "secret as text", template: `<plist><string>Secret</string>{{.UDID}}</plist>`, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := parseProfile("test.mobileconfig", []byte(tt.template))
+			if err != nil {
+				t.Fatalf("parseProfile: %v", err)
+			}
+			if p.secret != tt.want {
+				t.Errorf("secret = %v, want %v", p.secret, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  Command
+		want     []string
+		wantKeep []string
+	}{
+		{
+			name:     "no secrets",
+			command:  Command{UDID: "U", RequestType: "InstallProfile", Payload: []byte("profile")},
+			wantKeep: []string{"udid", "payload"},
+		},
+		{
+			name:     "PIN",
+			command:  Command{UDID: "U", RequestType: "DeviceLock", PIN: "123456", Message: "lost"},
+			want:     []string{"pin"},
+			wantKeep: []string{"udid", "message"},
+		},
+		{
+			name:     "secret profile",
+			command:  Command{UDID: "U", RequestType: "InstallProfile", Payload: []byte("password"), SecretPayload: true},
+			want:     []string{"payload"},
+			wantKeep: []string{"udid", "request_type"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(tt.command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(body), "secret_payload") || strings.Contains(string(body), "SecretPayload") {
+				t.Errorf("command body %s carries SecretPayload", body)
+			}
+			redacted, got := redactCommand(body, payloadSecrets(tt.command)...)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("redacted fields = %q, want %q", got, tt.want)
+			}
+			var fields map[string]json.RawMessage
+			if err := json.Unmarshal(redacted, &fields); err != nil {
+				t.Fatalf("decode redacted command: %v", err)
+			}
+			for _, name := range tt.want {
+				if _, ok := fields[name]; ok {
+					t.Errorf("redacted command %s still has %q", redacted, name)
+				}
+			}
+			for _, name := range tt.wantKeep {
+				if _, ok := fields[name]; !ok {
+					t.Errorf("redacted command %s lost %q", redacted, name)
+				}
+			}
+		})
+	}
+	if _, got := redactCommand([]byte("not JSON")); !slices.Equal(got, []string{"command"}) {
+		t.Errorf("redacted fields of invalid body = %q, want command", got)
+	}
+}
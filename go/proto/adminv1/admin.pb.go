@@ -0,0 +1,1664 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.12
+// 	protoc        (unknown)
+// source: adminv1/admin.proto
+
+// The gRPC admin API of the micromdm-webhook Go listener. It offers the same
+// operations as the HTTP admin API, plus a live stream of webhook events.
+// Every call needs the admin token as "authorization: Bearer <token>"
+// metadata.
+
+package adminv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Device struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Udid          string                 `protobuf:"bytes,1,opt,name=udid,proto3" json:"udid,omitempty"`
+	Enrolled      bool                   `protobuf:"varint,2,opt,name=enrolled,proto3" json:"enrolled,omitempty"`
+	LastSeen      *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`
+	InstalledApps []*InstalledApp        `protobuf:"bytes,4,rep,name=installed_apps,json=installedApps,proto3" json:"installed_apps,omitempty"`
+	Info          *DeviceInfo            `protobuf:"bytes,5,opt,name=info,proto3" json:"info,omitempty"`
+	Security      *SecurityPosture       `protobuf:"bytes,6,opt,name=security,proto3" json:"security,omitempty"`
+	Profiles      []*InstalledProfile    `protobuf:"bytes,7,rep,name=profiles,proto3" json:"profiles,omitempty"`
+	Certificates  []*DeviceCertificate   `protobuf:"bytes,8,rep,name=certificates,proto3" json:"certificates,omitempty"`
+	Tags          []string               `protobuf:"bytes,9,rep,name=tags,proto3" json:"tags,omitempty"`
+	// platform is macOS, iOS, iPadOS, or tvOS, or empty until the device has
+	// said what it is.
+	Platform      string `protobuf:"bytes,10,opt,name=platform,proto3" json:"platform,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Device) Reset() {
+	*x = Device{}
+	mi := &file_adminv1_admin_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Device) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Device) ProtoMessage() {}
+
+func (x *Device) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Device.ProtoReflect.Descriptor instead.
+func (*Device) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Device) GetUdid() string {
+	if x != nil {
+		return x.Udid
+	}
+	return ""
+}
+
+func (x *Device) GetEnrolled() bool {
+	if x != nil {
+		return x.Enrolled
+	}
+	return false
+}
+
+func (x *Device) GetLastSeen() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastSeen
+	}
+	return nil
+}
+
+func (x *Device) GetInstalledApps() []*InstalledApp {
+	if x != nil {
+		return x.InstalledApps
+	}
+	return nil
+}
+
+func (x *Device) GetInfo() *DeviceInfo {
+	if x != nil {
+		return x.Info
+	}
+	return nil
+}
+
+func (x *Device) GetSecurity() *SecurityPosture {
+	if x != nil {
+		return x.Security
+	}
+	return nil
+}
+
+func (x *Device) GetProfiles() []*InstalledProfile {
+	if x != nil {
+		return x.Profiles
+	}
+	return nil
+}
+
+func (x *Device) GetCertificates() []*DeviceCertificate {
+	if x != nil {
+		return x.Certificates
+	}
+	return nil
+}
+
+func (x *Device) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Device) GetPlatform() string {
+	if x != nil {
+		return x.Platform
+	}
+	return ""
+}
+
+type InstalledApp struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Identifier    string                 `protobuf:"bytes,1,opt,name=identifier,proto3" json:"identifier,omitempty"`
+	Name          string                 `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	ShortVersion  string                 `protobuf:"bytes,3,opt,name=short_version,json=shortVersion,proto3" json:"short_version,omitempty"`
+	Version       string                 `protobuf:"bytes,4,opt,name=version,proto3" json:"version,omitempty"`
+	BundleSize    int64                  `protobuf:"varint,5,opt,name=bundle_size,json=bundleSize,proto3" json:"bundle_size,omitempty"`
+	DynamicSize   int64                  `protobuf:"varint,6,opt,name=dynamic_size,json=dynamicSize,proto3" json:"dynamic_size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InstalledApp) Reset() {
+	*x = InstalledApp{}
+	mi := &file_adminv1_admin_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InstalledApp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InstalledApp) ProtoMessage() {}
+
+func (x *InstalledApp) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InstalledApp.ProtoReflect.Descriptor instead.
+func (*InstalledApp) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *InstalledApp) GetIdentifier() string {
+	if x != nil {
+		return x.Identifier
+	}
+	return ""
+}
+
+func (x *InstalledApp) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *InstalledApp) GetShortVersion() string {
+	if x != nil {
+		return x.ShortVersion
+	}
+	return ""
+}
+
+func (x *InstalledApp) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *InstalledApp) GetBundleSize() int64 {
+	if x != nil {
+		return x.BundleSize
+	}
+	return 0
+}
+
+func (x *InstalledApp) GetDynamicSize() int64 {
+	if x != nil {
+		return x.DynamicSize
+	}
+	return 0
+}
+
+type DeviceInfo struct {
+	state                   protoimpl.MessageState `protogen:"open.v1"`
+	DeviceName              string                 `protobuf:"bytes,1,opt,name=device_name,json=deviceName,proto3" json:"device_name,omitempty"`
+	OsVersion               string                 `protobuf:"bytes,2,opt,name=os_version,json=osVersion,proto3" json:"os_version,omitempty"`
+	BuildVersion            string                 `protobuf:"bytes,3,opt,name=build_version,json=buildVersion,proto3" json:"build_version,omitempty"`
+	ProductName             string                 `protobuf:"bytes,4,opt,name=product_name,json=productName,proto3" json:"product_name,omitempty"`
+	Model                   string                 `protobuf:"bytes,5,opt,name=model,proto3" json:"model,omitempty"`
+	ModelName               string                 `protobuf:"bytes,6,opt,name=model_name,json=modelName,proto3" json:"model_name,omitempty"`
+	SerialNumber            string                 `protobuf:"bytes,7,opt,name=serial_number,json=serialNumber,proto3" json:"serial_number,omitempty"`
+	BatteryLevel            float64                `protobuf:"fixed64,8,opt,name=battery_level,json=batteryLevel,proto3" json:"battery_level,omitempty"`
+	DeviceCapacity          float64                `protobuf:"fixed64,9,opt,name=device_capacity,json=deviceCapacity,proto3" json:"device_capacity,omitempty"`
+	AvailableDeviceCapacity float64                `protobuf:"fixed64,10,opt,name=available_device_capacity,json=availableDeviceCapacity,proto3" json:"available_device_capacity,omitempty"`
+	IsSupervised            bool                   `protobuf:"varint,11,opt,name=is_supervised,json=isSupervised,proto3" json:"is_supervised,omitempty"`
+	WifiMac                 string                 `protobuf:"bytes,12,opt,name=wifi_mac,json=wifiMac,proto3" json:"wifi_mac,omitempty"`
+	BluetoothMac            string                 `protobuf:"bytes,13,opt,name=bluetooth_mac,json=bluetoothMac,proto3" json:"bluetooth_mac,omitempty"`
+	UpdatedAt               *timestamppb.Timestamp `protobuf:"bytes,14,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	unknownFields           protoimpl.UnknownFields
+	sizeCache               protoimpl.SizeCache
+}
+
+func (x *DeviceInfo) Reset() {
+	*x = DeviceInfo{}
+	mi := &file_adminv1_admin_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeviceInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeviceInfo) ProtoMessage() {}
+
+func (x *DeviceInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeviceInfo.ProtoReflect.Descriptor instead.
+func (*DeviceInfo) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *DeviceInfo) GetDeviceName() string {
+	if x != nil {
+		return x.DeviceName
+	}
+	return ""
+}
+
+func (x *DeviceInfo) GetOsVersion() string {
+	if x != nil {
+		return x.OsVersion
+	}
+	return ""
+}
+
+func (x *DeviceInfo) GetBuildVersion() string {
+	if x != nil {
+		return x.BuildVersion
+	}
+	return ""
+}
+
+func (x *DeviceInfo) GetProductName() string {
+	if x != nil {
+		return x.ProductName
+	}
+	return ""
+}
+
+func (x *DeviceInfo) GetModel() string {
+	if x != nil {
+		return x.Model
+	}
+	return ""
+}
+
+func (x *DeviceInfo) GetModelName() string {
+	if x != nil {
+		return x.ModelName
+	}
+	return ""
+}
+
+func (x *DeviceInfo) GetSerialNumber() string {
+	if x != nil {
+		return x.SerialNumber
+	}
+	return ""
+}
+
+func (x *DeviceInfo) GetBatteryLevel() float64 {
+	if x != nil {
+		return x.BatteryLevel
+	}
+	return 0
+}
+
+func (x *DeviceInfo) GetDeviceCapacity() float64 {
+	if x != nil {
+		return x.DeviceCapacity
+	}
+	return 0
+}
+
+func (x *DeviceInfo) GetAvailableDeviceCapacity() float64 {
+	if x != nil {
+		return x.AvailableDeviceCapacity
+	}
+	return 0
+}
+
+func (x *DeviceInfo) GetIsSupervised() bool {
+	if x != nil {
+		return x.IsSupervised
+	}
+	return false
+}
+
+func (x *DeviceInfo) GetWifiMac() string {
+	if x != nil {
+		return x.WifiMac
+	}
+	return ""
+}
+
+func (x *DeviceInfo) GetBluetoothMac() string {
+	if x != nil {
+		return x.BluetoothMac
+	}
+	return ""
+}
+
+func (x *DeviceInfo) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+type SecurityPosture struct {
+	state                          protoimpl.MessageState `protogen:"open.v1"`
+	PasscodePresent                bool                   `protobuf:"varint,1,opt,name=passcode_present,json=passcodePresent,proto3" json:"passcode_present,omitempty"`
+	PasscodeCompliant              bool                   `protobuf:"varint,2,opt,name=passcode_compliant,json=passcodeCompliant,proto3" json:"passcode_compliant,omitempty"`
+	FdeEnabled                     bool                   `protobuf:"varint,3,opt,name=fde_enabled,json=fdeEnabled,proto3" json:"fde_enabled,omitempty"`
+	FdeHasPersonalRecoveryKey      bool                   `protobuf:"varint,4,opt,name=fde_has_personal_recovery_key,json=fdeHasPersonalRecoveryKey,proto3" json:"fde_has_personal_recovery_key,omitempty"`
+	FdeHasInstitutionalRecoveryKey bool                   `protobuf:"varint,5,opt,name=fde_has_institutional_recovery_key,json=fdeHasInstitutionalRecoveryKey,proto3" json:"fde_has_institutional_recovery_key,omitempty"`
+	FirewallEnabled                bool                   `protobuf:"varint,6,opt,name=firewall_enabled,json=firewallEnabled,proto3" json:"firewall_enabled,omitempty"`
+	FirewallBlockAllIncoming       bool                   `protobuf:"varint,7,opt,name=firewall_block_all_incoming,json=firewallBlockAllIncoming,proto3" json:"firewall_block_all_incoming,omitempty"`
+	FirewallStealthMode            bool                   `protobuf:"varint,8,opt,name=firewall_stealth_mode,json=firewallStealthMode,proto3" json:"firewall_stealth_mode,omitempty"`
+	SipEnabled                     *bool                  `protobuf:"varint,9,opt,name=sip_enabled,json=sipEnabled,proto3,oneof" json:"sip_enabled,omitempty"`
+	UpdatedAt                      *timestamppb.Timestamp `protobuf:"bytes,10,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	unknownFields                  protoimpl.UnknownFields
+	sizeCache                      protoimpl.SizeCache
+}
+
+func (x *SecurityPosture) Reset() {
+	*x = SecurityPosture{}
+	mi := &file_adminv1_admin_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SecurityPosture) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SecurityPosture) ProtoMessage() {}
+
+func (x *SecurityPosture) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SecurityPosture.ProtoReflect.Descriptor instead.
+func (*SecurityPosture) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SecurityPosture) GetPasscodePresent() bool {
+	if x != nil {
+		return x.PasscodePresent
+	}
+	return false
+}
+
+func (x *SecurityPosture) GetPasscodeCompliant() bool {
+	if x != nil {
+		return x.PasscodeCompliant
+	}
+	return false
+}
+
+func (x *SecurityPosture) GetFdeEnabled() bool {
+	if x != nil {
+		return x.FdeEnabled
+	}
+	return false
+}
+
+func (x *SecurityPosture) GetFdeHasPersonalRecoveryKey() bool {
+	if x != nil {
+		return x.FdeHasPersonalRecoveryKey
+	}
+	return false
+}
+
+func (x *SecurityPosture) GetFdeHasInstitutionalRecoveryKey() bool {
+	if x != nil {
+		return x.FdeHasInstitutionalRecoveryKey
+	}
+	return false
+}
+
+func (x *SecurityPosture) GetFirewallEnabled() bool {
+	if x != nil {
+		return x.FirewallEnabled
+	}
+	return false
+}
+
+func (x *SecurityPosture) GetFirewallBlockAllIncoming() bool {
+	if x != nil {
+		return x.FirewallBlockAllIncoming
+	}
+	return false
+}
+
+func (x *SecurityPosture) GetFirewallStealthMode() bool {
+	if x != nil {
+		return x.FirewallStealthMode
+	}
+	return false
+}
+
+func (x *SecurityPosture) GetSipEnabled() bool {
+	if x != nil && x.SipEnabled != nil {
+		return *x.SipEnabled
+	}
+	return false
+}
+
+func (x *SecurityPosture) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+type InstalledProfile struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Identifier    string                 `protobuf:"bytes,1,opt,name=identifier,proto3" json:"identifier,omitempty"`
+	Uuid          string                 `protobuf:"bytes,2,opt,name=uuid,proto3" json:"uuid,omitempty"`
+	Organization  string                 `protobuf:"bytes,3,opt,name=organization,proto3" json:"organization,omitempty"`
+	DisplayName   string                 `protobuf:"bytes,4,opt,name=display_name,json=displayName,proto3" json:"display_name,omitempty"`
+	IsManaged     bool                   `protobuf:"varint,5,opt,name=is_managed,json=isManaged,proto3" json:"is_managed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *InstalledProfile) Reset() {
+	*x = InstalledProfile{}
+	mi := &file_adminv1_admin_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *InstalledProfile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*InstalledProfile) ProtoMessage() {}
+
+func (x *InstalledProfile) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use InstalledProfile.ProtoReflect.Descriptor instead.
+func (*InstalledProfile) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *InstalledProfile) GetIdentifier() string {
+	if x != nil {
+		return x.Identifier
+	}
+	return ""
+}
+
+func (x *InstalledProfile) GetUuid() string {
+	if x != nil {
+		return x.Uuid
+	}
+	return ""
+}
+
+func (x *InstalledProfile) GetOrganization() string {
+	if x != nil {
+		return x.Organization
+	}
+	return ""
+}
+
+func (x *InstalledProfile) GetDisplayName() string {
+	if x != nil {
+		return x.DisplayName
+	}
+	return ""
+}
+
+func (x *InstalledProfile) GetIsManaged() bool {
+	if x != nil {
+		return x.IsManaged
+	}
+	return false
+}
+
+type DeviceCertificate struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommonName    string                 `protobuf:"bytes,1,opt,name=common_name,json=commonName,proto3" json:"common_name,omitempty"`
+	Subject       string                 `protobuf:"bytes,2,opt,name=subject,proto3" json:"subject,omitempty"`
+	Issuer        string                 `protobuf:"bytes,3,opt,name=issuer,proto3" json:"issuer,omitempty"`
+	NotBefore     *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=not_before,json=notBefore,proto3" json:"not_before,omitempty"`
+	NotAfter      *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=not_after,json=notAfter,proto3" json:"not_after,omitempty"`
+	IsIdentity    bool                   `protobuf:"varint,6,opt,name=is_identity,json=isIdentity,proto3" json:"is_identity,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeviceCertificate) Reset() {
+	*x = DeviceCertificate{}
+	mi := &file_adminv1_admin_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeviceCertificate) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeviceCertificate) ProtoMessage() {}
+
+func (x *DeviceCertificate) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeviceCertificate.ProtoReflect.Descriptor instead.
+func (*DeviceCertificate) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeviceCertificate) GetCommonName() string {
+	if x != nil {
+		return x.CommonName
+	}
+	return ""
+}
+
+func (x *DeviceCertificate) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *DeviceCertificate) GetIssuer() string {
+	if x != nil {
+		return x.Issuer
+	}
+	return ""
+}
+
+func (x *DeviceCertificate) GetNotBefore() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NotBefore
+	}
+	return nil
+}
+
+func (x *DeviceCertificate) GetNotAfter() *timestamppb.Timestamp {
+	if x != nil {
+		return x.NotAfter
+	}
+	return nil
+}
+
+func (x *DeviceCertificate) GetIsIdentity() bool {
+	if x != nil {
+		return x.IsIdentity
+	}
+	return false
+}
+
+type ListDevicesRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Enrolled *bool                  `protobuf:"varint,1,opt,name=enrolled,proto3,oneof" json:"enrolled,omitempty"`
+	// os_version is a version such as "17", optionally prefixed with one of
+	// =, >, >=, <, <=.
+	OsVersion string `protobuf:"bytes,2,opt,name=os_version,json=osVersion,proto3" json:"os_version,omitempty"`
+	Model     string `protobuf:"bytes,3,opt,name=model,proto3" json:"model,omitempty"`
+	Tag       string `protobuf:"bytes,4,opt,name=tag,proto3" json:"tag,omitempty"`
+	// sort is udid, last_seen, os_version, or model, prefixed with - for
+	// descending order.
+	Sort          string `protobuf:"bytes,5,opt,name=sort,proto3" json:"sort,omitempty"`
+	PageSize      int32  `protobuf:"varint,6,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken     string `protobuf:"bytes,7,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
+	Platform      string `protobuf:"bytes,8,opt,name=platform,proto3" json:"platform,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDevicesRequest) Reset() {
+	*x = ListDevicesRequest{}
+	mi := &file_adminv1_admin_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDevicesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesRequest) ProtoMessage() {}
+
+func (x *ListDevicesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesRequest.ProtoReflect.Descriptor instead.
+func (*ListDevicesRequest) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListDevicesRequest) GetEnrolled() bool {
+	if x != nil && x.Enrolled != nil {
+		return *x.Enrolled
+	}
+	return false
+}
+
+func (x *ListDevicesRequest) GetOsVersion() string {
+	if x != nil {
+		return x.OsVersion
+	}
+	return ""
+}
+
+func (x *ListDevicesRequest) GetModel() string {
+	if x != nil {
+		return x.Model
+	}
+	return ""
+}
+
+func (x *ListDevicesRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *ListDevicesRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+func (x *ListDevicesRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListDevicesRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+func (x *ListDevicesRequest) GetPlatform() string {
+	if x != nil {
+		return x.Platform
+	}
+	return ""
+}
+
+type ListDevicesResponse struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Devices []*Device              `protobuf:"bytes,1,rep,name=devices,proto3" json:"devices,omitempty"`
+	// next_page_token is empty on the last page.
+	NextPageToken string `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDevicesResponse) Reset() {
+	*x = ListDevicesResponse{}
+	mi := &file_adminv1_admin_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDevicesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesResponse) ProtoMessage() {}
+
+func (x *ListDevicesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesResponse.ProtoReflect.Descriptor instead.
+func (*ListDevicesResponse) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListDevicesResponse) GetDevices() []*Device {
+	if x != nil {
+		return x.Devices
+	}
+	return nil
+}
+
+func (x *ListDevicesResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
+type GetDeviceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Udid          string                 `protobuf:"bytes,1,opt,name=udid,proto3" json:"udid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetDeviceRequest) Reset() {
+	*x = GetDeviceRequest{}
+	mi := &file_adminv1_admin_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetDeviceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetDeviceRequest) ProtoMessage() {}
+
+func (x *GetDeviceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetDeviceRequest.ProtoReflect.Descriptor instead.
+func (*GetDeviceRequest) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *GetDeviceRequest) GetUdid() string {
+	if x != nil {
+		return x.Udid
+	}
+	return ""
+}
+
+// Command is a command in the form accepted by MicroMDM's /v1/commands
+// endpoint. params holds the fields other than udid and request_type.
+type Command struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Udid          string                 `protobuf:"bytes,1,opt,name=udid,proto3" json:"udid,omitempty"`
+	RequestType   string                 `protobuf:"bytes,2,opt,name=request_type,json=requestType,proto3" json:"request_type,omitempty"`
+	Params        *structpb.Struct       `protobuf:"bytes,3,opt,name=params,proto3" json:"params,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Command) Reset() {
+	*x = Command{}
+	mi := &file_adminv1_admin_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Command) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Command) ProtoMessage() {}
+
+func (x *Command) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Command.ProtoReflect.Descriptor instead.
+func (*Command) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Command) GetUdid() string {
+	if x != nil {
+		return x.Udid
+	}
+	return ""
+}
+
+func (x *Command) GetRequestType() string {
+	if x != nil {
+		return x.RequestType
+	}
+	return ""
+}
+
+func (x *Command) GetParams() *structpb.Struct {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+type SendCommandRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Command       *Command               `protobuf:"bytes,1,opt,name=command,proto3" json:"command,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendCommandRequest) Reset() {
+	*x = SendCommandRequest{}
+	mi := &file_adminv1_admin_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendCommandRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendCommandRequest) ProtoMessage() {}
+
+func (x *SendCommandRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendCommandRequest.ProtoReflect.Descriptor instead.
+func (*SendCommandRequest) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *SendCommandRequest) GetCommand() *Command {
+	if x != nil {
+		return x.Command
+	}
+	return nil
+}
+
+type SendCommandResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	CommandUuid   string                 `protobuf:"bytes,1,opt,name=command_uuid,json=commandUuid,proto3" json:"command_uuid,omitempty"`
+	RequestType   string                 `protobuf:"bytes,2,opt,name=request_type,json=requestType,proto3" json:"request_type,omitempty"`
+	Udid          string                 `protobuf:"bytes,3,opt,name=udid,proto3" json:"udid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SendCommandResponse) Reset() {
+	*x = SendCommandResponse{}
+	mi := &file_adminv1_admin_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SendCommandResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SendCommandResponse) ProtoMessage() {}
+
+func (x *SendCommandResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SendCommandResponse.ProtoReflect.Descriptor instead.
+func (*SendCommandResponse) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *SendCommandResponse) GetCommandUuid() string {
+	if x != nil {
+		return x.CommandUuid
+	}
+	return ""
+}
+
+func (x *SendCommandResponse) GetRequestType() string {
+	if x != nil {
+		return x.RequestType
+	}
+	return ""
+}
+
+func (x *SendCommandResponse) GetUdid() string {
+	if x != nil {
+		return x.Udid
+	}
+	return ""
+}
+
+type GetCommandHistoryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Udid          string                 `protobuf:"bytes,1,opt,name=udid,proto3" json:"udid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCommandHistoryRequest) Reset() {
+	*x = GetCommandHistoryRequest{}
+	mi := &file_adminv1_admin_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCommandHistoryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCommandHistoryRequest) ProtoMessage() {}
+
+func (x *GetCommandHistoryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCommandHistoryRequest.ProtoReflect.Descriptor instead.
+func (*GetCommandHistoryRequest) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *GetCommandHistoryRequest) GetUdid() string {
+	if x != nil {
+		return x.Udid
+	}
+	return ""
+}
+
+type GetCommandHistoryResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Records       []*CommandRecord       `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCommandHistoryResponse) Reset() {
+	*x = GetCommandHistoryResponse{}
+	mi := &file_adminv1_admin_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCommandHistoryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCommandHistoryResponse) ProtoMessage() {}
+
+func (x *GetCommandHistoryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCommandHistoryResponse.ProtoReflect.Descriptor instead.
+func (*GetCommandHistoryResponse) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *GetCommandHistoryResponse) GetRecords() []*CommandRecord {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+type CommandRecord struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Udid        string                 `protobuf:"bytes,1,opt,name=udid,proto3" json:"udid,omitempty"`
+	CommandUuid string                 `protobuf:"bytes,2,opt,name=command_uuid,json=commandUuid,proto3" json:"command_uuid,omitempty"`
+	RequestType string                 `protobuf:"bytes,3,opt,name=request_type,json=requestType,proto3" json:"request_type,omitempty"`
+	// status is Sent, or the status of the device's response.
+	Status     string                 `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	ErrorChain []*ErrorChainItem      `protobuf:"bytes,5,rep,name=error_chain,json=errorChain,proto3" json:"error_chain,omitempty"`
+	Time       *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=time,proto3" json:"time,omitempty"`
+	// error_class is transient or permanent for failed commands, and retrying
+	// set when a transiently failed command is sent again.
+	ErrorClass string `protobuf:"bytes,7,opt,name=error_class,json=errorClass,proto3" json:"error_class,omitempty"`
+	Retrying   bool   `protobuf:"varint,8,opt,name=retrying,proto3" json:"retrying,omitempty"`
+	// user_id is set for commands sent on the channel of a macOS user.
+	UserId        string `protobuf:"bytes,9,opt,name=user_id,json=userId,proto3" json:"user_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CommandRecord) Reset() {
+	*x = CommandRecord{}
+	mi := &file_adminv1_admin_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CommandRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CommandRecord) ProtoMessage() {}
+
+func (x *CommandRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CommandRecord.ProtoReflect.Descriptor instead.
+func (*CommandRecord) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *CommandRecord) GetUdid() string {
+	if x != nil {
+		return x.Udid
+	}
+	return ""
+}
+
+func (x *CommandRecord) GetCommandUuid() string {
+	if x != nil {
+		return x.CommandUuid
+	}
+	return ""
+}
+
+func (x *CommandRecord) GetRequestType() string {
+	if x != nil {
+		return x.RequestType
+	}
+	return ""
+}
+
+func (x *CommandRecord) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *CommandRecord) GetErrorChain() []*ErrorChainItem {
+	if x != nil {
+		return x.ErrorChain
+	}
+	return nil
+}
+
+func (x *CommandRecord) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *CommandRecord) GetErrorClass() string {
+	if x != nil {
+		return x.ErrorClass
+	}
+	return ""
+}
+
+func (x *CommandRecord) GetRetrying() bool {
+	if x != nil {
+		return x.Retrying
+	}
+	return false
+}
+
+func (x *CommandRecord) GetUserId() string {
+	if x != nil {
+		return x.UserId
+	}
+	return ""
+}
+
+type ErrorChainItem struct {
+	state                protoimpl.MessageState `protogen:"open.v1"`
+	ErrorCode            int32                  `protobuf:"varint,1,opt,name=error_code,json=errorCode,proto3" json:"error_code,omitempty"`
+	ErrorDomain          string                 `protobuf:"bytes,2,opt,name=error_domain,json=errorDomain,proto3" json:"error_domain,omitempty"`
+	LocalizedDescription string                 `protobuf:"bytes,3,opt,name=localized_description,json=localizedDescription,proto3" json:"localized_description,omitempty"`
+	UsEnglishDescription string                 `protobuf:"bytes,4,opt,name=us_english_description,json=usEnglishDescription,proto3" json:"us_english_description,omitempty"`
+	unknownFields        protoimpl.UnknownFields
+	sizeCache            protoimpl.SizeCache
+}
+
+func (x *ErrorChainItem) Reset() {
+	*x = ErrorChainItem{}
+	mi := &file_adminv1_admin_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ErrorChainItem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ErrorChainItem) ProtoMessage() {}
+
+func (x *ErrorChainItem) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ErrorChainItem.ProtoReflect.Descriptor instead.
+func (*ErrorChainItem) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ErrorChainItem) GetErrorCode() int32 {
+	if x != nil {
+		return x.ErrorCode
+	}
+	return 0
+}
+
+func (x *ErrorChainItem) GetErrorDomain() string {
+	if x != nil {
+		return x.ErrorDomain
+	}
+	return ""
+}
+
+func (x *ErrorChainItem) GetLocalizedDescription() string {
+	if x != nil {
+		return x.LocalizedDescription
+	}
+	return ""
+}
+
+func (x *ErrorChainItem) GetUsEnglishDescription() string {
+	if x != nil {
+		return x.UsEnglishDescription
+	}
+	return ""
+}
+
+type StreamEventsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// udid limits the stream to one device when set.
+	Udid          string `protobuf:"bytes,1,opt,name=udid,proto3" json:"udid,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamEventsRequest) Reset() {
+	*x = StreamEventsRequest{}
+	mi := &file_adminv1_admin_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamEventsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamEventsRequest) ProtoMessage() {}
+
+func (x *StreamEventsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamEventsRequest.ProtoReflect.Descriptor instead.
+func (*StreamEventsRequest) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *StreamEventsRequest) GetUdid() string {
+	if x != nil {
+		return x.Udid
+	}
+	return ""
+}
+
+type Event struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	EventId       string                 `protobuf:"bytes,1,opt,name=event_id,json=eventId,proto3" json:"event_id,omitempty"`
+	Topic         string                 `protobuf:"bytes,2,opt,name=topic,proto3" json:"topic,omitempty"`
+	Udid          string                 `protobuf:"bytes,3,opt,name=udid,proto3" json:"udid,omitempty"`
+	Time          *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=time,proto3" json:"time,omitempty"`
+	CommandUuid   string                 `protobuf:"bytes,5,opt,name=command_uuid,json=commandUuid,proto3" json:"command_uuid,omitempty"`
+	Status        string                 `protobuf:"bytes,6,opt,name=status,proto3" json:"status,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Event) Reset() {
+	*x = Event{}
+	mi := &file_adminv1_admin_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Event) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Event) ProtoMessage() {}
+
+func (x *Event) ProtoReflect() protoreflect.Message {
+	mi := &file_adminv1_admin_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Event.ProtoReflect.Descriptor instead.
+func (*Event) Descriptor() ([]byte, []int) {
+	return file_adminv1_admin_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *Event) GetEventId() string {
+	if x != nil {
+		return x.EventId
+	}
+	return ""
+}
+
+func (x *Event) GetTopic() string {
+	if x != nil {
+		return x.Topic
+	}
+	return ""
+}
+
+func (x *Event) GetUdid() string {
+	if x != nil {
+		return x.Udid
+	}
+	return ""
+}
+
+func (x *Event) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *Event) GetCommandUuid() string {
+	if x != nil {
+		return x.CommandUuid
+	}
+	return ""
+}
+
+func (x *Event) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+var File_adminv1_admin_proto protoreflect.FileDescriptor
+
+const file_adminv1_admin_proto_rawDesc = "" +
+	"\n" +
+	"\x13adminv1/admin.proto\x12\x18micromdmwebhook.admin.v1\x1a\x1cgoogle/protobuf/struct.proto\x1a\x1fgoogle/protobuf/timestamp.proto\"\x8a\x04\n" +
+	"\x06Device\x12\x12\n" +
+	"\x04udid\x18\x01 \x01(\tR\x04udid\x12\x1a\n" +
+	"\benrolled\x18\x02 \x01(\bR\benrolled\x127\n" +
+	"\tlast_seen\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\blastSeen\x12M\n" +
+	"\x0einstalled_apps\x18\x04 \x03(\v2&.micromdmwebhook.admin.v1.InstalledAppR\rinstalledApps\x128\n" +
+	"\x04info\x18\x05 \x01(\v2$.micromdmwebhook.admin.v1.DeviceInfoR\x04info\x12E\n" +
+	"\bsecurity\x18\x06 \x01(\v2).micromdmwebhook.admin.v1.SecurityPostureR\bsecurity\x12F\n" +
+	"\bprofiles\x18\a \x03(\v2*.micromdmwebhook.admin.v1.InstalledProfileR\bprofiles\x12O\n" +
+	"\fcertificates\x18\b \x03(\v2+.micromdmwebhook.admin.v1.DeviceCertificateR\fcertificates\x12\x12\n" +
+	"\x04tags\x18\t \x03(\tR\x04tags\x12\x1a\n" +
+	"\bplatform\x18\n" +
+	" \x01(\tR\bplatform\"\xc5\x01\n" +
+	"\fInstalledApp\x12\x1e\n" +
+	"\n" +
+	"identifier\x18\x01 \x01(\tR\n" +
+	"identifier\x12\x12\n" +
+	"\x04name\x18\x02 \x01(\tR\x04name\x12#\n" +
+	"\rshort_version\x18\x03 \x01(\tR\fshortVersion\x12\x18\n" +
+	"\aversion\x18\x04 \x01(\tR\aversion\x12\x1f\n" +
+	"\vbundle_size\x18\x05 \x01(\x03R\n" +
+	"bundleSize\x12!\n" +
+	"\fdynamic_size\x18\x06 \x01(\x03R\vdynamicSize\"\x98\x04\n" +
+	"\n" +
+	"DeviceInfo\x12\x1f\n" +
+	"\vdevice_name\x18\x01 \x01(\tR\n" +
+	"deviceName\x12\x1d\n" +
+	"\n" +
+	"os_version\x18\x02 \x01(\tR\tosVersion\x12#\n" +
+	"\rbuild_version\x18\x03 \x01(\tR\fbuildVersion\x12!\n" +
+	"\fproduct_name\x18\x04 \x01(\tR\vproductName\x12\x14\n" +
+	"\x05model\x18\x05 \x01(\tR\x05model\x12\x1d\n" +
+	"\n" +
+	"model_name\x18\x06 \x01(\tR\tmodelName\x12#\n" +
+	"\rserial_number\x18\a \x01(\tR\fserialNumber\x12#\n" +
+	"\rbattery_level\x18\b \x01(\x01R\fbatteryLevel\x12'\n" +
+	"\x0fdevice_capacity\x18\t \x01(\x01R\x0edeviceCapacity\x12:\n" +
+	"\x19available_device_capacity\x18\n" +
+	" \x01(\x01R\x17availableDeviceCapacity\x12#\n" +
+	"\ris_supervised\x18\v \x01(\bR\fisSupervised\x12\x19\n" +
+	"\bwifi_mac\x18\f \x01(\tR\awifiMac\x12#\n" +
+	"\rbluetooth_mac\x18\r \x01(\tR\fbluetoothMac\x129\n" +
+	"\n" +
+	"updated_at\x18\x0e \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\"\xa9\x04\n" +
+	"\x0fSecurityPosture\x12)\n" +
+	"\x10passcode_present\x18\x01 \x01(\bR\x0fpasscodePresent\x12-\n" +
+	"\x12passcode_compliant\x18\x02 \x01(\bR\x11passcodeCompliant\x12\x1f\n" +
+	"\vfde_enabled\x18\x03 \x01(\bR\n" +
+	"fdeEnabled\x12@\n" +
+	"\x1dfde_has_personal_recovery_key\x18\x04 \x01(\bR\x19fdeHasPersonalRecoveryKey\x12J\n" +
+	"\"fde_has_institutional_recovery_key\x18\x05 \x01(\bR\x1efdeHasInstitutionalRecoveryKey\x12)\n" +
+	"\x10firewall_enabled\x18\x06 \x01(\bR\x0ffirewallEnabled\x12=\n" +
+	"\x1bfirewall_block_all_incoming\x18\a \x01(\bR\x18firewallBlockAllIncoming\x122\n" +
+	"\x15firewall_stealth_mode\x18\b \x01(\bR\x13firewallStealthMode\x12$\n" +
+	"\vsip_enabled\x18\t \x01(\bH\x00R\n" +
+	"sipEnabled\x88\x01\x01\x129\n" +
+	"\n" +
+	"updated_at\x18\n" +
+	" \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAtB\x0e\n" +
+	"\f_sip_enabled\"\xac\x01\n" +
+	"\x10InstalledProfile\x12\x1e\n" +
+	"\n" +
+	"identifier\x18\x01 \x01(\tR\n" +
+	"identifier\x12\x12\n" +
+	"\x04uuid\x18\x02 \x01(\tR\x04uuid\x12\"\n" +
+	"\forganization\x18\x03 \x01(\tR\forganization\x12!\n" +
+	"\fdisplay_name\x18\x04 \x01(\tR\vdisplayName\x12\x1d\n" +
+	"\n" +
+	"is_managed\x18\x05 \x01(\bR\tisManaged\"\xfb\x01\n" +
+	"\x11DeviceCertificate\x12\x1f\n" +
+	"\vcommon_name\x18\x01 \x01(\tR\n" +
+	"commonName\x12\x18\n" +
+	"\asubject\x18\x02 \x01(\tR\asubject\x12\x16\n" +
+	"\x06issuer\x18\x03 \x01(\tR\x06issuer\x129\n" +
+	"\n" +
+	"not_before\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\tnotBefore\x127\n" +
+	"\tnot_after\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\bnotAfter\x12\x1f\n" +
+	"\vis_identity\x18\x06 \x01(\bR\n" +
+	"isIdentity\"\xf5\x01\n" +
+	"\x12ListDevicesRequest\x12\x1f\n" +
+	"\benrolled\x18\x01 \x01(\bH\x00R\benrolled\x88\x01\x01\x12\x1d\n" +
+	"\n" +
+	"os_version\x18\x02 \x01(\tR\tosVersion\x12\x14\n" +
+	"\x05model\x18\x03 \x01(\tR\x05model\x12\x10\n" +
+	"\x03tag\x18\x04 \x01(\tR\x03tag\x12\x12\n" +
+	"\x04sort\x18\x05 \x01(\tR\x04sort\x12\x1b\n" +
+	"\tpage_size\x18\x06 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\a \x01(\tR\tpageToken\x12\x1a\n" +
+	"\bplatform\x18\b \x01(\tR\bplatformB\v\n" +
+	"\t_enrolled\"y\n" +
+	"\x13ListDevicesResponse\x12:\n" +
+	"\adevices\x18\x01 \x03(\v2 .micromdmwebhook.admin.v1.DeviceR\adevices\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\"&\n" +
+	"\x10GetDeviceRequest\x12\x12\n" +
+	"\x04udid\x18\x01 \x01(\tR\x04udid\"q\n" +
+	"\aCommand\x12\x12\n" +
+	"\x04udid\x18\x01 \x01(\tR\x04udid\x12!\n" +
+	"\frequest_type\x18\x02 \x01(\tR\vrequestType\x12/\n" +
+	"\x06params\x18\x03 \x01(\v2\x17.google.protobuf.StructR\x06params\"Q\n" +
+	"\x12SendCommandRequest\x12;\n" +
+	"\acommand\x18\x01 \x01(\v2!.micromdmwebhook.admin.v1.CommandR\acommand\"o\n" +
+	"\x13SendCommandResponse\x12!\n" +
+	"\fcommand_uuid\x18\x01 \x01(\tR\vcommandUuid\x12!\n" +
+	"\frequest_type\x18\x02 \x01(\tR\vrequestType\x12\x12\n" +
+	"\x04udid\x18\x03 \x01(\tR\x04udid\".\n" +
+	"\x18GetCommandHistoryRequest\x12\x12\n" +
+	"\x04udid\x18\x01 \x01(\tR\x04udid\"^\n" +
+	"\x19GetCommandHistoryResponse\x12A\n" +
+	"\arecords\x18\x01 \x03(\v2'.micromdmwebhook.admin.v1.CommandRecordR\arecords\"\xd2\x02\n" +
+	"\rCommandRecord\x12\x12\n" +
+	"\x04udid\x18\x01 \x01(\tR\x04udid\x12!\n" +
+	"\fcommand_uuid\x18\x02 \x01(\tR\vcommandUuid\x12!\n" +
+	"\frequest_type\x18\x03 \x01(\tR\vrequestType\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12I\n" +
+	"\verror_chain\x18\x05 \x03(\v2(.micromdmwebhook.admin.v1.ErrorChainItemR\n" +
+	"errorChain\x12.\n" +
+	"\x04time\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12\x1f\n" +
+	"\verror_class\x18\a \x01(\tR\n" +
+	"errorClass\x12\x1a\n" +
+	"\bretrying\x18\b \x01(\bR\bretrying\x12\x17\n" +
+	"\auser_id\x18\t \x01(\tR\x06userId\"\xbd\x01\n" +
+	"\x0eErrorChainItem\x12\x1d\n" +
+	"\n" +
+	"error_code\x18\x01 \x01(\x05R\terrorCode\x12!\n" +
+	"\ferror_domain\x18\x02 \x01(\tR\verrorDomain\x123\n" +
+	"\x15localized_description\x18\x03 \x01(\tR\x14localizedDescription\x124\n" +
+	"\x16us_english_description\x18\x04 \x01(\tR\x14usEnglishDescription\")\n" +
+	"\x13StreamEventsRequest\x12\x12\n" +
+	"\x04udid\x18\x01 \x01(\tR\x04udid\"\xb7\x01\n" +
+	"\x05Event\x12\x19\n" +
+	"\bevent_id\x18\x01 \x01(\tR\aeventId\x12\x14\n" +
+	"\x05topic\x18\x02 \x01(\tR\x05topic\x12\x12\n" +
+	"\x04udid\x18\x03 \x01(\tR\x04udid\x12.\n" +
+	"\x04time\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x04time\x12!\n" +
+	"\fcommand_uuid\x18\x05 \x01(\tR\vcommandUuid\x12\x16\n" +
+	"\x06status\x18\x06 \x01(\tR\x06status2\xd6\x01\n" +
+	"\rDeviceService\x12j\n" +
+	"\vListDevices\x12,.micromdmwebhook.admin.v1.ListDevicesRequest\x1a-.micromdmwebhook.admin.v1.ListDevicesResponse\x12Y\n" +
+	"\tGetDevice\x12*.micromdmwebhook.admin.v1.GetDeviceRequest\x1a .micromdmwebhook.admin.v1.Device2\xdc\x02\n" +
+	"\x0eCommandService\x12j\n" +
+	"\vSendCommand\x12,.micromdmwebhook.admin.v1.SendCommandRequest\x1a-.micromdmwebhook.admin.v1.SendCommandResponse\x12|\n" +
+	"\x11GetCommandHistory\x122.micromdmwebhook.admin.v1.GetCommandHistoryRequest\x1a3.micromdmwebhook.admin.v1.GetCommandHistoryResponse\x12`\n" +
+	"\fStreamEvents\x12-.micromdmwebhook.admin.v1.StreamEventsRequest\x1a\x1f.micromdmwebhook.admin.v1.Event0\x01BJZHgithub.com/kurtpeek/micromdm-webhook-blueprints/go/proto/adminv1;adminv1b\x06proto3"
+
+var (
+	file_adminv1_admin_proto_rawDescOnce sync.Once
+	file_adminv1_admin_proto_rawDescData []byte
+)
+
+func file_adminv1_admin_proto_rawDescGZIP() []byte {
+	file_adminv1_admin_proto_rawDescOnce.Do(func() {
+		file_adminv1_admin_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_adminv1_admin_proto_rawDesc), len(file_adminv1_admin_proto_rawDesc)))
+	})
+	return file_adminv1_admin_proto_rawDescData
+}
+
+var file_adminv1_admin_proto_msgTypes = make([]protoimpl.MessageInfo, 18)
+var file_adminv1_admin_proto_goTypes = []any{
+	(*Device)(nil),                    // 0: micromdmwebhook.admin.v1.Device
+	(*InstalledApp)(nil),              // 1: micromdmwebhook.admin.v1.InstalledApp
+	(*DeviceInfo)(nil),                // 2: micromdmwebhook.admin.v1.DeviceInfo
+	(*SecurityPosture)(nil),           // 3: micromdmwebhook.admin.v1.SecurityPosture
+	(*InstalledProfile)(nil),          // 4: micromdmwebhook.admin.v1.InstalledProfile
+	(*DeviceCertificate)(nil),         // 5: micromdmwebhook.admin.v1.DeviceCertificate
+	(*ListDevicesRequest)(nil),        // 6: micromdmwebhook.admin.v1.ListDevicesRequest
+	(*ListDevicesResponse)(nil),       // 7: micromdmwebhook.admin.v1.ListDevicesResponse
+	(*GetDeviceRequest)(nil),          // 8: micromdmwebhook.admin.v1.GetDeviceRequest
+	(*Command)(nil),                   // 9: micromdmwebhook.admin.v1.Command
+	(*SendCommandRequest)(nil),        // 10: micromdmwebhook.admin.v1.SendCommandRequest
+	(*SendCommandResponse)(nil),       // 11: micromdmwebhook.admin.v1.SendCommandResponse
+	(*GetCommandHistoryRequest)(nil),  // 12: micromdmwebhook.admin.v1.GetCommandHistoryRequest
+	(*GetCommandHistoryResponse)(nil), // 13: micromdmwebhook.admin.v1.GetCommandHistoryResponse
+	(*CommandRecord)(nil),             // 14: micromdmwebhook.admin.v1.CommandRecord
+	(*ErrorChainItem)(nil),            // 15: micromdmwebhook.admin.v1.ErrorChainItem
+	(*StreamEventsRequest)(nil),       // 16: micromdmwebhook.admin.v1.StreamEventsRequest
+	(*Event)(nil),                     // 17: micromdmwebhook.admin.v1.Event
+	(*timestamppb.Timestamp)(nil),     // 18: google.protobuf.Timestamp
+	(*structpb.Struct)(nil),           // 19: google.protobuf.Struct
+}
+var file_adminv1_admin_proto_depIdxs = []int32{
+	18, // 0: micromdmwebhook.admin.v1.Device.last_seen:type_name -> google.protobuf.Timestamp
+	1,  // 1: micromdmwebhook.admin.v1.Device.installed_apps:type_name -> micromdmwebhook.admin.v1.InstalledApp
+	2,  // 2: micromdmwebhook.admin.v1.Device.info:type_name -> micromdmwebhook.admin.v1.DeviceInfo
+	3,  // 3: micromdmwebhook.admin.v1.Device.security:type_name -> micromdmwebhook.admin.v1.SecurityPosture
+	4,  // 4: micromdmwebhook.admin.v1.Device.profiles:type_name -> micromdmwebhook.admin.v1.InstalledProfile
+	5,  // 5: micromdmwebhook.admin.v1.Device.certificates:type_name -> micromdmwebhook.admin.v1.DeviceCertificate
+	18, // 6: micromdmwebhook.admin.v1.DeviceInfo.updated_at:type_name -> google.protobuf.Timestamp
+	18, // 7: micromdmwebhook.admin.v1.SecurityPosture.updated_at:type_name -> google.protobuf.Timestamp
+	18, // 8: micromdmwebhook.admin.v1.DeviceCertificate.not_before:type_name -> google.protobuf.Timestamp
+	18, // 9: micromdmwebhook.admin.v1.DeviceCertificate.not_after:type_name -> google.protobuf.Timestamp
+	0,  // 10: micromdmwebhook.admin.v1.ListDevicesResponse.devices:type_name -> micromdmwebhook.admin.v1.Device
+	19, // 11: micromdmwebhook.admin.v1.Command.params:type_name -> google.protobuf.Struct
+	9,  // 12: micromdmwebhook.admin.v1.SendCommandRequest.command:type_name -> micromdmwebhook.admin.v1.Command
+	14, // 13: micromdmwebhook.admin.v1.GetCommandHistoryResponse.records:type_name -> micromdmwebhook.admin.v1.CommandRecord
+	15, // 14: micromdmwebhook.admin.v1.CommandRecord.error_chain:type_name -> micromdmwebhook.admin.v1.ErrorChainItem
+	18, // 15: micromdmwebhook.admin.v1.CommandRecord.time:type_name -> google.protobuf.Timestamp
+	18, // 16: micromdmwebhook.admin.v1.Event.time:type_name -> google.protobuf.Timestamp
+	6,  // 17: micromdmwebhook.admin.v1.DeviceService.ListDevices:input_type -> micromdmwebhook.admin.v1.ListDevicesRequest
+	8,  // 18: micromdmwebhook.admin.v1.DeviceService.GetDevice:input_type -> micromdmwebhook.admin.v1.GetDeviceRequest
+	10, // 19: micromdmwebhook.admin.v1.CommandService.SendCommand:input_type -> micromdmwebhook.admin.v1.SendCommandRequest
+	12, // 20: micromdmwebhook.admin.v1.CommandService.GetCommandHistory:input_type -> micromdmwebhook.admin.v1.GetCommandHistoryRequest
+	16, // 21: micromdmwebhook.admin.v1.CommandService.StreamEvents:input_type -> micromdmwebhook.admin.v1.StreamEventsRequest
+	7,  // 22: micromdmwebhook.admin.v1.DeviceService.ListDevices:output_type -> micromdmwebhook.admin.v1.ListDevicesResponse
+	0,  // 23: micromdmwebhook.admin.v1.DeviceService.GetDevice:output_type -> micromdmwebhook.admin.v1.Device
+	11, // 24: micromdmwebhook.admin.v1.CommandService.SendCommand:output_type -> micromdmwebhook.admin.v1.SendCommandResponse
+	13, // 25: micromdmwebhook.admin.v1.CommandService.GetCommandHistory:output_type -> micromdmwebhook.admin.v1.GetCommandHistoryResponse
+	17, // 26: micromdmwebhook.admin.v1.CommandService.StreamEvents:output_type -> micromdmwebhook.admin.v1.Event
+	22, // [22:27] is the sub-list for method output_type
+	17, // [17:22] is the sub-list for method input_type
+	17, // [17:17] is the sub-list for extension type_name
+	17, // [17:17] is the sub-list for extension extendee
+	0,  // [0:17] is the sub-list for field type_name
+}
+
+func init() { file_adminv1_admin_proto_init() }
+func file_adminv1_admin_proto_init() {
+	if File_adminv1_admin_proto != nil {
+		return
+	}
+	file_adminv1_admin_proto_msgTypes[3].OneofWrappers = []any{}
+	file_adminv1_admin_proto_msgTypes[6].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_adminv1_admin_proto_rawDesc), len(file_adminv1_admin_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   18,
+			NumExtensions: 0,
+			NumServices:   2,
+		},
+		GoTypes:           file_adminv1_admin_proto_goTypes,
+		DependencyIndexes: file_adminv1_admin_proto_depIdxs,
+		MessageInfos:      file_adminv1_admin_proto_msgTypes,
+	}.Build()
+	File_adminv1_admin_proto = out.File
+	file_adminv1_admin_proto_goTypes = nil
+	file_adminv1_admin_proto_depIdxs = nil
+}
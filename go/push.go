@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/apns"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/sirupsen/logrus"
+)
+
+// apnsVars counts the pushes sent through APNs directly (pushed), those it
+// rejected (failed), the push tokens it reported are no longer valid
+// (unregistered), and the pushes left to MicroMDM for want of a usable push
+// token (fallback).
+var apnsVars = expvar.NewMap("apns")
+
+// The ways pushes are sent, as returned by pushDevice.
+const (
+	pushViaAPNs     = "apns"
+	pushViaMicroMDM = "micromdm"
+)
+
+// pushToken returns the push token of the TokenUpdate message raw, or nil if
+// it has none.
+func pushToken(raw []byte, at time.Time) *store.PushToken {
+	var msg struct {
+		Token     []byte
+		PushMagic string
+		Topic     string
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := plist.Unmarshal(raw, &msg); err != nil || len(msg.Token) == 0 || msg.PushMagic == "" {
+		return nil
+	}
+	return &store.PushToken{Token: msg.Token, PushMagic: msg.PushMagic, Topic: msg.Topic, UpdatedAt: at}
+}
+
+// pushDevice pushes the device with the given UDID, or the channel of its
+// user with userID if it is set, so it checks in, no faster than s.Limiter
+// allows. With s.APNs set, devices are pushed through APNs with the push
+// token they last sent. Otherwise, and for devices without a push token APNs
+// takes, MicroMDM is asked to push them, waiting at most s.MDMTimeout for the
+// answer. It returns how the push was sent.
+func (s *Server) pushDevice(ctx context.Context, udid, userID string) (string, error) {
+	if s.SkipCommands {
+		logFor(ctx).WithFields(logrus.Fields{"udid": udid, "user_id": userID}).Info("not pushing device")
+		return "", nil
+	}
+	if s.Limiter != nil {
+		if err := s.Limiter.Wait(ctx); err != nil {
+			return "", err
+		}
+	}
+	if s.APNs != nil {
+		pushed, err := s.pushDirect(ctx, udid, userID)
+		if pushed || err != nil {
+			return pushViaAPNs, err
+		}
+		apnsVars.Add("fallback", 1)
+	}
+	if s.MDMTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.MDMTimeout)
+		defer cancel()
+	}
+	id := udid
+	if userID != "" {
+		// MicroMDM keeps the push tokens of user channels by UserID.
+		id = userID
+	}
+	return pushViaMicroMDM, s.mdm().Push(ctx, id)
+}
+
+// pushDirect pushes the device or user channel through s.APNs, recording on
+// the device how the push went. It reports false, with no error, if the
+// channel has no push token, or one APNs reports is no longer valid.
+func (s *Server) pushDirect(ctx context.Context, udid, userID string) (bool, error) {
+	d, exists, err := s.loadDevice(udid)
+	if err != nil {
+		return false, fmt.Errorf("load device: %v", err)
+	}
+	if !exists {
+		return false, nil
+	}
+	pt := channelPushToken(&d, userID)
+	if pt == nil || pt.Unregistered {
+		return false, nil
+	}
+	topic := pt.Topic
+	if topic == "" {
+		topic = s.APNSTopic
+	}
+	err = s.APNs.Push(ctx, pt.Token, pt.PushMagic, topic)
+	now := time.Now().UTC()
+	var rejected *apns.Error
+	var record func(pt *store.PushToken)
+	switch {
+	case err == nil:
+		apnsVars.Add("pushed", 1)
+		record = func(pt *store.PushToken) { pt.PushedAt = &now }
+	case errors.As(err, &rejected):
+		apnsVars.Add("failed", 1)
+		unregistered := rejected.Unregistered()
+		record = func(pt *store.PushToken) {
+			pt.FailedAt, pt.Failure = &now, rejected.Reason
+			pt.Unregistered = pt.Unregistered || unregistered
+		}
+		logger := logFor(ctx).WithFields(logrus.Fields{"udid": udid, "user_id": userID, "reason": rejected.Reason})
+		if unregistered {
+			apnsVars.Add("unregistered", 1)
+			logger.Warn("APNs reports the push token is no longer valid; pushing through MicroMDM until the device sends a new one")
+		} else {
+			logger.Warn("APNs rejected push")
+		}
+	default:
+		apnsVars.Add("failed", 1)
+		return true, err
+	}
+	if err := s.recordPush(udid, userID, pt.Token, record); err != nil {
+		logFor(ctx).WithError(err).Error("save device push token")
+	}
+	if rejected != nil && rejected.Unregistered() {
+		return false, nil
+	}
+	return true, err
+}
+
+// channelPushToken returns the push token of d, or of its user with userID
+// if it is set, or nil if the channel has none.
+func channelPushToken(d *Device, userID string) *store.PushToken {
+	if userID == "" {
+		return d.Push
+	}
+	if u := d.User(userID); u != nil {
+		return u.Push
+	}
+	return nil
+}
+
+// pushRecordAttempts is how many times recordPush saves a device that other
+// instances of the webhook keep modifying.
+const pushRecordAttempts = 3
+
+// recordPush applies record to the push token of the device with udid, or
+// of its user with userID, if the token is still token. The device is
+// loaded again rather than kept over the APNs round trip, so that the
+// changes webhook events made to it meanwhile are not overwritten, and
+// saved again if another instance saved it first.
+func (s *Server) recordPush(udid, userID string, token []byte, record func(pt *store.PushToken)) error {
+	for attempt := 1; ; attempt++ {
+		d, exists, err := s.loadDevice(udid)
+		if err != nil {
+			return fmt.Errorf("load device: %v", err)
+		}
+		pt := channelPushToken(&d, userID)
+		if !exists || pt == nil || !bytes.Equal(pt.Token, token) {
+			// The device sent a new token, or was removed, during the
+			// push.
+			return nil
+		}
+		record(pt)
+		err = s.Devices.Save(d)
+		if !errors.Is(err, store.ErrDeviceConflict) || attempt == pushRecordAttempts {
+			return err
+		}
+	}
+}
+
+// handlePushDevice pushes a device, or with the user_id parameter the channel of
+// one of its macOS users, so it checks in for its queued commands.
+func (s *Server) handlePushDevice(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	userID := r.URL.Query().Get("user_id")
+	if u := d.User(userID); userID != "" && (u == nil || !u.Enrolled) {
+		http.Error(w, fmt.Sprintf("user %s has not enrolled on device %s", userID, d.UDID), http.StatusNotFound)
+		return
+	}
+	via, err := s.pushDevice(r.Context(), d.UDID, userID)
+	if err != nil {
+		logFor(r.Context()).WithFields(logrus.Fields{"udid": d.UDID, "user_id": userID, "via": via}).WithError(err).Error("push device")
+		http.Error(w, fmt.Sprintf("push device: %v", err), http.StatusBadGateway)
+		return
+	}
+	resp := map[string]string{"udid": d.UDID, "via": via}
+	if userID != "" {
+		resp["user_id"] = userID
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
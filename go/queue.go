@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// errQueueFull is returned for commands given up on because the command
+// queue had no room for them.
+var errQueueFull = errors.New("command queue is full")
+
+// errQueueClosed is returned for commands enqueued once shutdown has closed
+// the command queue.
+var errQueueClosed = errors.New("command queue is closed")
+
+// queueVars publishes the length and capacity of the command queue, and how
+// many commands it turned away, at /debug/vars.
+var queueVars = expvar.NewMap("command_queue")
+
+// queuedCommand is a command waiting to be sent by s.
+type queuedCommand struct {
+	ctx context.Context
+	s   *Server
+	c   Command
+
+	// msg is the command's message in the durable queue, acknowledged once
+	// the command is sent.
+	msg jetstream.Msg
+}
+
+// commandQueue sends the commands webhooks trigger from a bounded queue
+// with a pool of workers, so events are answered without waiting for
+// MicroMDM.
+type commandQueue struct {
+	jobs chan queuedCommand
+	wg   sync.WaitGroup
+
+	// closed is set under mu once close is called, so that commands
+	// enqueued late are turned away rather than sent on the closed jobs.
+	mu     sync.RWMutex
+	closed bool
+
+	// ctx is cancelled to abandon the commands left when shutdown times
+	// out.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	dropped expvar.Int
+
+	// durable, if set, keeps the queued commands on disk until they are
+	// sent; jobs then only holds those handed to workers.
+	durable *jetStreamQueue
+}
+
+func newCommandQueue(size, workers int) *commandQueue {
+	q := &commandQueue{jobs: make(chan queuedCommand, size)}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	queueVars.Set("length", expvar.Func(func() any { return len(q.jobs) }))
+	queueVars.Set("capacity", expvar.Func(func() any { return cap(q.jobs) }))
+	queueVars.Set("dropped", &q.dropped)
+	q.wg.Add(workers)
+	for range workers {
+		go q.work()
+	}
+	return q
+}
+
+// newDurableCommandQueue returns a queue that keeps up to size commands
+// (any number if size is 0) in a JetStream stream stored in dir, so the
+// commands not sent yet when the webhook stops are sent once it is started
+// again. Commands are only sent once start is called.
+func newDurableCommandQueue(dir string, size, workers int) (*commandQueue, error) {
+	d, err := openJetStreamQueue(dir, size, workers)
+	if err != nil {
+		return nil, err
+	}
+	q := newCommandQueue(0, workers)
+	q.durable = d
+	queueVars.Set("length", expvar.Func(func() any { return d.length() }))
+	queueVars.Set("capacity", expvar.Func(func() any { return size }))
+	return q, nil
+}
+
+// start begins sending the commands in a durable queue, including those
+// left by an earlier run, each by the server of its tenant among servers.
+// It does nothing for a queue kept in memory.
+func (q *commandQueue) start(servers []*Server) {
+	if q.durable != nil {
+		q.durable.feed(q, servers)
+	}
+}
+
+func (q *commandQueue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		ctx, cancel := context.WithCancel(job.ctx)
+		stop := context.AfterFunc(q.ctx, cancel)
+		if job.msg == nil {
+			job.s.sendCommandNow(ctx, job.c)
+		} else {
+			q.durable.send(ctx, job)
+		}
+		stop()
+		cancel()
+	}
+}
+
+// enqueue adds c to the queue to be sent by s. The command keeps the values
+// of ctx, such as its logger and trace, but not its cancellation. It
+// returns errQueueFull if there is no room for it, and errQueueClosed once
+// the queue is closed.
+func (q *commandQueue) enqueue(ctx context.Context, s *Server, c Command) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return errQueueClosed
+	}
+	if q.durable != nil {
+		err := q.durable.add(ctx, s.Tenant, c)
+		if errors.Is(err, errQueueFull) {
+			q.dropped.Add(1)
+		}
+		return err
+	}
+	select {
+	case q.jobs <- queuedCommand{ctx: context.WithoutCancel(ctx), s: s, c: c}:
+		return nil
+	default:
+		q.dropped.Add(1)
+		return errQueueFull
+	}
+}
+
+// close stops accepting commands and waits for the queued ones to be sent.
+// If ctx ends first, the commands being sent are cancelled and the rest
+// given up on, and ctx's error is returned. A durable queue instead keeps
+// the commands not sent, and those cancelled, for the next run. Commands
+// enqueued after close is called are turned away.
+func (q *commandQueue) close(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	if q.durable != nil {
+		q.durable.stop()
+		defer q.durable.close()
+	}
+	close(q.jobs)
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		if q.durable != nil {
+			// Cancelled commands are returned to the stream before it
+			// closes; the workers stop as soon as they are cancelled.
+			<-done
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	commandStream   = "COMMANDS"
+	commandSubject  = "commands"
+	commandConsumer = "workers"
+
+	// commandAckWait is how long a command handed to a worker may go
+	// without word from it before it is handed out again. Workers report
+	// progress while retrying, so this only matters if the webhook dies.
+	commandAckWait = 30 * time.Second
+)
+
+// errSecretCommand is returned for commands carrying secrets, which the
+// durable queue does not store.
+var errSecretCommand = errors.New("commands carrying secrets are not stored in the durable queue")
+
+// durableCommand is a command as kept in the durable queue. The request ID
+// ties the messages logged when it is sent to the event that triggered it.
+type durableCommand struct {
+	Tenant    string  `json:"tenant,omitempty"`
+	RequestID string  `json:"request_id,omitempty"`
+	Command   Command `json:"command"`
+}
+
+// jetStreamQueue keeps queued commands in a JetStream work-queue stream of
+// an embedded NATS server, which stores them on disk, unencrypted. Commands
+// are removed once a worker has sent them.
+type jetStreamQueue struct {
+	ns     *server.Server
+	nc     *nats.Conn
+	js     jetstream.JetStream
+	stream jetstream.Stream
+	cons   jetstream.Consumer
+
+	feeding  bool
+	stopping chan struct{}
+	fed      chan struct{} // closed once feed has stopped
+}
+
+func openJetStreamQueue(dir string, size, workers int) (*jetStreamQueue, error) {
+	ns, err := server.NewServer(&server.Options{
+		ServerName: "micromdm-webhook",
+		JetStream:  true,
+		StoreDir:   dir,
+		DontListen: true,
+		NoSigs:     true,
+		NoLog:      true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("start embedded NATS server: %v", err)
+	}
+	ns.Start()
+	if !ns.ReadyForConnections(10 * time.Second) {
+		ns.Shutdown()
+		return nil, fmt.Errorf("embedded NATS server did not start")
+	}
+	j := &jetStreamQueue{ns: ns, stopping: make(chan struct{}), fed: make(chan struct{})}
+	if err := j.open(size, workers); err != nil {
+		j.close()
+		return nil, fmt.Errorf("open durable command queue: %v", err)
+	}
+	return j, nil
+}
+
+func (j *jetStreamQueue) open(size, workers int) error {
+	var err error
+	if j.nc, err = nats.Connect(j.ns.ClientURL(), nats.InProcessServer(j.ns)); err != nil {
+		return err
+	}
+	if j.js, err = jetstream.New(j.nc); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	maxMsgs := int64(size)
+	if size <= 0 {
+		maxMsgs = -1
+	}
+	j.stream, err = j.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      commandStream,
+		Subjects:  []string{commandSubject},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+		MaxMsgs:   maxMsgs,
+		Discard:   jetstream.DiscardNew,
+	})
+	if err != nil {
+		return err
+	}
+	// The stream only holds commands not sent yet, so starting with a new
+	// consumer hands out those an earlier run was sending right away,
+	// rather than once their AckWait has passed.
+	if err := j.stream.DeleteConsumer(ctx, commandConsumer); err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+		return err
+	}
+	j.cons, err = j.stream.CreateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:       commandConsumer,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       commandAckWait,
+		MaxAckPending: max(workers, 1),
+	})
+	return err
+}
+
+// add stores c to be sent by the server of tenant. It returns errQueueFull
+// if the stream holds as many commands as it may. Commands carrying secrets
+// are refused, as they would be stored in the clear.
+func (j *jetStreamQueue) add(ctx context.Context, tenant string, c Command) error {
+	if c.hasSecrets() {
+		return errSecretCommand
+	}
+	data, err := json.Marshal(durableCommand{Tenant: tenant, RequestID: requestIDFrom(ctx), Command: c})
+	if err != nil {
+		return fmt.Errorf("encode command: %v", err)
+	}
+	_, err = j.js.Publish(ctx, commandSubject, data)
+	var apiErr *jetstream.APIError
+	if errors.As(err, &apiErr) && apiErr.Description == server.ErrMaxMsgs.Error() {
+		return errQueueFull
+	}
+	if err != nil {
+		return fmt.Errorf("add command to durable queue: %v", err)
+	}
+	return nil
+}
+
+// feed hands the stored commands to q's workers, one at a time as they
+// become free, until stop is called. Commands are fetched only once a
+// worker can take them, so none are held back when the webhook stops.
+func (j *jetStreamQueue) feed(q *commandQueue, servers []*Server) {
+	byTenant := make(map[string]*Server, len(servers))
+	for _, s := range servers {
+		byTenant[s.Tenant] = s
+	}
+	j.feeding = true
+	go func() {
+		defer close(j.fed)
+		for {
+			select {
+			case <-j.stopping:
+				return
+			default:
+			}
+			batch, err := j.cons.Fetch(1, jetstream.FetchMaxWait(time.Second))
+			if err != nil {
+				logrus.WithError(err).Warn("read durable command queue")
+				time.Sleep(time.Second)
+				continue
+			}
+			for msg := range batch.Messages() {
+				j.hand(q, byTenant, msg)
+			}
+		}
+	}()
+}
+
+// hand passes the command in msg to a worker, or back to the stream if the
+// queue is stopping first.
+func (j *jetStreamQueue) hand(q *commandQueue, byTenant map[string]*Server, msg jetstream.Msg) {
+	var d durableCommand
+	if err := json.Unmarshal(msg.Data(), &d); err != nil {
+		logrus.WithError(err).Error("dropping undecodable queued command")
+		msg.Term()
+		return
+	}
+	s, ok := byTenant[d.Tenant]
+	if !ok {
+		logrus.WithFields(logrus.Fields{"tenant": d.Tenant, "udid": d.Command.UDID}).Error("dropping queued command of unknown tenant")
+		msg.Term()
+		return
+	}
+	logger := logrus.WithFields(logrus.Fields{"request_id": d.RequestID, "udid": d.Command.UDID})
+	if d.Tenant != "" {
+		logger = logger.WithField("tenant", d.Tenant)
+	}
+	ctx := withLogger(context.WithValue(context.Background(), requestIDKey{}, d.RequestID), logger)
+	select {
+	case q.jobs <- queuedCommand{ctx: ctx, s: s, c: d.Command, msg: msg}:
+	case <-j.stopping:
+		msg.Nak()
+	}
+}
+
+// send sends the command of job, telling JetStream it is still being worked
+// on while it is retried, and removes it from the stream unless it was
+// cancelled by shutdown, in which case it is sent again after a restart.
+func (j *jetStreamQueue) send(ctx context.Context, job queuedCommand) {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(commandAckWait / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				job.msg.InProgress()
+			case <-done:
+				return
+			}
+		}
+	}()
+	job.s.sendCommandNow(ctx, job.c)
+	close(done)
+	if ctx.Err() != nil {
+		job.msg.Nak()
+		return
+	}
+	if err := job.msg.Ack(); err != nil {
+		logFor(ctx).WithError(err).Error("remove sent command from durable queue")
+	}
+}
+
+// length returns the number of commands stored, including those being sent.
+func (j *jetStreamQueue) length() int {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	info, err := j.stream.Info(ctx)
+	if err != nil {
+		return -1
+	}
+	return int(info.State.Msgs)
+}
+
+// stop stops handing commands to workers.
+func (j *jetStreamQueue) stop() {
+	if j.feeding {
+		close(j.stopping)
+		<-j.fed
+	}
+}
+
+// close shuts the embedded server down once the workers are done, after
+// their last acknowledgements have reached it.
+func (j *jetStreamQueue) close() {
+	if j.nc != nil {
+		j.nc.FlushTimeout(time.Second)
+		j.nc.Close()
+	}
+	j.ns.Shutdown()
+	j.ns.WaitForShutdown()
+}
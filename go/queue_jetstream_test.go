@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestJetStreamQueueRefusesSecrets(t *testing.T) {
+	j, err := openJetStreamQueue(t.TempDir(), 0, 1)
+	if err != nil {
+		t.Fatalf("open durable queue: %v", err)
+	}
+	defer j.close()
+	tests := []struct {
+		name    string
+		command Command
+		wantErr error
+	}{
+		{name: "plain", command: Command{UDID: "U", RequestType: "DeviceInformation"}},
+		{name: "profile", command: Command{UDID: "U", RequestType: "InstallProfile", Payload: []byte("profile")}},
+		{name: "PIN", command: Command{UDID: "U", RequestType: "DeviceLock", PIN: "123456"}, wantErr: errSecretCommand},
+		{name: "unlock token", command: Command{UDID: "U", RequestType: "ClearPasscode", UnlockToken: []byte("token")}, wantErr: errSecretCommand},
+		{name: "FileVault key", command: Command{UDID: "U", RequestType: "RotateFileVaultKey", FileVaultUnlock: &FileVaultUnlock{}}, wantErr: errSecretCommand},
+		{name: "secret profile", command: Command{UDID: "U", RequestType: "InstallProfile", Payload: []byte("password"), SecretPayload: true}, wantErr: errSecretCommand},
+	}
+	stored := 0
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := j.add(context.Background(), "", tt.command)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("add error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				stored++
+			}
+			if got := j.length(); got != stored {
+				t.Errorf("queue holds %d commands, want %d", got, stored)
+			}
+		})
+	}
+}
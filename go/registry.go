@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/micromdm/micromdm/mdm"
+)
+
+// The handlers of webhook events and command responses are looked up in
+// registries. A fork adds behaviors without touching handleWebhook by
+// registering handlers from an init function in a file of its own, the way
+// database/sql drivers register themselves:
+//
+//	func init() {
+//		registerTopicHandler(mdm.CheckoutTopic, (*Server).wipeCheckedOutDevice)
+//		registerResponseHandler("DeviceConfigured", "", (*Server).applyDeviceConfigured)
+//	}
+//
+// Registered handlers run after the built-in ones, in the order they were
+// registered. Registries must not be changed once the server has started.
+
+// topicHandler handles a webhook event of the topic it is registered for.
+// Errors are written to w, which stops the handlers registered after it.
+type topicHandler func(s *Server, ctx context.Context, event webhook.Event, w http.ResponseWriter)
+
+// topicHandlers maps a topic to the handlers of its events.
+var topicHandlers = map[string][]topicHandler{
+	mdm.AuthenticateTopic: {(*Server).handleAuthenticate},
+	mdm.TokenUpdateTopic:  {(*Server).handleTokenUpdate},
+	mdm.ConnectTopic:      {(*Server).handleConnect},
+	mdm.CheckoutTopic:     {(*Server).handleCheckOut},
+
+	declarativeManagementTopic: {(*Server).handleDeclarativeManagement},
+	userAuthenticateTopic:      {(*Server).handleUserAuthenticate},
+	setBootstrapTokenTopic:     {(*Server).handleSetBootstrapToken},
+	getBootstrapTokenTopic:     {(*Server).handleGetBootstrapToken},
+}
+
+// registerTopicHandler adds h to the handlers of topic's events. Topics
+// with handlers are known, so they can be configured in the config file.
+// It panics if topic is empty or h is nil.
+func registerTopicHandler(topic string, h topicHandler) {
+	if topic == "" || h == nil {
+		panic("registerTopicHandler: empty topic or nil handler")
+	}
+	topicHandlers[topic] = append(topicHandlers[topic], h)
+}
+
+// responseHandlers maps a RequestType to the handlers of its acknowledged
+// responses.
+var responseHandlers = map[string][]responseHandler{
+	"InstalledApplicationList": {(*Server).applyInstalledApplicationList},
+	"ManagedApplicationList":   {(*Server).applyManagedApplicationList},
+	"InstallApplication":       {(*Server).applyInstallApplication},
+	"DeviceInformation":        {(*Server).applyDeviceInformation, (*Server).escrowBypassCode, (*Server).reportToMunkiReport},
+	"SecurityInfo":             {(*Server).applySecurityInfo, (*Server).escrowFileVaultKey},
+	"ProfileList":              {(*Server).applyProfileList, (*Server).reconcileProfiles},
+	"CertificateList":          {(*Server).applyCertificateList},
+	"Settings":                 {(*Server).applySettings},
+	"AvailableOSUpdates":       {(*Server).applyAvailableOSUpdates},
+	"OSUpdateStatus":           {(*Server).applyOSUpdateStatus},
+	"ScheduleOSUpdate":         {(*Server).applyOSUpdateStatus},
+	"EnableLostMode":           {(*Server).applyLostMode},
+	"DisableLostMode":          {(*Server).applyLostMode},
+	"DeviceLocation":           {(*Server).applyDeviceLocation},
+	"RotateFileVaultKey":       {(*Server).applyRotateFileVaultKey},
+}
+
+// registerResponseHandler adds h to the handlers of requestType's
+// acknowledged responses. responseKey, if set, is the top-level key of the
+// response that identifies requestType, for responses that carry one. It
+// panics if requestType is empty, h is nil, or responseKey already
+// identifies another request type.
+func registerResponseHandler(requestType, responseKey string, h responseHandler) {
+	if requestType == "" || h == nil {
+		panic("registerResponseHandler: empty request type or nil handler")
+	}
+	if responseKey != "" {
+		if err := webhook.RegisterResponseKey(responseKey, requestType); err != nil {
+			panic("registerResponseHandler: " + err.Error())
+		}
+	}
+	responseHandlers[requestType] = append(responseHandlers[requestType], h)
+}
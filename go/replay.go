@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/sirupsen/logrus"
+)
+
+// runReplay handles archived webhook events again, as if MicroMDM had just
+// delivered them, to rebuild device state or to try new settings on past
+// events.
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `Usage: micromdm-webhook replay [flags] <file|directory|s3://bucket/prefix> ...`)
+		fs.PrintDefaults()
+	}
+	storeOpts := storeFlags(fs)
+	var (
+		flDryRun    = fs.Bool("dry-run", false, "handle the events with an empty in-memory store instead of the one selected by -store, and send no commands")
+		flSend      = fs.Bool("send-commands", false, "send the commands the events trigger to MicroMDM (requires -server-url and -api-token); otherwise they are only logged")
+		flServerURL = fs.String("server-url", "", "public HTTPS url of your MicroMDM server")
+		flAPIKey    = fs.String("api-token", "", "API Token for your MicroMDM server")
+		flTenant    = fs.String("tenant", "", "handle the events as those of this tenant")
+		flProfiles  = fs.String("expected-profiles", "", "comma-separated profile identifiers every device should have installed")
+		flS3URL     = fs.String("archive-s3-endpoint", "", "override the S3 endpoint, e.g. for MinIO or another S3-compatible store")
+		flLogLevel  = fs.String("log-level", "info", "minimum level of messages to log: debug, info, warn, or error")
+		flLogFormat = fs.String("log-format", "text", "log format: text or json")
+	)
+	fs.String("config", "", "YAML or TOML file of settings; flags given on the command line take precedence")
+	fc, err := loadSharedConfigFile(fs, args)
+	if err != nil {
+		return err
+	}
+	parseFlags(fs, args)
+	if err := setupLogging(*flLogLevel, *flLogFormat); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no archives to replay")
+	}
+	if *flSend {
+		if *flDryRun {
+			return fmt.Errorf("-send-commands cannot be used with -dry-run")
+		}
+		if err := requireSetting(*flServerURL, "server-url", "MicroMDM server URL"); err != nil {
+			return err
+		}
+		if err := requireSetting(*flAPIKey, "api-token", "MicroMDM API token"); err != nil {
+			return err
+		}
+	}
+
+	opts := storeOpts()
+	if *flDryRun {
+		opts = storeOptions{Kind: "memory"}
+	}
+	backend, err := openStore(opts)
+	if err != nil {
+		return err
+	}
+	if c, ok := backend.(io.Closer); ok {
+		defer c.Close()
+	}
+	history := historyFor(backend)
+	var devices DeviceStore = backend
+	if *flTenant != "" {
+		devices = store.NewTenantStore(*flTenant, backend, history)
+	}
+	s := NewServer(*flServerURL, *flAPIKey, devices)
+	s.Tenant = *flTenant
+	s.Topics = fc.Topics
+	s.SkipCommands = !*flSend
+	if *flProfiles != "" {
+		s.ExpectedProfiles = strings.Split(*flProfiles, ",")
+	}
+
+	var replayed, failed int
+	replay := func(raw []byte) error {
+		rec := httptest.NewRecorder()
+		s.handleWebhook(rec, httptest.NewRequest("POST", "/webhook", bytes.NewReader(raw)))
+		replayed++
+		if rec.Code >= 400 {
+			failed++
+			logrus.WithField("status", rec.Code).Warnf("event failed: %s", strings.TrimSpace(rec.Body.String()))
+		}
+		return nil
+	}
+	ctx, cancel := cliContext()
+	defer cancel()
+	for _, src := range fs.Args() {
+		if rest, ok := strings.CutPrefix(src, "s3://"); ok {
+			bucket, prefix, _ := strings.Cut(rest, "/")
+			err = readS3Archive(ctx, S3ArchiveOptions{Bucket: bucket, Prefix: prefix, Endpoint: *flS3URL}, replay)
+		} else {
+			err = readArchive([]string{src}, replay)
+		}
+		if err != nil {
+			return fmt.Errorf("replay %s: %v", src, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	logrus.WithFields(logrus.Fields{"events": replayed, "failed": failed}).Info("replay finished")
+	return nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"gopkg.in/yaml.v3"
+)
+
+// ruleVars counts the events each rule matched, keyed by its name.
+var ruleVars = expvar.NewMap("rules")
+
+// Rule runs actions on the events matching all of its conditions, so
+// behaviors such as sending InstalledApplicationList on TokenUpdate can be
+// configured rather than coded.
+type Rule struct {
+	// Name identifies the rule in logs, notifications, and /debug/vars.
+	Name string `yaml:"name"`
+
+	// When maps fields of the event to the glob patterns (as in path.Match)
+	// one of which each must match: topic, tenant, udid, request_type and
+	// status of command responses, device.<field> of the stored device by
+	// its JSON name, e.g. device.info.model or device.tags, and
+	// payload.<key> of what the device sent, e.g. payload.QueryResponses.
+	// OSVersion. Fields that are lists match if any of their items does,
+	// and missing fields are empty.
+	When map[string]RulePatterns `yaml:"when"`
+
+	// Tags, if set, is a tag expression the event's device must match,
+	// e.g. "kiosk NOT retired".
+	Tags *TagExpr `yaml:"tags"`
+
+	// Then are the actions run, in order, when the rule matches.
+	Then []RuleAction `yaml:"then"`
+}
+
+// RulePatterns are the patterns of a rule condition, given in the config
+// file as one pattern or a list of them.
+type RulePatterns []string
+
+func (p *RulePatterns) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*p = RulePatterns{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*p = list
+	return nil
+}
+
+// RuleAction is one thing a rule does. Exactly one of its fields is set.
+type RuleAction struct {
+	// Command is the request type of a command to send to the device.
+	// DeviceLock commands for Macs get a PIN, escrowed as by the admin API,
+	// and RestartDevice and ShutDownDevice ones are skipped for devices they
+	// need to be supervised on. Commands the device's platform does not
+	// take, such as EnableLostMode for a Mac, are skipped too.
+	Command string `yaml:"command"`
+
+	// Tag and Untag add a tag to the device and remove one from it.
+	Tag   string `yaml:"tag"`
+	Untag string `yaml:"untag"`
+
+	// Notify sends a notification, as the notifiers of topics do. Its
+	// Rule is the rule's name.
+	Notify *TopicNotifier `yaml:"notify"`
+
+	// Hook runs a program, given with its arguments, like the exec hooks of
+	// topics.
+	Hook []string `yaml:"hook"`
+
+	// Profile is the path, relative to the config file, of a configuration
+	// profile to install on the device, like those of blueprints.
+	Profile string `yaml:"profile"`
+
+	// RemoveProfile is the identifier of a profile to remove from the
+	// device.
+	RemoveProfile string `yaml:"remove-profile"`
+
+	// Settings are sent to the device with a Settings command, e.g. to
+	// name it after its serial number and user.
+	Settings *DeviceSettings `yaml:"settings"`
+
+	// profile holds the contents of Profile.
+	profile *profile
+}
+
+// ruleFields are the fields rule conditions can match other than those of
+// device. and payload.
+var ruleFields = []string{"topic", "tenant", "udid", "request_type", "status"}
+
+func (r Rule) validate() error {
+	if len(r.When) == 0 && r.Tags == nil {
+		return fmt.Errorf("no conditions")
+	}
+	for field, patterns := range r.When {
+		if !slices.Contains(ruleFields, field) && !strings.HasPrefix(field, "device.") && !strings.HasPrefix(field, "payload.") {
+			return fmt.Errorf("unknown field %q, want one of %s, device.<field>, or payload.<key>", field, strings.Join(ruleFields, ", "))
+		}
+		if len(patterns) == 0 {
+			return fmt.Errorf("%s: no patterns", field)
+		}
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("%s: pattern %q: %v", field, p, err)
+			}
+		}
+	}
+	if len(r.Then) == 0 {
+		return fmt.Errorf("no actions")
+	}
+	for i, a := range r.Then {
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("action %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (a RuleAction) validate() error {
+	set := 0
+	for _, ok := range []bool{a.Command != "", a.Tag != "", a.Untag != "", a.Notify != nil, len(a.Hook) > 0, a.Profile != "", a.RemoveProfile != "", a.Settings != nil} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("set exactly one of command, tag, untag, notify, hook, profile, remove-profile, or settings")
+	}
+	switch {
+	case a.Command != "":
+		if _, _, err := parseCommandPayload([]byte(fmt.Sprintf(`{"request_type": %q}`, a.Command))); err != nil {
+			return err
+		}
+	case a.Notify != nil:
+		return a.Notify.validate()
+	case a.Settings != nil:
+		return a.Settings.validate()
+	case len(a.Hook) > 0 && a.Hook[0] == "":
+		return fmt.Errorf("hook: no program")
+	}
+	return nil
+}
+
+// rule is a Rule ready to run.
+type rule struct {
+	Rule
+	// notify and hooks hold the notifier queues and hooks of the rule's
+	// notify and hook actions, by their index in Then.
+	notify  map[int]*notifyQueue
+	hooks   map[int]*execHook
+	matched *expvar.Int
+}
+
+// addRules sets up rules to run on the server's events, adding the
+// notifiers of their notify actions and the hooks of their hook actions to
+// s.Hooks. Email notifiers send through the SMTP server of smtp.
+func (s *Server) addRules(rules []Rule, smtp EmailOptions) error {
+	for _, r := range rules {
+		cr, err := s.newRule("rule", r, smtp)
+		if err != nil {
+			return fmt.Errorf("rules: %v", err)
+		}
+		ruleVars.Set(r.Name, cr.matched)
+		s.Rules = append(s.Rules, cr)
+	}
+	return nil
+}
+
+// newRule sets up the actions of r to run, naming their notifiers and hooks
+// kind/<name>-<n>.
+func (s *Server) newRule(kind string, r Rule, smtp EmailOptions) (*rule, error) {
+	cr := &rule{Rule: r, notify: make(map[int]*notifyQueue), hooks: make(map[int]*execHook), matched: new(expvar.Int)}
+	for i, a := range r.Then {
+		name := fmt.Sprintf("%s/%s-%d", kind, r.Name, i+1)
+		if len(a.Hook) > 0 {
+			cr.hooks[i] = s.Hooks.add(name, ExecHook{Command: a.Hook})
+		}
+		if a.Notify == nil {
+			continue
+		}
+		n, err := newTopicNotifier(name, *a.Notify, smtp)
+		if err != nil {
+			return nil, fmt.Errorf("%s: action %d: %v", r.Name, i+1, err)
+		}
+		cr.notify[i] = s.addNotifier(name, n, nil)
+	}
+	return cr, nil
+}
+
+// ruleEvent is what rule conditions are matched against.
+type ruleEvent map[string]interface{}
+
+func newRuleEvent(n Notification, event webhook.Event) (ruleEvent, error) {
+	b, err := json.Marshal(n.Device)
+	if err != nil {
+		return nil, fmt.Errorf("encode device: %v", err)
+	}
+	var device map[string]interface{}
+	if err := json.Unmarshal(b, &device); err != nil {
+		return nil, fmt.Errorf("decode device: %v", err)
+	}
+	e := ruleEvent{"topic": n.Topic, "tenant": n.Tenant, "udid": n.UDID, "device": device, "payload": n.Payload, "request_type": nil, "status": nil}
+	if a := event.AcknowledgeEvent; a != nil {
+		if ack, err := webhook.DecodeAcknowledgment(a.RawPayload); err == nil {
+			e["request_type"], e["status"] = ack.RequestType, ack.Status
+		}
+	}
+	return e, nil
+}
+
+// lookup returns the values of field, a path of keys separated by dots.
+func (e ruleEvent) lookup(field string) []string {
+	var v interface{} = map[string]interface{}(e)
+	for _, key := range strings.Split(field, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return []string{""}
+		}
+		v = m[key]
+	}
+	switch v := v.(type) {
+	case nil:
+		return []string{""}
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, item := range v {
+			values[i] = fmt.Sprint(item)
+		}
+		return values
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+func (r *rule) matches(d Device, e ruleEvent) bool {
+	if r.Tags != nil && !r.Tags.matches(d) {
+		return false
+	}
+	for field, patterns := range r.When {
+		if !slices.ContainsFunc(e.lookup(field), func(value string) bool {
+			return slices.ContainsFunc(patterns, func(p string) bool {
+				ok, _ := path.Match(p, value)
+				return ok
+			})
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRules runs the actions of the rules event matches, in the order the
+// rules are configured, on the device it is about as stored once it was
+// handled. body is the event as received, for hooks.
+func (s *Server) applyRules(ctx context.Context, event webhook.Event, udid string, body []byte) {
+	if len(s.Rules) == 0 || udid == "" {
+		return
+	}
+	d, _, err := s.loadDevice(udid)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device for rules")
+		return
+	}
+	tagged := false
+	for _, r := range s.Rules {
+		n := s.newNotification(event.Topic, d, event)
+		e, err := newRuleEvent(n, event)
+		if err != nil {
+			logFor(ctx).WithError(err).Error("match rules")
+			return
+		}
+		if !r.matches(d, e) {
+			continue
+		}
+		r.matched.Add(1)
+		ctx := withLogger(ctx, logFor(ctx).WithField("rule", r.Name))
+		logFor(ctx).Info("rule matched")
+		tagged = s.runActions(ctx, r, &d, event, n, body) || tagged
+	}
+	if tagged {
+		if err := s.Devices.Save(d); err != nil {
+			logFor(ctx).WithError(err).Error("save device tags")
+		}
+	}
+}
+
+// runActions runs the actions of r, in order, on d, about which event was
+// received as body. n is the notification for notify actions. It reports
+// whether the actions tagged or untagged d, which the caller saves.
+func (s *Server) runActions(ctx context.Context, r *rule, d *Device, event webhook.Event, n Notification, body []byte) bool {
+	tagged := false
+	for i, a := range r.Then {
+		switch {
+		case a.Command == "DeviceInformation":
+			s.requestDeviceInformation(ctx, *d)
+		case a.Command == "RestartDevice" || a.Command == "ShutDownDevice":
+			if err := checkPowerCommand(*d, a.Command); err != nil {
+				logFor(ctx).WithError(err).Error("run rule")
+				continue
+			}
+			s.sendCommandToDevice(ctx, *d, a.Command)
+		case a.Command == "DeviceLock":
+			if _, err := s.lockDevice(ctx, d, LockOptions{}); err != nil {
+				logFor(ctx).WithError(err).Error("lock device")
+			}
+		case a.Command != "":
+			s.sendCommandToDevice(ctx, *d, a.Command)
+		case a.Tag != "":
+			tagged = d.AddTag(a.Tag) || tagged
+		case a.Untag != "":
+			tagged = d.RemoveTag(a.Untag) || tagged
+		case a.profile != nil:
+			c, err := s.installProfileCommand(ctx, *d, "", a.profile)
+			if err != nil {
+				logFor(ctx).WithError(err).Error("run rule")
+				continue
+			}
+			s.sendCommand(ctx, c)
+		case a.RemoveProfile != "":
+			s.sendCommand(ctx, removeProfileCommand(*d, a.RemoveProfile))
+		case a.Settings != nil:
+			c, err := settingsCommand(*d, *a.Settings)
+			if err != nil {
+				logFor(ctx).WithError(err).Error("run rule")
+				continue
+			}
+			s.sendCommand(ctx, c)
+		case a.Notify != nil:
+			n.Rule, n.Device = r.Name, *d
+			s.Notifiers.enqueue(ctx, r.notify[i], n)
+		case len(a.Hook) > 0:
+			s.Hooks.run(ctx, r.hooks[i], s.hookEnv(event.Topic, event.EventID, d.UDID), body)
+		}
+	}
+	return tagged
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// scriptVars counts the runs of each script, keyed by its file name: runs,
+// and errors (failed or returned something invalid).
+var scriptVars = expvar.NewMap("scripts")
+
+// script is a Starlark file defining a handle(event, device) function, called
+// for every webhook event with the event and the stored device as dicts. It
+// returns None, or a dict of what to do:
+//
+//	{
+//	    "commands": ["InstalledApplicationList", {"request_type": "RestartDevice"}],
+//	    "tags": ["needs-attention"],
+//	    "untags": ["healthy"],
+//	}
+//
+// Commands given as request types are sent like those of TokenUpdate, and
+// those given as dicts like the admin API's.
+type script struct {
+	name   string
+	handle starlark.Callable
+	// timeout bounds each call of handle.
+	timeout time.Duration
+
+	runs, errors expvar.Int
+}
+
+// loadScripts loads the *.star files of dir, in the order of their names.
+func loadScripts(dir string, timeout time.Duration) ([]*script, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.star"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no *.star scripts in %s", dir)
+	}
+	sort.Strings(paths)
+	var scripts []*script
+	for _, path := range paths {
+		sc, err := loadScript(path, timeout)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, sc)
+	}
+	return scripts, nil
+}
+
+func loadScript(path string, timeout time.Duration) (*script, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read script: %v", err)
+	}
+	name := filepath.Base(path)
+	thread := &starlark.Thread{Name: name, Print: scriptPrint(logrus.NewEntry(logrus.StandardLogger()))}
+	// Scripts may loop and recurse; -script-timeout stops those that
+	// never finish.
+	opts := &syntax.FileOptions{Set: true, While: true, TopLevelControl: true, GlobalReassign: true, Recursion: true}
+	globals, err := starlark.ExecFileOptions(opts, thread, path, src, nil)
+	if err != nil {
+		return nil, fmt.Errorf("load script %s: %v", name, err)
+	}
+	// Calls share the globals; frozen, they cannot change between events.
+	globals.Freeze()
+	handle, ok := globals["handle"].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("load script %s: no handle(event, device) function", name)
+	}
+	sc := &script{name: name, handle: handle, timeout: timeout}
+	vars := new(expvar.Map)
+	vars.Set("runs", &sc.runs)
+	vars.Set("errors", &sc.errors)
+	scriptVars.Set(name, vars)
+	return sc, nil
+}
+
+// scriptPrint logs what scripts print.
+func scriptPrint(logger *logrus.Entry) func(*starlark.Thread, string) {
+	return func(thread *starlark.Thread, msg string) {
+		logger.WithField("script", thread.Name).Info(msg)
+	}
+}
+
+// scriptResult is what a script's handle function asks for.
+type scriptResult struct {
+	Commands []interface{} `json:"commands"`
+	Tags     []string      `json:"tags"`
+	Untags   []string      `json:"untags"`
+}
+
+// call runs handle with event and device, stopping it after sc.timeout.
+func (sc *script) call(ctx context.Context, event, device starlark.Value) (scriptResult, error) {
+	var res scriptResult
+	thread := &starlark.Thread{Name: sc.name, Print: scriptPrint(logFor(ctx))}
+	timer := time.AfterFunc(sc.timeout, func() { thread.Cancel("timed out after " + sc.timeout.String()) })
+	defer timer.Stop()
+	v, err := starlark.Call(thread, sc.handle, starlark.Tuple{event, device}, nil)
+	if err != nil {
+		if evalErr, ok := err.(*starlark.EvalError); ok {
+			return res, fmt.Errorf("%s", evalErr.Backtrace())
+		}
+		return res, err
+	}
+	if v == starlark.None {
+		return res, nil
+	}
+	if _, ok := v.(*starlark.Dict); !ok {
+		return res, fmt.Errorf("handle returned %s, want a dict or None", v.Type())
+	}
+	out, err := fromStarlark(v)
+	if err != nil {
+		return res, fmt.Errorf("handle returned %v", err)
+	}
+	// Re-encoding checks the dict's keys and value types.
+	b, err := json.Marshal(out)
+	if err != nil {
+		return res, fmt.Errorf("handle returned %v", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&res); err != nil {
+		return res, fmt.Errorf("handle returned an invalid dict: %v", err)
+	}
+	return res, nil
+}
+
+// runScripts calls the scripts with event and the device it is about, as
+// stored once it was handled, and does what they return.
+func (s *Server) runScripts(ctx context.Context, event webhook.Event, udid string) {
+	if len(s.Scripts) == 0 || udid == "" {
+		return
+	}
+	d, _, err := s.loadDevice(udid)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device for scripts")
+		return
+	}
+	tagged := false
+	for _, sc := range s.Scripts {
+		logger := logFor(ctx).WithField("script", sc.name)
+		e, err := newRuleEvent(s.newNotification(event.Topic, d, event), event)
+		if err != nil {
+			logger.WithError(err).Error("run script")
+			return
+		}
+		device := e["device"]
+		delete(e, "device")
+		e["event_id"] = event.EventID
+		sv, err := toStarlark(map[string]interface{}(e))
+		if err != nil {
+			logger.WithError(err).Error("run script")
+			return
+		}
+		dv, err := toStarlark(device)
+		if err != nil {
+			logger.WithError(err).Error("run script")
+			return
+		}
+		sc.runs.Add(1)
+		res, err := sc.call(withLogger(ctx, logger), sv, dv)
+		if err != nil {
+			sc.errors.Add(1)
+			logger.WithError(err).Error("run script")
+			continue
+		}
+		for _, c := range res.Commands {
+			switch c := c.(type) {
+			case string:
+				if c == "DeviceInformation" {
+					s.requestDeviceInformation(ctx, d)
+				} else {
+					s.sendCommandToDevice(ctx, d, c)
+				}
+			case map[string]interface{}:
+				s.sendScriptCommand(withLogger(ctx, logger), d, c)
+			default:
+				sc.errors.Add(1)
+				logger.Errorf("run script: command %v is neither a request type nor a dict", c)
+			}
+		}
+		for _, tag := range res.Tags {
+			tagged = d.AddTag(tag) || tagged
+		}
+		for _, tag := range res.Untags {
+			tagged = d.RemoveTag(tag) || tagged
+		}
+	}
+	if tagged {
+		if err := s.Devices.Save(d); err != nil {
+			logFor(ctx).WithError(err).Error("save device tags")
+		}
+	}
+}
+
+// sendScriptCommand sends the command a script returned as a dict, in the
+// format of MicroMDM's /v1/commands endpoint, to d.
+func (s *Server) sendScriptCommand(ctx context.Context, d Device, c map[string]interface{}) {
+	c["udid"] = d.UDID
+	b, err := json.Marshal(c)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("run script: encode command")
+		return
+	}
+	requestType, payload, err := parseCommandPayload(b)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("run script")
+		return
+	}
+	if _, err := s.postCommand(ctx, d.UDID, requestType, payload); err != nil {
+		logFor(ctx).WithFields(logrus.Fields{"udid": d.UDID, "request_type": requestType}).WithError(err).Error("send command")
+	}
+}
+
+// toStarlark converts v, as decoded from JSON or a plist, to a Starlark
+// value.
+func toStarlark(v interface{}) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []byte:
+		return starlark.Bytes(v), nil
+	case int64:
+		return starlark.MakeInt64(v), nil
+	case uint64:
+		return starlark.MakeUint64(v), nil
+	case int:
+		return starlark.MakeInt(v), nil
+	case float64:
+		if v == float64(int64(v)) {
+			// JSON decodes every number as a float.
+			return starlark.MakeInt64(int64(v)), nil
+		}
+		return starlark.Float(v), nil
+	case time.Time:
+		return starlark.String(v.UTC().Format(time.RFC3339)), nil
+	case []interface{}:
+		items := make([]starlark.Value, len(v))
+		for i, item := range v {
+			sv, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = sv
+		}
+		return starlark.NewList(items), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		d := starlark.NewDict(len(v))
+		for _, k := range keys {
+			sv, err := toStarlark(v[k])
+			if err != nil {
+				return nil, err
+			}
+			d.SetKey(starlark.String(k), sv)
+		}
+		return d, nil
+	}
+	return starlark.String(fmt.Sprint(v)), nil
+}
+
+// fromStarlark converts v to the Go value encoding it as JSON.
+func fromStarlark(v starlark.Value) (interface{}, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return nil, fmt.Errorf("integer %v out of range", v)
+	case starlark.Float:
+		return float64(v), nil
+	case *starlark.List:
+		return fromStarlarkIterable(v)
+	case starlark.Tuple:
+		return fromStarlarkIterable(v)
+	case *starlark.Dict:
+		m := make(map[string]interface{}, v.Len())
+		for _, item := range v.Items() {
+			k, ok := item[0].(starlark.String)
+			if !ok {
+				return nil, fmt.Errorf("dict key %v is not a string", item[0])
+			}
+			gv, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			m[string(k)] = gv
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported %s value %v", v.Type(), v)
+}
+
+func fromStarlarkIterable(v starlark.Indexable) ([]interface{}, error) {
+	items := make([]interface{}, v.Len())
+	for i := range items {
+		gv, err := fromStarlark(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		items[i] = gv
+	}
+	return items, nil
+}
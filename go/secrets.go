@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// profileSecrets are the values profile templates read with .Secret, kept
+// out of the profiles and the config file. They are loaded from a YAML file
+// mapping names to values, reloaded when it changes. A value is a string, or
+// a mapping from serial numbers or UDIDs to the strings of those devices,
+// with "*" for the other devices:
+//
+//	wifi-password: correct horse battery staple
+//	eap-password:
+//	  C02XYZ: s3cret
+//	  "*": guest
+type profileSecrets struct {
+	file string
+
+	mu      sync.Mutex
+	values  map[string]secretValue
+	modTime time.Time
+	checked time.Time
+}
+
+// secretValue is a profile secret: shared by every device, or set per device.
+type secretValue struct {
+	shared   string
+	byDevice map[string]string
+}
+
+// loadProfileSecrets reads the profile secrets file.
+func loadProfileSecrets(file string) (*profileSecrets, error) {
+	ps := &profileSecrets{file: file}
+	if err := ps.load(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (ps *profileSecrets) load() error {
+	info, err := os.Stat(ps.file)
+	if err != nil {
+		return fmt.Errorf("load profile secrets: %v", err)
+	}
+	b, err := os.ReadFile(ps.file)
+	if err != nil {
+		return fmt.Errorf("load profile secrets: %v", err)
+	}
+	var raw map[string]yaml.Node
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return fmt.Errorf("decode profile secrets %s: %v", ps.file, err)
+	}
+	values := make(map[string]secretValue, len(raw))
+	for name, n := range raw {
+		switch n.Kind {
+		case yaml.ScalarNode:
+			values[name] = secretValue{shared: n.Value}
+		case yaml.MappingNode:
+			var byDevice map[string]string
+			if err := n.Decode(&byDevice); err != nil {
+				return fmt.Errorf("profile secret %q: %v", name, err)
+			}
+			values[name] = secretValue{byDevice: byDevice}
+		default:
+			return fmt.Errorf("profile secret %q: want a string or a mapping of devices to strings", name)
+		}
+	}
+	ps.values, ps.modTime, ps.checked = values, info.ModTime(), time.Now()
+	return nil
+}
+
+// lookup returns the value of the secret called name for d.
+func (ps *profileSecrets) lookup(name string, d Device) (string, error) {
+	if ps == nil {
+		return "", fmt.Errorf("no profile secret %q: -profile-secrets is not set", name)
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if time.Since(ps.checked) >= certReloadInterval {
+		ps.checked = time.Now()
+		if info, err := os.Stat(ps.file); err == nil && !info.ModTime().Equal(ps.modTime) {
+			// The old secrets stay in use if the new file does not load.
+			if err := ps.load(); err != nil {
+				logrus.WithError(err).Warn("reload profile secrets")
+			} else {
+				logrus.WithField("file", ps.file).Info("reloaded profile secrets")
+			}
+		}
+	}
+	v, ok := ps.values[name]
+	if !ok {
+		return "", fmt.Errorf("no profile secret %q", name)
+	}
+	if v.byDevice == nil {
+		return v.shared, nil
+	}
+	for _, key := range []string{d.UDID, profileSerial(d), "*"} {
+		if value, ok := v.byDevice[key]; ok && key != "" {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("profile secret %q has no value for device %s", name, d.UDID)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/sirupsen/logrus"
+)
+
+// sentryTags are the log fields sent to Sentry as tags, so issues can be
+// searched and broken down by them. Other fields are sent as extra data.
+var sentryTags = []string{
+	"topic", "udid", "event_id", "tenant", "request_type", "command_uuid",
+	"request_id", "sink", "notifier", "target", "path",
+}
+
+// setupSentry reports the errors logged from now on, and the panics
+// recovered by recoverPanic, to the Sentry project of dsn. The returned
+// closer sends the events still buffered.
+func setupSentry(dsn, environment string) (closerFunc, error) {
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         dsn,
+		Environment: environment,
+		Release:     "micromdm-webhook@" + version,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("set up Sentry: %v", err)
+	}
+	logrus.AddHook(&sentryHook{hub: sentry.CurrentHub()})
+	return func() error {
+		if !sentry.Flush(5 * time.Second) {
+			return fmt.Errorf("timed out sending events to Sentry")
+		}
+		return nil
+	}, nil
+}
+
+// sentryHook sends the messages logged at error level and above to Sentry,
+// with their fields as context. Messages with the same text are grouped as
+// one issue, whatever their error.
+type sentryHook struct {
+	hub *sentry.Hub
+}
+
+func (h *sentryHook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel}
+}
+
+func (h *sentryHook) Fire(entry *logrus.Entry) error {
+	if _, ok := entry.Data["panic"]; ok {
+		// recoverPanic has reported it, with its stack.
+		return nil
+	}
+	event := sentry.NewEvent()
+	event.Level = sentry.LevelError
+	if entry.Level < logrus.ErrorLevel {
+		event.Level = sentry.LevelFatal
+	}
+	event.Message = entry.Message
+	event.Fingerprint = []string{entry.Message}
+	for k, v := range entry.Data {
+		switch {
+		case k == logrus.ErrorKey:
+			if err, ok := v.(error); ok {
+				event.Exception = []sentry.Exception{{Type: entry.Message, Value: err.Error()}}
+			}
+		case slices.Contains(sentryTags, k):
+			event.Tags[k] = fmt.Sprint(v)
+		default:
+			event.Extra[k] = v
+		}
+	}
+	h.hub.CaptureEvent(event)
+	if entry.Level < logrus.ErrorLevel {
+		// logrus exits after a fatal message.
+		h.hub.Flush(2 * time.Second)
+	}
+	return nil
+}
+
+// recoverPanic, deferred by a handler, turns a panic into a 500 response,
+// logs it with its stack, and reports it to Sentry, if set up, with the
+// fields of ctx's logger as context.
+func recoverPanic(ctx context.Context, w http.ResponseWriter) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	if v == http.ErrAbortHandler {
+		panic(v)
+	}
+	logger := logFor(ctx)
+	if hub := sentry.CurrentHub(); hub.Client() != nil {
+		hub.WithScope(func(scope *sentry.Scope) {
+			for k, field := range logger.Data {
+				if slices.Contains(sentryTags, k) {
+					scope.SetTag(k, fmt.Sprint(field))
+				} else {
+					scope.SetExtra(k, field)
+				}
+			}
+			hub.RecoverWithContext(ctx, v)
+		})
+	}
+	logger.WithFields(logrus.Fields{"panic": fmt.Sprint(v), "stack": string(debug.Stack())}).Error("handler panicked")
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
+// recoverPanics recovers the panics of next's handlers with recoverPanic.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer recoverPanic(r.Context(), w)
+		next.ServeHTTP(w, r)
+	})
+}
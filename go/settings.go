@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// Setting is one item of a Settings command, in the format of MicroMDM's
+// /v1/commands endpoint.
+type Setting struct {
+	Item       string `json:"item"`
+	Enabled    *bool  `json:"enabled,omitempty"`
+	DeviceName string `json:"device_name,omitempty"`
+	HostName   string `json:"hostname,omitempty"`
+}
+
+// DeviceSettings are what a Settings command changes, in rules, blueprints,
+// and the admin API. Unset fields are left alone.
+type DeviceSettings struct {
+	// DeviceName and HostName, which only Macs take, are templates executed
+	// with the device, e.g. `{{.Info.SerialNumber}} – {{.TagValue "user"}}`
+	// for a Mac tagged user:jdoe.
+	DeviceName string `yaml:"device-name" json:"device_name,omitempty"`
+	HostName   string `yaml:"hostname" json:"hostname,omitempty"`
+
+	// The other settings turn a feature on or off. Bluetooth requires a
+	// supervised iOS device or a Mac.
+	DataRoaming          *bool `yaml:"data-roaming" json:"data_roaming,omitempty"`
+	VoiceRoaming         *bool `yaml:"voice-roaming" json:"voice_roaming,omitempty"`
+	PersonalHotspot      *bool `yaml:"personal-hotspot" json:"personal_hotspot,omitempty"`
+	Bluetooth            *bool `yaml:"bluetooth" json:"bluetooth,omitempty"`
+	DiagnosticSubmission *bool `yaml:"diagnostic-submission" json:"diagnostic_submission,omitempty"`
+	AppAnalytics         *bool `yaml:"app-analytics" json:"app_analytics,omitempty"`
+}
+
+// toggles returns the Setting items of the settings that turn features on
+// or off, in a fixed order.
+func (ds DeviceSettings) toggles() []Setting {
+	items := []struct {
+		item    string
+		enabled *bool
+	}{
+		{"DataRoaming", ds.DataRoaming},
+		{"VoiceRoaming", ds.VoiceRoaming},
+		{"PersonalHotspot", ds.PersonalHotspot},
+		{"Bluetooth", ds.Bluetooth},
+		{"DiagnosticSubmission", ds.DiagnosticSubmission},
+		{"AppAnalytics", ds.AppAnalytics},
+	}
+	var settings []Setting
+	for _, it := range items {
+		if it.enabled != nil {
+			settings = append(settings, Setting{Item: it.item, Enabled: it.enabled})
+		}
+	}
+	return settings
+}
+
+func (ds DeviceSettings) validate() error {
+	if ds.DeviceName == "" && ds.HostName == "" && len(ds.toggles()) == 0 {
+		return fmt.Errorf("no settings")
+	}
+	for _, text := range []string{ds.DeviceName, ds.HostName} {
+		if _, err := parseSettingTemplate(text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseSettingTemplate(text string) (*template.Template, error) {
+	t, err := template.New("setting").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("settings: %v", err)
+	}
+	return t, nil
+}
+
+// renderSetting executes the template text with d. The result must not be
+// empty, since devices would not take an empty name.
+func renderSetting(name, text string, d Device) (string, error) {
+	t, err := parseSettingTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, d); err != nil {
+		return "", fmt.Errorf("render %s: %v", name, err)
+	}
+	value := strings.TrimSpace(b.String())
+	if value == "" {
+		return "", fmt.Errorf("render %s: empty for device %s", name, d.UDID)
+	}
+	return value, nil
+}
+
+// settingsCommand returns the Settings command applying ds to d.
+func settingsCommand(d Device, ds DeviceSettings) (Command, error) {
+	var settings []Setting
+	if ds.DeviceName != "" {
+		name, err := renderSetting("device name", ds.DeviceName, d)
+		if err != nil {
+			return Command{}, err
+		}
+		settings = append(settings, Setting{Item: "DeviceName", DeviceName: name})
+	}
+	if ds.HostName != "" {
+		name, err := renderSetting("hostname", ds.HostName, d)
+		if err != nil {
+			return Command{}, err
+		}
+		settings = append(settings, Setting{Item: "HostName", HostName: name})
+	}
+	settings = append(settings, ds.toggles()...)
+	return Command{UDID: d.UDID, RequestType: "Settings", Settings: settings}, nil
+}
+
+// handleChangeSettings queues a Settings command for a device. The body is a JSON
+// DeviceSettings, whose names are templates as in the config file.
+func (s *Server) handleChangeSettings(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	var ds DeviceSettings
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandBody)).Decode(&ds); err != nil {
+		http.Error(w, fmt.Sprintf("decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := ds.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c, err := settingsCommand(d, ds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.postAPICommand(w, r, c)
+}
+
+// applySettings logs the settings a device did not apply. A device that
+// applied a new name reports it with its next DeviceInformation response.
+func (s *Server) applySettings(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	results, err := webhook.ParseSettings(ack.Raw)
+	if err != nil {
+		return false, err
+	}
+	for _, res := range results {
+		if res.Status != "Acknowledged" {
+			logFor(ctx).WithFields(logrus.Fields{"item": res.Item, "status": res.Status, "error_chain": res.ErrorChain}).Warn("device did not apply setting")
+		}
+	}
+	return false, nil
+}
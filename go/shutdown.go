@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// listeners are the servers started by serve, stopped together on shutdown.
+type listeners struct {
+	http []*http.Server
+	grpc *grpc.Server
+
+	// servers are the webhook servers whose work is drained.
+	servers []*Server
+
+	// loops are the periodic jobs of servers, such as OS update checks,
+	// run until loopsCtx is cancelled.
+	loops     sync.WaitGroup
+	loopsCtx  context.Context
+	stopLoops context.CancelFunc
+
+	// queue, if set, is the command queue shared by servers.
+	queue *commandQueue
+
+	// forwarder, if set, is the event forwarder shared by servers.
+	forwarder *forwarder
+
+	// sinks, if set, publishes the servers' events to message systems.
+	sinks *sinks
+
+	// notifiers, if set, tells people about the servers' lifecycle events.
+	notifiers *notifiers
+
+	// hooks, if set, runs the servers' exec hooks.
+	hooks *execHooks
+
+	// snapshot, if set, writes the final snapshot of the memory store.
+	snapshot func() error
+
+	// closers are closed last, e.g. the device store.
+	closers []io.Closer
+}
+
+// closerFunc adapts a function to io.Closer.
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// goLoop runs loop in the background until shutdown, which stops it before
+// the command queue closes, since loops enqueue commands.
+func (l *listeners) goLoop(loop func(ctx context.Context)) {
+	if l.loopsCtx == nil {
+		l.loopsCtx, l.stopLoops = context.WithCancel(context.Background())
+	}
+	l.loops.Add(1)
+	go func() {
+		defer l.loops.Done()
+		loop(l.loopsCtx)
+	}()
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM, returning nil, or until a
+// listener fails, returning its error.
+func waitForShutdown(errc <-chan error) error {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	select {
+	case s := <-sig:
+		logrus.WithField("signal", s.String()).Info("shutting down")
+		return nil
+	case err := <-errc:
+		return err
+	}
+}
+
+// shutdown stops accepting connections, waits for in-flight requests, bulk
+// command jobs, background loops, queued commands, forwarded and published
+// events, notifications, and exec hooks to finish, and writes the final
+// snapshot. Whatever is left when timeout passes is abandoned.
+func (l *listeners) shutdown(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Event streams never finish on their own.
+	for _, s := range l.servers {
+		s.Events.Close()
+	}
+
+	if l.grpc != nil {
+		stopped := make(chan struct{})
+		go func() {
+			l.grpc.GracefulStop()
+			close(stopped)
+		}()
+		defer func() {
+			select {
+			case <-stopped:
+			case <-ctx.Done():
+				l.grpc.Stop()
+			}
+		}()
+	}
+	for _, srv := range l.http {
+		if err := srv.Shutdown(ctx); err != nil {
+			logrus.WithError(err).WithField("addr", srv.Addr).Error("shut down HTTP server")
+			srv.Close()
+		}
+	}
+	for _, s := range l.servers {
+		if err := s.BulkJobs.wait(ctx); err != nil {
+			logrus.WithError(err).Error("abandoning unfinished bulk command jobs")
+			break
+		}
+	}
+	if l.stopLoops != nil {
+		l.stopLoops()
+		stopped := make(chan struct{})
+		go func() {
+			l.loops.Wait()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			logrus.Error("abandoning unfinished background jobs")
+		}
+	}
+	if l.queue != nil {
+		if err := l.queue.close(ctx); err != nil {
+			logrus.WithError(err).Error("abandoning queued commands")
+		}
+	}
+	if l.forwarder != nil {
+		if err := l.forwarder.close(ctx); err != nil {
+			logrus.WithError(err).Error("abandoning events waiting to be forwarded")
+		}
+	}
+	if l.sinks != nil {
+		if err := l.sinks.close(ctx); err != nil {
+			logrus.WithError(err).Error("abandoning events waiting to be published")
+		}
+	}
+	if l.notifiers != nil {
+		if err := l.notifiers.close(ctx); err != nil {
+			logrus.WithError(err).Error("abandoning unsent notifications")
+		}
+	}
+	if l.hooks != nil {
+		if err := l.hooks.close(ctx); err != nil {
+			logrus.WithError(err).Error("killing unfinished exec hooks")
+		}
+	}
+
+	if l.snapshot != nil {
+		if err := l.snapshot(); err != nil {
+			logrus.WithError(err).Error("snapshot devices")
+		}
+	}
+	for _, c := range l.closers {
+		if err := c.Close(); err != nil {
+			logrus.WithError(err).Error("close")
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/fullsailor/pkcs7"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// profileSigningVars counts the profiles signed (signed) and those that
+// could not be (failed), which are then not sent.
+var profileSigningVars = expvar.NewMap("profile_signing")
+
+// maxSignedProfile bounds the size of the profiles a signing endpoint
+// returns.
+const maxSignedProfile = 16 << 20
+
+// profileSigningTimeout limits how long a signing endpoint may take to sign
+// each profile.
+const profileSigningTimeout = 30 * time.Second
+
+// profileSigner signs the XML configuration profiles sent to devices, so
+// that they show as verified on them.
+type profileSigner interface {
+	signProfile(ctx context.Context, profile []byte) ([]byte, error)
+}
+
+// certSigner signs profiles with a certificate and its RSA private key,
+// adding the intermediate certificates that chain it to a trusted root.
+type certSigner struct {
+	cert  *x509.Certificate
+	chain []*x509.Certificate
+	key   *rsa.PrivateKey
+}
+
+// loadCertSigner reads a PEM signing certificate, followed by its
+// intermediates, and its private key.
+func loadCertSigner(certFile, keyFile string) (*certSigner, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load profile signing identity: %v", err)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("load profile signing identity: the private key is not an RSA key")
+	}
+	s := &certSigner{key: key}
+	for i, der := range pair.Certificate {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("parse profile signing certificate %d: %v", i+1, err)
+		}
+		if i == 0 {
+			s.cert = cert
+		} else {
+			s.chain = append(s.chain, cert)
+		}
+	}
+	return s, nil
+}
+
+func (s *certSigner) signProfile(ctx context.Context, profile []byte) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(profile)
+	if err != nil {
+		return nil, fmt.Errorf("sign profile: %v", err)
+	}
+	if err := sd.AddSigner(s.cert, s.key, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("sign profile: %v", err)
+	}
+	for _, cert := range s.chain {
+		sd.AddCertificate(cert)
+	}
+	signed, err := sd.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("sign profile: %v", err)
+	}
+	return signed, nil
+}
+
+// endpointSigner has profiles signed by an external service, such as one
+// keeping the signing key in an HSM: each profile is posted to url as
+// application/x-apple-aspen-config, with the bearer token if it is set, and
+// the service answers with the signed profile.
+type endpointSigner struct {
+	url   string
+	token string
+	http  *http.Client
+}
+
+func newEndpointSigner(url, token string) *endpointSigner {
+	return &endpointSigner{url: url, token: token, http: &http.Client{Timeout: profileSigningTimeout, Transport: otelhttp.NewTransport(http.DefaultTransport)}}
+}
+
+func (s *endpointSigner) signProfile(ctx context.Context, profile []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(profile))
+	if err != nil {
+		return nil, fmt.Errorf("create signing request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-apple-aspen-config")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("sign profile: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("sign profile: signing endpoint returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	signed, err := io.ReadAll(io.LimitReader(resp.Body, maxSignedProfile))
+	if err != nil {
+		return nil, fmt.Errorf("sign profile: read signed profile: %v", err)
+	}
+	p7, err := pkcs7.Parse(signed)
+	if err != nil || len(p7.Signers) == 0 || !bytes.Equal(p7.Content, profile) {
+		return nil, fmt.Errorf("sign profile: the signing endpoint did not return the profile signed")
+	}
+	return signed, nil
+}
+
+// signProfile signs the rendered XML profile with s.ProfileSigner, if it is
+// set.
+func (s *Server) signProfile(ctx context.Context, profile []byte) ([]byte, error) {
+	if s.ProfileSigner == nil {
+		return profile, nil
+	}
+	signed, err := s.ProfileSigner.signProfile(ctx, profile)
+	if err != nil {
+		profileSigningVars.Add("failed", 1)
+		return nil, err
+	}
+	profileSigningVars.Add("signed", 1)
+	return signed, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/groob/plist"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// sinkQueueSize is how many messages can wait to be published to each sink.
+const sinkQueueSize = 1000
+
+// sinkVars counts the messages published to each sink, keyed by its name:
+// published, retried, failed (given up on), and dropped (the sink's queue
+// was full).
+var sinkVars = expvar.NewMap("sinks")
+
+// SinkMessage is what is published to message systems for each webhook
+// event: its summary and, for command responses, what the device answered,
+// decoded from its plist.
+type SinkMessage struct {
+	EventSummary
+	Tenant   string                 `json:"tenant,omitempty"`
+	Response map[string]interface{} `json:"response,omitempty"`
+}
+
+func newSinkMessage(tenant string, event webhook.Event, summary EventSummary) SinkMessage {
+	m := SinkMessage{EventSummary: summary, Tenant: tenant}
+	if ack := event.AcknowledgeEvent; ack != nil && len(ack.RawPayload) > 0 {
+		var resp map[string]interface{}
+		if err := plist.Unmarshal(ack.RawPayload, &resp); err == nil {
+			m.Response = resp
+		}
+	}
+	return m
+}
+
+// sinkTopic returns the topic, subject, or routing key a message is
+// published to: pattern with {topic} replaced by the event's topic, e.g.
+// "mdm-{topic}" for "mdm-mdm.Connect".
+func sinkTopic(pattern string, m SinkMessage) string {
+	return strings.ReplaceAll(pattern, "{topic}", m.Topic)
+}
+
+// parseSinkTopics parses the comma-separated topics of flag name, which
+// limit the events a sink publishes.
+func parseSinkTopics(name, list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	topics := strings.Split(list, ",")
+	for _, topic := range topics {
+		if !knownTopic(topic) {
+			return nil, fmt.Errorf("-%s: unknown topic %q", name, topic)
+		}
+	}
+	return topics, nil
+}
+
+// eventSink publishes messages to a message system. publish is called from
+// one goroutine at a time.
+type eventSink interface {
+	publish(ctx context.Context, m SinkMessage) error
+	io.Closer
+}
+
+type queuedMessage struct {
+	ctx context.Context
+	m   SinkMessage
+}
+
+// sinkQueue feeds one sink from a queue of its own, so a slow sink does not
+// hold up the others.
+type sinkQueue struct {
+	name string
+	sink eventSink
+	msgs chan queuedMessage
+
+	// topics, when set, limits the events published to those of the
+	// listed topics.
+	topics []string
+
+	published, retried, failed, dropped expvar.Int
+}
+
+// sinks publishes webhook events to message systems, retrying failures
+// according to retry.
+type sinks struct {
+	queues []*sinkQueue
+	retry  RetryPolicy
+	wg     sync.WaitGroup
+
+	// ctx is cancelled to abandon publishing when shutdown times out.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newSinks(retry RetryPolicy) *sinks {
+	s := &sinks{retry: retry}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// addSink publishes the server's events of topics, or all of them if it is
+// empty, to sink too, starting s.Sinks if needed.
+func (s *Server) addSink(name string, sink eventSink, topics []string) {
+	if s.Sinks == nil {
+		s.Sinks = newSinks(s.Retry)
+	}
+	s.Sinks.add(name, sink, topics)
+}
+
+// add starts publishing events of topics, or all of them if it is empty, to
+// sink under name, which identifies it in logs and at /debug/vars.
+func (s *sinks) add(name string, sink eventSink, topics []string) {
+	q := &sinkQueue{name: name, sink: sink, topics: topics, msgs: make(chan queuedMessage, sinkQueueSize)}
+	vars := new(expvar.Map)
+	vars.Set("published", &q.published)
+	vars.Set("retried", &q.retried)
+	vars.Set("failed", &q.failed)
+	vars.Set("dropped", &q.dropped)
+	vars.Set("queued", expvar.Func(func() any { return len(q.msgs) }))
+	sinkVars.Set(name, vars)
+	s.queues = append(s.queues, q)
+	s.wg.Add(1)
+	go s.run(q)
+}
+
+// publish queues m for every sink. Publishing keeps the values of ctx, such
+// as its logger, but not its cancellation.
+func (s *sinks) publish(ctx context.Context, m SinkMessage) {
+	for _, q := range s.queues {
+		if len(q.topics) > 0 && !slices.Contains(q.topics, m.Topic) {
+			continue
+		}
+		select {
+		case q.msgs <- queuedMessage{ctx: context.WithoutCancel(ctx), m: m}:
+		default:
+			q.dropped.Add(1)
+			logFor(ctx).WithField("sink", q.name).Error("sink queue is full, dropping event")
+		}
+	}
+}
+
+func (s *sinks) run(q *sinkQueue) {
+	defer s.wg.Done()
+	for qm := range q.msgs {
+		ctx, cancel := context.WithCancel(qm.ctx)
+		stop := context.AfterFunc(s.ctx, cancel)
+		s.publishTo(ctx, q, qm.m)
+		stop()
+		cancel()
+	}
+}
+
+func (s *sinks) publishTo(ctx context.Context, q *sinkQueue, m SinkMessage) {
+	logger := logFor(ctx).WithField("sink", q.name)
+	attempts := max(s.retry.MaxAttempts, 1)
+	for attempt := 1; ; attempt++ {
+		err := q.sink.publish(ctx, m)
+		if err == nil {
+			q.published.Add(1)
+			return
+		}
+		var perm *permanentError
+		if errors.As(err, &perm) || attempt >= attempts || ctx.Err() != nil {
+			q.failed.Add(1)
+			logger.WithError(err).WithField("attempts", attempt).Error("giving up publishing event")
+			return
+		}
+		q.retried.Add(1)
+		wait := s.retry.delay(attempt)
+		logger.WithError(err).WithFields(logrus.Fields{"attempt": attempt, "retry_in": wait.String()}).Warn("publish event failed, retrying")
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// close stops accepting messages, waits for the queued ones to be
+// published, and closes the sinks. If ctx ends first, publishing is
+// cancelled and ctx's error is returned. Nothing may be published after
+// close is called.
+func (s *sinks) close(ctx context.Context) error {
+	for _, q := range s.queues {
+		close(q.msgs)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.cancel()
+		err = ctx.Err()
+	}
+	for _, q := range s.queues {
+		if cerr := q.sink.Close(); cerr != nil {
+			logrus.WithError(cerr).WithField("sink", q.name).Error("close sink")
+		}
+	}
+	return err
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// AWSSinkOptions configures the SQS and SNS sinks. Either or both of
+// QueueURL and TopicARN may be set.
+type AWSSinkOptions struct {
+	QueueURL    string
+	SQSEndpoint string
+	SQSTopics   string
+
+	TopicARN    string
+	SNSEndpoint string
+	SNSTopics   string
+}
+
+// awsSinkFlags defines the flags of the SQS and SNS sinks on fs and returns
+// a function reading them once fs is parsed.
+func awsSinkFlags(fs *flag.FlagSet) func() AWSSinkOptions {
+	var (
+		flQueueURL = fs.String("sqs-queue-url", "", "URL of an SQS queue to send webhook events to (disabled when empty)")
+		flSQSURL   = fs.String("sqs-endpoint", "", "override the SQS endpoint, e.g. for LocalStack")
+		flSQSTopic = fs.String("sqs-topics", "", "comma-separated topics of the events sent to SQS (all when empty)")
+		flTopicARN = fs.String("sns-topic-arn", "", "ARN of an SNS topic to publish webhook events to (disabled when empty)")
+		flSNSURL   = fs.String("sns-endpoint", "", "override the SNS endpoint, e.g. for LocalStack")
+		flSNSTopic = fs.String("sns-topics", "", "comma-separated topics of the events published to SNS (all when empty)")
+	)
+	return func() AWSSinkOptions {
+		return AWSSinkOptions{
+			QueueURL:    *flQueueURL,
+			SQSEndpoint: *flSQSURL,
+			SQSTopics:   *flSQSTopic,
+			TopicARN:    *flTopicARN,
+			SNSEndpoint: *flSNSURL,
+			SNSTopics:   *flSNSTopic,
+		}
+	}
+}
+
+// addAWSSinks adds the SQS and SNS sinks selected by opts to s. Credentials
+// and region come from the standard AWS environment, shared config, or task
+// role.
+func (s *Server) addAWSSinks(opts AWSSinkOptions) error {
+	if opts.QueueURL == "" && opts.TopicARN == "" {
+		return nil
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return fmt.Errorf("load AWS config: %v", err)
+	}
+	if opts.QueueURL != "" {
+		topics, err := parseSinkTopics("sqs-topics", opts.SQSTopics)
+		if err != nil {
+			return err
+		}
+		client := sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+			if opts.SQSEndpoint != "" {
+				o.BaseEndpoint = aws.String(opts.SQSEndpoint)
+			}
+		})
+		s.addSink("sqs", &sqsSink{client: client, queueURL: opts.QueueURL}, topics)
+	}
+	if opts.TopicARN != "" {
+		topics, err := parseSinkTopics("sns-topics", opts.SNSTopics)
+		if err != nil {
+			return err
+		}
+		client := sns.NewFromConfig(cfg, func(o *sns.Options) {
+			if opts.SNSEndpoint != "" {
+				o.BaseEndpoint = aws.String(opts.SNSEndpoint)
+			}
+		})
+		s.addSink("sns", &snsSink{client: client, topicARN: opts.TopicARN}, topics)
+	}
+	return nil
+}
+
+// awsMessage returns the JSON body of m and, for FIFO queues and topics,
+// its message group, the device, so each device's events stay in order,
+// and deduplication ID, the event ID or a hash of the body.
+func awsMessage(m SinkMessage, fifo bool) (body string, group, dedup *string, err error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return "", nil, nil, &permanentError{fmt.Errorf("encode event: %v", err)}
+	}
+	if !fifo {
+		return string(b), nil, nil, nil
+	}
+	id := m.ID
+	if id == "" {
+		sum := sha256.Sum256(b)
+		id = hex.EncodeToString(sum[:])
+	}
+	udid := m.UDID
+	if udid == "" {
+		udid = "none"
+	}
+	return string(b), aws.String(udid), aws.String(id), nil
+}
+
+// awsError marks the client errors AWS returns, other than throttling, as
+// permanent: the request would fail again.
+func awsError(err error) error {
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) {
+		if code := re.HTTPStatusCode(); code >= 400 && code < 500 && code != http.StatusTooManyRequests {
+			return &permanentError{err}
+		}
+	}
+	return err
+}
+
+// sqsSink sends events to an SQS queue, with their topic, UDID, and tenant
+// as message attributes.
+type sqsSink struct {
+	client   *sqs.Client
+	queueURL string
+}
+
+func (q *sqsSink) publish(ctx context.Context, m SinkMessage) error {
+	body, group, dedup, err := awsMessage(m, strings.HasSuffix(q.queueURL, ".fifo"))
+	if err != nil {
+		return err
+	}
+	attrs := map[string]sqstypes.MessageAttributeValue{}
+	for name, v := range map[string]string{"topic": m.Topic, "udid": m.UDID, "tenant": m.Tenant} {
+		if v != "" {
+			attrs[name] = sqstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+		}
+	}
+	_, err = q.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:               aws.String(q.queueURL),
+		MessageBody:            aws.String(body),
+		MessageAttributes:      attrs,
+		MessageGroupId:         group,
+		MessageDeduplicationId: dedup,
+	})
+	return awsError(err)
+}
+
+func (q *sqsSink) Close() error { return nil }
+
+// snsSink publishes events to an SNS topic, with their topic, UDID, and
+// tenant as message attributes, so subscriptions can filter on them.
+type snsSink struct {
+	client   *sns.Client
+	topicARN string
+}
+
+func (t *snsSink) publish(ctx context.Context, m SinkMessage) error {
+	body, group, dedup, err := awsMessage(m, strings.HasSuffix(t.topicARN, ".fifo"))
+	if err != nil {
+		return err
+	}
+	attrs := map[string]snstypes.MessageAttributeValue{}
+	for name, v := range map[string]string{"topic": m.Topic, "udid": m.UDID, "tenant": m.Tenant} {
+		if v != "" {
+			attrs[name] = snstypes.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(v)}
+		}
+	}
+	_, err = t.client.Publish(ctx, &sns.PublishInput{
+		TopicArn:               aws.String(t.topicARN),
+		Message:                aws.String(body),
+		MessageAttributes:      attrs,
+		MessageGroupId:         group,
+		MessageDeduplicationId: dedup,
+	})
+	return awsError(err)
+}
+
+func (t *snsSink) Close() error { return nil }
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hamba/avro/v2"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// registryClient talks to the schema registry.
+var registryClient = &http.Client{Timeout: 30 * time.Second}
+
+// KafkaOptions configures a kafkaSink.
+type KafkaOptions struct {
+	Brokers []string
+	// Topic is the Kafka topic events are written to; {topic} is replaced
+	// by the event's topic, e.g. "micromdm.{topic}".
+	Topic string
+
+	TLS      bool
+	CAFile   string
+	Insecure bool
+	// SASL is the SASL mechanism, plain, scram-sha-256, or scram-sha-512,
+	// used with User and Password; empty for none.
+	SASL     string
+	User     string
+	Password string
+
+	// Format is json or avro. Avro messages are written in the Confluent
+	// wire format, with the schema registered in SchemaRegistry under
+	// "<topic>-value", or as bare Avro records without a registry.
+	Format         string
+	SchemaRegistry string
+}
+
+// kafkaFlags defines the flags of the Kafka sink on fs and returns a
+// function reading them once fs is parsed.
+func kafkaFlags(fs *flag.FlagSet) func() KafkaOptions {
+	var (
+		flBrokers  = fs.String("kafka-brokers", "", "comma-separated Kafka brokers to publish events to, e.g. kafka1:9092,kafka2:9092 (disabled when empty)")
+		flTopic    = fs.String("kafka-topic", "micromdm-events", "Kafka topic for events; {topic} is replaced by the event's topic")
+		flTLS      = fs.Bool("kafka-tls", false, "connect to the Kafka brokers with TLS")
+		flCA       = fs.String("kafka-ca", "", "PEM CA bundle to trust, in addition to the system roots, for the Kafka brokers' certificates")
+		flInsecure = fs.Bool("kafka-insecure-skip-verify", false, "do not verify the Kafka brokers' certificates (for development only)")
+		flSASL     = fs.String("kafka-sasl-mechanism", "", "SASL mechanism for Kafka: plain, scram-sha-256, or scram-sha-512 (none when empty)")
+		flUser     = fs.String("kafka-user", "", "SASL user name for Kafka")
+		flPassword = fs.String("kafka-password", "", "SASL password for Kafka")
+		flFormat   = fs.String("kafka-format", "json", "encoding of the messages written to Kafka: json or avro")
+		flRegistry = fs.String("kafka-schema-registry", "", "URL of a Confluent schema registry to register the Avro schema with")
+	)
+	return func() KafkaOptions {
+		opts := KafkaOptions{
+			Topic:          *flTopic,
+			TLS:            *flTLS || *flCA != "" || *flInsecure,
+			CAFile:         *flCA,
+			Insecure:       *flInsecure,
+			SASL:           *flSASL,
+			User:           *flUser,
+			Password:       *flPassword,
+			Format:         *flFormat,
+			SchemaRegistry: *flRegistry,
+		}
+		if *flBrokers != "" {
+			opts.Brokers = strings.Split(*flBrokers, ",")
+		}
+		return opts
+	}
+}
+
+// avroEventSchema is the Avro schema of the events written to Kafka. The
+// command response is carried as JSON, its shape depending on the command.
+const avroEventSchema = `{
+	"type": "record",
+	"name": "WebhookEvent",
+	"namespace": "com.micromdm.webhook",
+	"fields": [
+		{"name": "event_id", "type": "string"},
+		{"name": "topic", "type": "string"},
+		{"name": "udid", "type": "string"},
+		{"name": "time", "type": {"type": "long", "logicalType": "timestamp-millis"}},
+		{"name": "command_uuid", "type": "string", "default": ""},
+		{"name": "status", "type": "string", "default": ""},
+		{"name": "tenant", "type": "string", "default": ""},
+		{"name": "response", "type": ["null", "string"], "default": null}
+	]
+}`
+
+type avroEvent struct {
+	ID          string    `avro:"event_id"`
+	Topic       string    `avro:"topic"`
+	UDID        string    `avro:"udid"`
+	Time        time.Time `avro:"time"`
+	CommandUUID string    `avro:"command_uuid"`
+	Status      string    `avro:"status"`
+	Tenant      string    `avro:"tenant"`
+	Response    *string   `avro:"response"`
+}
+
+// kafkaSink writes events to Kafka, keyed by UDID so each device's events
+// stay in order on one partition.
+type kafkaSink struct {
+	w      *kafka.Writer
+	opts   KafkaOptions
+	schema avro.Schema
+
+	schemaIDs map[string]uint32 // by Kafka topic
+}
+
+func newKafkaSink(opts KafkaOptions) (*kafkaSink, error) {
+	k := &kafkaSink{opts: opts, schemaIDs: make(map[string]uint32)}
+	switch opts.Format {
+	case "json":
+	case "avro":
+		schema, err := avro.Parse(avroEventSchema)
+		if err != nil {
+			return nil, fmt.Errorf("parse Avro schema: %v", err)
+		}
+		k.schema = schema
+	default:
+		return nil, fmt.Errorf("invalid Kafka format %q: want json or avro", opts.Format)
+	}
+	if opts.SchemaRegistry != "" {
+		if u, err := url.Parse(opts.SchemaRegistry); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid schema registry URL %q", opts.SchemaRegistry)
+		}
+	}
+	transport := &kafka.Transport{ClientID: "micromdm-webhook"}
+	if opts.TLS {
+		cfg, err := clientTLSConfig(opts.CAFile, opts.Insecure)
+		if err != nil {
+			return nil, fmt.Errorf("Kafka TLS: %v", err)
+		}
+		transport.TLS = cfg
+	}
+	if opts.SASL != "" {
+		mech, err := kafkaSASL(opts.SASL, opts.User, opts.Password)
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mech
+	}
+	k.w = &kafka.Writer{
+		Addr:         kafka.TCP(opts.Brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		// Events are retried by the sink queue, and written one at a time,
+		// so there is no point waiting to fill a batch.
+		MaxAttempts:  1,
+		BatchTimeout: time.Millisecond,
+		Transport:    transport,
+	}
+	return k, nil
+}
+
+func kafkaSASL(mechanism, user, password string) (sasl.Mechanism, error) {
+	switch strings.ToLower(mechanism) {
+	case "plain":
+		return plain.Mechanism{Username: user, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, user, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, user, password)
+	default:
+		return nil, fmt.Errorf("invalid Kafka SASL mechanism %q: want plain, scram-sha-256, or scram-sha-512", mechanism)
+	}
+}
+
+func (k *kafkaSink) publish(ctx context.Context, m SinkMessage) error {
+	topic := sinkTopic(k.opts.Topic, m)
+	value, err := k.encode(ctx, topic, m)
+	if err != nil {
+		return err
+	}
+	return k.w.WriteMessages(ctx, kafka.Message{
+		Topic: topic,
+		Key:   []byte(m.UDID),
+		Value: value,
+		Headers: []kafka.Header{
+			{Key: "topic", Value: []byte(m.Topic)},
+			{Key: "event_id", Value: []byte(m.ID)},
+		},
+	})
+}
+
+func (k *kafkaSink) encode(ctx context.Context, topic string, m SinkMessage) ([]byte, error) {
+	if k.schema == nil {
+		return json.Marshal(m)
+	}
+	e := avroEvent{
+		ID:          m.ID,
+		Topic:       m.Topic,
+		UDID:        m.UDID,
+		Time:        m.Time,
+		CommandUUID: m.CommandUUID,
+		Status:      m.Status,
+		Tenant:      m.Tenant,
+	}
+	if m.Response != nil {
+		resp, err := json.Marshal(m.Response)
+		if err != nil {
+			return nil, &permanentError{fmt.Errorf("encode response: %v", err)}
+		}
+		s := string(resp)
+		e.Response = &s
+	}
+	record, err := avro.Marshal(k.schema, e)
+	if err != nil {
+		return nil, &permanentError{fmt.Errorf("encode Avro record: %v", err)}
+	}
+	if k.opts.SchemaRegistry == "" {
+		return record, nil
+	}
+	id, err := k.schemaID(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	// The Confluent wire format: a zero magic byte, then the schema ID.
+	value := make([]byte, 5, 5+len(record))
+	binary.BigEndian.PutUint32(value[1:], id)
+	return append(value, record...), nil
+}
+
+// schemaID registers the Avro schema for topic's values in the schema
+// registry, once, and returns its ID.
+func (k *kafkaSink) schemaID(ctx context.Context, topic string) (uint32, error) {
+	if id, ok := k.schemaIDs[topic]; ok {
+		return id, nil
+	}
+	body, err := json.Marshal(map[string]string{"schema": k.schema.String()})
+	if err != nil {
+		return 0, err
+	}
+	u := strings.TrimSuffix(k.opts.SchemaRegistry, "/") + "/subjects/" + url.PathEscape(topic+"-value") + "/versions"
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader(body))
+	if err != nil {
+		return 0, &permanentError{fmt.Errorf("create request: %v", err)}
+	}
+	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	resp, err := registryClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("register Avro schema: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("register Avro schema: schema registry returned %s", resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return 0, &permanentError{err}
+		}
+		return 0, err
+	}
+	var out struct {
+		ID uint32 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return 0, fmt.Errorf("decode schema registry response: %v", err)
+	}
+	k.schemaIDs[topic] = out.ID
+	return out.ID, nil
+}
+
+func (k *kafkaSink) Close() error {
+	return k.w.Close()
+}
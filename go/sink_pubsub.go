@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PubSubOptions configures a pubSubSink.
+type PubSubOptions struct {
+	Project string
+	// Topic is the Pub/Sub topic events are published to; {topic} is
+	// replaced by the event's topic, for a topic per event type.
+	Topic string
+}
+
+// pubSubFlags defines the flags of the Pub/Sub sink on fs and returns a
+// function reading them once fs is parsed.
+func pubSubFlags(fs *flag.FlagSet) func() PubSubOptions {
+	var (
+		flProject = fs.String("pubsub-project", "", "Google Cloud project to publish webhook events to Pub/Sub in (disabled when empty)")
+		flTopic   = fs.String("pubsub-topic", "micromdm-events", "Pub/Sub topic for events; {topic} is replaced by the event's topic, e.g. micromdm-{topic}")
+	)
+	return func() PubSubOptions {
+		return PubSubOptions{Project: *flProject, Topic: *flTopic}
+	}
+}
+
+// pubSubSink publishes events to Pub/Sub as JSON, with their topic, UDID,
+// tenant, and event ID as attributes. Each device's UDID is its ordering
+// key, so subscriptions with message ordering receive its events in order.
+type pubSubSink struct {
+	client *pubsub.Client
+	topic  string
+	topics map[string]*pubsub.Topic // by name
+}
+
+// newPubSubSink connects to Pub/Sub with the application default
+// credentials, or to the emulator named by PUBSUB_EMULATOR_HOST.
+func newPubSubSink(opts PubSubOptions) (*pubSubSink, error) {
+	client, err := pubsub.NewClient(context.Background(), opts.Project)
+	if err != nil {
+		return nil, fmt.Errorf("connect to Pub/Sub: %v", err)
+	}
+	return &pubSubSink{client: client, topic: opts.Topic, topics: make(map[string]*pubsub.Topic)}, nil
+}
+
+func (p *pubSubSink) publish(ctx context.Context, m SinkMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return &permanentError{fmt.Errorf("encode event: %v", err)}
+	}
+	name := sinkTopic(p.topic, m)
+	t, ok := p.topics[name]
+	if !ok {
+		t = p.client.Topic(name)
+		t.EnableMessageOrdering = true
+		// Events are published one at a time, so there is no point
+		// waiting to fill a batch.
+		t.PublishSettings.DelayThreshold = time.Millisecond
+		p.topics[name] = t
+	}
+	attrs := map[string]string{"topic": m.Topic, "udid": m.UDID, "event_id": m.ID}
+	if m.Tenant != "" {
+		attrs["tenant"] = m.Tenant
+	}
+	_, err = t.Publish(ctx, &pubsub.Message{Data: data, Attributes: attrs, OrderingKey: m.UDID}).Get(ctx)
+	if err == nil {
+		return nil
+	}
+	// A failure pauses publishing with the ordering key until resumed.
+	t.ResumePublish(m.UDID)
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.PermissionDenied:
+		return &permanentError{err}
+	}
+	return err
+}
+
+func (p *pubSubSink) Close() error {
+	for _, t := range p.topics {
+		t.Stop()
+	}
+	return p.client.Close()
+}
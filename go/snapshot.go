@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// snapshot is the on-disk format written by writeSnapshot.
+type snapshot struct {
+	SavedAt time.Time `json:"saved_at"`
+	Devices []Device  `json:"devices"`
+}
+
+// restoreSnapshot loads the devices in the snapshot at path into store. A
+// missing snapshot file is not an error.
+func restoreSnapshot(store DeviceStore, path string) error {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("read snapshot: %v", err)
+	}
+	var snap snapshot
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return fmt.Errorf("decode snapshot %s: %v", path, err)
+	}
+	for _, d := range snap.Devices {
+		if err := store.Save(d); err != nil {
+			return fmt.Errorf("restore device %s: %v", d.UDID, err)
+		}
+	}
+	logrus.WithFields(logrus.Fields{"devices": len(snap.Devices), "saved_at": snap.SavedAt}).Info("restored snapshot")
+	return nil
+}
+
+// writeSnapshot atomically replaces the snapshot at path with the current
+// contents of store. The snapshot is written to a temporary file in the same
+// directory and renamed into place, so a crash never leaves a partial file.
+func writeSnapshot(store DeviceStore, path string) error {
+	devices, err := store.List()
+	if err != nil {
+		return fmt.Errorf("list devices: %v", err)
+	}
+	b, err := json.MarshalIndent(snapshot{SavedAt: time.Now().UTC(), Devices: devices}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("create snapshot temp file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write snapshot: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync snapshot: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename snapshot: %v", err)
+	}
+	return nil
+}
+
+// snapshotLoop writes a snapshot of store to path every interval until ctx
+// is done, so that shutdown can write the final one after it.
+func snapshotLoop(ctx context.Context, store DeviceStore, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := writeSnapshot(store, path); err != nil {
+			logrus.WithError(err).Error("snapshot devices")
+		}
+	}
+}
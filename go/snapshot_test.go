@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+)
+
+func TestSnapshotLoopStops(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	devices := store.NewMemoryStore()
+	if err := devices.Save(Device{UDID: "A"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		snapshotLoop(ctx, devices, path, time.Millisecond)
+		close(stopped)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("snapshotLoop did not stop")
+	}
+
+	// The final snapshot is not overwritten once the loop has stopped.
+	if err := devices.Save(Device{UDID: "B"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeSnapshot(devices, path); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	restored := store.NewMemoryStore()
+	if err := restoreSnapshot(restored, path); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := restored.List(); len(got) != 2 {
+		t.Errorf("snapshot holds %d devices, want 2", len(got))
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// staticVars counts the signed URLs of static files made (signed), the
+// downloads of them (served), and the requests rejected for a missing,
+// invalid, or expired signature (rejected).
+var staticVars = expvar.NewMap("static_files")
+
+// defaultStaticURLTTL is how long signed static file URLs stay valid.
+const defaultStaticURLTTL = time.Hour
+
+// staticHost serves the files of a content directory, such as profiles,
+// packages, and images command payloads point devices at, under /static/.
+// As with the apps of an appHost, each URL is signed and expires, so the
+// files can be served from the public internet to only the devices given
+// their URLs. Files and directories whose names start with a dot are not
+// served, and the directory cannot be escaped through symbolic links.
+type staticHost struct {
+	urlSigner
+	root *os.Root
+	ttl  time.Duration
+
+	// maxTTL bounds the lifetimes the admin API asks for.
+	maxTTL time.Duration
+}
+
+// StaticFile is a file served by the staticHost, by its slash-separated path
+// under the directory.
+type StaticFile struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// StaticURLRequest asks for a signed URL of the static file Path, valid for
+// TTL, a duration such as "15m", or by default -static-url-ttl.
+type StaticURLRequest struct {
+	Path string `json:"path"`
+	TTL  string `json:"ttl,omitempty"`
+}
+
+// StaticURL is a signed URL of a static file.
+type StaticURL struct {
+	Path      string    `json:"path"`
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// newStaticHost serves the files in dir with the URLs of signer, valid for
+// ttl unless asked otherwise, up to the longer of ttl and a day.
+func newStaticHost(dir string, signer urlSigner, ttl time.Duration) (*staticHost, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, fmt.Errorf("static directory: %v", err)
+	}
+	return &staticHost{urlSigner: signer, root: root, ttl: ttl, maxTTL: max(ttl, 24*time.Hour)}, nil
+}
+
+// errNoStaticFile is returned for paths that are not files served from the
+// directory.
+var errNoStaticFile = errors.New("no such file")
+
+// stat returns the details of the file at the slash-separated name.
+func (h *staticHost) stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) || name == "." || strings.HasPrefix(name, ".") || strings.Contains(name, "/.") {
+		return nil, errNoStaticFile
+	}
+	info, err := h.root.Stat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		// Paths leading out of the directory are not found either.
+		return nil, errNoStaticFile
+	}
+	return info, nil
+}
+
+// list returns the files of the directory and its subdirectories, sorted by
+// path.
+func (h *staticHost) list() ([]StaticFile, error) {
+	files := []StaticFile{}
+	err := fs.WalkDir(h.root.FS(), ".", func(name string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && strings.HasPrefix(e.Name(), ".") {
+			if e.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if e.IsDir() {
+			return nil
+		}
+		info, err := h.stat(name)
+		if err != nil {
+			return nil
+		}
+		files = append(files, StaticFile{Path: name, Size: info.Size(), ModifiedAt: info.ModTime().UTC()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read static directory: %v", err)
+	}
+	return files, nil
+}
+
+// fileURL returns a signed URL of the file at the slash-separated name,
+// valid for ttl.
+func (h *staticHost) fileURL(name string, ttl time.Duration) (StaticURL, error) {
+	name = strings.TrimPrefix(name, "/")
+	if _, err := h.stat(name); err != nil {
+		return StaticURL{}, err
+	}
+	segments := strings.Split(name, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	staticVars.Add("signed", 1)
+	return StaticURL{Path: name, URL: h.sign("/static/"+strings.Join(segments, "/"), expires), ExpiresAt: expires.UTC()}, nil
+}
+
+// handler serves the files to requests with a valid signature.
+func (h *staticHost) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !h.verify(r) {
+			staticVars.Add("rejected", 1)
+			logFor(r.Context()).WithFields(logrus.Fields{"path": r.URL.Path, "remote_addr": r.RemoteAddr}).Warn("rejected static file download: missing, invalid, or expired signature")
+			http.Error(w, "invalid or expired signature", http.StatusForbidden)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/static/")
+		info, err := h.stat(name)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := h.root.Open(name)
+		if err != nil {
+			logFor(r.Context()).WithError(err).Error("serve static file")
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		staticVars.Add("served", 1)
+		if strings.EqualFold(path.Ext(name), ".mobileconfig") {
+			w.Header().Set("Content-Type", "application/x-apple-aspen-config")
+		}
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+	})
+}
+
+// handleListStaticFiles lists the files of -static-dir.
+func (s *Server) handleListStaticFiles(w http.ResponseWriter, r *http.Request) {
+	if s.Static == nil {
+		http.Error(w, "static files are not hosted; set -static-dir", http.StatusServiceUnavailable)
+		return
+	}
+	files, err := s.Static.list()
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("list static files")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, files)
+}
+
+// handleSignStaticURL returns a signed URL of a file of -static-dir, for
+// command payloads to point devices at. The body is a JSON StaticURLRequest.
+func (s *Server) handleSignStaticURL(w http.ResponseWriter, r *http.Request) {
+	if s.Static == nil {
+		http.Error(w, "static files are not hosted; set -static-dir", http.StatusServiceUnavailable)
+		return
+	}
+	var req StaticURLRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxCommandBody)).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("decode JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+	ttl := s.Static.ttl
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 || d > s.Static.maxTTL {
+			http.Error(w, fmt.Sprintf("invalid ttl %q; want a duration up to %s", req.TTL, s.Static.maxTTL), http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	u, err := s.Static.fileURL(req.Path, ttl)
+	if err == errNoStaticFile {
+		http.Error(w, fmt.Sprintf("no file %q in the static directory", req.Path), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("sign static file URL")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logFor(r.Context()).WithFields(logrus.Fields{"path": u.Path, "expires_at": u.ExpiresAt}).Info("signed static file URL")
+	writeJSON(w, http.StatusOK, u)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// TagExpr is an expression selecting devices by their tags, such as
+// "all AND kiosk NOT retired". Its terms are glob patterns (as in
+// path.Match) one of the device's tags must match, or one of:
+//
+//	all       every device
+//	enrolled  devices currently enrolled
+//	retired   devices retired when they checked out
+//
+// A tag spelled like one of these, or like an operator, is written
+// tag:<name>. Terms are combined with NOT, AND, and OR, in that order of
+// precedence, and grouped with parentheses; terms next to each other are
+// ANDed, and operators are not case-sensitive.
+type TagExpr struct {
+	src  string
+	root tagNode
+}
+
+// tagNode is a parsed TagExpr.
+type tagNode interface {
+	matches(d Device) bool
+}
+
+type (
+	tagAll      struct{}
+	tagEnrolled struct{}
+	tagRetired  struct{}
+	tagPattern  string
+	tagNot      struct{ x tagNode }
+	tagAnd      []tagNode
+	tagOr       []tagNode
+)
+
+func (tagAll) matches(Device) bool        { return true }
+func (tagEnrolled) matches(d Device) bool { return d.Enrolled }
+func (tagRetired) matches(d Device) bool  { return isRetired(d) }
+func (p tagPattern) matches(d Device) bool {
+	return slices.ContainsFunc(d.Tags, func(tag string) bool {
+		ok, _ := path.Match(string(p), tag)
+		return ok
+	})
+}
+func (n tagNot) matches(d Device) bool { return !n.x.matches(d) }
+func (n tagAnd) matches(d Device) bool {
+	return !slices.ContainsFunc(n, func(x tagNode) bool { return !x.matches(d) })
+}
+func (n tagOr) matches(d Device) bool {
+	return slices.ContainsFunc(n, func(x tagNode) bool { return x.matches(d) })
+}
+
+// parseTagExpr parses a tag expression.
+func parseTagExpr(s string) (*TagExpr, error) {
+	p := &tagParser{tokens: tokenizeTagExpr(s)}
+	if len(p.tokens) == 0 {
+		return nil, fmt.Errorf("tag expression is empty")
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("tag expression %q: %v", s, err)
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, fmt.Errorf("tag expression %q: unexpected %q", s, tok)
+	}
+	return &TagExpr{src: s, root: root}, nil
+}
+
+// tokenizeTagExpr splits s into parentheses and the words between them.
+func tokenizeTagExpr(s string) []string {
+	var tokens []string
+	for _, word := range strings.Fields(s) {
+		for word != "" {
+			i := strings.IndexAny(word, "()")
+			switch {
+			case i < 0:
+				tokens, word = append(tokens, word), ""
+			case i == 0:
+				tokens, word = append(tokens, word[:1]), word[1:]
+			default:
+				tokens, word = append(tokens, word[:i]), word[i:]
+			}
+		}
+	}
+	return tokens
+}
+
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *tagParser) peek() (string, bool) {
+	if p.pos >= len(p.tokens) {
+		return "", false
+	}
+	return p.tokens[p.pos], true
+}
+
+// accept consumes the next token if it is the operator op.
+func (p *tagParser) accept(op string) bool {
+	if tok, ok := p.peek(); ok && strings.EqualFold(tok, op) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *tagParser) parseOr() (tagNode, error) {
+	var or tagOr
+	for {
+		x, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		or = append(or, x)
+		if !p.accept("OR") {
+			break
+		}
+	}
+	if len(or) == 1 {
+		return or[0], nil
+	}
+	return or, nil
+}
+
+func (p *tagParser) parseAnd() (tagNode, error) {
+	var and tagAnd
+	for {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		and = append(and, x)
+		if p.accept("AND") {
+			continue
+		}
+		if tok, ok := p.peek(); !ok || tok == ")" || strings.EqualFold(tok, "OR") {
+			break
+		}
+	}
+	if len(and) == 1 {
+		return and[0], nil
+	}
+	return and, nil
+}
+
+func (p *tagParser) parseNot() (tagNode, error) {
+	if p.accept("NOT") {
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return tagNot{x}, nil
+	}
+	tok, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end")
+	}
+	p.pos++
+	switch {
+	case tok == "(":
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return x, nil
+	case tok == ")" || strings.EqualFold(tok, "AND") || strings.EqualFold(tok, "OR"):
+		return nil, fmt.Errorf("unexpected %q", tok)
+	case strings.EqualFold(tok, "all"):
+		return tagAll{}, nil
+	case strings.EqualFold(tok, "enrolled"):
+		return tagEnrolled{}, nil
+	case strings.EqualFold(tok, "retired"):
+		return tagRetired{}, nil
+	}
+	tok = strings.TrimPrefix(tok, "tag:")
+	if _, err := path.Match(tok, ""); err != nil {
+		return nil, fmt.Errorf("pattern %q: %v", tok, err)
+	}
+	return tagPattern(tok), nil
+}
+
+// matches reports whether d is selected by the expression.
+func (e *TagExpr) matches(d Device) bool {
+	return e.root.matches(d)
+}
+
+func (e *TagExpr) String() string {
+	return e.src
+}
+
+func (e *TagExpr) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	parsed, err := parseTagExpr(s)
+	if err != nil {
+		return err
+	}
+	*e = *parsed
+	return nil
+}
+
+func (e *TagExpr) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := parseTagExpr(s)
+	if err != nil {
+		return err
+	}
+	*e = *parsed
+	return nil
+}
+
+func (e *TagExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.src)
+}
+
+// TagCount is a tag and the number of devices tagged with it.
+type TagCount struct {
+	Tag     string `json:"tag"`
+	Devices int    `json:"devices"`
+}
+
+// handleListTags returns the tags of the devices, in order, with how many
+// devices have each.
+func (s *Server) handleListTags(w http.ResponseWriter, r *http.Request) {
+	devices, err := s.Devices.List()
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("list devices")
+		http.Error(w, fmt.Sprintf("list devices: %v", err), http.StatusInternalServerError)
+		return
+	}
+	counts := make(map[string]int)
+	for _, d := range devices {
+		for _, tag := range d.Tags {
+			counts[tag]++
+		}
+	}
+	tags := make([]TagCount, 0, len(counts))
+	for tag, n := range counts {
+		tags = append(tags, TagCount{Tag: tag, Devices: n})
+	}
+	slices.SortFunc(tags, func(a, b TagCount) int { return strings.Compare(a.Tag, b.Tag) })
+	writeJSON(w, http.StatusOK, tags)
+}
+
+// handleAddTag tags a device. Devices that have not enrolled yet can be
+// tagged ahead of enrollment, e.g. so a blueprint matches them.
+func (s *Server) handleAddTag(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+	if err := validateTag(tag); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	d, _, err := s.loadDevice(r.PathValue("udid"))
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("load device")
+		http.Error(w, fmt.Sprintf("load device: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if d.AddTag(tag) {
+		if err := s.Devices.Save(d); err != nil {
+			logFor(r.Context()).WithError(err).Error("save device")
+			http.Error(w, fmt.Sprintf("save device: %v", err), http.StatusInternalServerError)
+			return
+		}
+		logFor(r.Context()).WithFields(logrus.Fields{"udid": d.UDID, "tag": tag}).Info("tagged device")
+	}
+	writeDeviceTags(w, d)
+}
+
+// handleRemoveTag removes a tag from a device.
+func (s *Server) handleRemoveTag(w http.ResponseWriter, r *http.Request) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return
+	}
+	tag := r.PathValue("tag")
+	if d.RemoveTag(tag) {
+		if err := s.Devices.Save(d); err != nil {
+			logFor(r.Context()).WithError(err).Error("save device")
+			http.Error(w, fmt.Sprintf("save device: %v", err), http.StatusInternalServerError)
+			return
+		}
+		logFor(r.Context()).WithFields(logrus.Fields{"udid": d.UDID, "tag": tag}).Info("untagged device")
+	}
+	writeDeviceTags(w, d)
+}
+
+func writeDeviceTags(w http.ResponseWriter, d Device) {
+	tags := d.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"udid": d.UDID, "tags": tags})
+}
+
+// validateTag returns an error if tag cannot be used in tag expressions.
+func validateTag(tag string) error {
+	if tag == "" || strings.ContainsAny(tag, " \t\n()") {
+		return fmt.Errorf("tag %q: tags cannot be empty or contain spaces or parentheses", tag)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseTagExpr(t *testing.T) {
+	devices := map[string]Device{
+		"kiosk":      {UDID: "kiosk", Enrolled: true, Tags: []string{"kiosk", "floor-1"}},
+		"lab":        {UDID: "lab", Enrolled: true, Tags: []string{"lab", "floor-2"}},
+		"unenrolled": {UDID: "unenrolled", Tags: []string{"kiosk"}},
+		"and":        {UDID: "and", Enrolled: true, Tags: []string{"and"}},
+	}
+	tests := []struct {
+		expr    string
+		want    []string
+		wantErr bool
+	}{
+		{expr: "all", want: []string{"and", "kiosk", "lab", "unenrolled"}},
+		{expr: "kiosk", want: []string{"kiosk", "unenrolled"}},
+		{expr: "floor-*", want: []string{"kiosk", "lab"}},
+		{expr: "enrolled", want: []string{"and", "kiosk", "lab"}},
+		{expr: "kiosk AND enrolled", want: []string{"kiosk"}},
+		{expr: "kiosk enrolled", want: []string{"kiosk"}},
+		{expr: "kiosk OR lab", want: []string{"kiosk", "lab", "unenrolled"}},
+		{expr: "all NOT kiosk", want: []string{"and", "lab"}},
+		{expr: "not not kiosk", want: []string{"kiosk", "unenrolled"}},
+		// AND binds tighter than OR.
+		{expr: "lab OR kiosk AND NOT enrolled", want: []string{"lab", "unenrolled"}},
+		{expr: "(lab OR kiosk) AND NOT enrolled", want: []string{"unenrolled"}},
+		{expr: "(lab)(floor-2)", want: []string{"lab"}},
+		{expr: "tag:and", want: []string{"and"}},
+		{expr: "nothing"},
+		{expr: "", wantErr: true},
+		{expr: "   ", wantErr: true},
+		{expr: "kiosk AND", wantErr: true},
+		{expr: "OR kiosk", wantErr: true},
+		{expr: "NOT", wantErr: true},
+		{expr: "(kiosk", wantErr: true},
+		{expr: "kiosk)", wantErr: true},
+		{expr: "()", wantErr: true},
+		{expr: "[kiosk", wantErr: true},
+	}
+	for _, tt := range tests {
+		e, err := parseTagExpr(tt.expr)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTagExpr(%q) error = %v, want error %v", tt.expr, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if e.String() != tt.expr {
+			t.Errorf("parseTagExpr(%q).String() = %q", tt.expr, e.String())
+		}
+		var got []string
+		for _, udid := range []string{"and", "kiosk", "lab", "unenrolled"} {
+			if e.matches(devices[udid]) {
+				got = append(got, udid)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("parseTagExpr(%q) matches %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
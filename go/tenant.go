@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+// forTenant returns a Server for a tenant's MicroMDM server. It shares the
+// settings of s, and keeps its devices in its own namespace of backend.
+func (s *Server) forTenant(name string, tc TenantConfig, backend DeviceStore, history CommandHistory) *Server {
+	ts := NewServer(tc.ServerURL, tc.APIToken, store.NewTenantStore(name, backend, history))
+	ts.Tenant = name
+	ts.ExpectedProfiles = s.ExpectedProfiles
+	ts.CertExpiryWarning = s.CertExpiryWarning
+	ts.CertRenewal = s.CertRenewal
+	ts.AdminToken = s.AdminToken
+	if tc.AdminToken != "" {
+		ts.AdminToken = tc.AdminToken
+	}
+	ts.Escrow = s.Escrow
+	ts.FileVault = s.FileVault
+	ts.FileVaultKeyMaxAge = s.FileVaultKeyMaxAge
+	ts.ProfileSigner = s.ProfileSigner
+	ts.ProfileSecrets = s.ProfileSecrets
+	ts.BootstrapTokenGrace = s.BootstrapTokenGrace
+	ts.APNs, ts.APNSTopic = s.APNs, s.APNSTopic
+	if s.DEP != nil {
+		ts.DEP = newDEPSync(s.DEP.url, s.DEP.token)
+	}
+	ts.EnterpriseApps = s.EnterpriseApps
+	ts.Static = s.Static
+	ts.Erasures = newEraseRequests(s.Erasures.window)
+	ts.BulkRate = s.BulkRate
+	ts.Topics = s.Topics
+	ts.Rules = s.Rules
+	ts.Scripts = s.Scripts
+	ts.Blueprints = s.Blueprints
+	ts.Decommission = s.Decommission
+	ts.Compliance = s.Compliance
+	ts.Hooks = s.Hooks
+	ts.TopicHooks = s.TopicHooks
+	ts.WebhookSecret = s.WebhookSecret
+	if tc.WebhookSecret != "" {
+		ts.WebhookSecret = []byte(tc.WebhookSecret)
+	}
+	ts.SignatureHeader = s.SignatureHeader
+	ts.WebhookUser, ts.WebhookPassword = s.WebhookUser, s.WebhookPassword
+	if tc.WebhookPassword != "" {
+		ts.WebhookUser, ts.WebhookPassword = tc.WebhookUser, tc.WebhookPassword
+	}
+	ts.WebhookToken = s.WebhookToken
+	if tc.WebhookToken != "" {
+		ts.WebhookToken = tc.WebhookToken
+	}
+	ts.ClientCertRequired = s.ClientCertRequired
+	ts.Retry = s.Retry
+	ts.ErrorRetry, ts.TransientErrors = s.ErrorRetry, s.TransientErrors
+	ts.NotNowRetry = s.NotNowRetry
+	ts.ResponsiveWindow = s.ResponsiveWindow
+	ts.DeadLetters = s.DeadLetters
+	ts.Queue = s.Queue
+	ts.Forward = s.Forward
+	ts.Sinks = s.Sinks
+	ts.Notifiers = s.Notifiers
+	ts.FailureThreshold = s.FailureThreshold
+	ts.MDMTimeout = s.MDMTimeout
+	if s.Dedup != nil {
+		ts.Dedup = newEventDedup(s.Dedup.window)
+	}
+	for _, a := range s.Archives {
+		ta, err := a.forTenant(name)
+		if err != nil {
+			logrus.WithField("tenant", name).Fatal(err)
+		}
+		ts.Archives = append(ts.Archives, ta)
+	}
+	if s.Limiter != nil {
+		ts.Limiter = rate.NewLimiter(s.Limiter.Limit(), s.Limiter.Burst())
+	}
+	if s.Breaker != nil {
+		ts.Breaker = newCircuitBreaker(name, s.Breaker.policy)
+	}
+	ts.ClientCertNames = s.ClientCertNames
+	ts.AllowedNets = s.AllowedNets
+	ts.TrustedProxies = s.TrustedProxies
+	return ts
+}
+
+// serveTenants registers on mux the webhook of each tenant at
+// /webhook/{tenant} and, if it has an admin token, its admin API and GraphQL
+// endpoint under /tenants/{tenant}/.
+func (s *Server) serveTenants(mux *http.ServeMux, tenants map[string]TenantConfig, backend DeviceStore, history CommandHistory) []*Server {
+	names := make([]string, 0, len(tenants))
+	for name := range tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var servers []*Server
+	for _, name := range names {
+		ts := s.forTenant(name, tenants[name], backend, history)
+		mux.Handle("/webhook/"+name, ts.webhookHandler())
+		if ts.hasDeclarations() {
+			mux.Handle("/tenants/"+name+"/declarative-management/{endpoint...}", ts.declarativeManagementHandler())
+		}
+		if ts.AdminToken != "" {
+			prefix := "/tenants/" + name
+			mux.Handle(prefix+"/api/", ts.apiHandler(prefix))
+			mux.Handle(prefix+"/graphql", ts.graphQLHandler())
+		}
+		logrus.WithFields(logrus.Fields{"tenant": name, "server_url": ts.MDMServerURL, "path": "/webhook/" + name}).Info("serving tenant")
+		servers = append(servers, ts)
+	}
+	return servers
+}
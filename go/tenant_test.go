@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+)
+
+func TestWebhookRejectsTenantUDID(t *testing.T) {
+	backend, history := store.NewMemoryStore(), store.NewMemoryHistory()
+	acme := store.NewTenantStore("acme", backend, history)
+	if err := acme.Save(Device{UDID: "X", Tags: []string{"acme"}}); err != nil {
+		t.Fatal(err)
+	}
+	for _, tenant := range []string{"", "other"} {
+		s := NewServer("http://mdm.invalid", "", store.NewTenantStore(tenant, backend, history))
+		s.Tenant = tenant
+		body := `{"topic":"mdm.Authenticate","event_id":"1","created_at":"2026-10-14T10:00:00Z","checkin_event":{"udid":"acme/X","raw_payload":""}}`
+		w := httptest.NewRecorder()
+		s.webhookHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("tenant %q: status = %d, want %d", tenant, w.Code, http.StatusBadRequest)
+		}
+	}
+	d, err := acme.Get("X")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !d.HasTag("acme") || d.Enrolled {
+		t.Errorf("acme device was modified: %+v", d)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// certReloadInterval is how often the certificate files are checked for
+// changes, so renewed certificates are served without a restart.
+const certReloadInterval = time.Minute
+
+// serverTLSConfig returns the TLS config of the listener, serving the
+// certificate in certFile and keyFile. With a CA bundle, clients may present
+// a certificate, which is verified against the bundle. Certificates are
+// optional at the TLS layer because the listener is shared with the admin
+// API; requireClientCert enforces them for webhooks.
+func serverTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	kp := &keyPair{certFile: certFile, keyFile: keyFile}
+	if err := kp.load(); err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, GetCertificate: kp.get}
+	if caFile == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA bundle: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("client CA bundle %s: no certificates found", caFile)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return cfg, nil
+}
+
+// clientTLSConfig returns the TLS config for connections to a server whose
+// certificate is issued by a CA in caFile, if set, or by one of the system
+// roots. insecure turns off verification altogether, and is meant only for
+// development.
+func clientTLSConfig(caFile string, insecure bool) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: insecure}
+	if caFile == "" {
+		return cfg, nil
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read CA bundle: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("CA bundle %s: no certificates found", caFile)
+	}
+	cfg.RootCAs = pool
+	return cfg, nil
+}
+
+// keyPair is a certificate loaded from files, reloaded when they change.
+type keyPair struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+func (kp *keyPair) load() error {
+	info, err := os.Stat(kp.certFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %v", err)
+	}
+	cert, err := tls.LoadX509KeyPair(kp.certFile, kp.keyFile)
+	if err != nil {
+		return fmt.Errorf("load TLS certificate: %v", err)
+	}
+	kp.cert, kp.modTime, kp.checked = &cert, info.ModTime(), time.Now()
+	return nil
+}
+
+func (kp *keyPair) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	kp.mu.Lock()
+	defer kp.mu.Unlock()
+	if time.Since(kp.checked) < certReloadInterval {
+		return kp.cert, nil
+	}
+	kp.checked = time.Now()
+	if info, err := os.Stat(kp.certFile); err == nil && !info.ModTime().Equal(kp.modTime) {
+		cert := kp.cert
+		if err := kp.load(); err != nil {
+			// Keep serving the old certificate until the new one is
+			// complete, e.g. while the key file is still being written.
+			logrus.WithError(err).Warn("reload TLS certificate")
+			kp.cert = cert
+		} else {
+			logrus.WithField("file", kp.certFile).Info("reloaded TLS certificate")
+		}
+	}
+	return kp.cert, nil
+}
+
+// redirectToHTTPS redirects every request to the same URL on the HTTPS
+// port. 308 keeps the method and body of webhook POSTs.
+func redirectToHTTPS(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// requireClientCert rejects requests that did not present a client
+// certificate verified against the -client-ca bundle and, if
+// s.ClientCertNames is set, issued to one of those names.
+func (s *Server) requireClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			logFor(r.Context()).WithField("remote_addr", r.RemoteAddr).Warn("rejected webhook: no verified client certificate")
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		leaf := r.TLS.VerifiedChains[0][0]
+		if len(s.ClientCertNames) > 0 && !certMatches(leaf, s.ClientCertNames) {
+			logFor(r.Context()).WithFields(logrus.Fields{"remote_addr": r.RemoteAddr, "common_name": leaf.Subject.CommonName}).Warn("rejected webhook: client certificate not allowed")
+			http.Error(w, "client certificate not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// certMatches reports whether the certificate's common name or one of its
+// DNS names is in names.
+func certMatches(cert *x509.Certificate, names []string) bool {
+	if slices.Contains(names, cert.Subject.CommonName) {
+		return true
+	}
+	for _, name := range cert.DNSNames {
+		if slices.Contains(names, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the webhook. Until setupTracing installs a
+// provider, its spans are not recorded.
+var tracer = otel.Tracer("github.com/kurtpeek/micromdm-webhook-blueprints/go")
+
+// setupTracing exports spans over OTLP/HTTP to endpoint, e.g.
+// http://otel-collector:4318. With an empty endpoint, tracing is enabled only
+// if the standard OTEL_EXPORTER_OTLP_ENDPOINT variables are set. The returned
+// function flushes and stops the exporter.
+func setupTracing(ctx context.Context, endpoint string) (func() error, error) {
+	var opts []otlptracehttp.Option
+	switch {
+	case endpoint != "":
+		opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+	case os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "":
+		return func() error { return nil }, nil
+	}
+	exp, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP exporter: %v", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName("micromdm-webhook"), semconv.ServiceVersion(version)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("detect tracing resource: %v", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return tp.Shutdown(ctx)
+	}, nil
+}
+
+// traceHandler starts a server span for each request to next, continuing
+// the trace of the caller if it sent one. Spans are named after the route
+// that served the request, rather than its path, to keep UDIDs out of span
+// names. Probe requests are not traced.
+func traceHandler(next http.Handler) http.Handler {
+	named := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r)
+		if route := r.Pattern; route != "" {
+			if !strings.Contains(route, " ") {
+				route = r.Method + " " + route
+			}
+			trace.SpanFromContext(r.Context()).SetName(route)
+		}
+	})
+	return otelhttp.NewHandler(named, "http.server",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string { return r.Method }),
+		otelhttp.WithFilter(func(r *http.Request) bool {
+			switch r.URL.Path {
+			case "/healthz", "/readyz", "/version":
+				return false
+			}
+			return true
+		}),
+	)
+}
+
+// mdmAttributes returns the span attributes identifying a device and command.
+func mdmAttributes(udid, requestType string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("mdm.udid", udid),
+		attribute.String("mdm.request_type", requestType),
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+
+	"github.com/groob/plist"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/webhook"
+	"github.com/sirupsen/logrus"
+)
+
+// A Mac has an MDM channel for the device and, once a user with MDM enabled
+// for them logs in, one for that user, on which user-scoped commands such as
+// InstallProfile of user profiles are sent. User channels check in with
+// UserAuthenticate and TokenUpdate messages carrying the UserID of the user,
+// and MicroMDM queues and pushes their commands by UserID rather than UDID.
+
+// userAuthenticateTopic is the topic of UserAuthenticate check-ins. MicroMDM
+// 1.6 answers them itself, rejecting the user, so macOS goes on to send the
+// user's TokenUpdate without posting an event; the handler is for servers
+// that forward them.
+const userAuthenticateTopic = "mdm.UserAuthenticate"
+
+// userVars counts the users that checked in with UserAuthenticate
+// (authenticated) and TokenUpdate (enrolled), and the user profiles sent to
+// them from blueprints (profiles).
+var userVars = expvar.NewMap("user_channel")
+
+// UserCheckin is the user of a check-in message sent on a user channel.
+type UserCheckin struct {
+	UserID        string
+	UserShortName string
+	UserLongName  string
+	NotOnConsole  bool
+}
+
+// userCheckin returns the user of the raw check-in message, and whether it
+// was sent on a user channel.
+func userCheckin(raw []byte) (UserCheckin, bool) {
+	var uc UserCheckin
+	if len(raw) == 0 {
+		return uc, false
+	}
+	if err := plist.Unmarshal(raw, &uc); err != nil {
+		return uc, false
+	}
+	return uc, uc.UserID != ""
+}
+
+// userOf returns the user of d with the given UserID, adding it to d if d
+// has none.
+func userOf(d *Device, userID string) *store.User {
+	if u := d.User(userID); u != nil {
+		return u
+	}
+	d.Users = append(d.Users, store.User{UserID: userID})
+	return &d.Users[len(d.Users)-1]
+}
+
+// handleUserAuthenticate records the user of a UserAuthenticate message, sent
+// when a user with MDM enabled for them first logs in on a Mac. The user's
+// channel takes commands once it sends a TokenUpdate.
+func (s *Server) handleUserAuthenticate(ctx context.Context, event webhook.Event, w http.ResponseWriter) {
+	if event.CheckinEvent == nil {
+		logFor(ctx).Error("The event has no CheckinEvent")
+		http.Error(w, "The event has no CheckinEvent", http.StatusBadRequest)
+		return
+	}
+	uc, ok := userCheckin(event.CheckinEvent.RawPayload)
+	if !ok {
+		logFor(ctx).Error("UserAuthenticate message has no UserID")
+		http.Error(w, "UserAuthenticate message has no UserID", http.StatusBadRequest)
+		return
+	}
+	if _, _, ok := s.recordUser(ctx, event, w, uc, false); ok {
+		userVars.Add("authenticated", 1)
+		logFor(ctx).WithField("user_id", uc.UserID).Info("user authenticating")
+	}
+}
+
+// handleUserTokenUpdate handles a TokenUpdate sent on the channel of uc,
+// which macOS sends when the user logs in with MDM enabled for them, and
+// whenever the channel's push token changes. The user is marked enrolled,
+// and on their first TokenUpdate sent the user profiles of the device's
+// blueprint. Unlike those of the device, user TokenUpdates leave the
+// device's enrollment alone and send no enrollment commands.
+func (s *Server) handleUserTokenUpdate(ctx context.Context, event webhook.Event, w http.ResponseWriter, uc UserCheckin) {
+	ctx = withLogger(ctx, logFor(ctx).WithField("user_id", uc.UserID))
+	d, first, ok := s.recordUser(ctx, event, w, uc, true)
+	if !ok {
+		return
+	}
+	if !first {
+		logFor(ctx).Info("user token update")
+		return
+	}
+	userVars.Add("enrolled", 1)
+	logFor(ctx).WithFields(logrus.Fields{"short_name": uc.UserShortName, "not_on_console": uc.NotOnConsole}).Info("enrolled user")
+	if b := s.blueprintNamed(d.Blueprint); b != nil {
+		s.applyUserProfiles(ctx, d, uc.UserID, b)
+	}
+}
+
+// recordUser records uc, who checked in with event, as a user of the
+// event's device, marking them enrolled if enrolled is set, and saves the
+// device. It reports whether the user was not enrolled before, and writes
+// any error to w.
+func (s *Server) recordUser(ctx context.Context, event webhook.Event, w http.ResponseWriter, uc UserCheckin, enrolled bool) (Device, bool, bool) {
+	d, exists, err := s.loadDevice(event.CheckinEvent.UDID)
+	if err != nil {
+		logFor(ctx).WithError(err).Error("load device")
+		http.Error(w, fmt.Sprintf("load device: %v", err), http.StatusInternalServerError)
+		return d, false, false
+	}
+	if !exists {
+		logFor(ctx).Warn("user checked in on a device that has not enrolled")
+	}
+	u := userOf(&d, uc.UserID)
+	first := enrolled && !u.Enrolled
+	u.ShortName, u.LongName, u.NotOnConsole = uc.UserShortName, uc.UserLongName, uc.NotOnConsole
+	u.Enrolled = enrolled
+	u.LastSeen = eventTime(event)
+	if pt := pushToken(event.CheckinEvent.RawPayload, u.LastSeen); pt != nil {
+		u.Push = pt
+	}
+	if err := s.Devices.Save(d); err != nil {
+		logFor(ctx).WithError(err).Error("save device")
+		http.Error(w, fmt.Sprintf("save device: %v", err), http.StatusInternalServerError)
+		return d, false, false
+	}
+	return d, first, true
+}
+
+// applyUserProfiles sends the user profiles of b, rendered with d, on the
+// channel of the user of d with the given UserID.
+func (s *Server) applyUserProfiles(ctx context.Context, d Device, userID string, b *Blueprint) {
+	if len(b.userProfiles) == 0 {
+		return
+	}
+	logFor(ctx).WithFields(logrus.Fields{"blueprint": b.Name, "profiles": len(b.userProfiles)}).Info("installing user profiles")
+	for _, p := range b.userProfiles {
+		c, err := s.installProfileCommand(ctx, d, userID, p)
+		if err != nil {
+			logFor(ctx).WithError(err).Error("install user profile")
+			continue
+		}
+		s.sendCommandNow(ctx, c)
+		userVars.Add("profiles", 1)
+	}
+}
+
+// applyUserAcknowledgment applies ack, a response on the channel of a user of
+// d, to that user: acknowledged ProfileList responses become the user's
+// profiles, and InstallApplication and ManagedApplicationList ones update
+// the user's app installs. The device's other response handlers do not run,
+// since the response describes the user rather than the device. It reports
+// whether d was modified.
+func (s *Server) applyUserAcknowledgment(ctx context.Context, d *Device, ack acknowledgment) (bool, error) {
+	u := userOf(d, ack.UserID)
+	u.LastSeen = ack.Time
+	if ack.Status != "Acknowledged" {
+		return true, nil
+	}
+	switch ack.RequestType {
+	case "InstallApplication":
+		_, err := s.applyInstallApplication(ctx, d, ack)
+		return true, err
+	case "ManagedApplicationList":
+		_, err := s.applyManagedApplicationList(ctx, d, ack)
+		return true, err
+	case "ProfileList":
+	default:
+		return true, nil
+	}
+	profiles, err := webhook.ParseProfileList(ack.Raw)
+	if err != nil {
+		return false, err
+	}
+	u.Profiles = profiles
+	logFor(ctx).WithField("profiles", len(profiles)).Info("updated user profile inventory")
+	return true, nil
+}
+
+// userChannelBody returns body, a command for MicroMDM's /v1/commands,
+// addressed to the user channel with the given UserID, which MicroMDM queues
+// user commands under in place of the UDID.
+func userChannelBody(body []byte, userID string) ([]byte, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, fmt.Errorf("encode user command: %v", err)
+	}
+	id, err := json.Marshal(userID)
+	if err != nil {
+		return nil, fmt.Errorf("encode user command: %v", err)
+	}
+	fields["udid"] = id
+	delete(fields, "user_id")
+	return json.Marshal(fields)
+}
+
+// apiUser returns the device of the request and the UserID of its user_id
+// path parameter, or writes a 404 if the user has not enrolled on the device.
+func (s *Server) apiUser(w http.ResponseWriter, r *http.Request) (Device, string, bool) {
+	d, ok := s.apiDevice(w, r)
+	if !ok {
+		return d, "", false
+	}
+	userID := r.PathValue("user_id")
+	if u := d.User(userID); u == nil || !u.Enrolled {
+		http.Error(w, fmt.Sprintf("user %s has not enrolled on device %s", userID, d.UDID), http.StatusNotFound)
+		return d, "", false
+	}
+	return d, userID, true
+}
+
+// handleSendUserCommand queues a command on the channel of a macOS user of a
+// device. The body is as for handleSendCommand.
+func (s *Server) handleSendUserCommand(w http.ResponseWriter, r *http.Request) {
+	d, userID, ok := s.apiUser(w, r)
+	if !ok {
+		return
+	}
+	requestType, payload, ok := readCommandPayload(w, r)
+	if !ok {
+		return
+	}
+	payload["udid"] = d.UDID
+
+	uuid, err := s.postCommandTo(r.Context(), d.UDID, userID, requestType, payload)
+	if err != nil {
+		logFor(r.Context()).WithFields(logrus.Fields{"udid": d.UDID, "user_id": userID, "request_type": requestType}).WithError(err).Error("send command")
+		http.Error(w, fmt.Sprintf("send command: %v", err), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"command_uuid": uuid,
+		"request_type": requestType,
+		"udid":         d.UDID,
+		"user_id":      userID,
+	})
+}
+
+// handleInstallUserProfile queues an InstallProfile command on the channel of
+// a macOS user of a device. The body is as for handleInstallProfile.
+func (s *Server) handleInstallUserProfile(w http.ResponseWriter, r *http.Request) {
+	d, userID, ok := s.apiUser(w, r)
+	if !ok {
+		return
+	}
+	if c, ok := s.readProfileCommand(w, r, d, userID); ok {
+		s.postAPICommand(w, r, c)
+	}
+}
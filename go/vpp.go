@@ -0,0 +1,183 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kurtpeek/micromdm-webhook-blueprints/go/pkg/store"
+)
+
+// The kinds of Apps and Books (VPP) licenses installs take.
+const (
+	// licenseDevice is a license assigned to the device's serial number,
+	// taken by installs with PurchaseMethod 1.
+	licenseDevice = "device"
+	// licenseUser is a license assigned to the Apple ID of a macOS user,
+	// taken by installs on the user's channel.
+	licenseUser = "user"
+)
+
+// licenseKind returns the kind of VPP license a takes, or "" for installs
+// that take none the server knows of, such as those bought with redemption
+// codes.
+func licenseKind(a store.AppInstall) string {
+	switch {
+	case a.PurchaseMethod == 1:
+		return licenseDevice
+	case a.UserID != "":
+		return licenseUser
+	}
+	return ""
+}
+
+// licenseReclaim returns why the license a took on d is no longer in use,
+// so that it can be revoked in Apple Business Manager or Apple School Manager
+// and assigned elsewhere, or "" if it is in use.
+func licenseReclaim(d Device, a store.AppInstall) string {
+	switch {
+	case isRetired(d):
+		return "device retired"
+	case !d.Enrolled:
+		return "device not enrolled"
+	case a.RemovedAt != nil:
+		return "app removed"
+	case slices.Contains(failedAppStates, a.State):
+		return "install " + a.State
+	}
+	return ""
+}
+
+// LicenseAssignment is a VPP license taken by an install on a device or one
+// of its users.
+type LicenseAssignment struct {
+	UDID   string `json:"udid"`
+	Name   string `json:"device_name,omitempty"`
+	Serial string `json:"serial_number,omitempty"`
+	// License is device or user, for the user of the install's UserID.
+	License string `json:"license"`
+	store.AppInstall
+	// Reclaim is why the license is no longer in use, if it is not.
+	Reclaim string `json:"reclaim,omitempty"`
+}
+
+// LicenseReport is the VPP licenses of each app consumed by the tracked
+// installs.
+type LicenseReport struct {
+	Apps []AppLicenses `json:"apps"`
+}
+
+// AppLicenses are the VPP licenses of an app taken by the tracked installs.
+type AppLicenses struct {
+	Identifier    string              `json:"identifier,omitempty"`
+	ITunesStoreID int64               `json:"itunes_store_id,omitempty"`
+	Consumed      int                 `json:"consumed"`
+	Device        int                 `json:"device"`
+	User          int                 `json:"user"`
+	Reclaimable   int                 `json:"reclaimable"`
+	Assignments   []LicenseAssignment `json:"assignments"`
+}
+
+// handleGetLicenseReport returns a LicenseReport of the VPP licenses the
+// tracked App Store installs consumed, and which of them could be
+// reclaimed. The identifier parameter picks one app by bundle ID or iTunes
+// Store ID, and reclaimable=true limits the report to the licenses no longer
+// in use.
+func (s *Server) handleGetLicenseReport(w http.ResponseWriter, r *http.Request) {
+	var reclaimableOnly bool
+	if v := r.URL.Query().Get("reclaimable"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid reclaimable value %q", v), http.StatusBadRequest)
+			return
+		}
+		reclaimableOnly = b
+	}
+	app := r.URL.Query().Get("identifier")
+
+	devices, err := s.Devices.List()
+	if err != nil {
+		logFor(r.Context()).WithError(err).Error("list devices")
+		http.Error(w, fmt.Sprintf("list devices: %v", err), http.StatusInternalServerError)
+		return
+	}
+	// Installs sent by iTunes Store ID learn the bundle ID of their app
+	// from the device's response; those still waiting for it are grouped
+	// with the others of the app by way of the installs that did.
+	bundleIDs := make(map[int64]string)
+	for _, d := range devices {
+		for _, a := range d.AppInstalls {
+			if a.ITunesStoreID != 0 && a.Identifier != "" {
+				bundleIDs[a.ITunesStoreID] = a.Identifier
+			}
+		}
+	}
+
+	byApp := make(map[string]*AppLicenses)
+	for _, d := range devices {
+		for _, a := range d.AppInstalls {
+			kind := licenseKind(a)
+			if kind == "" {
+				continue
+			}
+			id := a.Identifier
+			if id == "" {
+				id = bundleIDs[a.ITunesStoreID]
+			}
+			key := id
+			if key == "" {
+				key = strconv.FormatInt(a.ITunesStoreID, 10)
+			}
+			if app != "" && app != key && app != strconv.FormatInt(a.ITunesStoreID, 10) {
+				continue
+			}
+			reclaim := licenseReclaim(d, a)
+			if reclaimableOnly && reclaim == "" {
+				continue
+			}
+			l := byApp[key]
+			if l == nil {
+				l = &AppLicenses{Identifier: id}
+				byApp[key] = l
+			}
+			if l.ITunesStoreID == 0 {
+				l.ITunesStoreID = a.ITunesStoreID
+			}
+			la := LicenseAssignment{UDID: d.UDID, License: kind, AppInstall: a, Reclaim: reclaim}
+			if d.Info != nil {
+				la.Name, la.Serial = d.Info.DeviceName, d.Info.SerialNumber
+			}
+			l.Assignments = append(l.Assignments, la)
+			l.Consumed++
+			if kind == licenseDevice {
+				l.Device++
+			} else {
+				l.User++
+			}
+			if reclaim != "" {
+				l.Reclaimable++
+			}
+		}
+	}
+
+	report := LicenseReport{Apps: make([]AppLicenses, 0, len(byApp))}
+	for _, l := range byApp {
+		slices.SortFunc(l.Assignments, func(a, b LicenseAssignment) int {
+			if c := strings.Compare(a.UDID, b.UDID); c != 0 {
+				return c
+			}
+			return strings.Compare(a.UserID, b.UserID)
+		})
+		report.Apps = append(report.Apps, *l)
+	}
+	slices.SortFunc(report.Apps, func(a, b AppLicenses) int {
+		if c := strings.Compare(a.Identifier, b.Identifier); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ITunesStoreID, b.ITunesStoreID)
+	})
+	writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	const body = `{"topic":"mdm.Connect"}`
+	sign := func(key, body string) string {
+		mac := hmac.New(sha256.New, []byte(key))
+		io.WriteString(mac, body)
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	tests := []struct {
+		name   string
+		header string
+		sent   string
+		value  string
+		body   string
+		want   int
+	}{
+		{name: "valid", value: sign("secret", body), body: body, want: http.StatusOK},
+		{name: "valid without prefix", value: strings.TrimPrefix(sign("secret", body), "sha256="), body: body, want: http.StatusOK},
+		{name: "custom header", header: "X-Signature", sent: "X-Signature", value: sign("secret", body), body: body, want: http.StatusOK},
+		{name: "missing", body: body, want: http.StatusUnauthorized},
+		{name: "wrong key", value: sign("other", body), body: body, want: http.StatusUnauthorized},
+		{name: "modified body", value: sign("secret", body), body: body + " ", want: http.StatusUnauthorized},
+		{name: "not hex", value: "sha256=zz", body: body, want: http.StatusUnauthorized},
+		{name: "wrong header", header: "X-Signature", value: sign("secret", body), body: body, want: http.StatusUnauthorized},
+		{name: "too large", value: sign("secret", body), body: strings.Repeat("x", maxWebhookBody+1), want: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{WebhookSecret: []byte("secret"), SignatureHeader: tt.header}
+			var got string
+			h := s.verifySignature(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				got = string(b)
+			}))
+			r := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(tt.body))
+			if tt.value != "" {
+				header := tt.sent
+				if header == "" {
+					header = defaultSignatureHeader
+				}
+				r.Header.Set(header, tt.value)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && got != tt.body {
+				t.Errorf("handler read body %q, want %q", got, tt.body)
+			}
+		})
+	}
+}